@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/state"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// addRequest is what the add subcommand records about a torrent besides
+// its metadata
+type addRequest struct {
+	stateFile    string
+	displayName  string
+	torrentPath  string
+	source       state.Source
+	downloadPath string
+}
+
+// entry returns the state file entry for a torrent added this way
+func (r addRequest) entry(infoHash [20]byte, name string, trackers []string) state.Torrent {
+	return state.Torrent{
+		InfoHash:     hex.EncodeToString(infoHash[:]),
+		Name:         name,
+		DisplayName:  r.displayName,
+		TorrentPath:  r.torrentPath,
+		Source:       &r.source,
+		DownloadPath: r.downloadPath,
+		Trackers:     trackers,
+	}
+}
+
+// runAdd records a parsed torrent in the state file without starting it
+// and exits
+func runAdd(r addRequest, t *torrent.TorrentFile) {
+	if err := addStopped(r.stateFile, r.entry(t.InfoHash, t.Info.Name, trackerList(t))); err != nil {
+		exit("Error saving state", err)
+	}
+	os.Exit(ExitCompleted)
+}
+
+// runAddMagnet records a magnet link in the state file without fetching
+// its metadata and exits. Without a name the info hash stands in for it.
+func runAddMagnet(r addRequest, magnet *torrent.Magnet) {
+	name := magnet.Name
+	if name == "" {
+		name = hex.EncodeToString(magnet.InfoHash[:])
+	}
+
+	if err := addStopped(r.stateFile, r.entry(magnet.InfoHash, name, magnet.Trackers)); err != nil {
+		exit("Error saving state", err)
+	}
+	os.Exit(ExitCompleted)
+}
+
+// addStopped records a torrent in the state file without starting it. It
+// is started later by giving its info hash with the same -state. A torrent
+// already in the state file keeps its progress and display name.
+func addStopped(stateFile string, t state.Torrent) error {
+	store := state.NewStore(stateFile, os.Getenv(statePassphraseEnv))
+	session, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	if saved := session.Find(t.InfoHash); saved != nil {
+		t.Downloaded, t.Uploaded = saved.Downloaded, saved.Uploaded
+		t.Completed, t.Pieces = saved.Completed, saved.Pieces
+		if t.DisplayName == "" {
+			t.DisplayName = saved.DisplayName
+		}
+	}
+	t.Stopped = true
+	t.UpdatedAt = time.Now()
+
+	session.Update(t)
+	if err := store.Save(session); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s without starting it; start it with: go-torrent -state %s %s\n", t.Name, stateFile, t.InfoHash)
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// subcommands are the commands accepted as the first argument
+var subcommands = []string{"download", "serve", "repair", "add", "fetch-meta", "completion"}
+
+// completionScripts load the completion of go-torrent into each shell:
+// they pass the words typed so far to "go-torrent __complete" and offer
+// the lines it prints, a value optionally followed by a tab and a
+// description. Without any, bash and zsh complete file names.
+var completionScripts = map[string]string{
+	"bash": `_go_torrent() {
+	local IFS=$'\n'
+	COMPREPLY=($(go-torrent __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+	COMPREPLY=("${COMPREPLY[@]%%$'\t'*}")
+	if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+		compopt -o nospace
+	fi
+}
+complete -o default -F _go_torrent go-torrent
+`,
+	"zsh": `#compdef go-torrent
+_go_torrent() {
+	local -a lines description
+	local line value
+	lines=(${(f)"$(go-torrent __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ! ${#lines} )); then
+		_files
+		return
+	fi
+	for line in $lines; do
+		value=${line%%$'\t'*}
+		description=("${line/$'\t'/  -- }")
+		if [[ $value == */ ]]; then
+			compadd -Q -S '' -d description -- "$value"
+		else
+			compadd -Q -d description -- "$value"
+		fi
+	done
+}
+compdef _go_torrent go-torrent
+`,
+	"fish": `complete -c go-torrent -f -a '(go-torrent __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+}
+
+// printCompletionScript prints the completion script of a shell
+func printCompletionScript(shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell %q (supported: bash, zsh, fish)", shell)
+	}
+
+	fmt.Print(script)
+	return nil
+}
+
+// complete returns the completions of the last of words, the arguments
+// typed so far: subcommands, flags, flag values, torrent files for the
+// first argument and directories for the second. The -pieces and -bytes
+// values cover each file of the torrent given on the command line, to
+// download some of its files.
+func complete(fs *flag.FlagSet, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current, typed := words[len(words)-1], words[:len(words)-1]
+
+	hasSubcommand := len(typed) > 0 && slices.Contains(subcommands, typed[0])
+	if hasSubcommand {
+		if typed[0] == "completion" {
+			return matching([]string{"bash", "zsh", "fish"}, current)
+		}
+		typed = typed[1:]
+	}
+
+	// Sort the typed words into flags, with their values, and arguments
+	var positional []string
+	values := make(map[string]string)
+	var pending *flag.Flag
+	for _, word := range typed {
+		if pending != nil {
+			values[pending.Name] = word
+			pending = nil
+			continue
+		}
+
+		if len(word) > 1 && word[0] == '-' {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if hasValue {
+				values[name] = value
+			} else if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+				pending = f
+			}
+			continue
+		}
+
+		positional = append(positional, word)
+	}
+
+	switch {
+	case pending != nil:
+		return completeFlagValue(fs, pending.Name, current, positional, values)
+	case strings.HasPrefix(current, "-"):
+		var completions []string
+		fs.VisitAll(func(f *flag.Flag) {
+			if strings.HasPrefix("-"+f.Name, current) {
+				completions = append(completions, "-"+f.Name+"\t"+f.Usage)
+			}
+		})
+		return completions
+	case len(positional) == 0:
+		completions := completePath(current, isTorrentFile)
+		if !hasSubcommand && len(typed) == 0 {
+			completions = append(matching(subcommands, current), completions...)
+		}
+		return completions
+	case len(positional) == 1:
+		return completePath(current, func(string) bool { return false })
+	default:
+		return nil
+	}
+}
+
+// completeFlagValue returns the completions of the value of a flag
+func completeFlagValue(fs *flag.FlagSet, name, current string, positional []string, values map[string]string) []string {
+	switch name {
+	case "pieces", "bytes":
+		if len(positional) == 0 {
+			return nil
+		}
+		torrentFile, err := torrent.ParseFromFile(positional[0])
+		if err != nil {
+			return nil
+		}
+		return matching(fileRanges(torrentFile, name == "pieces"), current)
+	case "on-name-collision":
+		return matching([]string{"suffix", "subdir", "share"}, current)
+	case "profile":
+		configPath, ok := values["config"]
+		if !ok {
+			configPath = fs.Lookup("config").DefValue
+		}
+		config, err := loadConfig(configPath)
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return matching(names, current)
+	default:
+		return completePath(current, func(string) bool { return true })
+	}
+}
+
+// fileRanges returns the piece or byte range of every file of a torrent,
+// described by the file's path, after the range of the whole torrent
+func fileRanges(t *torrent.TorrentFile, pieces bool) []string {
+	files := t.Info.Files
+	if !t.Info.IsDirectory {
+		files = []torrent.FileDict{{Length: t.Info.Length, Path: []string{t.Info.Name}}}
+	}
+
+	format := func(first, last int64, description string) string {
+		if pieces {
+			first, last = first/t.Info.PieceLength, last/t.Info.PieceLength
+		}
+		return fmt.Sprintf("%d-%d\t%s", first, last, description)
+	}
+
+	total := t.TotalLength()
+	if total == 0 || t.Info.PieceLength <= 0 {
+		return nil
+	}
+	ranges := []string{format(0, total-1, "everything")}
+
+	var offset int64
+	for _, file := range files {
+		if file.Length > 0 {
+			ranges = append(ranges, format(offset, offset+file.Length-1, filepath.Join(file.Path...)))
+		}
+		offset += file.Length
+	}
+	return ranges
+}
+
+// completePath returns the directories, with a trailing slash, and the
+// files accepted by want that start with prefix
+func completePath(prefix string, want func(name string) bool) []string {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var completions []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+
+		// Follow symlinks to tell directories apart
+		info, err := os.Stat(filepath.Join(readDir, name))
+		switch {
+		case err == nil && info.IsDir():
+			completions = append(completions, dir+name+"/")
+		case want(name):
+			completions = append(completions, dir+name)
+		}
+	}
+	return completions
+}
+
+func isTorrentFile(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".torrent")
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// matching returns the candidates that start with prefix
+func matching(candidates []string, prefix string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// newCompletionFixture returns a directory holding a multi-file torrent,
+// a subdirectory, another file and a configuration with two profiles
+func newCompletionFixture(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "multi.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = bencode.Encode(f, map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]interface{}{
+			"name":         "multi",
+			"piece length": int64(4),
+			"pieces":       string(make([]byte, 4*20)),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(5), "path": []interface{}{"a.txt"}},
+				map[string]interface{}{"length": int64(0), "path": []interface{}{"empty"}},
+				map[string]interface{}{"length": int64(11), "path": []interface{}{"sub", "c.txt"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "movies"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"profiles": {"home": {}, "hotspot": {}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestComplete(t *testing.T) {
+	dir := newCompletionFixture(t) + string(filepath.Separator)
+	torrentPath := dir + "multi.torrent"
+
+	fs := flag.NewFlagSet("go-torrent", flag.ContinueOnError)
+	fs.String("pieces", "", "pieces to download")
+	fs.String("bytes", "", "bytes to download")
+	fs.String("on-name-collision", "suffix", "collision policy")
+	fs.String("config", "", "configuration file")
+	fs.String("profile", "", "profile")
+	fs.Bool("verbose", false, "verbose output")
+	fs.Bool("version", false, "print the version")
+
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"subcommands", []string{"fe"}, []string{"fetch-meta"}},
+		{"completion shells", []string{"completion", "z"}, []string{"zsh"}},
+		{"flags", []string{"-ver"}, []string{"-verbose\tverbose output", "-version\tprint the version"}},
+		{"flags after a subcommand", []string{"add", "-pro"}, []string{"-profile\tprofile"}},
+		{"flag value", []string{"-on-name-collision", "s"}, []string{"suffix", "subdir", "share"}},
+		{"flag value after a subcommand", []string{"download", "-on-name-collision", "sh"}, []string{"share"}},
+		{"torrent argument", []string{dir}, []string{dir + "movies/", torrentPath}},
+		{"bool flag takes no value", []string{"-verbose", dir}, []string{dir + "movies/", torrentPath}},
+		{"inline value takes no word", []string{"-on-name-collision=share", dir + "mo"}, []string{dir + "movies/"}},
+		{"directory argument", []string{torrentPath, dir}, []string{dir + "movies/"}},
+		{"no third argument", []string{torrentPath, dir, ""}, nil},
+		{"profiles of the configuration", []string{"-config=" + dir + "config.json", "-profile", "h"}, []string{"home", "hotspot"}},
+		{"pieces per file", []string{torrentPath, "-pieces", ""}, []string{"0-3\teverything", "0-1\ta.txt", "1-3\t" + filepath.Join("sub", "c.txt")}},
+		{"bytes per file", []string{torrentPath, "-bytes", "5"}, []string{"5-15\t" + filepath.Join("sub", "c.txt")}},
+		{"ranges need a torrent", []string{"-pieces", ""}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := complete(fs, tt.words); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("complete(%q) = %q, want %q", tt.words, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRanges(t *testing.T) {
+	single := &torrent.TorrentFile{
+		Info: torrent.InfoDict{PieceLength: 4, Name: "video.mkv", Length: 10},
+	}
+	multi := &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: 4,
+			Name:        "multi",
+			IsDirectory: true,
+			Files: []torrent.FileDict{
+				{Length: 5, Path: []string{"a.txt"}},
+				{Length: 0, Path: []string{"empty"}},
+				{Length: 11, Path: []string{"sub", "c.txt"}},
+			},
+		},
+	}
+	empty := &torrent.TorrentFile{
+		Info: torrent.InfoDict{PieceLength: 4, Name: "empty"},
+	}
+
+	tests := []struct {
+		name    string
+		torrent *torrent.TorrentFile
+		pieces  bool
+		want    []string
+	}{
+		{"single file pieces", single, true, []string{"0-2\teverything", "0-2\tvideo.mkv"}},
+		{"single file bytes", single, false, []string{"0-9\teverything", "0-9\tvideo.mkv"}},
+		{"multi-file pieces", multi, true, []string{"0-3\teverything", "0-1\ta.txt", "1-3\t" + filepath.Join("sub", "c.txt")}},
+		{"multi-file bytes", multi, false, []string{"0-15\teverything", "0-4\ta.txt", "5-15\t" + filepath.Join("sub", "c.txt")}},
+		{"empty torrent", empty, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileRanges(tt.torrent, tt.pieces); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fileRanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// Process exit codes, so wrapping scripts can branch on the failure type
+const (
+	ExitCompleted          = 0   // Download finished (or was already complete)
+	ExitError              = 1   // Any other failure
+	ExitUsage              = 2   // Invalid command line
+	ExitInvalidTorrent     = 3   // The torrent file could not be read or parsed, or isn't the expected one
+	ExitTrackerUnreachable = 4   // No tracker could be contacted and no peers were found
+	ExitDiskFull           = 5   // The download path ran out of space
+	ExitTimeout            = 6   // The download, or fetching the metadata of a magnet link, didn't finish within -timeout
+	ExitUnavailable        = 7   // The download stalled on pieces no peer has, with -stop-when-stalled, or no peer sent the metadata of a magnet link
+	ExitCancelled          = 130 // Interrupted before the download finished
+)
+
+// maxTrackerFailures is how many consecutive failed announces, without a
+// single connected peer, are treated as the tracker being unreachable
+const maxTrackerFailures = 3
+
+// exitCode maps an error to the exit code describing it
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitCompleted
+	case errors.Is(err, torrent.ErrInvalidTorrentFile),
+		errors.Is(err, torrent.ErrInvalidInfoDict),
+		errors.Is(err, torrent.ErrInvalidPieces),
+		errors.Is(err, torrent.ErrInfoHashMismatch),
+		errors.Is(err, torrent.ErrInvalidMagnet),
+		errors.Is(err, bencode.ErrInvalidBencode),
+		errors.Is(err, bencode.ErrIntegerFormat),
+		errors.Is(err, bencode.ErrStringLength):
+		return ExitInvalidTorrent
+	case errors.Is(err, tracker.ErrTrackerUnreachable):
+		return ExitTrackerUnreachable
+	case errors.Is(err, syscall.ENOSPC):
+		return ExitDiskFull
+	case errors.Is(err, download.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, download.ErrTorrentUnavailable), errors.Is(err, download.ErrNoMetadataPeers):
+		return ExitUnavailable
+	case errors.Is(err, download.ErrDownloadCancelled):
+		return ExitCancelled
+	default:
+		return ExitError
+	}
+}
+
+// exit prints the error, shows it as a desktop notification with -notify
+// and exits with the code describing it
+func exit(format string, err error) {
+	fmt.Printf(format+": %v\n", err)
+	desktopNotify("go-torrent", fmt.Sprintf(format+": %v", err))
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitCompleted},
+		{errors.New("boom"), ExitError},
+		{torrent.ErrInvalidTorrentFile, ExitInvalidTorrent},
+		{torrent.ErrInvalidInfoDict, ExitInvalidTorrent},
+		{torrent.ErrInvalidPieces, ExitInvalidTorrent},
+		{torrent.ErrInfoHashMismatch, ExitInvalidTorrent},
+		{torrent.ErrInvalidMagnet, ExitInvalidTorrent},
+		{bencode.ErrInvalidBencode, ExitInvalidTorrent},
+		{bencode.ErrIntegerFormat, ExitInvalidTorrent},
+		{bencode.ErrStringLength, ExitInvalidTorrent},
+		{tracker.ErrTrackerUnreachable, ExitTrackerUnreachable},
+		{&os.PathError{Op: "write", Path: "/downloads/a.iso", Err: syscall.ENOSPC}, ExitDiskFull},
+		{download.ErrDeadlineExceeded, ExitTimeout},
+		{context.DeadlineExceeded, ExitTimeout},
+		{download.ErrTorrentUnavailable, ExitUnavailable},
+		{download.ErrNoMetadataPeers, ExitUnavailable},
+		{download.ErrDownloadCancelled, ExitCancelled},
+	}
+
+	for _, tt := range tests {
+		// Callers wrap the sentinels with context of their own
+		wrapped := tt.err
+		if wrapped != nil {
+			wrapped = fmt.Errorf("failed to download: %w", tt.err)
+		}
+
+		if got := exitCode(wrapped); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", wrapped, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// runFetchMeta fetches the metadata of a magnet link into a .torrent file
+// and exits. Without an output file it is named after the torrent, or its
+// info hash when the link has no name.
+func runFetchMeta(magnet *torrent.Magnet, output string, peerID [20]byte, port int, timeout time.Duration) {
+	if output == "" {
+		output = hex.EncodeToString(magnet.InfoHash[:]) + ".torrent"
+		if magnet.Name != "" {
+			output = magnet.Name + ".torrent"
+		}
+	}
+
+	metainfo, err := fetchMagnetMetadata(magnet, peerID, port, timeout)
+	if err != nil {
+		exit("Error fetching metadata", err)
+	}
+	if err := os.WriteFile(output, metainfo, 0644); err != nil {
+		exit("Error saving metadata", err)
+	}
+	fmt.Printf("Saved the metadata of %x to %s\n", magnet.InfoHash, output)
+	os.Exit(ExitCompleted)
+}
+
+// fetchMagnetMetadata fetches the metadata of a magnet link from peers and
+// returns it as a .torrent file, giving up after timeout unless it is 0
+func fetchMagnetMetadata(magnet *torrent.Magnet, peerID [20]byte, port int, timeout time.Duration) ([]byte, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	fmt.Printf("Fetching the metadata of %x from peers\n", magnet.InfoHash)
+	return download.FetchMetadata(ctx, magnet, peerID, tracker.NewClient(peerID, port), port)
+}
@@ -0,0 +1,32 @@
+//go:build geoip
+
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/geoip"
+)
+
+var geoipDB = flag.String("geoip", "", "MaxMind country database (.mmdb) used to tag peers with their country")
+
+func init() {
+	setupHooks = append(setupHooks, func(dm *download.DownloadManager) error {
+		if *geoipDB == "" {
+			return nil
+		}
+
+		db, err := geoip.Open(*geoipDB)
+		if err != nil {
+			return err
+		}
+
+		dm.GeoIP = func(ip net.IP) string {
+			country, _ := db.Country(ip)
+			return country
+		}
+		return nil
+	})
+}
@@ -1,91 +1,638 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/piyushgupta53/go-torrent/internal/control"
 	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/socks"
+	"github.com/piyushgupta53/go-torrent/internal/state"
 	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracing"
 	"github.com/piyushgupta53/go-torrent/internal/tracker"
 )
 
 const (
 	clearLine = "\r\033[K"
+
+	// statePassphraseEnv holds the passphrase used to encrypt the state file
+	statePassphraseEnv = "GO_TORRENT_STATE_PASSPHRASE"
+
+	// torProxyAddr is the SOCKS5 port of a local Tor daemon
+	torProxyAddr = "127.0.0.1:9050"
 )
 
+// setupHooks configure the download for features compiled in with build
+// tags, such as GeoIP; they run once the command line has been parsed
+var setupHooks []func(dm *download.DownloadManager) error
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go-torrent <torrent-file> [download-path]")
-		os.Exit(1)
+	verifyOnComplete := flag.Bool("verify-on-complete", false, "re-hash the downloaded data from disk once the download completes")
+	assumeData := flag.Bool("assume-data", false, "use existing data in the download path (e.g. from another torrent), verify it and seed it")
+	quickCheck := flag.Float64("quick-check", 0, "hash this percentage of the pieces of the existing data in the download path, always the first and last, report its estimated integrity and exit")
+	port := flag.Int("port", 6881, "port announced to trackers for incoming peer connections")
+	webSeed := flag.String("web-seed", "", "HTTP web seed (BEP 19) URL used when the swarm is slow or missing pieces")
+	writeBufferMB := flag.Int("write-buffer", 0, "MB of verified pieces to buffer in memory and write together (0 writes immediately)")
+	inFlightMB := flag.Int("inflight-budget", download.DefaultInFlightBudget/(1024*1024), "MB of piece data that may be requested or waiting for the disk before requests pause (0 is unlimited)")
+	pieceMemoryMB := flag.Int("piece-memory", download.DefaultPartialPieceBudget/(1024*1024), "MB of memory incomplete pieces may take up before only started pieces are downloaded (0 is unlimited)")
+	stateFile := flag.String("state", "", "file to save session state (torrents, trackers, stats) to; encrypted when $"+statePassphraseEnv+" is set")
+	trustResume := flag.Bool("trust-resume", false, "mark the pieces saved in -state as complete without hashing them, then re-verify them in the background while seeding")
+	seedOnly := flag.Bool("seed-only", false, "only upload: never request pieces, start from verified data in the download path")
+	noSeed := flag.Bool("no-seed", false, "disconnect from all peers and exit once the download completes")
+	uploadSlots := flag.Int("upload-slots", download.DefaultUploadSlots, "number of interested peers to upload to at a time, one of them picked in turn (0 uploads to every interested peer)")
+	maxPeers := flag.Int("max-peers", 50, "number of peers to connect to (the ceiling with -auto-peers)")
+	autoPeers := flag.Bool("auto-peers", false, "adjust the number of peers to the achieved throughput")
+	minPeers := flag.Int("min-peers", 10, "fewest peers to aim for with -auto-peers")
+	pinDNS := flag.Bool("pin-tracker-dns", false, "resolve each tracker host once and keep using that address for the session")
+	dnsServer := flag.String("dns", "", "encrypted DNS server for tracker lookups: an https:// DNS-over-HTTPS URL or tls://host[:port] for DNS-over-TLS")
+	proxy := flag.String("proxy", "", "SOCKS5 proxy ([user:pass@]host:port) for all tracker, peer and web seed connections")
+	anonymous := flag.Bool("anonymous", false, "route everything through the SOCKS5 proxy (Tor at "+torProxyAddr+" unless -proxy is set), never accept connections and hide the client in the peer ID")
+	swarmCert := flag.String("swarm-cert", "", "certificate shared by a private swarm; peer connections are tunneled through TLS and only peers with the same certificate are accepted")
+	swarmKey := flag.String("swarm-key", "", "private key of -swarm-cert")
+	downloadLimit := flag.Int64("download-limit", 0, "KB/s of piece data to download across all peers (0 is unlimited)")
+	uploadLimit := flag.Int64("upload-limit", 0, "KB/s of piece data to upload across all peers (0 is unlimited)")
+	weight := flag.Float64("weight", 1, "share of the rate limits this torrent gets while other torrents compete for them")
+	otlpEndpoint := flag.String("otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector (e.g. http://localhost:4318) to export a trace of every piece's receive, verify, write and have steps to (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	slowPiece := flag.Duration("slow-piece", 0, "log the steps of pieces taking longer than this from their last block to the have messages, e.g. 500ms (0 never does)")
+	notifyDesktop := flag.Bool("notify", false, "show a desktop notification when the download completes or fails, while running in a terminal")
+	grpcAddr := flag.String("grpc", "", "address (e.g. 127.0.0.1:6800) to serve the gRPC control service of api/gotorrent/v1/control.proto on, without TLS")
+	configPath := flag.String("config", defaultConfigPath(), "configuration file holding named profiles")
+	profileName := flag.String("profile", "", "profile from the configuration file to use (default: its \"profile\" entry); SIGHUP switches to the file's current \"profile\", as does the SwitchProfile call of -grpc")
+	tcpNoDelay := flag.Bool("tcp-nodelay", true, "send small peer messages such as requests immediately (TCP_NODELAY)")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", peer.DefaultSocketOptions().KeepAlive, "TCP keep-alive interval of peer connections (negative disables)")
+	tcpReadBuffer := flag.Int("tcp-read-buffer", 0, "KB of receive buffer per peer connection (0 lets the OS size it)")
+	tcpWriteBuffer := flag.Int("tcp-write-buffer", 0, "KB of send buffer per peer connection (0 lets the OS size it)")
+	strict := flag.Bool("strict", false, "only accept the metainfo keys from the specifications, refusing torrents that misspell them, e.g. \"annouce\"")
+	expectHash := flag.String("expect-hash", "", "refuse to start unless the torrent has this info hash (40 hex or 32 base32 characters)")
+	sequential := flag.Bool("sequential", false, "download pieces roughly in order, e.g. to start playing a video early")
+	sequentialWindow := flag.Int("sequential-window", download.DefaultSequentialWindow().Size, "pieces ahead of the first missing one that -sequential picks from")
+	sequentialStrict := flag.Bool("sequential-strict", false, "with -sequential, download the window strictly in order instead of rarest first")
+	pieceRange := flag.String("pieces", "", "download only these pieces, given as first-last (inclusive), e.g. to repair pieces that failed verification")
+	byteRange := flag.String("bytes", "", "download only the pieces holding these payload bytes, given as first-last (inclusive), e.g. to preview a file")
+	timeout := flag.Duration("timeout", 0, "give up if the download hasn't completed after this long, e.g. 30m (0 waits forever)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "on Ctrl+C, wait this long for the tracker to hear we left and the data to be flushed before closing everything at once; a second Ctrl+C doesn't wait")
+	saveTorrent := flag.String("save-torrent", "", "save the .torrent file of a magnet link here once its metadata is fetched, to add or share it later")
+	displayName := flag.String("display-name", "", "name to show the torrent as, e.g. instead of a long generated one, without renaming its files; saved in -state")
+	onNameCollision := flag.String("on-name-collision", "suffix", "where to save a torrent whose name another torrent already uses in the download path: suffix (\"name (2)\"), subdir (below its info hash) or share (the same files)")
+	stallTimeout := flag.Duration("stall-timeout", 0, "report the download as stalled once peers have lacked a needed piece and nothing completed for this long, e.g. 1h (0 never does)")
+	stopWhenStalled := flag.Bool("stop-when-stalled", false, "with -stall-timeout, stop and exit once the download stalls")
+	listen := flag.String("listen", "", "address to accept incoming peer connections on, e.g. :6881")
+	var peers stringList
+	flag.Var(&peers, "peer", "connect directly to this peer (host:port) without using the tracker; may be repeated")
+	importPeers := flag.String("import-peers", "", "connect to the peers listed in this file (host:port per line), e.g. written by -export-peers, in addition to the tracker's")
+	exportPeers := flag.String("export-peers", "", "write the known peers to this file when the download stops, to bootstrap another instance or the next run with -import-peers")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-torrent [download] [flags] <torrent-file|url|magnet|-|info-hash saved in -state> [download-path]")
+		fmt.Fprintln(os.Stderr, "       go-torrent serve [flags] <torrent-file> [data-path]")
+		fmt.Fprintln(os.Stderr, "       go-torrent repair [flags] <torrent-file> [data-path]")
+		fmt.Fprintln(os.Stderr, "       go-torrent add -state <file> [flags] <torrent-file|url|magnet|-> [download-path]")
+		fmt.Fprintln(os.Stderr, "       go-torrent fetch-meta [flags] <magnet> [torrent-file]")
+		fmt.Fprintln(os.Stderr, "       go-torrent completion bash|zsh|fish")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExit codes: %d completed, %d error, %d usage, %d invalid torrent, %d tracker unreachable, %d disk full, %d timed out, %d unavailable, %d cancelled\n",
+			ExitCompleted, ExitError, ExitUsage, ExitInvalidTorrent, ExitTrackerUnreachable, ExitDiskFull, ExitTimeout, ExitUnavailable, ExitCancelled)
 	}
 
-	torrentPath := os.Args[1]
+	// "serve" seeds existing data to peers that connect directly to us;
+	// "repair" re-downloads the pieces of existing data that fail the
+	// check; "add" records a torrent in -state without starting it;
+	// "fetch-meta" only fetches the metadata of a magnet link into a
+	// .torrent file; "download" is the default and may be left out
+	args := os.Args[1:]
 
-	// Determine download path
-	downloadPath := "."
-	if len(os.Args) >= 3 {
-		downloadPath = os.Args[2]
+	// "completion <shell>" prints a completion script, which asks
+	// "__complete" for the completions of the words typed so far
+	if len(args) > 0 && args[0] == "__complete" {
+		for _, completion := range complete(flag.CommandLine, args[1:]) {
+			fmt.Println(completion)
+		}
+		os.Exit(ExitCompleted)
+	}
+	if len(args) > 0 && args[0] == "completion" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: go-torrent completion bash|zsh|fish")
+			os.Exit(ExitUsage)
+		}
+		if err := printCompletionScript(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitUsage)
+		}
+		os.Exit(ExitCompleted)
 	}
 
-	// Parse the torrent file
-	torrentFile, err := torrent.ParseFromFile(torrentPath)
+	serve := len(args) > 0 && args[0] == "serve"
+	repair := len(args) > 0 && args[0] == "repair"
+	add := len(args) > 0 && args[0] == "add"
+	fetchMeta := len(args) > 0 && args[0] == "fetch-meta"
+	if serve || repair || add || fetchMeta || (len(args) > 0 && args[0] == "download") {
+		args = args[1:]
+	}
+
+	positional := parseArgs(flag.CommandLine, args)
+	if len(positional) < 1 {
+		flag.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	torrentPath := positional[0]
+
+	// The profile fills in whatever the command line leaves unset
+	config, err := loadConfig(*configPath)
 	if err != nil {
-		fmt.Printf("Error parsing torrent file: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Invalid -config: %v\n", err)
+		os.Exit(ExitUsage)
 	}
 
-	// Display torrent info
-	fmt.Printf("Torrent: %s\n", filepath.Base(torrentPath))
-	fmt.Printf("Announce URL: %s\n", torrentFile.Announce)
+	if *profileName == "" {
+		*profileName = config.Profile
+	}
 
-	if torrentFile.Info.IsDirectory {
-		fmt.Printf("Content: Directory (%s) with %d files\n", torrentFile.Info.Name, len(torrentFile.Info.Files))
+	rewriter, err := torrent.NewTrackerRewriter(config.TrackerRewrites)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -config: %v\n", err)
+		os.Exit(ExitUsage)
+	}
 
-		// Display some file information (limit to 5 files to avoid cluttering the screen)
-		var totalShown int
-		var totalSize int64
+	profile, err := config.lookup(*profileName)
+	if err == nil {
+		err = profile.applyToFlags(flag.CommandLine)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -profile: %v\n", err)
+		os.Exit(ExitUsage)
+	}
 
-		for i, file := range torrentFile.Info.Files {
-			if i < 5 {
-				fmt.Printf("  File %d: %s (%s)\n",
-					i+1,
-					filepath.Join(file.Path...),
-					formatSize(file.Length))
-				totalShown++
+	// Determine download path
+	downloadPath := "."
+	if len(positional) >= 2 && !fetchMeta {
+		downloadPath = positional[1]
+	} else if profile.DownloadDir != "" {
+		downloadPath = profile.DownloadDir
+	}
+
+	// An info hash adds a torrent saved in the state file again from where
+	// it was first added, into its download path unless another is given
+	if _, statErr := os.Stat(torrentPath); statErr != nil && *stateFile != "" {
+		if infoHash, err := torrent.ParseInfoHash(torrentPath); err == nil {
+			saved, err := savedTorrent(*stateFile, infoHash)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot re-add %s: %v\n", torrentPath, err)
+				os.Exit(ExitUsage)
+			}
+
+			name := saved.Name
+			if saved.DisplayName != "" {
+				name = saved.DisplayName
+			}
+			fmt.Printf("Re-adding %s from %s\n", name, saved.Source.URI)
+			torrentPath = saved.Source.URI
+			if len(positional) < 2 && saved.DownloadPath != "" {
+				downloadPath = saved.DownloadPath
+			}
+			if *expectHash == "" {
+				*expectHash = hex.EncodeToString(infoHash[:])
 			}
-			totalSize += file.Length
 		}
+	}
+	source := state.SourceOf(torrentPath, time.Now())
+	added := addRequest{
+		stateFile:    *stateFile,
+		displayName:  *displayName,
+		torrentPath:  torrentPath,
+		source:       source,
+		downloadPath: downloadPath,
+	}
 
-		if totalShown < len(torrentFile.Info.Files) {
-			remaining := len(torrentFile.Info.Files) - totalShown
-			fmt.Printf("  ... and %d more files\n", remaining)
+	var expectedHash [20]byte
+	if *expectHash != "" {
+		if expectedHash, err = torrent.ParseInfoHash(*expectHash); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -expect-hash: %v\n", err)
+			os.Exit(ExitUsage)
 		}
+	}
 
-		fmt.Printf("Total Size: %s\n", formatSize(totalSize))
-	} else {
-		fmt.Printf("Content: Single file (%s)\n", torrentFile.Info.Name)
-		fmt.Printf("Size: %s\n", formatSize(torrentFile.Info.Length))
+	if *pieceRange != "" && *byteRange != "" {
+		fmt.Fprintln(os.Stderr, "-pieces and -bytes cannot be used together")
+		os.Exit(ExitUsage)
 	}
 
-	fmt.Printf("Pieces: %d (each %s)\n",
-		torrentFile.NumPieces(),
-		formatSize(torrentFile.Info.PieceLength))
+	if *seedOnly && *noSeed {
+		fmt.Fprintln(os.Stderr, "-seed-only and -no-seed cannot be used together")
+		os.Exit(ExitUsage)
+	}
+
+	if repair && (*seedOnly || *assumeData) {
+		fmt.Fprintln(os.Stderr, "repair cannot be used with -seed-only or -assume-data")
+		os.Exit(ExitUsage)
+	}
+
+	if *quickCheck < 0 || *quickCheck > 100 || (*quickCheck > 0 && (add || fetchMeta)) {
+		fmt.Fprintln(os.Stderr, "-quick-check takes a percentage above 0 and at most 100 and cannot be used with add or fetch-meta")
+		os.Exit(ExitUsage)
+	}
+
+	if add && *stateFile == "" {
+		fmt.Fprintln(os.Stderr, "add needs -state to record the torrent in")
+		os.Exit(ExitUsage)
+	}
+
+	if fetchMeta && !torrent.IsMagnet(torrentPath) {
+		fmt.Fprintln(os.Stderr, "fetch-meta needs a magnet link")
+		os.Exit(ExitUsage)
+	}
+
+	if *saveTorrent != "" && (add || fetchMeta || !torrent.IsMagnet(torrentPath)) {
+		fmt.Fprintln(os.Stderr, "-save-torrent needs a magnet link to download; fetch-meta takes the file to save to as its second argument")
+		os.Exit(ExitUsage)
+	}
+
+	if *exportPeers != "" && (add || fetchMeta) {
+		fmt.Fprintln(os.Stderr, "-export-peers needs a torrent to download or serve")
+		os.Exit(ExitUsage)
+	}
+
+	if *importPeers != "" && add {
+		fmt.Fprintln(os.Stderr, "-import-peers cannot be used with add, which connects to no peers")
+		os.Exit(ExitUsage)
+	}
+
+	var imported []string
+	if *importPeers != "" {
+		imported, err = importPeerList(*importPeers, *exportPeers)
+		if err != nil {
+			exit("Error importing peers", err)
+		}
+	}
+
+	if *trustResume && (*stateFile == "" || repair) {
+		fmt.Fprintln(os.Stderr, "-trust-resume needs -state and cannot be used with repair")
+		os.Exit(ExitUsage)
+	}
+
+	if *notifyDesktop {
+		setupNotifications()
+	}
+
+	if serve {
+		*seedOnly = true
+		if *listen == "" {
+			*listen = fmt.Sprintf(":%d", *port)
+		}
+	}
+
+	tracker.DefaultResolver.Pin = *pinDNS
+	peer.SetRateLimits(*downloadLimit*1024, *uploadLimit*1024)
+	peer.SetSocketOptions(peer.SocketOptions{
+		NoDelay:     *tcpNoDelay,
+		KeepAlive:   *tcpKeepAlive,
+		ReadBuffer:  *tcpReadBuffer * 1024,
+		WriteBuffer: *tcpWriteBuffer * 1024,
+	})
+
+	// Anonymous mode only ever talks to the network through the proxy and
+	// doesn't name the client to peers
+	if *anonymous {
+		peer.SetClientVersion("")
+		if *listen != "" {
+			fmt.Fprintln(os.Stderr, "-anonymous cannot accept incoming connections (-listen, serve)")
+			os.Exit(ExitUsage)
+		}
+		if *proxy == "" {
+			*proxy = torProxyAddr
+		}
+	}
+
+	// The proxy resolves tracker hosts itself
+	if *dnsServer != "" {
+		if *proxy != "" {
+			fmt.Fprintln(os.Stderr, "-dns cannot be used with -proxy or -anonymous, the proxy resolves tracker hosts")
+			os.Exit(ExitUsage)
+		}
+
+		resolver, err := tracker.NewSecureResolver(*dnsServer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -dns: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		tracker.DefaultResolver.Lookup = resolver.LookupIPAddr
+	}
+
+	fetcher := torrent.NewFetcher()
+	if *proxy != "" {
+		dialer, err := parseProxy(*proxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -proxy: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+
+		tracker.SetProxy(dialer.DialContext)
+		peer.SetProxy(dialer.DialContext)
+		download.SetWebSeedProxy(dialer.DialContext)
+		fetcher.Client.Transport = &http.Transport{DialContext: dialer.DialContext}
+	}
+
+	// Private swarm mode tunnels every peer connection through TLS
+	if *swarmCert != "" || *swarmKey != "" {
+		config, err := peer.NewSwarmTLSConfig(*swarmCert, *swarmKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -swarm-cert/-swarm-key: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		peer.SetSwarmTLS(config)
+	}
 
 	// Generate peer ID
-	peerID, err := tracker.GeneratePeerID()
+	generatePeerID := tracker.GeneratePeerID
+	if *anonymous {
+		generatePeerID = tracker.GenerateAnonymousPeerID
+	}
+
+	peerID, err := generatePeerID()
 	if err != nil {
-		fmt.Printf("Error generating peer ID: %v\n", err)
-		os.Exit(1)
+		exit("Error generating peer ID", err)
+	}
+
+	// Magnet links name a torrent whose metadata is fetched from peers;
+	// "add" records them without fetching anything
+	var magnet *torrent.Magnet
+	if torrent.IsMagnet(torrentPath) {
+		magnet, err = torrent.ParseMagnet(torrentPath)
+		if err != nil {
+			fmt.Printf("Error parsing magnet link: %v\n", err)
+			os.Exit(ExitInvalidTorrent)
+		}
+		magnet.Peers = append(magnet.Peers, peers...)
+		magnet.Peers = append(magnet.Peers, imported...)
+
+		if add {
+			runAddMagnet(added, magnet)
+		}
+	}
+
+	if fetchMeta {
+		output := ""
+		if len(positional) >= 2 {
+			output = positional[len(positional)-1]
+		}
+		runFetchMeta(magnet, output, peerID, *port, *timeout)
+	}
+
+	// Parse the torrent file, through the metadata cache next to the state
+	// file. Cached metadata isn't encrypted, so an encrypted state skips it,
+	// and it was parsed accepting key aliases, so -strict does too.
+	parseOptions := torrent.ParseOptions{Strict: *strict}
+	parse := func(path string) (*torrent.TorrentFile, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return torrent.ParseBytesWithOptions(data, parseOptions)
+	}
+	if *stateFile != "" && os.Getenv(statePassphraseEnv) == "" && !*strict {
+		parse = state.NewMetaCache(filepath.Join(filepath.Dir(*stateFile), "metadata")).ParseFile
+	}
+
+	// Torrents given by URL are fetched every time, as they may change
+	if torrent.IsURL(torrentPath) {
+		fmt.Printf("Fetching %s\n", torrentPath)
+		fetcher.Options = parseOptions
+		parse = fetcher.Fetch
+	}
+
+	// "-" reads the torrent from stdin, e.g. piped from curl
+	if torrentPath == "-" {
+		parse = func(string) (*torrent.TorrentFile, error) {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			return torrent.ParseBytesWithOptions(data, parseOptions)
+		}
+	}
+
+	// Magnet links are fetched every time, there's no file to cache
+	var metainfo []byte
+	if magnet != nil {
+		parse = func(string) (*torrent.TorrentFile, error) {
+			metainfo, err = fetchMagnetMetadata(magnet, peerID, *port, *timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", torrent.ErrFetchFailed, err)
+			}
+			return torrent.ParseBytesWithOptions(metainfo, parseOptions)
+		}
+	}
+
+	torrentFile, err := parse(torrentPath)
+	if errors.Is(err, torrent.ErrFetchFailed) {
+		exit("Error fetching torrent file", err)
+	}
+	if err != nil {
+		fmt.Printf("Error parsing torrent file: %v\n", err)
+		os.Exit(ExitInvalidTorrent)
+	}
+
+	if *expectHash != "" {
+		if err := torrentFile.CheckInfoHash(expectedHash); err != nil {
+			exit("Refusing to start", err)
+		}
+	}
+	if magnet != nil {
+		if err := torrentFile.CheckInfoHash(magnet.InfoHash); err != nil {
+			exit("Error fetching metadata", err)
+		}
+		if *saveTorrent != "" {
+			if err := os.WriteFile(*saveTorrent, metainfo, 0644); err != nil {
+				exit("Error saving the torrent file", err)
+			}
+			fmt.Printf("Saved the torrent file to %s\n", *saveTorrent)
+		}
+	}
+
+	// Point trackers that moved to their new address
+	if n := rewriter.Rewrite(torrentFile); n > 0 {
+		fmt.Printf("Rewrote %d tracker URLs\n", n)
+		for _, stats := range rewriter.Stats() {
+			if stats.Fired > 0 {
+				fmt.Printf("  %s -> %s: %d\n", stats.Rule.Find, stats.Rule.Replace, stats.Fired)
+			}
+		}
+	}
+
+	printTorrentInfo(torrentPath, torrentFile)
+
+	if add {
+		runAdd(added, torrentFile)
 	}
 
 	// Create download manager
-	dm := download.NewDownloadManager(torrentFile, peerID, downloadPath, 50)
+	// Auto-tuning starts at the floor and works its way up
+	targetPeers := *maxPeers
+	if *autoPeers {
+		targetPeers = *minPeers
+	}
+
+	dm := download.NewDownloadManager(torrentFile, peerID, downloadPath, targetPeers)
+	dm.Metainfo = metainfo
+	dm.AutoTunePeers = *autoPeers
+	dm.PeerTuning.Floor = *minPeers
+	dm.PeerTuning.Ceiling = *maxPeers
+	dm.VerifyOnComplete = *verifyOnComplete
+	dm.AssumeData = *assumeData
+	dm.SeedOnly = *seedOnly
+	dm.NoSeed = *noSeed || repair
+	dm.UploadSlots = *uploadSlots
+	dm.Repair = repair
+	dm.SetListenPort(*port)
+	dm.WriteBufferSize = *writeBufferMB * 1024 * 1024
+	dm.InFlightBudget = int64(*inFlightMB) * 1024 * 1024
+	dm.PartialPieceBudget = int64(*pieceMemoryMB) * 1024 * 1024
+	dm.StallTimeout = *stallTimeout
+	dm.SlowPieceThreshold = *slowPiece
+	dm.NameCollisions, err = download.ParseNameCollisionPolicy(*onNameCollision)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -on-name-collision: %v\n", err)
+		os.Exit(ExitUsage)
+	}
+	dm.StopWhenStalled = *stopWhenStalled
+	dm.BandwidthWeight = *weight
+	dm.Sequential = *sequential
+	dm.SequentialWindow = download.SequentialWindow{Size: *sequentialWindow, Strict: *sequentialStrict}
+	if *webSeed != "" {
+		dm.WebSeeds = append(dm.WebSeeds, *webSeed)
+	}
+
+	// Ranges leave the rest of the payload on disk untouched
+	if *pieceRange != "" {
+		first, last, err := parseRange(*pieceRange)
+		if err == nil {
+			err = dm.SetPieceRange(int(first), int(last))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -pieces: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		fmt.Printf("Downloading pieces %d-%d\n", first, last)
+	}
+	if *byteRange != "" {
+		first, last, err := parseRange(*byteRange)
+		if err == nil {
+			err = dm.SetByteRange(first, last-first+1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -bytes: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		fmt.Printf("Downloading bytes %d-%d\n", first, last)
+	}
+
+	// Direct mode: no tracker, only the peers we were given
+	dm.ListenAddr = *listen
+	if serve || len(peers) > 0 {
+		dm.DisableTracker = true
+	}
+	if len(peers) > 0 {
+		manual := download.NewManualSource()
+		for _, addr := range peers {
+			if err := manual.Add(addr); err != nil {
+				fmt.Printf("Error adding peer: %v\n", err)
+				os.Exit(ExitUsage)
+			}
+		}
+		dm.PeerSources = append(dm.PeerSources, manual)
+	}
+	if len(imported) > 0 {
+		added, err := dm.ImportPeers(imported)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -import-peers: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+		fmt.Printf("Imported %d peers\n", added)
+	}
+
+	// Load the saved session state
+	var store *state.Store
+	var session *state.Session
+	if *stateFile != "" {
+		store = state.NewStore(*stateFile, os.Getenv(statePassphraseEnv))
+		session, err = store.Load()
+		if err != nil {
+			exit("Error loading state file", err)
+		}
+
+		saved := session.Find(hex.EncodeToString(torrentFile.InfoHash[:]))
+		if saved != nil {
+			fmt.Printf("Previously downloaded %s, uploaded %s\n", formatSize(saved.Downloaded), formatSize(saved.Uploaded))
+			dm.SetDisplayName(saved.DisplayName)
+			dm.RestoreTrackerBackoffs(restoreBackoffs(saved.TrackerBackoff))
+			source = source.KeepAddedAt(saved.Source)
+		}
+
+		if *trustResume {
+			if saved == nil || saved.Pieces == "" {
+				fmt.Printf("No resume data saved for this torrent\n")
+			} else {
+				pieces, err := hex.DecodeString(saved.Pieces)
+				if err != nil {
+					exit("Error loading resume data", err)
+				}
+				dm.TrustedPieces = peer.Bitfield(pieces)
+			}
+		}
+	}
+
+	saveState := func() {
+		if *exportPeers != "" {
+			if err := writePeerList(*exportPeers, dm.KnownPeers()); err != nil {
+				fmt.Printf("%sFailed to export peers: %v\n", clearLine, err)
+			}
+		}
+		if store == nil {
+			return
+		}
+
+		session.Update(torrentState(torrentFile, torrentPath, source, downloadPath, dm))
+		if err := store.Save(session); err != nil {
+			fmt.Printf("%sFailed to save state: %v\n", clearLine, err)
+		}
+	}
+
+	// The display name given on the command line replaces the saved one
+	if *displayName != "" {
+		dm.SetDisplayName(*displayName)
+	}
+	dm.OnDisplayNameSet = func(string) { saveState() }
+
+	// Trace the steps of every piece
+	var exporter *tracing.Exporter
+	if *otlpEndpoint != "" {
+		exporter, err = tracing.NewExporter(*otlpEndpoint, "go-torrent")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -otlp: %v\n", err)
+			os.Exit(ExitUsage)
+		}
+
+		dm.OnPieceTimings = func(t download.PieceTimings) {
+			exporter.Export(pieceSpans(t, torrentFile.InfoHash)...)
+		}
+	}
 
 	// Handle Ctrl+C gracefully
 	sigChan := make(chan os.Signal, 1)
@@ -93,11 +640,56 @@ func main() {
 
 	go func() {
 		<-sigChan
-		fmt.Printf("\nShutting down...\n")
-		dm.Stop()
-		os.Exit(0)
+		fmt.Printf("\n")
+		if err := shutdown(dm, *shutdownTimeout, sigChan); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+		saveState()
+		if exporter != nil {
+			exporter.Close()
+		}
+
+		if dm.IsComplete() {
+			os.Exit(ExitCompleted)
+		}
+		os.Exit(ExitCancelled)
 	}()
 
+	// SIGHUP re-reads the configuration and switches to its current profile
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			if err := reloadProfile(*configPath, "", dm, downloadPath); err != nil {
+				fmt.Printf("%sNot switching profile: %v\n", clearLine, err)
+			}
+		}
+	}()
+
+	// SIGUSR1 announces to the tracker right away and SIGUSR2 checks the
+	// data on disk again, e.g. after its files were edited
+	if reannounceSignal != nil {
+		forceChan := make(chan os.Signal, 1)
+		signal.Notify(forceChan, reannounceSignal, recheckSignal)
+
+		go func() {
+			for sig := range forceChan {
+				if sig == reannounceSignal {
+					fmt.Printf("%sRe-announcing to the tracker\n", clearLine)
+					dm.ForceReannounce()
+					continue
+				}
+
+				go func() {
+					if err := dm.ForceRecheck(); err != nil {
+						fmt.Printf("%sRecheck failed: %v\n", clearLine, err)
+					}
+				}()
+			}
+		}()
+	}
+
 	// Set up callbacks
 	completedPieces := make(map[int]bool)
 	dm.OnPieceCompleted = func(index int) {
@@ -106,7 +698,86 @@ func main() {
 	}
 
 	dm.OnDownloadComplete = func() {
-		fmt.Printf("\n%sDownload complete!\n", clearLine)
+		if repair {
+			report := dm.RepairReport()
+			fmt.Printf("\n%sRepaired %d pieces: %s\n", clearLine, len(report.Fixed), formatPieces(report.Fixed))
+		} else {
+			fmt.Printf("\n%sDownload complete!\n", clearLine)
+		}
+
+		// Callbacks run with the download manager locked
+		go func() {
+			saveState()
+			if repair {
+				desktopNotify("Repair complete", dm.DisplayName())
+			} else {
+				desktopNotify("Download complete", dm.DisplayName())
+			}
+		}()
+	}
+
+	dm.OnSeedingStopped = func() {
+		dm.Stop()
+		saveState()
+		os.Exit(ExitCompleted)
+	}
+
+	dm.OnStorageError = func(err error) {
+		fmt.Printf("\n%sDownload paused, cannot write to disk: %v\n", clearLine, err)
+
+		// A full disk will not recover on its own
+		if exitCode(err) == ExitDiskFull {
+			go func() {
+				dm.Stop()
+				saveState()
+				desktopNotify("go-torrent", fmt.Sprintf("Download stopped, cannot write to disk: %v", err))
+				os.Exit(ExitDiskFull)
+			}()
+		}
+	}
+
+	trackerFailures := 0
+	dm.OnTrackerError = func(err error) {
+		if !errors.Is(err, tracker.ErrTrackerUnreachable) {
+			trackerFailures = 0
+			return
+		}
+
+		trackerFailures++
+		if trackerFailures >= maxTrackerFailures && dm.GetStats().ActivePeers == 0 && !dm.IsComplete() {
+			if dns := tracker.DefaultResolver.Stats(); dns.Failures > 0 {
+				fmt.Printf("%d of %d tracker DNS lookups failed\n", dns.Failures, dns.Lookups)
+			}
+			dm.Stop()
+			exit("Giving up", err)
+		}
+	}
+
+	dm.OnAborted = func(err error) {
+		fmt.Printf("\n%s", clearLine)
+		saveState()
+		exit("Download aborted", err)
+	}
+
+	dm.OnStalled = func(err error) {
+		fmt.Printf("\n%sDownload stalled, the connected peers lack pieces we need: %v\n", clearLine, err)
+		if dm.StopWhenStalled {
+			saveState()
+			exit("Download stopped", err)
+		}
+	}
+
+	dm.OnCheckProgress = func(checked, total int) {
+		if checked == total || checked%max(total/100, 1) == 0 {
+			fmt.Printf("%sChecking pieces: %d/%d (%.0f%%)", clearLine, checked, total, float64(checked)*100/float64(total))
+		}
+		if checked == total {
+			fmt.Println()
+		}
+	}
+
+	dm.OnVerifiedComplete = func() {
+		fmt.Printf("%sAll pieces verified against the data on disk\n", clearLine)
 	}
 
 	var lastSpeedDisplay float64
@@ -161,21 +832,343 @@ func main() {
 		completed := int(float64(width) * stats.Progress / 100.0)
 		bar := strings.Repeat("█", completed) + strings.Repeat("░", width-completed)
 
-		fmt.Printf("%s[%s] %.1f%% | %s | Peers: %d | ETA: %s",
-			clearLine, bar, stats.Progress, speedStr, stats.ActivePeers, etaStr)
+		var countries string
+		if dm.GeoIP != nil && stats.ActivePeers > 0 {
+			countries = " " + formatCountries(dm.CountPeersByCountry())
+		}
+		if snubbed := dm.CountPeersByState()[peer.StateSnubbed]; snubbed > 0 {
+			countries += fmt.Sprintf(" (%d snubbed)", snubbed)
+		}
+
+		// Fewer than one distributed copy means the peers can't complete us
+		if stats.ActivePeers > 0 && stats.Progress < 100 {
+			countries += fmt.Sprintf(" | Avail: %.2f", stats.Availability)
+		}
+
+		// Disk activity tells a slow disk apart from a slow swarm
+		var disk string
+		if stats.DiskWriteRate > 0 || stats.Disk.QueuedPieces > 0 {
+			disk = fmt.Sprintf(" | Disk: %s/s", formatSize(stats.DiskWriteRate))
+			if stats.Disk.QueuedPieces > 0 {
+				disk += fmt.Sprintf(" (%d queued)", stats.Disk.QueuedPieces)
+			}
+			if stats.Throttled {
+				disk += " (throttled)"
+			}
+		}
+
+		fmt.Printf("%s[%s] %.1f%% | %s | Peers: %d%s%s | ETA: %s",
+			clearLine, bar, stats.Progress, speedStr, stats.ActivePeers, countries, disk, etaStr)
 	}
 
-	// Start download
-	fmt.Printf("\nStarting download to %s...\n", downloadPath)
-	if err := dm.Start(); err != nil {
-		fmt.Printf("Failed to start download: %v\n", err)
-		os.Exit(1)
+	for _, setup := range setupHooks {
+		if err := setup(dm); err != nil {
+			exit("Error setting up download", err)
+		}
+	}
+
+	// A quick check only samples the data, to decide whether a full check
+	// or a repair is worth it
+	if *quickCheck > 0 {
+		runQuickCheck(dm, downloadPath, *quickCheck)
+	}
+
+	// The control service wraps the callbacks set above
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			exit("Failed to listen for gRPC clients", err)
+		}
+
+		server := control.NewServer(dm)
+		server.Source = &control.Source{Kind: source.Kind, URI: source.URI, AddedAt: source.AddedAt}
+		server.OnSwitchProfile = func(name string) error {
+			return reloadProfile(*configPath, name, dm, downloadPath)
+		}
+		go server.Serve(listener)
+		fmt.Printf("Serving gRPC control service on %s\n", listener.Addr())
+	}
+
+	// Start download, within the time limit if there is one
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if repair {
+		fmt.Printf("\nRepairing data in %s...\n", downloadPath)
+	} else {
+		fmt.Printf("\nStarting download to %s...\n", downloadPath)
+	}
+	if err := dm.StartContext(ctx); err != nil {
+		exit("Failed to start download", err)
+	}
+	fmt.Printf("Saving to %s\n", dm.SavePath())
+
+	if repair && dm.IsComplete() {
+		fmt.Printf("All pieces are intact, nothing to repair\n")
+		dm.Stop()
+		os.Exit(ExitCompleted)
 	}
 
 	// Wait forever (shutdown happens through signal handler)
 	select {}
 }
 
+// printTorrentInfo shows what a torrent holds before it is added or
+// downloaded
+func printTorrentInfo(torrentPath string, torrentFile *torrent.TorrentFile) {
+	if torrentPath == "-" {
+		fmt.Printf("Torrent: %s (stdin)\n", torrentFile.Info.Name)
+	} else {
+		fmt.Printf("Torrent: %s\n", filepath.Base(torrentPath))
+	}
+	fmt.Printf("Announce URL: %s\n", torrentFile.Announce)
+
+	if torrentFile.Info.IsDirectory {
+		fmt.Printf("Content: Directory (%s) with %d files\n", torrentFile.Info.Name, len(torrentFile.Info.Files))
+
+		// Display some file information (limit to 5 files to avoid cluttering the screen)
+		var totalShown int
+		var totalSize int64
+
+		for i, file := range torrentFile.Info.Files {
+			if i < 5 {
+				fmt.Printf("  File %d: %s (%s)\n",
+					i+1,
+					filepath.Join(file.Path...),
+					formatSize(file.Length))
+				totalShown++
+			}
+			totalSize += file.Length
+		}
+
+		if totalShown < len(torrentFile.Info.Files) {
+			remaining := len(torrentFile.Info.Files) - totalShown
+			fmt.Printf("  ... and %d more files\n", remaining)
+		}
+
+		fmt.Printf("Total Size: %s\n", formatSize(totalSize))
+	} else {
+		fmt.Printf("Content: Single file (%s)\n", torrentFile.Info.Name)
+		fmt.Printf("Size: %s\n", formatSize(torrentFile.Info.Length))
+	}
+
+	fmt.Printf("Pieces: %d (each %s)\n",
+		torrentFile.NumPieces(),
+		formatSize(torrentFile.Info.PieceLength))
+}
+
+// formatPieces lists piece indexes, joining consecutive ones into ranges,
+// e.g. "3-5, 9"
+func formatPieces(pieces []int) string {
+	sorted := append([]int(nil), pieces...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+
+		if i == j {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatCountries summarizes peer counts by country, most peers first,
+// e.g. "(DE 3, US 2, ?? 1)"
+func formatCountries(counts map[string]int) string {
+	countries := make([]string, 0, len(counts))
+	for country := range counts {
+		countries = append(countries, country)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		if counts[countries[i]] != counts[countries[j]] {
+			return counts[countries[i]] > counts[countries[j]]
+		}
+		return countries[i] < countries[j]
+	})
+
+	parts := make([]string, len(countries))
+	for i, country := range countries {
+		name := country
+		if name == "" {
+			name = "??"
+		}
+		parts[i] = fmt.Sprintf("%s %d", name, counts[country])
+	}
+
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseRange parses an inclusive range given as first-last, or as a single
+// number for a range of one
+func parseRange(value string) (int64, int64, error) {
+	firstStr, lastStr, found := strings.Cut(value, "-")
+	if !found {
+		lastStr = firstStr
+	}
+
+	first, err := strconv.ParseInt(firstStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range start in %q", value)
+	}
+	last, err := strconv.ParseInt(lastStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range end in %q", value)
+	}
+	if first < 0 || last < first {
+		return 0, 0, fmt.Errorf("range %q is empty", value)
+	}
+
+	return first, last, nil
+}
+
+// parseProxy parses a SOCKS5 proxy given as [user:pass@]host:port
+func parseProxy(value string) (*socks.Dialer, error) {
+	u, err := url.Parse("socks5://" + value)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("missing port in %q", value)
+	}
+
+	dialer := socks.NewDialer(u.Host)
+	if u.User != nil {
+		dialer.Username = u.User.Username()
+		dialer.Password, _ = u.User.Password()
+	}
+
+	return dialer, nil
+}
+
+// savedTorrent returns the torrent saved in the state file under an info
+// hash, if it can be added again from its source
+func savedTorrent(stateFile string, infoHash [20]byte) (*state.Torrent, error) {
+	session, err := state.NewStore(stateFile, os.Getenv(statePassphraseEnv)).Load()
+	if err != nil {
+		return nil, err
+	}
+
+	saved := session.Find(hex.EncodeToString(infoHash[:]))
+	switch {
+	case saved == nil:
+		return nil, fmt.Errorf("not in the state file")
+	case !saved.Source.Readdable():
+		return nil, fmt.Errorf("it wasn't added from a file or URL")
+	}
+
+	return saved, nil
+}
+
+// torrentState captures the state of a download for the state file
+func torrentState(torrentFile *torrent.TorrentFile, torrentPath string, source state.Source, downloadPath string, dm *download.DownloadManager) state.Torrent {
+	var displayName string
+	if name := dm.DisplayName(); name != torrentFile.Info.Name {
+		displayName = name
+	}
+
+	stats := dm.GetStats()
+	return state.Torrent{
+		InfoHash:     hex.EncodeToString(torrentFile.InfoHash[:]),
+		Name:         torrentFile.Info.Name,
+		DisplayName:  displayName,
+		TorrentPath:  torrentPath,
+		Source:       &source,
+		DownloadPath: downloadPath,
+		Trackers:     trackerList(torrentFile),
+		Downloaded:   stats.Downloaded,
+		Uploaded:     stats.Uploaded,
+		Completed:    dm.PieceManager.IsComplete(),
+		Pieces:       hex.EncodeToString(dm.PieceManager.Bitfield()),
+		UpdatedAt:    time.Now(),
+
+		TrackerBackoff: saveBackoffs(dm.TrackerBackoffs()),
+	}
+}
+
+// saveBackoffs converts tracker backoffs for the state file
+func saveBackoffs(backoffs []download.TrackerBackoff) []state.TrackerBackoff {
+	var saved []state.TrackerBackoff
+	for _, b := range backoffs {
+		saved = append(saved, state.TrackerBackoff{
+			URL:          b.URL,
+			LastAnnounce: b.LastAnnounce,
+			Interval:     int(b.Interval / time.Second),
+			MinInterval:  int(b.MinInterval / time.Second),
+			Failures:     b.Failures,
+		})
+	}
+	return saved
+}
+
+// restoreBackoffs converts tracker backoffs read from the state file
+func restoreBackoffs(saved []state.TrackerBackoff) []download.TrackerBackoff {
+	var backoffs []download.TrackerBackoff
+	for _, b := range saved {
+		backoffs = append(backoffs, download.TrackerBackoff{
+			URL:          b.URL,
+			LastAnnounce: b.LastAnnounce,
+			Interval:     time.Duration(b.Interval) * time.Second,
+			MinInterval:  time.Duration(b.MinInterval) * time.Second,
+			Failures:     b.Failures,
+		})
+	}
+	return backoffs
+}
+
+// trackerList returns the primary tracker of a torrent followed by every
+// tracker of its announce list
+func trackerList(torrentFile *torrent.TorrentFile) []string {
+	trackers := []string{torrentFile.Announce}
+	for _, tier := range torrentFile.AnnounceList {
+		trackers = append(trackers, tier...)
+	}
+	return trackers
+}
+
 // formatSize formats a byte size into a human-readable format
 func formatSize(bytes int64) string {
 	const (
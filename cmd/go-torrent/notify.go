@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/piyushgupta53/go-torrent/internal/notify"
+)
+
+// notifier shows desktop notifications with -notify, nil otherwise
+var notifier notify.Notifier
+
+// setupNotifications enables desktop notifications when go-torrent runs in
+// the foreground of a terminal, where someone is there to see them; run
+// from a service or a script it prints everything as usual
+func setupNotifications() {
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+
+	notifier, err = notify.New()
+	if err != nil {
+		fmt.Printf("Not showing desktop notifications: %v\n", err)
+	}
+}
+
+// desktopNotify shows a desktop notification when they are enabled. It
+// waits for the OS to take it, so call it outside of callbacks.
+func desktopNotify(title, message string) {
+	if notifier == nil {
+		return
+	}
+
+	if err := notifier.Notify(title, message); err != nil {
+		fmt.Printf("%sFailed to show desktop notification: %v\n", clearLine, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readPeerList reads a peer list written by writePeerList or by hand: one
+// host:port per line, with blank lines and lines starting with # ignored
+func readPeerList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
+
+// writePeerList replaces the peer list at path with addrs, one per line.
+// The list is written to a temporary file first, so an interrupted write
+// leaves the previous list.
+func writePeerList(path string, addrs []string) error {
+	var b strings.Builder
+	for _, addr := range addrs {
+		b.WriteString(addr)
+		b.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".peers-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// importPeerList reads the peers of -import-peers. A missing list is
+// empty when it is also the -export-peers list, so the same file carries
+// the peers from one run to the next.
+func importPeerList(path, exportPath string) ([]string, error) {
+	addrs, err := readPeerList(path)
+	if errors.Is(err, os.ErrNotExist) && path == exportPath {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer list: %w", err)
+	}
+	return addrs, nil
+}
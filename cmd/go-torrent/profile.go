@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/piyushgupta53/go-torrent/internal/control"
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// Profile holds the settings that depend on where the client runs, such as
+// "home", "seedbox" or "mobile-hotspot". Zero values leave the command line
+// defaults in place.
+type Profile struct {
+	DownloadLimit int64  `json:"download_limit"` // KB/s, 0 for unlimited
+	UploadLimit   int64  `json:"upload_limit"`   // KB/s, 0 for unlimited
+	MaxPeers      int    `json:"max_peers"`
+	MinPeers      int    `json:"min_peers"`
+	DownloadDir   string `json:"download_dir"`
+	StateFile     string `json:"state_file"`
+
+	// TCP options of peer connections
+	TCPNoDelay     *bool  `json:"tcp_nodelay"`
+	TCPKeepAlive   string `json:"tcp_keepalive"`    // Duration such as "30s"; negative disables
+	TCPReadBuffer  int    `json:"tcp_read_buffer"`  // KB, 0 for the OS default
+	TCPWriteBuffer int    `json:"tcp_write_buffer"` // KB, 0 for the OS default
+}
+
+// Config is the configuration file
+type Config struct {
+	Profile  string             `json:"profile"` // Profile used when -profile isn't given
+	Profiles map[string]Profile `json:"profiles"`
+
+	// TrackerRewrites are applied in order to the trackers of every torrent
+	// when it is loaded
+	TrackerRewrites []torrent.RewriteRule `json:"tracker_rewrites"`
+}
+
+// defaultConfigPath returns the configuration file used when -config isn't given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-torrent", "config.json")
+}
+
+// loadConfig reads the configuration file. A missing or empty file is an
+// empty configuration.
+func loadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// lookup returns the named profile; an empty name selects no profile
+func (c *Config) lookup(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("%w %q (configured: %s)", control.ErrUnknownProfile, name, strings.Join(names, ", "))
+	}
+
+	return profile, nil
+}
+
+// applyToFlags sets the flags the profile covers, except those given on the
+// command line, which take precedence
+func (p Profile) applyToFlags(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	set := func(name, value string, ok bool) {
+		if ok && !given[name] && err == nil {
+			if setErr := fs.Set(name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", name, setErr)
+			}
+		}
+	}
+
+	set("download-limit", strconv.FormatInt(p.DownloadLimit, 10), p.DownloadLimit > 0)
+	set("upload-limit", strconv.FormatInt(p.UploadLimit, 10), p.UploadLimit > 0)
+	set("max-peers", strconv.Itoa(p.MaxPeers), p.MaxPeers > 0)
+	set("min-peers", strconv.Itoa(p.MinPeers), p.MinPeers > 0)
+	set("state", p.StateFile, p.StateFile != "")
+	set("tcp-nodelay", strconv.FormatBool(p.TCPNoDelay != nil && *p.TCPNoDelay), p.TCPNoDelay != nil)
+	set("tcp-keepalive", p.TCPKeepAlive, p.TCPKeepAlive != "")
+	set("tcp-read-buffer", strconv.Itoa(p.TCPReadBuffer), p.TCPReadBuffer > 0)
+	set("tcp-write-buffer", strconv.Itoa(p.TCPWriteBuffer), p.TCPWriteBuffer > 0)
+
+	return err
+}
+
+// reloadProfile re-reads the configuration and switches a running download
+// to one of its profiles, "" for its "profile" entry
+func reloadProfile(configPath, name string, dm *download.DownloadManager, downloadPath string) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = config.Profile
+	}
+
+	profile, err := config.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	switchProfile(name, profile, dm, downloadPath)
+	return nil
+}
+
+// switchProfile applies a profile to a running download. Rate limits and
+// the peer cap change immediately; directories only apply to the next run.
+func switchProfile(name string, p Profile, dm *download.DownloadManager, downloadPath string) {
+	peer.SetRateLimits(p.DownloadLimit*1024, p.UploadLimit*1024)
+	if p.MaxPeers > 0 {
+		dm.SetMaxPeers(p.MaxPeers)
+	}
+
+	fmt.Printf("%sSwitched to profile %q: download %s, upload %s, %d peers\n",
+		clearLine, name, formatLimit(p.DownloadLimit), formatLimit(p.UploadLimit), dm.MaxPeers())
+
+	if p.DownloadDir != "" && p.DownloadDir != downloadPath {
+		fmt.Printf("Download directory %s applies from the next start\n", p.DownloadDir)
+	}
+}
+
+// formatLimit formats a rate limit in KB/s
+func formatLimit(kbps int64) string {
+	if kbps <= 0 {
+		return "unlimited"
+	}
+	return formatSize(kbps*1024) + "/s"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+)
+
+// maxBadPiecesShown is how many failed pieces a quick check lists
+const maxBadPiecesShown = 20
+
+// runQuickCheck hashes percent of the pieces of the existing data in
+// downloadPath, reports how much of it is estimated to be intact and exits:
+// with ExitCompleted when every sampled piece passed and ExitError otherwise
+func runQuickCheck(dm *download.DownloadManager, downloadPath string, percent float64) {
+	fs, err := download.OpenExistingStorage(dm.Torrent, downloadPath)
+	if err != nil {
+		exit("Quick check failed", err)
+	}
+	dm.Storage = fs
+
+	fmt.Printf("\nQuick check of %g%% of the pieces in %s...\n", percent, downloadPath)
+	result, err := dm.QuickCheck(percent)
+	fs.Close()
+	if err != nil {
+		exit("Quick check failed", err)
+	}
+
+	fmt.Printf("%d of %d sampled pieces (of %d) are intact, estimated integrity %.1f%%\n",
+		len(result.Checked)-len(result.Bad), len(result.Checked), result.Total, result.Intact())
+	if len(result.Bad) == 0 {
+		os.Exit(ExitCompleted)
+	}
+
+	shown := result.Bad
+	if len(shown) > maxBadPiecesShown {
+		shown = shown[:maxBadPiecesShown]
+	}
+	fmt.Printf("Corrupt pieces: %v", shown)
+	if len(shown) < len(result.Bad) {
+		fmt.Printf(" and %d more", len(result.Bad)-len(shown))
+	}
+	fmt.Println()
+	exit("Quick check failed", fmt.Errorf("%w: %d sampled pieces are corrupt, run repair to check and fix every piece",
+		download.ErrVerificationFailed, len(result.Bad)))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+)
+
+// spinnerFrames animate the line showing the shutdown step
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// shutdown stops the download politely within timeout, and at once on
+// the next signal from sigChan, showing a spinner with the current step
+func shutdown(dm *download.DownloadManager, timeout time.Duration, sigChan <-chan os.Signal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var mu sync.Mutex
+	step := download.ShutdownClosingPeers
+	progress := func(s download.ShutdownStep) {
+		mu.Lock()
+		step = s
+		mu.Unlock()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- dm.Shutdown(ctx, progress) }()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		mu.Lock()
+		current := step
+		mu.Unlock()
+		fmt.Printf("%s%c Shutting down: %s...", clearLine, spinnerFrames[frame%len(spinnerFrames)], current)
+
+		select {
+		case err := <-done:
+			fmt.Printf("%sShut down\n", clearLine)
+			return err
+		case <-ticker.C:
+		}
+	}
+}
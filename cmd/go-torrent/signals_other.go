@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reannounceSignal and recheckSignal are not supported on this platform
+var (
+	reannounceSignal os.Signal
+	recheckSignal    os.Signal
+)
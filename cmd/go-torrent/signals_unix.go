@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reannounceSignal makes a running download announce to its tracker right
+// away and recheckSignal makes it check its data on disk again
+var (
+	reannounceSignal os.Signal = syscall.SIGUSR1
+	recheckSignal    os.Signal = syscall.SIGUSR2
+)
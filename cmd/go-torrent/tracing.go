@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/tracing"
+)
+
+// pieceSpans turns the timings of a piece into a trace: a span for the
+// whole piece, with a child span for each step
+func pieceSpans(t download.PieceTimings, infoHash [20]byte) []tracing.Span {
+	traceID := tracing.NewTraceID()
+	root := tracing.Span{
+		TraceID: traceID,
+		SpanID:  tracing.NewSpanID(),
+		Name:    "piece",
+		Start:   t.FirstBlock,
+		End:     t.HaveEnd,
+		Attributes: []tracing.Attribute{
+			{Key: "torrent.info_hash", Value: hex.EncodeToString(infoHash[:])},
+			{Key: "piece.index", Value: t.Index},
+			{Key: "piece.length", Value: t.Length},
+			{Key: "piece.peers", Value: t.Peers},
+		},
+	}
+
+	var failed string
+	if !t.Verified {
+		failed = "hash check failed"
+		root.End = t.VerifyEnd
+		root.Error = failed
+	}
+
+	spans := []tracing.Span{root}
+	step := func(name string, start, end time.Time, err string) {
+		spans = append(spans, tracing.Span{
+			TraceID:  traceID,
+			SpanID:   tracing.NewSpanID(),
+			ParentID: root.SpanID,
+			Name:     name,
+			Start:    start,
+			End:      end,
+			Error:    err,
+		})
+	}
+
+	step("receive", t.FirstBlock, t.LastBlock, "")
+	step("queue", t.LastBlock, t.VerifyStart, "")
+	step("verify", t.VerifyStart, t.VerifyEnd, failed)
+	if t.Verified {
+		step("write", t.VerifyEnd, t.WriteEnd, "")
+		step("complete", t.WriteEnd, t.HaveStart, "")
+		step("broadcast-have", t.HaveStart, t.HaveEnd, "")
+	}
+	return spans
+}
@@ -1,3 +1,3 @@
 module github.com/piyushgupta53/go-torrent
 
-go 1.23.1
+go 1.24
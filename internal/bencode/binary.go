@@ -0,0 +1,26 @@
+package bencode
+
+import (
+	"bufio"
+	"io"
+)
+
+// DecodeBinary reads one bencoded value like Decode, but returns strings
+// as []byte rather than string, for binary data such as the pieces of a
+// torrent or compact peer lists. Dictionary keys stay strings. Encode
+// writes the []byte values back byte for byte.
+func DecodeBinary(r io.Reader) (interface{}, error) {
+	d := &decoder{r: bufio.NewReader(r), binary: true}
+
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+
+	return d.decodeNext()
+}
+
+// SetBinary makes Decode, and Unmarshal into an interface{}, return
+// strings as []byte, as DecodeBinary does, or as string again
+func (dec *Decoder) SetBinary(binary bool) {
+	dec.d.binary = binary
+}
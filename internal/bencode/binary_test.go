@@ -0,0 +1,66 @@
+package bencode
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBinary(t *testing.T) {
+	// Pieces that aren't valid UTF-8, and a long string read in chunks
+	pieces := "\xff\x00\xfe\x80" + strings.Repeat("\xc3", stringChunkSize+1)
+	input := "d4:infod6:lengthi8e6:pieces" + strconv.Itoa(len(pieces)) + ":" + pieces + "e5:peersl6:\x7f\x00\x00\x01\x1a\xe1ee"
+
+	v, err := DecodeBinary(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("DecodeBinary() error = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"info": map[string]interface{}{
+			"length": int64(8),
+			"pieces": []byte(pieces),
+		},
+		"peers": []interface{}{[]byte("\x7f\x00\x00\x01\x1a\xe1")},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("DecodeBinary() = %v, want every string as []byte", v)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if buf.String() != input {
+		t.Error("Encode() of the decoded value differs from the input")
+	}
+}
+
+func TestDecoderSetBinary(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("3:\x00\x01\x02d4:data2:\xff\xfe4:name4:teste3:abc"))
+	dec.SetBinary(true)
+
+	v, err := dec.Decode()
+	if err != nil || !reflect.DeepEqual(v, []byte{0, 1, 2}) {
+		t.Fatalf("Decode() = %#v, %v, want []byte{0, 1, 2}", v, err)
+	}
+
+	// Generic fields follow the mode, typed fields their type
+	var s struct {
+		Data interface{} `bencode:"data"`
+		Name string      `bencode:"name"`
+	}
+	if err := dec.Unmarshal(&s); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(s.Data, []byte{0xff, 0xfe}) || s.Name != "test" {
+		t.Errorf("Unmarshal() = %#v, want Data as []byte", s)
+	}
+
+	dec.SetBinary(false)
+	if v, err := dec.Decode(); err != nil || v != "abc" {
+		t.Errorf("Decode() = %#v, %v after SetBinary(false), want \"abc\"", v, err)
+	}
+}
@@ -2,11 +2,11 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 )
 
 // Common errors
@@ -16,119 +16,277 @@ var (
 	ErrStringLength   = errors.New("invalid string length")
 )
 
+// SyntaxError describes where decoding failed. It wraps ErrInvalidBencode,
+// ErrIntegerFormat or ErrStringLength, io.ErrUnexpectedEOF when the input
+// ends early, ErrNotCanonical when strict decoding rejects it, or
+// ErrLimitExceeded when it goes past the limits of the decoder.
+type SyntaxError struct {
+	Offset int64  // Byte offset of the offending token in the input
+	Msg    string // What was expected and what was found
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// decoder reads bencoded values and keeps track of the offset in the input
+type decoder struct {
+	r       *bufio.Reader
+	offset  int64
+	capture *bytes.Buffer // Collects the bytes consumed while set, see DecodeRaw
+	strict  bool          // Reject unsorted and duplicate keys, see DecodeStrict
+	binary  bool          // Strings are []byte, see DecodeBinary
+
+	limits   Limits // See DecodeLimited
+	depth    int    // Lists and dictionaries the decoder is inside of
+	elements int    // Values read so far
+}
+
+// Decode reads one bencoded value. Empty input returns io.EOF; malformed
+// input returns a *SyntaxError. Non-canonical input is tolerated, see
+// DecodeStrict.
 func Decode(r io.Reader) (interface{}, error) {
-	br := bufio.NewReader(r)
+	d := &decoder{r: bufio.NewReader(r)}
+
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
 
-	return decodeNext(br)
+	return d.decodeNext()
 }
 
-func decodeNext(r *bufio.Reader) (interface{}, error) {
-	// peek the first byte to determine the type
-	b, err := r.Peek(1)
+// syntaxError returns a SyntaxError at the given offset
+func (d *decoder) syntaxError(offset int64, err error, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: offset, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+// peek returns the next byte without consuming it. The end of the input is
+// reported as a SyntaxError expecting what.
+func (d *decoder) peek(what string) (byte, error) {
+	b, err := d.r.Peek(1)
+	if err == io.EOF {
+		return 0, d.syntaxError(d.offset, io.ErrUnexpectedEOF, "unexpected end of input, expected %s", what)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// readByte consumes the next byte. The end of the input is reported as a
+// SyntaxError expecting what.
+func (d *decoder) readByte(what string) (byte, error) {
+	b, err := d.peek(what)
+	if err != nil {
+		return 0, err
+	}
+	if d.limits.MaxSize > 0 && d.offset >= d.limits.MaxSize {
+		return 0, d.syntaxError(d.offset, ErrLimitExceeded, "input longer than %d bytes", d.limits.MaxSize)
+	}
+
+	d.r.ReadByte()
+	d.offset++
+	if d.capture != nil {
+		d.capture.WriteByte(b)
+	}
+	return b, nil
+}
+
+// sink returns where the bytes of skipped strings go: the capture buffer
+// while capturing, nowhere otherwise
+func (d *decoder) sink() io.Writer {
+	if d.capture != nil {
+		return d.capture
+	}
+	return io.Discard
+}
+
+// describe names a byte for an error message
+func describe(b byte) string {
+	return strconv.QuoteRune(rune(b))
+}
 
+func (d *decoder) decodeNext() (interface{}, error) {
+	// peek the first byte to determine the type
+	b, err := d.peek("a value")
 	if err != nil {
 		return nil, err
 	}
 
 	switch {
-	case b[0] >= '0' && b[0] <= 9:
-		return decodeString(r)
-	case b[0] == 'i':
-		return decodeInteger(r)
-	case b[0] == 'l':
-		return decodeList(r)
-	case b[0] == 'd':
-		return decodeDict(r)
+	case b >= '0' && b <= '9' && d.binary:
+		return d.readString()
+	case b >= '0' && b <= '9':
+		return d.decodeString()
+	case b == 'i':
+		return d.decodeInteger()
+	case b == 'l':
+		return d.decodeList()
+	case b == 'd':
+		return d.decodeDict()
 	default:
-		return nil, ErrInvalidBencode
+		return nil, d.syntaxError(d.offset, ErrInvalidBencode,
+			"expected a string, integer, list or dictionary, found %s", describe(b))
 	}
 }
 
-func decodeString(r *bufio.Reader) (string, error) {
-	// Read digits until we hit a colon
-	lengthStr, err := readUntil(r, ':')
-
+// e.g. 4:spam
+func (d *decoder) decodeString() (string, error) {
+	b, err := d.readString()
 	if err != nil {
 		return "", err
 	}
+	return string(b), nil
+}
 
-	// convert length string into an integer
-	length, err := strconv.Atoi(lengthStr)
+// readString reads a string as the bytes it holds
+func (d *decoder) readString() ([]byte, error) {
+	start := d.offset
+	length, err := d.stringLength()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Read exactly length bytes
-	stringBytes := make([]byte, length)
-	_, err = io.ReadFull(r, stringBytes)
-
+	// Read exactly length bytes. A long string is read in as it arrives,
+	// so a length prefix far beyond the input allocates nothing.
+	var stringBytes []byte
+	var n int
+	if length <= stringChunkSize {
+		stringBytes = make([]byte, length)
+		n, err = io.ReadFull(d.r, stringBytes)
+	} else {
+		var buf bytes.Buffer
+		var copied int64
+		copied, err = io.CopyN(&buf, d.r, int64(length))
+		stringBytes, n = buf.Bytes(), int(copied)
+	}
+	d.offset += int64(n)
+	if d.capture != nil {
+		d.capture.Write(stringBytes[:n])
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, d.syntaxError(start, io.ErrUnexpectedEOF, "string of length %d ends after %d bytes", length, n)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(stringBytes), nil
+	return stringBytes, nil
 }
 
-// e.g. 4:spam
-func readUntil(r *bufio.Reader, delimiter byte) (string, error) {
-	var result []byte
+// stringChunkSize is the longest string allocated whole before it is read
+const stringChunkSize = 64 * 1024
 
-	for {
-		b, err := r.ReadByte()
+// stringLength reads the length of a string and the colon after it, and
+// checks it against the limits
+func (d *decoder) stringLength() (int, error) {
+	start := d.offset
 
+	// Read digits until we hit a colon
+	var lengthStr []byte
+	for {
+		b, err := d.readByte("':' after string length")
 		if err != nil {
-			return "", err
+			return 0, err
 		}
 
-		if b == delimiter {
+		if b == ':' {
 			break
 		}
+		if b < '0' || b > '9' {
+			return 0, d.syntaxError(d.offset-1, ErrStringLength, "expected ':' after string length, found %s", describe(b))
+		}
 
-		result = append(result, b)
+		lengthStr = append(lengthStr, b)
 	}
 
-	return string(result), nil
+	// convert length string into an integer
+	length, err := strconv.Atoi(string(lengthStr))
+	if err != nil {
+		return 0, d.syntaxError(start, ErrStringLength, "string length %s out of range", lengthStr)
+	}
+	return length, d.checkString(start, length)
 }
 
-// e.g. i42e
-func decodeInteger(r *bufio.Reader) (int64, error) {
-	// Skip the leading 'i'
-	_, err := r.ReadByte()
+// skipString discards a string without holding it in memory
+func (d *decoder) skipString() error {
+	start := d.offset
+	length, err := d.stringLength()
 	if err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(d.sink(), d.r, int64(length))
+	d.offset += n
+	if err == io.EOF {
+		return d.syntaxError(start, io.ErrUnexpectedEOF, "string of length %d ends after %d bytes", length, n)
+	}
+	return err
+}
+
+// e.g. i42e
+func (d *decoder) decodeInteger() (int64, error) {
+	start := d.offset
+	if err := d.count(start); err != nil {
 		return 0, err
 	}
 
-	// Read digits until we hit 'e'
-	numStr, err := readUntil(r, 'e')
-	if err != nil {
+	// Skip the leading 'i'
+	if _, err := d.readByte("'i'"); err != nil {
 		return 0, err
 	}
 
-	// Validate the integer format
-	if len(numStr) > 1 && numStr[0] == '0' {
-		return 0, ErrIntegerFormat
+	// Read the sign and digits until we hit 'e'
+	var numStr []byte
+	for {
+		b, err := d.readByte("'e' to end the integer")
+		if err != nil {
+			return 0, err
+		}
+
+		if b == 'e' {
+			break
+		}
+		if (b < '0' || b > '9') && (b != '-' || len(numStr) > 0) {
+			return 0, d.syntaxError(d.offset-1, ErrIntegerFormat, "expected digit or 'e' in integer, found %s", describe(b))
+		}
+
+		numStr = append(numStr, b)
 	}
 
-	if len(numStr) > 1 && strings.HasPrefix(numStr, "-0") {
-		return 0, ErrIntegerFormat
+	// Validate the integer format
+	switch {
+	case len(numStr) == 0 || string(numStr) == "-":
+		return 0, d.syntaxError(start, ErrIntegerFormat, "integer has no digits")
+	case string(numStr) == "-0":
+		return 0, d.syntaxError(start, ErrIntegerFormat, "integer is a negative zero")
+	case (len(numStr) > 1 && numStr[0] == '0') || (len(numStr) > 2 && numStr[0] == '-' && numStr[1] == '0'):
+		return 0, d.syntaxError(start, ErrIntegerFormat, "integer %s has a leading zero", numStr)
 	}
 
 	// Convert string int to integer
-	num, err := strconv.ParseInt(numStr, 10, 64)
-
+	num, err := strconv.ParseInt(string(numStr), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid interger: %w", err)
+		return 0, d.syntaxError(start, ErrIntegerFormat, "integer %s out of range", numStr)
 	}
 
 	return num, nil
 }
 
 // Example: l4:spam4:eggse represents the list ["spam", "eggs"]
-func decodeList(r *bufio.Reader) ([]interface{}, error) {
-	// Skip the leading 'l'
-	_, err := r.ReadByte()
+func (d *decoder) decodeList() ([]interface{}, error) {
+	if err := d.enter(d.offset); err != nil {
+		return nil, err
+	}
 
-	if err != nil {
+	// Skip the leading 'l'
+	if _, err := d.readByte("'l'"); err != nil {
 		return nil, err
 	}
 
@@ -137,20 +295,20 @@ func decodeList(r *bufio.Reader) ([]interface{}, error) {
 	// Keep decoding until we hit 'e'
 	for {
 		// Peek to see if we've reached the end of the list
-		b, err := r.Peek(1)
+		b, err := d.peek("a list item or 'e' to end the list")
 		if err != nil {
 			return nil, err
 		}
 
-		if b[0] == 'e' {
+		if b == 'e' {
 			// Skip the trailing 'e'
-			_, err = r.ReadByte()
+			_, err = d.readByte("'e'")
+			d.leave()
 			return list, err
 		}
 
 		// Decode the next item
-		item, err := decodeNext(r)
-
+		item, err := d.decodeNext()
 		if err != nil {
 			return nil, err
 		}
@@ -160,45 +318,53 @@ func decodeList(r *bufio.Reader) ([]interface{}, error) {
 }
 
 // Example: d3:cow3:moo4:spam4:eggse represents the map {"cow": "moo", "spam": "eggs"}
-func decodeDict(r *bufio.Reader) (map[string]interface{}, error) {
+func (d *decoder) decodeDict() (map[string]interface{}, error) {
+	if err := d.enter(d.offset); err != nil {
+		return nil, err
+	}
+
 	// Skip the leading 'd'
-	_, err := r.ReadByte()
-	if err != nil {
+	if _, err := d.readByte("'d'"); err != nil {
 		return nil, err
 	}
 
 	dict := make(map[string]interface{})
 
+	var keys keyOrder
 	for {
 		// Peek to see if we've reached the end 'e'
-		b, err := r.Peek(1)
-
+		b, err := d.peek("a dictionary key or 'e' to end the dictionary")
 		if err != nil {
 			return nil, err
 		}
 
-		if b[0] == 'e' {
+		if b == 'e' {
 			// Skip the trailing byte 'e'
-			_, err = r.ReadByte()
+			_, err = d.readByte("'e'")
+			d.leave()
 			return dict, err
 		}
 
-		key, err := decodeNext(r)
+		if b < '0' || b > '9' {
+			return nil, d.syntaxError(d.offset, ErrInvalidBencode, "expected a string dictionary key, found %s", describe(b))
+		}
+
+		start := d.offset
+		key, err := d.decodeString()
 		if err != nil {
 			return nil, err
 		}
-
-		keyStr, ok := key.(string)
-		if !ok {
-			return nil, ErrInvalidBencode
+		if d.strict {
+			if err := keys.check(d, start, key); err != nil {
+				return nil, err
+			}
 		}
 
-		value, err := decodeNext(r)
+		value, err := d.decodeNext()
 		if err != nil {
 			return nil, err
 		}
 
-		dict[keyStr] = value
+		dict[key] = value
 	}
-
 }
@@ -2,6 +2,8 @@ package bencode
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -160,3 +162,37 @@ func TestComplex(t *testing.T) {
 		t.Errorf("Decode() = %v, want %v", got, expected)
 	}
 }
+
+func TestDecodeErrorOffsets(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int64
+		msg    string
+		err    error
+	}{
+		{"d3:cow3:moo4spam4:eggse", 12, "expected ':' after string length, found 's'", ErrStringLength},
+		{"d3:fooi12x4ee", 9, "expected digit or 'e' in integer, found 'x'", ErrIntegerFormat},
+		{"li03ee", 1, "integer 03 has a leading zero", ErrIntegerFormat},
+		{"d3:fooxe", 6, "expected a string, integer, list or dictionary, found 'x'", ErrInvalidBencode},
+		{"di1ei2ee", 1, "expected a string dictionary key, found 'i'", ErrInvalidBencode},
+		{"d3:foo10:abce", 6, "string of length 10 ends after 4 bytes", io.ErrUnexpectedEOF},
+		{"l4:spam", 7, "unexpected end of input, expected a list item or 'e' to end the list", io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewBufferString(tt.input))
+
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Decode(%q) error = %v, want a SyntaxError", tt.input, err)
+			continue
+		}
+		if syntaxErr.Offset != tt.offset || syntaxErr.Msg != tt.msg || !errors.Is(err, tt.err) {
+			t.Errorf("Decode(%q) error = %v (%v), want offset %d: %s (%v)", tt.input, err, syntaxErr.Err, tt.offset, tt.msg, tt.err)
+		}
+	}
+
+	if _, err := Decode(bytes.NewBufferString("")); err != io.EOF {
+		t.Errorf("Decode(\"\") error = %v, want io.EOF", err)
+	}
+}
@@ -1,12 +1,17 @@
 package bencode
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"sort"
 )
 
-// Encode writes a bencode representation of v to the provided writer
+// Encode writes a bencode representation of v to the provided writer. The
+// generic values Decode returns are written directly; any other value,
+// such as a struct with `bencode` tags, a slice of a concrete type or a
+// time.Time, is encoded as Marshal does.
 func Encode(w io.Writer, v interface{}) error {
 	return encodeValue(w, v)
 }
@@ -16,6 +21,8 @@ func encodeValue(w io.Writer, v interface{}) error {
 	switch val := v.(type) {
 	case string:
 		return encodeString(w, val)
+	case []byte:
+		return encodeBytes(w, val)
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		return encodeInteger(w, val)
 	case []interface{}:
@@ -23,7 +30,12 @@ func encodeValue(w io.Writer, v interface{}) error {
 	case map[string]interface{}:
 		return encodeDict(w, val)
 	default:
-		return fmt.Errorf("cannont encode type %T", v)
+		var buf bytes.Buffer
+		if err := marshalValue(&buf, reflect.ValueOf(v)); err != nil {
+			return err
+		}
+		_, err := buf.WriteTo(w)
+		return err
 	}
 }
 
@@ -33,6 +45,16 @@ func encodeString(w io.Writer, s string) error {
 	return err
 }
 
+// encodeBytes writes a bencoded string holding b, as decoded by
+// DecodeBinary
+func encodeBytes(w io.Writer, b []byte) error {
+	if _, err := fmt.Fprintf(w, "%d:", len(b)); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
 func encodeInteger(w io.Writer, v interface{}) error {
 	_, err := fmt.Fprintf(w, "i%de", v)
 	return err
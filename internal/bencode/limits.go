@@ -0,0 +1,83 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrLimitExceeded is wrapped by the SyntaxError of input that goes past
+// the Limits of the decoder
+var ErrLimitExceeded = errors.New("bencode limit exceeded")
+
+// Limits bound what decoding may allocate, so a hostile tracker or peer
+// can't make us run out of memory. A zero field is unlimited.
+type Limits struct {
+	MaxDepth        int   // Lists and dictionaries nested in each other
+	MaxStringLength int   // Bytes in one string
+	MaxElements     int   // Strings, integers, lists and dictionaries in all
+	MaxSize         int64 // Bytes of input read in all
+}
+
+// DefaultLimits suit the messages of trackers and peers, which are far
+// smaller; .torrent files may exceed them
+var DefaultLimits = Limits{
+	MaxDepth:        32,
+	MaxStringLength: 4 << 20,
+	MaxElements:     1 << 17,
+	MaxSize:         8 << 20,
+}
+
+// DecodeLimited reads one bencoded value like Decode, returning a
+// SyntaxError wrapping ErrLimitExceeded as soon as the input goes past
+// the limits. A string is checked against them before it is allocated.
+func DecodeLimited(r io.Reader, limits Limits) (interface{}, error) {
+	d := &decoder{r: bufio.NewReader(r), limits: limits}
+
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+
+	return d.decodeNext()
+}
+
+// SetLimits bounds what the decoder reads from now on, as DecodeLimited
+// does. The elements and size count everything read since the start.
+func (dec *Decoder) SetLimits(limits Limits) {
+	dec.d.limits = limits
+}
+
+// enter records a list or dictionary starting at offset
+func (d *decoder) enter(offset int64) error {
+	d.depth++
+	if d.limits.MaxDepth > 0 && d.depth > d.limits.MaxDepth {
+		return d.syntaxError(offset, ErrLimitExceeded, "lists and dictionaries nested deeper than %d", d.limits.MaxDepth)
+	}
+	return d.count(offset)
+}
+
+// leave records the end of a list or dictionary
+func (d *decoder) leave() {
+	d.depth--
+}
+
+// count records a value starting at offset
+func (d *decoder) count(offset int64) error {
+	d.elements++
+	if d.limits.MaxElements > 0 && d.elements > d.limits.MaxElements {
+		return d.syntaxError(offset, ErrLimitExceeded, "more than %d values", d.limits.MaxElements)
+	}
+	return nil
+}
+
+// checkString checks a string of the given length, whose length prefix
+// starts at offset, before it is read
+func (d *decoder) checkString(offset int64, length int) error {
+	if d.limits.MaxStringLength > 0 && length > d.limits.MaxStringLength {
+		return d.syntaxError(offset, ErrLimitExceeded, "string of length %d is longer than %d bytes", length, d.limits.MaxStringLength)
+	}
+	if d.limits.MaxSize > 0 && d.offset+int64(length) > d.limits.MaxSize {
+		return d.syntaxError(offset, ErrLimitExceeded, "string of length %d goes past %d bytes of input", length, d.limits.MaxSize)
+	}
+	return d.count(offset)
+}
@@ -0,0 +1,86 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeLimited(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		offset int64 // Of the error, -1 when the input is within the limits
+	}{
+		{"within", "d1:ali1ei2eee", Limits{MaxDepth: 2, MaxStringLength: 1, MaxElements: 5, MaxSize: 13}, -1},
+		{"depth", "lllleeee", Limits{MaxDepth: 3}, 3},
+		{"string length", "l3:abc4:abcde", Limits{MaxStringLength: 3}, 6},
+		{"elements", "li1ei2ei3ee", Limits{MaxElements: 3}, 7},
+		{"size", "l3:abci1ee", Limits{MaxSize: 8}, 8},
+		{"string past size", "l3:abc4:abcde", Limits{MaxSize: 10}, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeLimited(strings.NewReader(tt.input), tt.limits)
+			if tt.offset < 0 {
+				if err != nil {
+					t.Fatalf("DecodeLimited() error = %v", err)
+				}
+				return
+			}
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, ErrLimitExceeded) || syntaxErr.Offset != tt.offset {
+				t.Errorf("DecodeLimited() error = %v, want ErrLimitExceeded at offset %d", err, tt.offset)
+			}
+
+			// Without limits the input is fine
+			if _, err := Decode(strings.NewReader(tt.input)); err != nil {
+				t.Errorf("Decode() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeHugeLengthPrefix(t *testing.T) {
+	// A length prefix claiming a terabyte must not be allocated up front
+	_, err := Decode(strings.NewReader("1099511627776:abc"))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Decode() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	_, err = DecodeLimited(strings.NewReader("1099511627776:abc"), DefaultLimits)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeLimited() error = %v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	// Tokens, skipped values and decoded values all count
+	dec := NewDecoder(strings.NewReader("ld1:ai1eel1:bee"))
+	dec.SetLimits(Limits{MaxDepth: 2})
+
+	if _, err := dec.NextToken(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Skip(); err != nil {
+		t.Fatalf("Skip() within the depth error = %v", err)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatalf("Decode() within the depth error = %v", err)
+	}
+
+	dec = NewDecoder(strings.NewReader("lld1:ai1eeee"))
+	dec.SetLimits(Limits{MaxDepth: 2})
+	for range 2 {
+		if _, err := dec.NextToken(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dec.Skip(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Skip() past the depth error = %v, want ErrLimitExceeded", err)
+	}
+}
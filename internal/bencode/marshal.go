@@ -0,0 +1,422 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnmarshalTypeError describes a value that doesn't fit the Go type it is
+// unmarshaled into
+type UnmarshalTypeError struct {
+	Value  string       // The bencode value, e.g. "string" or "integer 300"
+	Type   reflect.Type // The Go type it didn't fit
+	Field  string       // Path of the struct field or map key, e.g. "info.piece length"
+	Offset int64        // Byte offset of the value in the input
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("offset %d: cannot unmarshal %s into field %s of type %s", e.Offset, e.Value, e.Field, e.Type)
+	}
+	return fmt.Sprintf("offset %d: cannot unmarshal %s into Go value of type %s", e.Offset, e.Value, e.Type)
+}
+
+// Marshal returns the bencoding of v. Strings and byte slices are strings,
+// integers are integers, bools are i0e and i1e, slices and arrays are
+// lists and maps with string keys and structs are dictionaries, with keys
+// in sorted order. A time.Time is an integer of Unix seconds, as in the
+// "creation date" of torrents. Pointers and interfaces are encoded as the
+// value they hold; nil ones are left out of lists and dictionaries. A
+// RawMessage is written as is.
+//
+// Struct fields are named by their `bencode:"name"` tag, or else by their
+// Go name. The "omitempty" option leaves out zero values, and a tag of "-"
+// leaves the field out. Unexported fields are ignored.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshalValue(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the bencoded data into the value v points to, the
+// reverse of Marshal. Dictionary keys without a matching struct field are
+// skipped; values of the wrong type return an *UnmarshalTypeError and
+// malformed input a *SyntaxError. Decoding into an interface{} stores
+// what Decode returns, into a RawMessage the bytes of the value and into a
+// time.Time an integer of Unix seconds.
+func Unmarshal(data []byte, v interface{}) error {
+	dec := NewDecoder(bytes.NewReader(data))
+	err := dec.Unmarshal(v)
+	if err == io.EOF {
+		return dec.d.syntaxError(0, io.ErrUnexpectedEOF, "unexpected end of input, expected a value")
+	}
+	if err != nil {
+		return err
+	}
+	if dec.InputOffset() != int64(len(data)) {
+		return dec.d.syntaxError(dec.InputOffset(), ErrInvalidBencode, "unexpected data after the top-level value")
+	}
+	return nil
+}
+
+// Unmarshal reads the next value into the value v points to, as the
+// package-level Unmarshal does. At the top level the end of the input is
+// io.EOF.
+func (dec *Decoder) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	return dec.unmarshal(rv.Elem(), "")
+}
+
+// field is a struct field encoded as a dictionary entry
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields caches the fields of each struct type, sorted by name
+var structFields sync.Map // reflect.Type -> []field
+
+// fieldsOf returns the dictionary entries of a struct type
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]field)
+	}
+
+	var fields []field
+	for _, sf := range reflect.VisibleFields(t) {
+		if !sf.IsExported() || sf.Anonymous {
+			continue
+		}
+
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name: name, index: sf.Index, omitEmpty: opts == "omitempty"})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+
+	structFields.Store(t, fields)
+	return fields
+}
+
+// lookupField returns the field of a struct type a dictionary key sets
+func lookupField(t reflect.Type, key string) (field, bool) {
+	fields := fieldsOf(t)
+	i := sort.Search(len(fields), func(i int) bool { return fields[i].name >= key })
+	if i < len(fields) && fields[i].name == key {
+		return fields[i], true
+	}
+	return field{}, false
+}
+
+// timeType is encoded as an integer of Unix seconds
+var timeType = reflect.TypeOf(time.Time{})
+
+// omitted reports whether a value is left out of lists and dictionaries
+func omitted(v reflect.Value) bool {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	return false
+}
+
+func marshalValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot marshal nil")
+	}
+	if v.Type() == rawMessageType {
+		return marshalRaw(buf, v.Interface().(RawMessage))
+	}
+	if v.Type() == timeType {
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Interface().(time.Time).Unix(), 10))
+		buf.WriteByte('e')
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot marshal nil %s", v.Type())
+		}
+		return marshalValue(buf, v.Elem())
+	case reflect.String:
+		buf.WriteString(strconv.Itoa(v.Len()))
+		buf.WriteByte(':')
+		buf.WriteString(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf.WriteString(strconv.Itoa(v.Len()))
+			buf.WriteByte(':')
+			for i := 0; i < v.Len(); i++ {
+				buf.WriteByte(byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if omitted(v.Index(i)) {
+				continue
+			}
+			if err := marshalValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: cannot marshal map with %s keys", v.Type().Key())
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		buf.WriteByte('d')
+		for _, key := range keys {
+			if omitted(v.MapIndex(key)) {
+				continue
+			}
+			marshalValue(buf, key)
+			if err := marshalValue(buf, v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		buf.WriteByte('d')
+		for _, f := range fieldsOf(v.Type()) {
+			fv, err := v.FieldByIndexErr(f.index)
+			if err != nil || omitted(fv) || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			marshalValue(buf, reflect.ValueOf(f.name))
+			if err := marshalValue(buf, fv); err != nil {
+				return fmt.Errorf("%w (field %s)", err, f.name)
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: cannot marshal type %s", v.Type())
+	}
+	return nil
+}
+
+// unmarshal decodes the next value into v; path names v in errors
+func (dec *Decoder) unmarshal(v reflect.Value, path string) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == rawMessageType {
+		raw, err := dec.DecodeRaw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+
+	// Decoding into an interface{} keeps the generic values
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		value, err := dec.Decode()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	tok, err := dec.NextToken()
+	if err != nil {
+		return err
+	}
+	if tok.Kind == TokenEnd {
+		return dec.d.syntaxError(tok.Offset, ErrInvalidBencode, "expected a value, found 'e'")
+	}
+	return dec.unmarshalToken(tok, v, path)
+}
+
+// unmarshalToken decodes the value starting with tok into v
+func (dec *Decoder) unmarshalToken(tok Token, v reflect.Value, path string) error {
+	mismatch := func(value string) error {
+		return &UnmarshalTypeError{Value: value, Type: v.Type(), Field: path, Offset: tok.Offset}
+	}
+
+	switch tok.Kind {
+	case TokenString:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(tok.Bytes))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(tok.Bytes)
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if len(tok.Bytes) != v.Len() {
+				return mismatch(fmt.Sprintf("string of length %d", len(tok.Bytes)))
+			}
+			reflect.Copy(v, reflect.ValueOf(tok.Bytes))
+		default:
+			return mismatch("string")
+		}
+
+	case TokenInteger:
+		n := tok.Int
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(time.Unix(n, 0)))
+			return nil
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(n) {
+				return mismatch("integer " + strconv.FormatInt(n, 10))
+			}
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return mismatch("integer " + strconv.FormatInt(n, 10))
+			}
+			v.SetUint(uint64(n))
+		case reflect.Bool:
+			if n != 0 && n != 1 {
+				return mismatch("integer " + strconv.FormatInt(n, 10))
+			}
+			v.SetBool(n == 1)
+		default:
+			return mismatch("integer")
+		}
+
+	case TokenListStart:
+		switch v.Kind() {
+		case reflect.Slice:
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return mismatch("list")
+			}
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			for i := 0; dec.More(); i++ {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := dec.unmarshal(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+				v.Set(reflect.Append(v, elem))
+			}
+		case reflect.Array:
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return mismatch("list")
+			}
+			i := 0
+			for ; dec.More(); i++ {
+				if i >= v.Len() {
+					return mismatch(fmt.Sprintf("list longer than %d", v.Len()))
+				}
+				if err := dec.unmarshal(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			for ; i < v.Len(); i++ {
+				v.Index(i).SetZero()
+			}
+		default:
+			return mismatch("list")
+		}
+		return dec.end()
+
+	case TokenDictStart:
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return mismatch("dictionary")
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			for dec.More() {
+				key, err := dec.NextToken()
+				if err != nil {
+					return err
+				}
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := dec.unmarshal(elem, joinPath(path, string(key.Bytes))); err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(string(key.Bytes)).Convert(v.Type().Key()), elem)
+			}
+		case reflect.Struct:
+			for dec.More() {
+				key, err := dec.NextToken()
+				if err != nil {
+					return err
+				}
+				f, ok := lookupField(v.Type(), string(key.Bytes))
+				if !ok {
+					if err := dec.Skip(); err != nil {
+						return err
+					}
+					continue
+				}
+				fv, err := v.FieldByIndexErr(f.index)
+				if err != nil {
+					return err
+				}
+				if err := dec.unmarshal(fv, joinPath(path, f.name)); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch("dictionary")
+		}
+		return dec.end()
+	}
+
+	return nil
+}
+
+// end reads the end of the list or dictionary whose items were read
+func (dec *Decoder) end() error {
+	_, err := dec.NextToken()
+	return err
+}
+
+// joinPath appends a dictionary key to the path of a value
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
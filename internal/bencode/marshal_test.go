@@ -0,0 +1,157 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int64      `bencode:"piece length"`
+	Pieces      []byte     `bencode:"pieces"`
+	Private     bool       `bencode:"private,omitempty"`
+	Files       []testFile `bencode:"files,omitempty"`
+	Length      *int64     `bencode:"length"`
+	Ignored     string     `bencode:"-"`
+	internal    int
+}
+
+type testTorrent struct {
+	Announce     string                 `bencode:"announce"`
+	AnnounceList [][]string             `bencode:"announce-list,omitempty"`
+	Info         testInfo               `bencode:"info"`
+	InfoHash     [4]byte                `bencode:"hash"`
+	Extra        map[string]interface{} `bencode:"extra,omitempty"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	length := int64(1 << 33)
+	sent := testTorrent{
+		Announce:     "http://tracker.example/announce",
+		AnnounceList: [][]string{{"http://a.example"}, {"http://b.example", "udp://c.example"}},
+		Info:         testInfo{Name: "test", PieceLength: 16384, Pieces: []byte{0, 1, 2, 'e'}, Private: true, Length: &length, Ignored: "x"},
+		InfoHash:     [4]byte{1, 2, 3, 4},
+		Extra:        map[string]interface{}{"b": int64(2), "a": "x", "l": []interface{}{int64(1)}},
+	}
+
+	data, err := Marshal(sent)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	// Keys are sorted, and the generic decoder reads it the same way
+	want := "d8:announce31:http://tracker.example/announce13:announce-listll16:http://a.exampleel16:http://b.example15:udp://c.exampleee" +
+		"5:extrad1:a1:x1:bi2e1:lli1eee4:hash4:\x01\x02\x03\x04" +
+		"4:infod6:lengthi8589934592e4:name4:test12:piece lengthi16384e6:pieces4:\x00\x01\x02e7:privatei1eee"
+	if string(data) != want {
+		t.Errorf("Marshal() =\n%q, want\n%q", data, want)
+	}
+	if _, err := Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("Decode(Marshal()) error = %v", err)
+	}
+
+	var got testTorrent
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	sent.Info.Ignored = ""
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, sent)
+	}
+}
+
+func TestUnmarshalSkipsUnknownKeys(t *testing.T) {
+	var info testInfo
+	if err := Unmarshal([]byte("d5:nodesll1:ai1eee4:name4:test5:otherd1:xi1eee"), &info); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if info.Name != "test" {
+		t.Errorf("Unmarshal() = %+v, want name test", info)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		field  string
+		offset int64
+	}{
+		{"d4:name5:fil", "", 0}, // Syntax error: the string ends early
+		{"d12:piece length3:abce", "piece length", 16},
+		{"d5:filesld6:lengthi-1e4:pathli1eeeee", "files[0].path[0]", 29},
+		{"d7:privatei2ee", "private", 10},
+	}
+
+	for _, tt := range tests {
+		var info testInfo
+		err := Unmarshal([]byte(tt.input), &info)
+
+		var typeErr *UnmarshalTypeError
+		var syntaxErr *SyntaxError
+		switch {
+		case tt.field == "" && !errors.As(err, &syntaxErr):
+			t.Errorf("Unmarshal(%q) error = %v, want a SyntaxError", tt.input, err)
+		case tt.field != "" && (!errors.As(err, &typeErr) || typeErr.Field != tt.field || typeErr.Offset != tt.offset):
+			t.Errorf("Unmarshal(%q) error = %v, want an UnmarshalTypeError at %s, offset %d", tt.input, err, tt.field, tt.offset)
+		}
+	}
+
+	var n uint8
+	if err := Unmarshal([]byte("i256e"), &n); err == nil {
+		t.Error("Unmarshal(i256e) into uint8 succeeded")
+	}
+	if err := Unmarshal([]byte("i1ei2e"), &n); err == nil {
+		t.Error("Unmarshal() with data after the value succeeded")
+	}
+	if err := Unmarshal([]byte("i1e"), n); err == nil {
+		t.Error("Unmarshal() into a non-pointer succeeded")
+	}
+}
+
+func TestEncodeTypedValues(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	type metainfo struct {
+		Announce  string    `bencode:"announce"`
+		Created   time.Time `bencode:"creation date,omitempty"`
+		Comment   *string   `bencode:"comment"`
+		Pieces    []byte    `bencode:"pieces"`
+		Tiers     [][]int   `bencode:"tiers"`
+		Unchanged time.Time `bencode:"unchanged,omitempty"`
+	}
+
+	// Typed values are encoded as Marshal does, also inside generic ones
+	v := map[string]interface{}{
+		"info": metainfo{Announce: "a", Created: created, Pieces: []byte{0, 'e'}, Tiers: [][]int{{1, 2}, {3}}},
+		"list": []interface{}{[]string{"x"}, true},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := "d4:infod8:announce1:a13:creation datei1700000000e6:pieces2:\x00e5:tierslli1ei2eeli3eeee4:listll1:xei1eee"
+	if buf.String() != want {
+		t.Errorf("Encode() =\n%q, want\n%q", buf.String(), want)
+	}
+
+	var got struct {
+		Info metainfo `bencode:"info"`
+	}
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !got.Info.Created.Equal(created) || !got.Info.Unchanged.IsZero() {
+		t.Errorf("Unmarshal() creation date = %v, want %v", got.Info.Created, created)
+	}
+
+	if err := Encode(&buf, func() {}); err == nil {
+		t.Error("Encode() of a func succeeded")
+	}
+}
@@ -0,0 +1,63 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// RawMessage is a bencoded value kept byte for byte. Unmarshal stores the
+// value exactly as it appears in the input, e.g. to hash the info
+// dictionary of a torrent without re-encoding it, which changes the hash
+// of torrents whose creators didn't encode it canonically. Marshal writes
+// it unchanged.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// DecodeRaw reads the next value, checking its syntax, and returns its
+// bytes as they appear in the input. The value ends at InputOffset.
+func (dec *Decoder) DecodeRaw() (RawMessage, error) {
+	b, err := dec.next()
+	if err != nil {
+		return nil, err
+	}
+	if b == 'e' {
+		return nil, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode, "expected a value, found 'e'")
+	}
+
+	var buf bytes.Buffer
+	dec.d.capture = &buf
+	if dec.wantKey() {
+		// A key goes through NextToken, which checks its order
+		_, err = dec.NextToken()
+	} else if err = dec.d.skipValue(); err == nil {
+		dec.done()
+	}
+	dec.d.capture = nil
+	if err != nil {
+		return nil, err
+	}
+
+	return RawMessage(buf.Bytes()), nil
+}
+
+// marshalRaw writes a RawMessage after checking that it holds exactly one
+// value, so it can't corrupt the surrounding list or dictionary
+func marshalRaw(buf *bytes.Buffer, raw RawMessage) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("bencode: cannot marshal empty RawMessage")
+	}
+
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(raw))}
+	if err := d.skipValue(); err != nil {
+		return fmt.Errorf("bencode: invalid RawMessage: %w", err)
+	}
+	if d.offset != int64(len(raw)) {
+		return fmt.Errorf("bencode: invalid RawMessage: data after the value at offset %d", d.offset)
+	}
+
+	buf.Write(raw)
+	return nil
+}
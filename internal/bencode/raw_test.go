@@ -0,0 +1,72 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRawMessage(t *testing.T) {
+	// The info dictionary's keys are out of order, which re-encoding fixes
+	info := "d4:name4:test6:lengthi5ee"
+	data := []byte("d8:announce3:url4:info" + info + "e")
+
+	var torrent struct {
+		Announce string     `bencode:"announce"`
+		Info     RawMessage `bencode:"info"`
+	}
+	if err := Unmarshal(data, &torrent); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if string(torrent.Info) != info {
+		t.Errorf("Info = %q, want %q", torrent.Info, info)
+	}
+	if torrent.Announce != "url" {
+		t.Errorf("Announce = %q, want %q", torrent.Announce, "url")
+	}
+
+	encoded, err := Marshal(torrent)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Marshal() = %q, want %q", encoded, data)
+	}
+
+	for _, raw := range []RawMessage{nil, RawMessage("4:spa"), RawMessage("i1ei2e")} {
+		if _, err := Marshal([]interface{}{raw}); err == nil {
+			t.Errorf("Marshal(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestDecoderDecodeRaw(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte("d1:ai1e1:bl3:fooi-2ee1:c0:e")))
+
+	if tok, err := dec.NextToken(); err != nil || tok.Kind != TokenDictStart {
+		t.Fatalf("NextToken() = %v, %v, want the dictionary start", tok, err)
+	}
+
+	var raws []string
+	for dec.More() {
+		if _, err := dec.NextToken(); err != nil {
+			t.Fatalf("NextToken() error = %v", err)
+		}
+		raw, err := dec.DecodeRaw()
+		if err != nil {
+			t.Fatalf("DecodeRaw() error = %v", err)
+		}
+		raws = append(raws, string(raw))
+	}
+	want := []string{"i1e", "l3:fooi-2ee", "0:"}
+	if len(raws) != len(want) || raws[0] != want[0] || raws[1] != want[1] || raws[2] != want[2] {
+		t.Errorf("DecodeRaw() values = %q, want %q", raws, want)
+	}
+	if dec.InputOffset() != 26 {
+		t.Errorf("InputOffset() = %d, want 26", dec.InputOffset())
+	}
+
+	// The end of the dictionary is no value
+	if _, err := dec.DecodeRaw(); err == nil {
+		t.Error("DecodeRaw() at the end of the dictionary succeeded")
+	}
+}
@@ -0,0 +1,321 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoValue is returned by Skip when the next token ends a list or
+// dictionary instead of starting a value
+var ErrNoValue = errors.New("bencode: no value to skip")
+
+// TokenKind is the kind of a token read by a Decoder
+type TokenKind int
+
+const (
+	TokenString    TokenKind = iota + 1 // A string, including dictionary keys
+	TokenInteger                        // An integer
+	TokenListStart                      // 'l', followed by the items of the list
+	TokenDictStart                      // 'd', followed by alternating keys and values
+	TokenEnd                            // 'e', ending the innermost list or dictionary
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenString:
+		return "string"
+	case TokenInteger:
+		return "integer"
+	case TokenListStart:
+		return "list start"
+	case TokenDictStart:
+		return "dictionary start"
+	case TokenEnd:
+		return "end"
+	default:
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+}
+
+// Token is a piece of bencoded input read by a Decoder
+type Token struct {
+	Kind   TokenKind
+	Offset int64  // Byte offset of the token in the input
+	Bytes  []byte // The string of a TokenString
+	Int    int64  // The value of a TokenInteger
+	Key    bool   // The string is a dictionary key
+}
+
+// frame is a list or dictionary the Decoder is inside of
+type frame struct {
+	dict    bool
+	wantKey bool     // The next token of the dictionary is a key or its end
+	keys    keyOrder // Keys read so far, checked when strict
+}
+
+// Decoder reads bencoded input one token at a time, like the Token method
+// of encoding/json's Decoder, so that large inputs such as tracker
+// responses or torrents are walked without building a map of everything.
+// Values of no interest are passed over with Skip and values of interest
+// read whole with Decode. Tokens are checked as they are read: dictionary
+// keys must be strings and lists and dictionaries must be closed.
+type Decoder struct {
+	d     decoder
+	stack []frame
+}
+
+// NewDecoder returns a decoder reading from r. It reads ahead, so r may
+// have been read past the last token.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{d: decoder{r: bufio.NewReader(r)}}
+}
+
+// InputOffset returns the number of input bytes consumed so far, which is
+// the offset just past the last token or value read
+func (dec *Decoder) InputOffset() int64 {
+	return dec.d.offset
+}
+
+// Depth returns the number of lists and dictionaries the decoder is
+// inside of
+func (dec *Decoder) Depth() int {
+	return len(dec.stack)
+}
+
+// More reports whether the current list or dictionary has another item,
+// or whether there is more input at the top level
+func (dec *Decoder) More() bool {
+	b, err := dec.d.r.Peek(1)
+	if err != nil {
+		return false
+	}
+	return len(dec.stack) == 0 || b[0] != 'e'
+}
+
+// next peeks at the first byte of the next token and checks that it may
+// come next: a key in a dictionary expecting one, and 'e' only inside a
+// list or dictionary. At the top level the end of the input is io.EOF.
+func (dec *Decoder) next() (byte, error) {
+	if len(dec.stack) == 0 {
+		if _, err := dec.d.r.Peek(1); err != nil {
+			return 0, err
+		}
+	}
+
+	b, err := dec.d.peek(dec.expected())
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b == 'e' && len(dec.stack) == 0:
+		return 0, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode, "expected a string, integer, list or dictionary, found 'e'")
+	case b == 'e':
+		if top := dec.stack[len(dec.stack)-1]; top.dict && !top.wantKey {
+			return 0, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode, "expected a dictionary value, found 'e'")
+		}
+	case dec.wantKey() && (b < '0' || b > '9'):
+		return 0, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode, "expected a string dictionary key, found %s", describe(b))
+	}
+	return b, nil
+}
+
+// expected describes the next token for errors at the end of the input
+func (dec *Decoder) expected() string {
+	switch {
+	case len(dec.stack) == 0:
+		return "a value"
+	case dec.wantKey():
+		return "a dictionary key or 'e' to end the dictionary"
+	case dec.stack[len(dec.stack)-1].dict:
+		return "a dictionary value"
+	default:
+		return "a list item or 'e' to end the list"
+	}
+}
+
+// wantKey reports whether the next token is a dictionary key
+func (dec *Decoder) wantKey() bool {
+	return len(dec.stack) > 0 && dec.stack[len(dec.stack)-1].wantKey
+}
+
+// done records that a key or a value was read: a dictionary alternates
+// between wanting a key and wanting a value
+func (dec *Decoder) done() {
+	if len(dec.stack) > 0 && dec.stack[len(dec.stack)-1].dict {
+		top := &dec.stack[len(dec.stack)-1]
+		top.wantKey = !top.wantKey
+	}
+}
+
+// NextToken reads the next token. It returns io.EOF at the end of the
+// input between top-level values and a *SyntaxError for malformed input.
+func (dec *Decoder) NextToken() (Token, error) {
+	b, err := dec.next()
+	if err != nil {
+		return Token{}, err
+	}
+
+	tok := Token{Offset: dec.d.offset}
+	switch {
+	case b >= '0' && b <= '9':
+		s, err := dec.d.decodeString()
+		if err != nil {
+			return Token{}, err
+		}
+		tok.Kind, tok.Bytes, tok.Key = TokenString, []byte(s), dec.wantKey()
+		if tok.Key && dec.d.strict {
+			if err := dec.stack[len(dec.stack)-1].keys.check(&dec.d, tok.Offset, s); err != nil {
+				return Token{}, err
+			}
+		}
+		dec.done()
+	case b == 'i':
+		n, err := dec.d.decodeInteger()
+		if err != nil {
+			return Token{}, err
+		}
+		tok.Kind, tok.Int = TokenInteger, n
+		dec.done()
+	case b == 'l' || b == 'd':
+		if err := dec.d.enter(tok.Offset); err != nil {
+			return Token{}, err
+		}
+		if _, err := dec.d.readByte(""); err != nil {
+			return Token{}, err
+		}
+		tok.Kind = TokenListStart
+		if b == 'd' {
+			tok.Kind = TokenDictStart
+		}
+		dec.stack = append(dec.stack, frame{dict: b == 'd', wantKey: b == 'd'})
+	case b == 'e':
+		if _, err := dec.d.readByte(""); err != nil {
+			return Token{}, err
+		}
+		dec.d.leave()
+		tok.Kind = TokenEnd
+		dec.stack = dec.stack[:len(dec.stack)-1]
+		dec.done()
+	default:
+		return Token{}, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode,
+			"expected a string, integer, list or dictionary, found %s", describe(b))
+	}
+
+	return tok, nil
+}
+
+// Decode reads the next value whole, as the package-level Decode does,
+// e.g. the value of a dictionary key found with NextToken
+func (dec *Decoder) Decode() (interface{}, error) {
+	b, err := dec.next()
+	if err != nil {
+		return nil, err
+	}
+	if b == 'e' {
+		return nil, dec.d.syntaxError(dec.d.offset, ErrInvalidBencode, "expected a value, found 'e'")
+	}
+	if dec.wantKey() {
+		key, err := dec.NextToken()
+		if err != nil {
+			return nil, err
+		}
+		return string(key.Bytes), nil
+	}
+
+	v, err := dec.d.decodeNext()
+	if err != nil {
+		return nil, err
+	}
+	dec.done()
+	return v, nil
+}
+
+// Skip passes over the next value, the whole of a list or dictionary,
+// without holding its strings in memory. It returns ErrNoValue when the
+// next token is the end of a list or dictionary.
+func (dec *Decoder) Skip() error {
+	b, err := dec.next()
+	if err != nil {
+		return err
+	}
+	if b == 'e' {
+		return ErrNoValue
+	}
+	if dec.wantKey() {
+		_, err := dec.NextToken()
+		return err
+	}
+
+	if err := dec.d.skipValue(); err != nil {
+		return err
+	}
+	dec.done()
+	return nil
+}
+
+// skipValue passes over a value, checking its syntax
+func (d *decoder) skipValue() error {
+	b, err := d.peek("a value")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case b >= '0' && b <= '9':
+		return d.skipString()
+	case b == 'i':
+		_, err := d.decodeInteger()
+		return err
+	case b == 'l' || b == 'd':
+		if err := d.enter(d.offset); err != nil {
+			return err
+		}
+		if _, err := d.readByte(""); err != nil {
+			return err
+		}
+		var keys keyOrder
+		for key := b == 'd'; ; key = b == 'd' && !key {
+			what := "a list item or 'e' to end the list"
+			if b == 'd' {
+				what = "a dictionary key or 'e' to end the dictionary"
+				if !key {
+					what = "a dictionary value"
+				}
+			}
+
+			next, err := d.peek(what)
+			if err != nil {
+				return err
+			}
+			if next == 'e' && (b == 'l' || key) {
+				d.leave()
+				_, err := d.readByte("")
+				return err
+			}
+			if key && (next < '0' || next > '9') {
+				return d.syntaxError(d.offset, ErrInvalidBencode, "expected a string dictionary key, found %s", describe(next))
+			}
+			if key && d.strict {
+				// Checking the order needs the key, which is short
+				start := d.offset
+				k, err := d.decodeString()
+				if err != nil {
+					return err
+				}
+				if err := keys.check(d, start, k); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.skipValue(); err != nil {
+				return err
+			}
+		}
+	default:
+		return d.syntaxError(d.offset, ErrInvalidBencode,
+			"expected a string, integer, list or dictionary, found %s", describe(b))
+	}
+}
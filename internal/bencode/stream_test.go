@@ -0,0 +1,113 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoderTokens(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d3:agei-3e4:listl1:ai1eee"))
+
+	want := []Token{
+		{Kind: TokenDictStart, Offset: 0},
+		{Kind: TokenString, Offset: 1, Bytes: []byte("age"), Key: true},
+		{Kind: TokenInteger, Offset: 6, Int: -3},
+		{Kind: TokenString, Offset: 10, Bytes: []byte("list"), Key: true},
+		{Kind: TokenListStart, Offset: 16},
+		{Kind: TokenString, Offset: 17, Bytes: []byte("a")},
+		{Kind: TokenInteger, Offset: 20, Int: 1},
+		{Kind: TokenEnd, Offset: 23},
+		{Kind: TokenEnd, Offset: 24},
+	}
+	for i, w := range want {
+		tok, err := dec.NextToken()
+		if err != nil {
+			t.Fatalf("token %d: error = %v", i, err)
+		}
+		if !reflect.DeepEqual(tok, w) {
+			t.Errorf("token %d = %+v, want %+v", i, tok, w)
+		}
+	}
+
+	if _, err := dec.NextToken(); err != io.EOF {
+		t.Errorf("NextToken() at the end error = %v, want io.EOF", err)
+	}
+	if dec.InputOffset() != 25 || dec.Depth() != 0 {
+		t.Errorf("InputOffset() = %d, Depth() = %d, want 25 and 0", dec.InputOffset(), dec.Depth())
+	}
+}
+
+func TestDecoderSkipAndDecode(t *testing.T) {
+	// Find the "info" dictionary, skipping the large "pieces" before it
+	input := "d6:pieces" + "100000:" + strings.Repeat("x", 100000) + "5:nodesll1:ai1eee4:infod4:name4:testee"
+	dec := NewDecoder(strings.NewReader(input))
+
+	if tok, err := dec.NextToken(); err != nil || tok.Kind != TokenDictStart {
+		t.Fatalf("NextToken() = %+v, %v, want a dictionary", tok, err)
+	}
+
+	var info interface{}
+	for dec.More() {
+		key, err := dec.NextToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(key.Bytes) == "info" {
+			if info, err = dec.Decode(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := dec.Skip(); err != nil {
+			t.Fatalf("Skip() after %q error = %v", key.Bytes, err)
+		}
+	}
+
+	if want := map[string]interface{}{"name": "test"}; !reflect.DeepEqual(info, want) {
+		t.Errorf("info = %v, want %v", info, want)
+	}
+	if err := dec.Skip(); !errors.Is(err, ErrNoValue) {
+		t.Errorf("Skip() at the end of the dictionary error = %v, want ErrNoValue", err)
+	}
+	if tok, err := dec.NextToken(); err != nil || tok.Kind != TokenEnd {
+		t.Errorf("NextToken() = %+v, %v, want the end", tok, err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int64
+		msg    string
+	}{
+		{"di1ei2ee", 1, "expected a string dictionary key, found 'i'"},
+		{"d1:ae", 4, "expected a dictionary value, found 'e'"},
+		{"e", 0, "expected a string, integer, list or dictionary, found 'e'"},
+		{"l1:a", 4, "unexpected end of input, expected a list item or 'e' to end the list"},
+	}
+
+	for _, tt := range tests {
+		dec := NewDecoder(bytes.NewBufferString(tt.input))
+		var err error
+		for err == nil {
+			_, err = dec.NextToken()
+		}
+
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Offset != tt.offset || syntaxErr.Msg != tt.msg {
+			t.Errorf("NextToken() on %q error = %v, want offset %d: %s", tt.input, err, tt.offset, tt.msg)
+		}
+	}
+
+	// Skip checks what it skips
+	dec := NewDecoder(strings.NewReader("ld1:ai1ei2ei3eee"))
+	dec.NextToken()
+	var syntaxErr *SyntaxError
+	if err := dec.Skip(); !errors.As(err, &syntaxErr) || syntaxErr.Offset != 8 {
+		t.Errorf("Skip() error = %v, want a SyntaxError at offset 8", err)
+	}
+}
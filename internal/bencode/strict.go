@@ -0,0 +1,65 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrNotCanonical is wrapped by the SyntaxError of strict decoding for
+// input that is valid bencode but not canonical
+var ErrNotCanonical = errors.New("bencode is not canonical")
+
+// DecodeStrict reads one bencoded value like Decode, but only accepts the
+// canonical encoding BEP 3 requires: the keys of every dictionary sorted
+// and unique, and nothing after the value. Decode tolerates both, as most
+// clients do for torrents and trackers that get them wrong, keeping the
+// last value of a duplicate key.
+func DecodeStrict(r io.Reader) (interface{}, error) {
+	d := &decoder{r: bufio.NewReader(r), strict: true}
+
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+
+	v, err := d.decodeNext()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.r.Peek(1)
+	switch {
+	case err == nil:
+		return nil, d.syntaxError(d.offset, ErrNotCanonical, "unexpected data after the top-level value")
+	case err != io.EOF:
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetStrict makes the decoder reject unsorted and duplicate dictionary
+// keys, as DecodeStrict does, or tolerate them again. Data after a
+// top-level value is the next value, as the decoder reads a stream.
+func (dec *Decoder) SetStrict(strict bool) {
+	dec.d.strict = strict
+}
+
+// keyOrder checks that the keys of a dictionary are sorted and unique
+type keyOrder struct {
+	last string
+	seen bool
+}
+
+// check returns a SyntaxError wrapping ErrNotCanonical unless key, read at
+// offset, sorts after the previous key
+func (o *keyOrder) check(d *decoder, offset int64, key string) error {
+	switch {
+	case o.seen && key == o.last:
+		return d.syntaxError(offset, ErrNotCanonical, "duplicate dictionary key %q", key)
+	case o.seen && key < o.last:
+		return d.syntaxError(offset, ErrNotCanonical, "dictionary key %q sorts before the previous key %q", key, o.last)
+	}
+
+	o.last, o.seen = key, true
+	return nil
+}
@@ -0,0 +1,103 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int64 // Of the error, -1 when the input is canonical
+	}{
+		{"canonical", "d1:ai1e1:bld1:xi1e1:yi2eeee", -1},
+		{"unsorted keys", "d1:bi1e1:ai2ee", 7},
+		{"duplicate key", "d1:ai1e1:ai2ee", 7},
+		{"unsorted nested keys", "d1:ald1:yi1e1:xi2eeee", 12},
+		{"trailing data", "i1ei2e", 3},
+		{"byte order", "d1:Bi1e1:ai2ee", -1}, // 'B' sorts before 'a'
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Lenient decoding accepts everything
+			lenient, err := Decode(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			strict, err := DecodeStrict(strings.NewReader(tt.input))
+			if tt.offset < 0 {
+				if err != nil {
+					t.Fatalf("DecodeStrict() error = %v", err)
+				}
+				if !reflect.DeepEqual(strict, lenient) {
+					t.Errorf("DecodeStrict() = %v, want %v", strict, lenient)
+				}
+				return
+			}
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, ErrNotCanonical) || syntaxErr.Offset != tt.offset {
+				t.Errorf("DecodeStrict() error = %v, want ErrNotCanonical at offset %d", err, tt.offset)
+			}
+		})
+	}
+
+	// Lenient decoding keeps the last value of a duplicate key
+	v, _ := Decode(strings.NewReader("d1:ai1e1:ai2ee"))
+	if got := v.(map[string]interface{})["a"]; got != int64(2) {
+		t.Errorf("Decode() of a duplicate key = %v, want the last value 2", got)
+	}
+}
+
+func TestDecoderStrict(t *testing.T) {
+	input := "d1:ai1e1:bd1:yi1e1:xi2eee"
+
+	// Every way of reading the nested dictionary checks its keys
+	read := map[string]func(dec *Decoder) error{
+		"Skip":      func(dec *Decoder) error { return dec.Skip() },
+		"Decode":    func(dec *Decoder) error { _, err := dec.Decode(); return err },
+		"DecodeRaw": func(dec *Decoder) error { _, err := dec.DecodeRaw(); return err },
+		"Unmarshal": func(dec *Decoder) error {
+			var v struct {
+				B map[string]int `bencode:"b"`
+			}
+			return dec.Unmarshal(&v)
+		},
+	}
+	for name, f := range read {
+		dec := NewDecoder(strings.NewReader(input))
+		dec.SetStrict(true)
+		if name != "Unmarshal" {
+			for range 4 { // Up to the value of "b"
+				if _, err := dec.NextToken(); err != nil {
+					t.Fatalf("%s: NextToken() error = %v", name, err)
+				}
+			}
+		}
+		if err := f(dec); !errors.Is(err, ErrNotCanonical) {
+			t.Errorf("%s error = %v, want ErrNotCanonical", name, err)
+		}
+
+		// Lenient decoding reads the whole dictionary
+		if err := f(NewDecoder(strings.NewReader(input))); err != nil {
+			t.Errorf("lenient %s error = %v", name, err)
+		}
+	}
+
+	// Keys read as tokens are checked too
+	dec := NewDecoder(strings.NewReader("d1:bi1e1:ai2ee"))
+	dec.SetStrict(true)
+	var err error
+	for err == nil {
+		_, err = dec.NextToken()
+	}
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || !errors.Is(err, ErrNotCanonical) || syntaxErr.Offset != 7 {
+		t.Errorf("NextToken() error = %v, want ErrNotCanonical at offset 7", err)
+	}
+}
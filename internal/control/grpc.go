@@ -0,0 +1,103 @@
+package control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxMessageSize is the largest request message accepted, the default of
+// gRPC servers
+const maxMessageSize = 4 * 1024 * 1024
+
+// gRPC status codes used by the service
+const (
+	codeOK                 = 0
+	codeInvalidArgument    = 3
+	codeNotFound           = 5
+	codeFailedPrecondition = 9
+	codeAborted            = 10
+	codeUnimplemented      = 12
+	codeInternal           = 13
+)
+
+// statusError is an error returned to the client as a gRPC status
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.msg)
+}
+
+func statusf(code int, format string, args ...interface{}) error {
+	return &statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// readMessage reads one length-prefixed message from a request body
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, statusf(codeInvalidArgument, "reading request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, statusf(codeUnimplemented, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxMessageSize {
+		return nil, statusf(codeInvalidArgument, "request message of %d bytes exceeds %d bytes", length, maxMessageSize)
+	}
+
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, statusf(codeInvalidArgument, "reading request message: %v", err)
+	}
+	return msg, nil
+}
+
+// writeMessage sends one length-prefixed, uncompressed message and flushes
+// it, so that streamed messages reach the client as they are sent
+func writeMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// writeStatus ends a call with the status of err, OK when it is nil
+func writeStatus(w http.ResponseWriter, err error) {
+	code, msg := codeOK, ""
+	if err != nil {
+		code, msg = codeInternal, err.Error()
+		if s, ok := err.(*statusError); ok {
+			code, msg = s.code, s.msg
+		}
+	}
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", percentEncode(msg))
+	}
+}
+
+// percentEncode escapes a status message as the gRPC protocol requires:
+// bytes outside printable ASCII, and '%' itself, become %XX
+func percentEncode(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			out = fmt.Appendf(out, "%%%02X", c)
+		} else {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
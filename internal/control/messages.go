@@ -0,0 +1,208 @@
+package control
+
+import (
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+)
+
+// The encoders below follow the field numbers of
+// api/gotorrent/v1/control.proto
+
+// rateWindow is the window of the peer rates reported by ListPeers
+const rateWindow = 30 * time.Second
+
+// Event fields, one per member of the oneof
+const (
+	eventProgress = 1
+	eventPeer     = 2
+	eventTracker  = 3
+	eventPiece    = 4
+)
+
+// decodeTorrentRequest returns the info hash of a TorrentRequest
+func decodeTorrentRequest(data []byte) ([]byte, error) {
+	fields, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var infoHash []byte
+	for _, f := range fields {
+		if f.Number == 1 && f.WireType == wireBytes {
+			infoHash = f.Bytes
+		}
+	}
+	return infoHash, nil
+}
+
+// decodeDisplayName returns the display name of a SetDisplayNameRequest,
+// whose info hash decodeTorrentRequest reads
+func decodeDisplayName(data []byte) (string, error) {
+	fields, err := decodeMessage(data)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	for _, f := range fields {
+		if f.Number == 2 && f.WireType == wireBytes {
+			name = string(f.Bytes)
+		}
+	}
+	return name, nil
+}
+
+// decodeProfileName returns the profile of a SwitchProfileRequest, whose
+// info hash decodeTorrentRequest reads
+func decodeProfileName(data []byte) (string, error) {
+	fields, err := decodeMessage(data)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	for _, f := range fields {
+		if f.Number == 2 && f.WireType == wireBytes {
+			name = string(f.Bytes)
+		}
+	}
+	return name, nil
+}
+
+// decodeListenPort returns the port of a SetListenPortRequest, whose info
+// hash decodeTorrentRequest reads
+func decodeListenPort(data []byte) (int64, error) {
+	fields, err := decodeMessage(data)
+	if err != nil {
+		return 0, err
+	}
+
+	var port int64
+	for _, f := range fields {
+		if f.Number == 2 && f.WireType == wireVarint {
+			port = int64(f.Value)
+		}
+	}
+	return port, nil
+}
+
+// decodePeerAddrs returns the addresses of an ImportPeersRequest, whose
+// info hash decodeTorrentRequest reads
+func decodePeerAddrs(data []byte) ([]string, error) {
+	fields, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, f := range fields {
+		if f.Number == 2 && f.WireType == wireBytes {
+			addrs = append(addrs, string(f.Bytes))
+		}
+	}
+	return addrs, nil
+}
+
+func (s *Server) encodeStats(stats download.Stats) []byte {
+	var e encoder
+	e.bytes(1, s.dm.Torrent.InfoHash[:])
+	e.string(2, s.dm.Torrent.Info.Name)
+	e.string(3, stats.State)
+	e.double(4, stats.Progress)
+	e.int64(5, stats.Downloaded)
+	e.int64(6, stats.Uploaded)
+	e.int64(7, stats.DownloadSpeed)
+	e.int64(8, stats.UploadSpeed)
+	e.int64(9, int64(stats.PiecesCompleted))
+	e.int64(10, int64(stats.PiecesTotal))
+	e.int64(11, int64(stats.ActivePeers))
+	e.int64(12, int64(stats.KnownPeers))
+	e.double(13, stats.Availability)
+	e.int64(14, stats.TimeRemaining.Milliseconds())
+	e.string(15, s.dm.DisplayName())
+	if s.Source != nil {
+		var se encoder
+		se.string(1, s.Source.Kind)
+		se.string(2, s.Source.URI)
+		se.int64(3, s.Source.AddedAt.Unix())
+		e.message(16, se.buf)
+	}
+	return e.buf
+}
+
+func encodePeer(p download.PeerStats) []byte {
+	var e encoder
+	e.string(1, p.Addr)
+	e.string(2, p.Country)
+	e.string(3, p.Client)
+	e.string(4, p.State.String())
+	e.int64(5, p.PayloadRead)
+	e.int64(6, p.PayloadWritten)
+	for _, r := range p.Rates {
+		if r.Window == rateWindow {
+			e.int64(7, r.DownloadRate)
+			e.int64(8, r.UploadRate)
+		}
+	}
+	return e.buf
+}
+
+func encodePeerList(peers []download.PeerStats) []byte {
+	var e encoder
+	for _, p := range peers {
+		e.message(1, encodePeer(p))
+	}
+	return e.buf
+}
+
+func encodeUnchokePreview(preview download.UnchokePreview) []byte {
+	var e encoder
+	e.bool(1, preview.Seeding)
+	e.int64(2, int64(preview.Slots))
+	for _, p := range preview.Peers {
+		var pe encoder
+		pe.string(1, p.Addr)
+		pe.int64(2, int64(p.Rank))
+		pe.int64(3, p.Rate)
+		pe.bool(4, p.Unchoked)
+		pe.bool(5, p.WillUnchoke)
+		pe.string(6, p.Reason)
+		e.message(3, pe.buf)
+	}
+	return e.buf
+}
+
+func encodePeerAddrList(addrs []string) []byte {
+	var e encoder
+	for _, addr := range addrs {
+		e.string(1, addr)
+	}
+	return e.buf
+}
+
+// encodeEvent wraps the encoded member of an Event
+func encodeEvent(member int, v []byte) []byte {
+	var e encoder
+	e.message(member, v)
+	return e.buf
+}
+
+func peerEvent(addr string, connected bool) []byte {
+	var e encoder
+	e.string(1, addr)
+	e.bool(2, connected)
+	return encodeEvent(eventPeer, e.buf)
+}
+
+func trackerEvent(err error) []byte {
+	var e encoder
+	e.string(1, err.Error())
+	return encodeEvent(eventTracker, e.buf)
+}
+
+func pieceEvent(index int) []byte {
+	var e encoder
+	e.int64(1, int64(index))
+	return encodeEvent(eventPiece, e.buf)
+}
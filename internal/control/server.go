@@ -0,0 +1,288 @@
+// Package control serves the gRPC control service described in
+// api/gotorrent/v1/control.proto. It speaks the gRPC protocol over
+// unencrypted HTTP/2 itself, with a small protobuf codec, so that the
+// client doesn't depend on the gRPC and protobuf modules.
+package control
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+)
+
+// ServiceName is the full name of the control service
+const ServiceName = "gotorrent.v1.Control"
+
+// ErrUnknownProfile is wrapped by OnSwitchProfile errors for profiles
+// that aren't configured, which SwitchProfile reports as NOT_FOUND
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// eventBuffer is the number of events queued for each WatchEvents stream;
+// streams that fall further behind miss events
+const eventBuffer = 64
+
+// Server serves the control service for a download
+type Server struct {
+	dm     *download.DownloadManager
+	server *http.Server
+
+	// Source is reported in Stats, nil when unknown
+	Source *Source
+
+	// OnSwitchProfile switches the client to the named profile of its
+	// configuration, "" for the configured default. Without it
+	// SwitchProfile fails with UNIMPLEMENTED.
+	OnSwitchProfile func(name string) error
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]bool
+}
+
+// NewServer returns a server controlling dm. It hooks into the callbacks
+// of dm, calling the callbacks already set first, so create it once the
+// other callbacks are set and before starting the download.
+func NewServer(dm *download.DownloadManager) *Server {
+	s := &Server{
+		dm:          dm,
+		subscribers: make(map[chan []byte]bool),
+	}
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	s.server = &http.Server{Handler: s, Protocols: &protocols}
+
+	dm.OnStatsUpdated = chain(dm.OnStatsUpdated, func(stats download.Stats) {
+		s.publish(encodeEvent(eventProgress, s.encodeStats(stats)))
+	})
+	dm.OnPeerConnected = chain(dm.OnPeerConnected, func(addr string) {
+		s.publish(peerEvent(addr, true))
+	})
+	dm.OnPeerDisconnected = chain(dm.OnPeerDisconnected, func(addr string) {
+		s.publish(peerEvent(addr, false))
+	})
+	dm.OnTrackerError = chain(dm.OnTrackerError, func(err error) {
+		s.publish(trackerEvent(err))
+	})
+	dm.OnPieceCompleted = chain(dm.OnPieceCompleted, func(index int) {
+		s.publish(pieceEvent(index))
+	})
+
+	return s
+}
+
+// Source is where the torrent was added from
+type Source struct {
+	Kind    string // file, url, stdin or magnet
+	URI     string // Absolute path, URL or magnet link, "" for stdin
+	AddedAt time.Time
+}
+
+// chain returns a callback calling prev, when set, and then next
+func chain[T any](prev, next func(T)) func(T) {
+	if prev == nil {
+		return next
+	}
+	return func(v T) {
+		prev(v)
+		next(v)
+	}
+}
+
+// ListenAndServe accepts control connections on addr until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts control connections on l until Close
+func (s *Server) Serve(l net.Listener) error {
+	err := s.server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close closes the listener and every open call
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// ServeHTTP handles one call, at /gotorrent.v1.Control/<method>
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	writeStatus(w, s.call(w, r))
+}
+
+// call runs the method named by the request path
+func (s *Server) call(w http.ResponseWriter, r *http.Request) error {
+	method, ok := strings.CutPrefix(r.URL.Path, "/"+ServiceName+"/")
+	if !ok {
+		return statusf(codeUnimplemented, "unknown service %s", r.URL.Path)
+	}
+
+	req, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	infoHash, err := decodeTorrentRequest(req)
+	if err != nil {
+		return statusf(codeInvalidArgument, "%v", err)
+	}
+	if len(infoHash) > 0 && !bytes.Equal(infoHash, s.dm.Torrent.InfoHash[:]) {
+		return statusf(codeNotFound, "no torrent with info hash %x", infoHash)
+	}
+
+	switch method {
+	case "GetStats":
+		return writeMessage(w, s.encodeStats(s.dm.GetStats()))
+	case "ListPeers":
+		return writeMessage(w, encodePeerList(s.dm.GetPeerStats()))
+	case "PreviewUnchoke":
+		return writeMessage(w, encodeUnchokePreview(s.dm.UnchokePreview()))
+	case "Reannounce":
+		s.dm.ForceReannounce()
+		return writeMessage(w, nil)
+	case "Recheck":
+		if err := s.dm.ForceRecheck(); err != nil {
+			if errors.Is(err, download.ErrCheckInProgress) {
+				return statusf(codeAborted, "%v", err)
+			}
+			return err
+		}
+		return writeMessage(w, nil)
+	case "SetDisplayName":
+		name, err := decodeDisplayName(req)
+		if err != nil {
+			return statusf(codeInvalidArgument, "%v", err)
+		}
+		if !utf8.ValidString(name) {
+			return statusf(codeInvalidArgument, "display name is not valid UTF-8")
+		}
+		s.dm.SetDisplayName(strings.TrimSpace(name))
+		return writeMessage(w, nil)
+	case "SetListenPort":
+		port, err := decodeListenPort(req)
+		if err != nil {
+			return statusf(codeInvalidArgument, "%v", err)
+		}
+		if port < 1 || port > 65535 {
+			return statusf(codeInvalidArgument, "port %d out of range", port)
+		}
+		s.dm.SetListenPort(int(port))
+		return writeMessage(w, nil)
+	case "SwitchProfile":
+		if s.OnSwitchProfile == nil {
+			return statusf(codeUnimplemented, "profiles are not supported")
+		}
+		name, err := decodeProfileName(req)
+		if err != nil {
+			return statusf(codeInvalidArgument, "%v", err)
+		}
+		if err := s.OnSwitchProfile(strings.TrimSpace(name)); err != nil {
+			if errors.Is(err, ErrUnknownProfile) {
+				return statusf(codeNotFound, "%v", err)
+			}
+			return statusf(codeFailedPrecondition, "%v", err)
+		}
+		return writeMessage(w, nil)
+	case "ExportTorrent":
+		if s.dm.Metainfo == nil {
+			return statusf(codeFailedPrecondition, "the .torrent file of %s isn't known, it wasn't added from a magnet link", s.dm.Torrent.Info.Name)
+		}
+		var e encoder
+		e.bytes(1, s.dm.Metainfo)
+		return writeMessage(w, e.buf)
+	case "ExportPeers":
+		return writeMessage(w, encodePeerAddrList(s.dm.KnownPeers()))
+	case "ImportPeers":
+		addrs, err := decodePeerAddrs(req)
+		if err != nil {
+			return statusf(codeInvalidArgument, "%v", err)
+		}
+		added, err := s.dm.ImportPeers(addrs)
+		if err != nil {
+			return statusf(codeInvalidArgument, "%v", err)
+		}
+		var e encoder
+		e.int64(1, int64(added))
+		return writeMessage(w, e.buf)
+	case "WatchEvents":
+		return s.watchEvents(w, r)
+	default:
+		return statusf(codeUnimplemented, "unknown method %s", method)
+	}
+}
+
+// watchEvents streams events to the client, starting with the current
+// progress, until the client goes away
+func (s *Server) watchEvents(w http.ResponseWriter, r *http.Request) error {
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	if err := writeMessage(w, encodeEvent(eventProgress, s.encodeStats(s.dm.GetStats()))); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case event := <-events:
+			if err := writeMessage(w, event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) subscribe() chan []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make(chan []byte, eventBuffer)
+	s.subscribers[events] = true
+	return events
+}
+
+func (s *Server) unsubscribe(events chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, events)
+}
+
+// publish queues an event for every stream without waiting, since the
+// download manager may call back with its lock held
+func (s *Server) publish(event []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for events := range s.subscribers {
+		select {
+		case events <- event:
+		default:
+			// The stream fell behind
+		}
+	}
+}
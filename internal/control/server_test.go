@@ -0,0 +1,425 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/download"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// startServer serves a download that hasn't started and returns a client
+// for it; setup functions run on the server before it serves
+func startServer(t *testing.T, setup ...func(*Server)) (*download.DownloadManager, *grpcClient) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+		InfoHash:   [20]byte{1, 2, 3},
+	}
+	dm := download.NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(dm)
+	for _, f := range setup {
+		f(server)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return dm, &grpcClient{t: t, base: "http://" + l.Addr().String(), client: &http.Client{Transport: transport}}
+}
+
+type grpcClient struct {
+	t      *testing.T
+	base   string
+	client *http.Client
+}
+
+// call starts a call with a TorrentRequest for infoHash
+func (c *grpcClient) call(ctx context.Context, method string, infoHash []byte) *http.Response {
+	var e encoder
+	e.bytes(1, infoHash)
+	return c.send(ctx, method, e.buf)
+}
+
+// send starts a call with an encoded request message
+func (c *grpcClient) send(ctx context.Context, method string, msg []byte) *http.Response {
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/"+ServiceName+"/"+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	return resp
+}
+
+// next reads the next message of a response
+func (c *grpcClient) next(resp *http.Response) []field {
+	var prefix [5]byte
+	if _, err := io.ReadFull(resp.Body, prefix[:]); err != nil {
+		c.t.Fatalf("reading message: %v", err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(resp.Body, msg); err != nil {
+		c.t.Fatalf("reading message: %v", err)
+	}
+
+	fields, err := decodeMessage(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return fields
+}
+
+// status reads the rest of a response and returns its gRPC status
+func (c *grpcClient) status(resp *http.Response) string {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Trailer.Get("Grpc-Status")
+}
+
+func TestGetStats(t *testing.T) {
+	_, client := startServer(t)
+
+	resp := client.call(context.Background(), "GetStats", nil)
+	fields := client.next(resp)
+	if status := client.status(resp); status != "0" {
+		t.Fatalf("GetStats status = %s, want 0", status)
+	}
+
+	var infoHash []byte
+	var name string
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			infoHash = f.Bytes
+		case 2:
+			name = string(f.Bytes)
+		}
+	}
+	if !bytes.Equal(infoHash, []byte{1, 2, 3, 19: 0}) || name != "test.bin" {
+		t.Errorf("GetStats() = info hash %x, name %q", infoHash, name)
+	}
+
+	if status := client.status(client.call(context.Background(), "GetStats", []byte("other"))); status != "5" {
+		t.Errorf("GetStats for another torrent status = %s, want 5 (NOT_FOUND)", status)
+	}
+	if status := client.status(client.call(context.Background(), "Pause", nil)); status != "12" {
+		t.Errorf("unknown method status = %s, want 12 (UNIMPLEMENTED)", status)
+	}
+}
+
+func TestGetStatsSource(t *testing.T) {
+	addedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := func(client *grpcClient) []field {
+		resp := client.call(context.Background(), "GetStats", nil)
+		defer client.status(resp)
+		for _, f := range client.next(resp) {
+			if f.Number == 16 {
+				fields, err := decodeMessage(f.Bytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return fields
+			}
+		}
+		return nil
+	}
+
+	_, client := startServer(t, func(s *Server) {
+		s.Source = &Source{Kind: "magnet", URI: "magnet:?xt=urn:btih:0102", AddedAt: addedAt}
+	})
+
+	var kind, uri string
+	var added int64
+	for _, f := range source(client) {
+		switch f.Number {
+		case 1:
+			kind = string(f.Bytes)
+		case 2:
+			uri = string(f.Bytes)
+		case 3:
+			added = int64(f.Value)
+		}
+	}
+	if kind != "magnet" || uri != "magnet:?xt=urn:btih:0102" || added != addedAt.Unix() {
+		t.Errorf("source = %q, %q, added at %d, want the magnet link added at %d", kind, uri, added, addedAt.Unix())
+	}
+
+	// Without a known source the field is left out
+	_, client = startServer(t)
+	if fields := source(client); fields != nil {
+		t.Errorf("source = %v, want none", fields)
+	}
+}
+
+func TestSetDisplayName(t *testing.T) {
+	dm, client := startServer(t)
+
+	var saved []string
+	dm.OnDisplayNameSet = func(name string) { saved = append(saved, name) }
+
+	setName := func(name string) string {
+		var e encoder
+		e.string(2, name)
+		return client.status(client.send(context.Background(), "SetDisplayName", e.buf))
+	}
+	displayName := func() string {
+		resp := client.call(context.Background(), "GetStats", nil)
+		defer client.status(resp)
+		for _, f := range client.next(resp) {
+			if f.Number == 15 {
+				return string(f.Bytes)
+			}
+		}
+		return ""
+	}
+
+	if name := displayName(); name != "test.bin" {
+		t.Errorf("display name = %q, want the name in the metainfo", name)
+	}
+
+	if status := setName("  Test Data  "); status != "0" {
+		t.Fatalf("SetDisplayName status = %s, want 0", status)
+	}
+	if name := displayName(); name != "Test Data" {
+		t.Errorf("display name = %q, want %q", name, "Test Data")
+	}
+	if dm.Torrent.Info.Name != "test.bin" {
+		t.Errorf("SetDisplayName renamed the torrent to %q", dm.Torrent.Info.Name)
+	}
+
+	if status := setName(""); status != "0" {
+		t.Fatalf("SetDisplayName status = %s, want 0", status)
+	}
+	if name := displayName(); name != "test.bin" {
+		t.Errorf("display name after clearing it = %q, want the name in the metainfo", name)
+	}
+
+	if status := setName("\xff"); status != "3" {
+		t.Errorf("SetDisplayName with invalid UTF-8 status = %s, want 3 (INVALID_ARGUMENT)", status)
+	}
+
+	if len(saved) != 2 || saved[0] != "Test Data" || saved[1] != "" {
+		t.Errorf("OnDisplayNameSet calls = %q, want the new name and then \"\"", saved)
+	}
+}
+
+func TestSetListenPort(t *testing.T) {
+	dm, client := startServer(t)
+
+	setPort := func(port int64) string {
+		var e encoder
+		e.int64(2, port)
+		return client.status(client.send(context.Background(), "SetListenPort", e.buf))
+	}
+
+	if status := setPort(7000); status != "0" {
+		t.Fatalf("SetListenPort status = %s, want 0", status)
+	}
+	if port := dm.ListenPort(); port != 7000 {
+		t.Errorf("ListenPort() = %d, want 7000", port)
+	}
+
+	for _, port := range []int64{0, -1, 70000} {
+		if status := setPort(port); status != "3" {
+			t.Errorf("SetListenPort(%d) status = %s, want 3 (INVALID_ARGUMENT)", port, status)
+		}
+	}
+	if port := dm.ListenPort(); port != 7000 {
+		t.Errorf("ListenPort() = %d after invalid ports, want 7000", port)
+	}
+}
+
+func TestSwitchProfile(t *testing.T) {
+	var switched []string
+	_, client := startServer(t, func(s *Server) {
+		s.OnSwitchProfile = func(name string) error {
+			switch name {
+			case "", "home":
+				switched = append(switched, name)
+				return nil
+			case "broken":
+				return errors.New("failed to decode config.json")
+			default:
+				return fmt.Errorf("%w %q", ErrUnknownProfile, name)
+			}
+		}
+	})
+
+	switchTo := func(name string) string {
+		var e encoder
+		e.string(2, name)
+		return client.status(client.send(context.Background(), "SwitchProfile", e.buf))
+	}
+
+	tests := []struct {
+		name   string
+		status string
+	}{
+		{" home ", "0"},
+		{"", "0"},
+		{"seedbox", "5"}, // NOT_FOUND
+		{"broken", "9"},  // FAILED_PRECONDITION
+	}
+	for _, tt := range tests {
+		if status := switchTo(tt.name); status != tt.status {
+			t.Errorf("SwitchProfile(%q) status = %s, want %s", tt.name, status, tt.status)
+		}
+	}
+
+	if !slices.Equal(switched, []string{"home", ""}) {
+		t.Errorf("switched to %q, want home and then the default", switched)
+	}
+
+	// A client without profiles doesn't implement the call
+	_, client = startServer(t)
+	if status := switchTo("home"); status != "12" {
+		t.Errorf("SwitchProfile without OnSwitchProfile status = %s, want 12 (UNIMPLEMENTED)", status)
+	}
+}
+
+func TestExportTorrent(t *testing.T) {
+	dm, client := startServer(t)
+
+	if status := client.status(client.call(context.Background(), "ExportTorrent", nil)); status != "9" {
+		t.Errorf("ExportTorrent without metainfo status = %s, want 9 (FAILED_PRECONDITION)", status)
+	}
+
+	dm.Metainfo = []byte("d8:announce0:4:infod4:name8:test.binee")
+	resp := client.call(context.Background(), "ExportTorrent", nil)
+	fields := client.next(resp)
+	if status := client.status(resp); status != "0" {
+		t.Fatalf("ExportTorrent status = %s, want 0", status)
+	}
+	if len(fields) != 1 || !bytes.Equal(fields[0].Bytes, dm.Metainfo) {
+		t.Errorf("ExportTorrent() = %+v, want the metainfo", fields)
+	}
+}
+
+func TestPreviewUnchoke(t *testing.T) {
+	dm, client := startServer(t)
+	dm.UploadSlots = 3
+
+	resp := client.call(context.Background(), "PreviewUnchoke", nil)
+	fields := client.next(resp)
+	if status := client.status(resp); status != "0" {
+		t.Fatalf("PreviewUnchoke status = %s, want 0", status)
+	}
+
+	// No peers are connected yet
+	if len(fields) != 1 || fields[0].Number != 2 || fields[0].Value != 3 {
+		t.Errorf("PreviewUnchoke() = %+v, want 3 upload slots and no peers", fields)
+	}
+}
+
+func TestImportExportPeers(t *testing.T) {
+	_, client := startServer(t)
+
+	importPeers := func(addrs ...string) *http.Response {
+		var e encoder
+		for _, addr := range addrs {
+			e.string(2, addr)
+		}
+		return client.send(context.Background(), "ImportPeers", e.buf)
+	}
+	added := func(resp *http.Response) uint64 {
+		fields := client.next(resp)
+		if status := client.status(resp); status != "0" {
+			t.Fatalf("ImportPeers status = %s, want 0", status)
+		}
+		if len(fields) == 0 {
+			return 0
+		}
+		return fields[0].Value
+	}
+
+	if n := added(importPeers("10.0.0.2:6881", "10.0.0.1:6881")); n != 2 {
+		t.Errorf("ImportPeers() added %d peers, want 2", n)
+	}
+	if n := added(importPeers("10.0.0.1:6881")); n != 0 {
+		t.Errorf("ImportPeers() of a known peer added %d peers, want 0", n)
+	}
+	if status := client.status(importPeers("10.0.0.3:6881", "no port")); status != "3" {
+		t.Errorf("ImportPeers with an invalid address status = %s, want 3 (INVALID_ARGUMENT)", status)
+	}
+
+	resp := client.call(context.Background(), "ExportPeers", nil)
+	fields := client.next(resp)
+	if status := client.status(resp); status != "0" {
+		t.Fatalf("ExportPeers status = %s, want 0", status)
+	}
+	var addrs []string
+	for _, f := range fields {
+		addrs = append(addrs, string(f.Bytes))
+	}
+	if want := []string{"10.0.0.1:6881", "10.0.0.2:6881"}; !slices.Equal(addrs, want) {
+		t.Errorf("ExportPeers() = %q, want %q", addrs, want)
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	dm, client := startServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp := client.call(ctx, "WatchEvents", nil)
+	defer resp.Body.Close()
+
+	if fields := client.next(resp); len(fields) != 1 || fields[0].Number != eventProgress {
+		t.Fatalf("first event = %+v, want progress", fields)
+	}
+
+	dm.OnPieceCompleted(1)
+
+	fields := client.next(resp)
+	if len(fields) != 1 || fields[0].Number != eventPiece {
+		t.Fatalf("event = %+v, want a piece event", fields)
+	}
+	piece, err := decodeMessage(fields[0].Bytes)
+	if err != nil || len(piece) != 1 || piece[0].Value != 1 {
+		t.Errorf("piece event = %+v, %v, want piece 1", piece, err)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	var e encoder
+	e.int64(1, -2)
+	e.string(2, "peer")
+	e.double(3, 0.5)
+	e.string(4, "") // Left out
+
+	fields, err := decodeMessage(e.buf)
+	if err != nil {
+		t.Fatalf("decodeMessage() error = %v", err)
+	}
+	if len(fields) != 3 || int64(fields[0].Value) != -2 || string(fields[1].Bytes) != "peer" || fields[2].WireType != wireFixed64 {
+		t.Errorf("decodeMessage() = %+v", fields)
+	}
+
+	if _, err := decodeMessage(e.buf[:len(e.buf)-1]); err != ErrInvalidMessage {
+		t.Errorf("decodeMessage() of a truncated message error = %v, want ErrInvalidMessage", err)
+	}
+}
@@ -0,0 +1,119 @@
+package control
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrInvalidMessage is returned for protobuf messages that can't be parsed
+var ErrInvalidMessage = errors.New("invalid protobuf message")
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// encoder appends protobuf fields to a message. Fields holding their zero
+// value are left out, as proto3 does.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *encoder) int64(field int, v int64) {
+	if v != 0 {
+		e.tag(field, wireVarint)
+		e.buf = binary.AppendUvarint(e.buf, uint64(v))
+	}
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.int64(field, 1)
+	}
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v != 0 {
+		e.tag(field, wireFixed64)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+	}
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	if len(v) > 0 {
+		e.message(field, v)
+	}
+}
+
+func (e *encoder) string(field int, v string) {
+	e.bytes(field, []byte(v))
+}
+
+// message appends an embedded message, even an empty one, so that the
+// member of a oneof it sets survives
+func (e *encoder) message(field int, v []byte) {
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// field is one field of a decoded message: varint and fixed values are in
+// Value, length-delimited ones in Bytes
+type field struct {
+	Number   int
+	WireType int
+	Value    uint64
+	Bytes    []byte
+}
+
+// decodeMessage splits a message into its fields, in the order they appear
+func decodeMessage(data []byte) ([]field, error) {
+	var fields []field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return nil, ErrInvalidMessage
+		}
+		data = data[n:]
+
+		f := field{Number: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case wireVarint:
+			f.Value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, ErrInvalidMessage
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, ErrInvalidMessage
+			}
+			f.Value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, ErrInvalidMessage
+			}
+			f.Value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, ErrInvalidMessage
+			}
+			f.Bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, ErrInvalidMessage
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
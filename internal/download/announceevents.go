@@ -0,0 +1,183 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// announceEvents tracks the events each tracker has acknowledged for a
+// torrent, so started opens every session with a tracker, completed is
+// sent exactly once when the download finishes and stopped closes it
+type announceEvents struct {
+	mu       sync.Mutex
+	trackers map[string]*trackerEvents
+}
+
+// trackerEvents is what one tracker has been told
+type trackerEvents struct {
+	started   bool // The tracker knows we are in the swarm
+	completed bool // The tracker was told the download completed
+	seeding   bool // We joined with the download already complete
+}
+
+// newAnnounceEvents creates an empty event tracker
+func newAnnounceEvents() *announceEvents {
+	return &announceEvents{trackers: make(map[string]*trackerEvents)}
+}
+
+// next returns the event for a regular announce to a tracker
+func (e *announceEvents) next(url string, complete bool) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := e.trackers[url]
+	switch {
+	case t == nil || !t.started:
+		return "started"
+	case complete && !t.completed && !t.seeding:
+		return "completed"
+	}
+
+	return ""
+}
+
+// joined reports whether a tracker knows we are in the swarm
+func (e *announceEvents) joined(url string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := e.trackers[url]
+	return t != nil && t.started
+}
+
+// joinedTrackers returns the trackers that know we are in the swarm, sorted
+func (e *announceEvents) joinedTrackers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var urls []string
+	for url, t := range e.trackers {
+		if t.started {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// sent records an event the tracker acknowledged
+func (e *announceEvents) sent(url, event string, complete bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch event {
+	case "started":
+		e.trackers[url] = &trackerEvents{started: true, seeding: complete}
+	case "completed":
+		if t := e.trackers[url]; t != nil {
+			t.completed = true
+		}
+	case "stopped":
+		// A later announce starts a new session with the tracker
+		delete(e.trackers, url)
+	}
+}
+
+// nextEvent returns the event for a regular announce. An incomplete
+// upload-only download reports itself as paused (BEP 21) so trackers don't
+// count it as a leecher.
+func (dm *DownloadManager) nextEvent() string {
+	event := dm.events.next(dm.trackerURL(), dm.PieceManager.IsComplete())
+	if event == "" && dm.SeedOnly && !dm.PieceManager.IsComplete() {
+		return "paused"
+	}
+	return event
+}
+
+// announceStopped tells every tracker that saw us join, including those
+// announces failed over from, that we are leaving the swarm, reporting a
+// completion a tracker hasn't heard of first. Trackers that never saw us
+// join are left alone. Cancelling ctx abandons the announces.
+func (dm *DownloadManager) announceStopped(ctx context.Context) {
+	if dm.DisableTracker {
+		return
+	}
+
+	complete := dm.PieceManager.IsComplete()
+	for _, url := range dm.events.joinedTrackers() {
+		if dm.events.next(url, complete) == "completed" {
+			if _, err := dm.announceTo(ctx, url, "completed"); err != nil {
+				fmt.Printf("Tracker error: %v\n", err)
+			}
+		}
+
+		if _, err := dm.announceTo(ctx, url, "stopped"); err != nil {
+			fmt.Printf("Tracker error: %v\n", err)
+		}
+	}
+}
+
+// trackerList returns the torrent's trackers in the order they are tried:
+// the announce URL, then the announce-list tiers (BEP 12) without duplicates
+func trackerList(t *torrent.TorrentFile) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+
+	add(t.Announce)
+	for _, tier := range t.AnnounceList {
+		for _, url := range tier {
+			add(url)
+		}
+	}
+	return urls
+}
+
+// trackerURL returns the tracker announces currently go to
+func (dm *DownloadManager) trackerURL() string {
+	urls := trackerList(dm.Torrent)
+	if len(urls) == 0 {
+		return dm.Torrent.Announce
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return urls[dm.trackerIndex%len(urls)]
+}
+
+// nextTracker moves announces on to the next tracker, wrapping around after
+// the last backup and passing over tiers that are being skipped; when every
+// other tier is skipped the next tracker is tried anyway. It reports false
+// when the torrent has a single tracker.
+func (dm *DownloadManager) nextTracker() (string, bool) {
+	urls := trackerList(dm.Torrent)
+	if len(urls) < 2 {
+		return "", false
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	now := time.Now()
+	next := (dm.trackerIndex + 1) % len(urls)
+	for i := next; i != dm.trackerIndex; i = (i + 1) % len(urls) {
+		if dm.health.usable(trackerTier(dm.Torrent, urls[i]), now) {
+			next = i
+			break
+		}
+	}
+
+	dm.trackerIndex = next
+	dm.trackerID = "" // Tracker IDs belong to the tracker that sent them
+	return urls[dm.trackerIndex], true
+}
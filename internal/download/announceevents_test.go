@@ -0,0 +1,85 @@
+package download
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestAnnounceEventsLifecycle(t *testing.T) {
+	const url = "http://tracker.example/announce"
+	e := newAnnounceEvents()
+
+	step := func(complete bool, want string) {
+		t.Helper()
+		if got := e.next(url, complete); got != want {
+			t.Fatalf("next(complete=%v) = %q, want %q", complete, got, want)
+		}
+	}
+
+	// started is repeated until the tracker acknowledges it
+	step(false, "started")
+	step(false, "started")
+	e.sent(url, "started", false)
+	step(false, "")
+
+	// completed goes out once, when the download finishes
+	step(true, "completed")
+	e.sent(url, "completed", true)
+	step(true, "")
+
+	// After stopped, a new session with the tracker begins
+	e.sent(url, "stopped", true)
+	if e.joined(url) {
+		t.Error("joined() = true after stopped")
+	}
+	step(true, "started")
+
+	// A torrent that was complete when it joined never sends completed
+	e.sent(url, "started", true)
+	step(true, "")
+}
+
+func TestAnnounceStoppedAfterFailover(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:     "http://a.example/announce",
+		AnnounceList: [][]string{{"http://a.example/announce"}, {"http://b.example/announce"}, {"http://c.example/announce"}},
+		Info:         torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash:   make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &fakeAnnouncer{}
+	dm.Tracker = announcer
+
+	// a saw us join, then announces failed over to b, which did too
+	if _, err := dm.announce(context.Background(), "started"); err != nil {
+		t.Fatal(err)
+	}
+	dm.nextTracker()
+	if _, err := dm.announce(context.Background(), "started"); err != nil {
+		t.Fatal(err)
+	}
+
+	announcer.mu.Lock()
+	announcer.events, announcer.urls = nil, nil
+	announcer.mu.Unlock()
+
+	dm.announceStopped(context.Background())
+
+	announcer.mu.Lock()
+	defer announcer.mu.Unlock()
+	want := []string{"stopped http://a.example/announce", "stopped http://b.example/announce"}
+	var got []string
+	for i, event := range announcer.events {
+		got = append(got, event+" "+announcer.urls[i])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("announced %q, want %q", got, want)
+	}
+	if joined := dm.events.joinedTrackers(); len(joined) != 0 {
+		t.Errorf("trackers still joined after stopping: %q", joined)
+	}
+}
@@ -0,0 +1,125 @@
+package download
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeerTuning bounds the automatic adjustment of the peer target
+type PeerTuning struct {
+	Floor    int           // Fewest peers to aim for
+	Ceiling  int           // Most peers to aim for
+	Step     int           // Peers added or removed per adjustment
+	Interval time.Duration // Time between adjustments
+}
+
+// DefaultPeerTuning returns the tuning used when none is configured
+func DefaultPeerTuning() PeerTuning {
+	return PeerTuning{
+		Floor:    10,
+		Ceiling:  200,
+		Step:     5,
+		Interval: 30 * time.Second,
+	}
+}
+
+const (
+	// saturatedRatio is the share of the estimated link capacity above
+	// which the link is considered full
+	saturatedRatio = 0.9
+
+	// minGainRatio is the throughput gain expected from adding peers
+	minGainRatio = 1.05
+
+	// capacityDecay lets the capacity estimate follow a link that got slower
+	capacityDecay = 0.98
+)
+
+// peerTuner adjusts the peer target by hill climbing: while the link has
+// spare capacity more peers are added, and once it is saturated peers that
+// did not raise throughput are given up again
+type peerTuner struct {
+	capacity float64 // Estimated link capacity in bytes/s
+	lastRate int64   // Throughput at the previous adjustment
+	raised   bool    // Whether the previous adjustment added peers
+}
+
+// next returns the new peer target given the throughput achieved with the
+// current one
+func (t *peerTuner) next(target int, rate int64, tuning PeerTuning) int {
+	t.capacity *= capacityDecay
+	if float64(rate) > t.capacity {
+		t.capacity = float64(rate)
+	}
+
+	gained := float64(rate) >= float64(t.lastRate)*minGainRatio
+	saturated := t.capacity > 0 && float64(rate) >= t.capacity*saturatedRatio
+
+	next := target
+	switch {
+	case t.raised && !gained:
+		// The last peers we added did not help
+		next -= tuning.Step
+	case !saturated || gained:
+		next += tuning.Step
+	}
+
+	if next < tuning.Floor {
+		next = tuning.Floor
+	}
+	if next > tuning.Ceiling {
+		next = tuning.Ceiling
+	}
+
+	t.raised = next > target
+	t.lastRate = rate
+
+	return next
+}
+
+// MaxPeers returns the current target number of connected peers
+func (dm *DownloadManager) MaxPeers() int {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.maxPeers
+}
+
+// SetMaxPeers changes the number of peers to connect to, or the ceiling
+// when auto-tuning. Peers beyond a lowered target are not disconnected.
+func (dm *DownloadManager) SetMaxPeers(n int) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if n <= 0 {
+		return
+	}
+
+	dm.PeerTuning.Ceiling = n
+	if dm.PeerTuning.Floor > n {
+		dm.PeerTuning.Floor = n
+	}
+	if !dm.AutoTunePeers || dm.maxPeers > n {
+		dm.maxPeers = n
+	}
+	dm.PeerPool.SetMaxSessions(dm.maxPeers)
+}
+
+// tunePeers moves the peer target according to the achieved throughput.
+// Lowering the target only stops new connections; existing peers stay.
+func (dm *DownloadManager) tunePeers() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if dm.tuner == nil {
+		dm.tuner = &peerTuner{}
+	}
+
+	target := dm.tuner.next(dm.maxPeers, dm.Stats.DownloadSpeed, dm.PeerTuning)
+	if target == dm.maxPeers {
+		return
+	}
+
+	fmt.Printf("Adjusting peer target from %d to %d\n", dm.maxPeers, target)
+	dm.maxPeers = target
+	dm.PeerPool.SetMaxSessions(target)
+}
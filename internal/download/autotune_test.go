@@ -0,0 +1,36 @@
+package download
+
+import "testing"
+
+func TestPeerTunerNext(t *testing.T) {
+	tuning := PeerTuning{Floor: 10, Ceiling: 30, Step: 5}
+	tuner := &peerTuner{}
+
+	steps := []struct {
+		rate int64
+		want int
+	}{
+		{100, 25}, // Link has room, add peers
+		{200, 30}, // Throughput rose, keep adding
+		{300, 30}, // Capped at the ceiling
+		{300, 30}, // Saturated without gain, hold
+	}
+
+	target := 20
+	for i, step := range steps {
+		target = tuner.next(target, step.rate, tuning)
+		if target != step.want {
+			t.Fatalf("step %d: next() = %d, want %d", i, target, step.want)
+		}
+	}
+
+	// Peers that don't raise throughput are given up
+	tuner = &peerTuner{}
+	target = tuner.next(20, 100, tuning)
+	if target != 25 {
+		t.Fatalf("next() = %d, want 25", target)
+	}
+	if target = tuner.next(target, 100, tuning); target != 20 {
+		t.Errorf("next() after no gain = %d, want 20", target)
+	}
+}
@@ -0,0 +1,48 @@
+package download
+
+// PieceAvailability returns for every piece the number of connected peers
+// that have it
+func (dm *DownloadManager) PieceAvailability() []int {
+	counts := make([]int, dm.Torrent.NumPieces())
+	for _, session := range dm.PeerPool.GetPeers() {
+		for i := range counts {
+			if session.HasPiece(i) {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// Availability returns the number of distributed copies of the torrent
+// among the connected peers, not counting us. The whole part is the number
+// of peers holding the rarest piece; the fraction is the share of pieces
+// held more often than that. Below 1.0 some piece is missing from the
+// swarm we can see, and the download can't complete until a peer with it
+// connects.
+func (dm *DownloadManager) Availability() float64 {
+	return distributedCopies(dm.PieceAvailability())
+}
+
+// distributedCopies computes Availability from per-piece peer counts
+func distributedCopies(counts []int) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+
+	rarest := counts[0]
+	for _, n := range counts[1:] {
+		if n < rarest {
+			rarest = n
+		}
+	}
+
+	above := 0
+	for _, n := range counts {
+		if n > rarest {
+			above++
+		}
+	}
+
+	return float64(rarest) + float64(above)/float64(len(counts))
+}
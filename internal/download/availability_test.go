@@ -0,0 +1,23 @@
+package download
+
+import "testing"
+
+func TestDistributedCopies(t *testing.T) {
+	tests := []struct {
+		counts []int
+		want   float64
+	}{
+		{nil, 0},
+		{[]int{0, 0, 0, 0}, 0},
+		{[]int{1, 0, 1, 1}, 0.75},
+		{[]int{1, 1, 1, 1}, 1},
+		{[]int{2, 1, 3, 1}, 1.5},
+		{[]int{5, 5}, 5},
+	}
+
+	for _, tt := range tests {
+		if got := distributedCopies(tt.counts); got != tt.want {
+			t.Errorf("distributedCopies(%v) = %v, want %v", tt.counts, got, tt.want)
+		}
+	}
+}
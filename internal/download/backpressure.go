@@ -0,0 +1,85 @@
+package download
+
+import (
+	"fmt"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// DefaultInFlightBudget is the number of bytes of piece data that may be
+// requested from peers or waiting for the disk before requests pause
+const DefaultInFlightBudget = 64 * 1024 * 1024
+
+// throttledPiece is a piece whose next block request was held back
+// because the in-flight budget was used up
+type throttledPiece struct {
+	piece   *Piece
+	session *peer.Session
+}
+
+// InFlight returns the bytes of the blocks that have been requested or
+// received, which are or will soon be held in memory
+func (p *Piece) InFlight() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := 0
+	for _, block := range p.Blocks {
+		if block.Data != nil || p.Requested[block.Index] {
+			n += block.Length
+		}
+	}
+
+	return n
+}
+
+// inFlightBytes returns the bytes of piece data requested from peers or web
+// seeds and not yet written to disk; callers must hold dm.mu
+func (dm *DownloadManager) inFlightBytes() int64 {
+	var n int64
+	for index := range dm.activePieces {
+		n += int64(dm.PieceManager.Pieces[index].InFlight())
+	}
+
+	if dm.Storage != nil {
+		n += dm.Storage.DiskStats().QueuedBytes
+	}
+
+	return n
+}
+
+// overBudget reports whether new block requests must wait for the disk to
+// catch up; callers must hold dm.mu
+func (dm *DownloadManager) overBudget() bool {
+	return dm.InFlightBudget > 0 && dm.inFlightBytes() >= dm.InFlightBudget
+}
+
+// throttle holds back the next request for a piece until the in-flight
+// budget allows it; callers must hold dm.mu
+func (dm *DownloadManager) throttle(piece *Piece, session *peer.Session) {
+	if dm.throttled == nil {
+		dm.throttled = make(map[int]throttledPiece)
+	}
+
+	if len(dm.throttled) == 0 {
+		fmt.Printf("Disk is falling behind, pausing block requests\n")
+	}
+	dm.throttled[piece.Index] = throttledPiece{piece: piece, session: session}
+}
+
+// resumeThrottled requests the next blocks of throttled pieces while the
+// in-flight budget allows it. Pieces that were reset or handed to another
+// peer in the meantime are dropped. Callers must hold dm.mu.
+func (dm *DownloadManager) resumeThrottled() {
+	for index, t := range dm.throttled {
+		if dm.overBudget() {
+			return
+		}
+
+		delete(dm.throttled, index)
+		if dm.activePieces[index] != t.session.GetAddr() {
+			continue
+		}
+		dm.requestBlocks(t.piece, t.session)
+	}
+}
@@ -0,0 +1,70 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// queuedStorage is a fakeStorage whose write buffer holds queued bytes
+type queuedStorage struct {
+	fakeStorage
+	queued int64
+}
+
+func (s *queuedStorage) DiskStats() DiskStats { return DiskStats{QueuedBytes: s.queued} }
+
+func TestPieceInFlight(t *testing.T) {
+	piece := NewPiece(0, [20]byte{}, 2*BlockSize+100)
+
+	if n := piece.InFlight(); n != 0 {
+		t.Errorf("InFlight() = %d before any request, want 0", n)
+	}
+
+	piece.NextRequest()
+	if n := piece.InFlight(); n != BlockSize {
+		t.Errorf("InFlight() = %d with one request, want %d", n, BlockSize)
+	}
+
+	// A received block that was never requested still takes memory
+	if err := piece.AddBlock(2*BlockSize, make([]byte, 100), "peer"); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+	if n := piece.InFlight(); n != BlockSize+100 {
+		t.Errorf("InFlight() = %d, want %d", n, BlockSize+100)
+	}
+
+	piece.ClearBlocks()
+	if n := piece.InFlight(); n != 0 {
+		t.Errorf("InFlight() = %d after ClearBlocks, want 0", n)
+	}
+}
+
+func TestDownloadManagerOverBudget(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 2 * BlockSize, Name: "test.bin", Length: 4 * BlockSize},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	storage := &queuedStorage{}
+	dm.Storage = storage
+	dm.InFlightBudget = 2 * BlockSize
+
+	dm.activePieces[0] = "peer"
+	dm.PieceManager.Pieces[0].NextRequest()
+	if dm.overBudget() {
+		t.Errorf("overBudget() = true with %d of %d bytes in flight", dm.inFlightBytes(), dm.InFlightBudget)
+	}
+
+	// Pieces waiting for the disk count against the same budget
+	storage.queued = BlockSize
+	if !dm.overBudget() {
+		t.Errorf("overBudget() = false with %d of %d bytes in flight", dm.inFlightBytes(), dm.InFlightBudget)
+	}
+
+	dm.InFlightBudget = 0
+	if dm.overBudget() {
+		t.Error("overBudget() = true without a budget")
+	}
+}
@@ -0,0 +1,193 @@
+package download
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// Peers found by the peer sources stay candidates until no source has
+// reported them for candidateMaxAge. A candidate is dialed again at most
+// every candidateRetry, whenever we are short of peers.
+const (
+	candidateMaxAge        = 30 * time.Minute
+	candidateRetry         = 5 * time.Minute
+	candidateCheckInterval = 30 * time.Second
+)
+
+// peerCandidate is a peer we may connect to
+type peerCandidate struct {
+	peer      tracker.Peer
+	sources   map[string]bool // Sources that reported the peer
+	firstSeen time.Time
+	lastSeen  time.Time // Last time a source reported the peer
+	attempted time.Time // Last time the peer was dialed
+}
+
+// peerCandidates merges the peers reported by every tracker and peer
+// source. A peer reported several times is kept once and refreshed, so
+// overlapping announce responses don't cause duplicate dials, and peers
+// no source reports any more age out.
+type peerCandidates struct {
+	mu    sync.Mutex
+	peers map[string]*peerCandidate // "ip:port" -> candidate
+}
+
+// newPeerCandidates creates an empty candidate set
+func newPeerCandidates() *peerCandidates {
+	return &peerCandidates{peers: make(map[string]*peerCandidate)}
+}
+
+// add merges found peers into the set, refreshing the peers already known,
+// and returns the number of new peers
+func (c *peerCandidates) add(found []PeerInfo, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := 0
+	for _, info := range found {
+		addr := info.Peer.String()
+		candidate := c.peers[addr]
+		if candidate == nil {
+			candidate = &peerCandidate{peer: info.Peer, sources: make(map[string]bool), firstSeen: now}
+			c.peers[addr] = candidate
+			added++
+		}
+
+		// Compact responses carry no peer ID, so keep one we learned elsewhere
+		if info.Peer.ID != ([20]byte{}) {
+			candidate.peer.ID = info.Peer.ID
+		}
+		candidate.sources[info.Source] = true
+		candidate.lastSeen = now
+	}
+
+	return added
+}
+
+// expire drops the candidates no source reported within maxAge and
+// returns how many were dropped
+func (c *peerCandidates) expire(now time.Time, maxAge time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for addr, candidate := range c.peers {
+		if now.Sub(candidate.lastSeen) > maxAge {
+			delete(c.peers, addr)
+			dropped++
+		}
+	}
+
+	return dropped
+}
+
+// due returns the candidates not dialed within retry, most recently
+// reported first, and records them as dialed
+func (c *peerCandidates) due(now time.Time, retry time.Duration) []tracker.Peer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var candidates []*peerCandidate
+	for _, candidate := range c.peers {
+		if candidate.attempted.IsZero() || now.Sub(candidate.attempted) >= retry {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].lastSeen.Equal(candidates[j].lastSeen) {
+			return candidates[i].lastSeen.After(candidates[j].lastSeen)
+		}
+		return candidates[i].firstSeen.Before(candidates[j].firstSeen)
+	})
+
+	peers := make([]tracker.Peer, len(candidates))
+	for i, candidate := range candidates {
+		candidate.attempted = now
+		peers[i] = candidate.peer
+	}
+
+	return peers
+}
+
+// count returns the number of candidates
+func (c *peerCandidates) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.peers)
+}
+
+// addrs returns the addresses of the candidates, sorted
+func (c *peerCandidates) addrs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addrs := make([]string, 0, len(c.peers))
+	for addr := range c.peers {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Strings(addrs)
+	return addrs
+}
+
+// KnownPeers returns the addresses of the peers the sources found, sorted,
+// for ImportPeers to bootstrap another instance or a later run with. The
+// peers that connected to us are included under the addresses they said
+// they listen on.
+func (dm *DownloadManager) KnownPeers() []string {
+	return dm.candidates.addrs()
+}
+
+// ImportPeers adds peers given as host:port, e.g. from KnownPeers of
+// another instance, to the candidates and returns the number of peers that
+// were new. Nothing is added if an address is invalid.
+func (dm *DownloadManager) ImportPeers(addrs []string) (int, error) {
+	found := make([]PeerInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		p, err := parsePeerAddr(addr)
+		if err != nil {
+			return 0, err
+		}
+		found = append(found, PeerInfo{Peer: p, Source: "import"})
+	}
+
+	// Dialing blocks, so the new peers are connected in the background
+	added := dm.candidates.add(found, time.Now())
+	if added > 0 && dm.ctx != nil {
+		go dm.connectCandidates()
+	}
+	return added, nil
+}
+
+// addListenAddrs records the addresses a connected peer listens on as
+// candidates under its peer ID, so a dropped connection is retried over all
+// of them at once, whichever address family works
+func (dm *DownloadManager) addListenAddrs(session *peer.Session) {
+	var found []PeerInfo
+	for _, p := range session.ListenAddrs() {
+		found = append(found, PeerInfo{Peer: p, Source: "handshake"})
+	}
+
+	if added := dm.candidates.add(found, time.Now()); added > 0 {
+		fmt.Printf("Peer %s has %d new addresses to reconnect to\n", session.GetAddr(), added)
+	}
+}
+
+// connectCandidates dials the candidates that are due while we are short
+// of peers
+func (dm *DownloadManager) connectCandidates() {
+	if dm.seedingStopped() || dm.PeerPool.GetConnectedPeers() >= dm.MaxPeers() {
+		return
+	}
+
+	if peers := dm.candidates.due(time.Now(), candidateRetry); len(peers) > 0 {
+		dm.connectPeers(peers)
+	}
+}
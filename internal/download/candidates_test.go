@@ -0,0 +1,102 @@
+package download
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+func TestPeerCandidatesMerge(t *testing.T) {
+	c := newPeerCandidates()
+	now := time.Now()
+
+	a := tracker.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	b := tracker.Peer{IP: net.IPv4(10, 0, 0, 2), Port: 6881}
+	withID := a
+	withID.ID = [20]byte{1}
+
+	// Overlapping responses from two trackers keep each peer once
+	if added := c.add([]PeerInfo{{Peer: a, Source: "tracker"}, {Peer: b, Source: "tracker"}}, now); added != 2 {
+		t.Errorf("add() = %d, want 2", added)
+	}
+	if added := c.add([]PeerInfo{{Peer: withID, Source: "pex"}, {Peer: a, Source: "tracker"}}, now.Add(time.Minute)); added != 0 {
+		t.Errorf("add() = %d for known peers, want 0", added)
+	}
+	if c.count() != 2 {
+		t.Fatalf("count() = %d, want 2", c.count())
+	}
+
+	// The most recently reported peer goes first, with the ID learned from PEX
+	peers := c.due(now.Add(time.Minute), candidateRetry)
+	if len(peers) != 2 || peers[0].String() != a.String() || peers[0].ID != withID.ID {
+		t.Fatalf("due() = %v, want %s with its ID first", peers, a.String())
+	}
+	if candidate := c.peers[a.String()]; !candidate.sources["tracker"] || !candidate.sources["pex"] {
+		t.Errorf("sources = %v, want tracker and pex", candidate.sources)
+	}
+
+	// Dialed peers wait before they are due again
+	if peers := c.due(now.Add(2*time.Minute), candidateRetry); len(peers) != 0 {
+		t.Errorf("due() = %v right after dialing, want none", peers)
+	}
+	if peers := c.due(now.Add(time.Minute+candidateRetry), candidateRetry); len(peers) != 2 {
+		t.Errorf("due() = %v after the retry delay, want both peers", peers)
+	}
+
+	// Peers no source reports any more age out
+	if dropped := c.expire(now.Add(candidateMaxAge+30*time.Second), candidateMaxAge); dropped != 1 || c.count() != 1 {
+		t.Errorf("expire() = %d leaving %d, want 1 leaving 1", dropped, c.count())
+	}
+}
+
+func TestImportPeers(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	pool := &fakePool{connect: make(chan []tracker.Peer, 1)}
+	dm.PeerPool = pool
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+	dm.DisableTracker = true
+
+	// A list with an invalid address is rejected whole
+	if _, err := dm.ImportPeers([]string{"10.0.0.1:6881", "10.0.0.2"}); err == nil {
+		t.Error("ImportPeers() with an address without a port succeeded")
+	}
+	if peers := dm.KnownPeers(); len(peers) != 0 {
+		t.Errorf("KnownPeers() = %v after a rejected import, want none", peers)
+	}
+
+	added, err := dm.ImportPeers([]string{"10.0.0.2:6881", "10.0.0.1:6881", "10.0.0.2:6881"})
+	if err != nil || added != 2 {
+		t.Fatalf("ImportPeers() = %d, %v, want 2 new peers", added, err)
+	}
+	if added, _ := dm.ImportPeers([]string{"10.0.0.1:6881"}); added != 0 {
+		t.Errorf("ImportPeers() = %d for a known peer, want 0", added)
+	}
+	want := []string{"10.0.0.1:6881", "10.0.0.2:6881"}
+	if peers := dm.KnownPeers(); !slices.Equal(peers, want) {
+		t.Errorf("KnownPeers() = %v, want %v", peers, want)
+	}
+
+	// Peers imported before the start are dialed without waiting for a source
+	if err := dm.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer dm.Stop()
+
+	select {
+	case peers := <-pool.connect:
+		if len(peers) != 2 {
+			t.Errorf("Connect(%v), want the imported peers", peers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("imported peers never reached the pool")
+	}
+}
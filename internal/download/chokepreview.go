@@ -0,0 +1,119 @@
+package download
+
+import (
+	"fmt"
+	"sort"
+)
+
+// UnchokePreview is what the next choking round would do with the
+// connected peers, to understand who gets an upload slot and tune
+// UploadSlots
+type UnchokePreview struct {
+	Seeding bool        // Peers are ranked by how fast they download from us instead of upload to us
+	Slots   int         // UploadSlots, one of them optimistic; 0 unchokes every interested peer
+	Peers   []PeerChoke // Interested peers best first, then the others by address
+}
+
+// PeerChoke is where a peer stands in the next choking round
+type PeerChoke struct {
+	Addr        string
+	Rank        int    // Position among the interested peers from 1, 0 for the others
+	Rate        int64  // Bytes per second over the last 30 seconds the peer is ranked by
+	Unchoked    bool   // We upload to the peer now
+	WillUnchoke bool   // The next round unchokes the peer, unless a random optimistic pick does
+	Reason      string // Why, e.g. "regular slot" or "outranked"
+}
+
+// UnchokePreview ranks the peers as the next choking round will, without
+// changing whom we upload to. While downloading the optimistic unchoke
+// moves on to a peer picked at random, so those that may get it are only
+// marked as candidates.
+func (dm *DownloadManager) UnchokePreview() UnchokePreview {
+	seeding := dm.SeedOnly || dm.PieceManager.WantedComplete()
+	sessions := dm.PeerPool.GetPeers()
+
+	// A copy, so the state the next round starts from stays as it is
+	dm.mu.Lock()
+	c := dm.choker
+	if seeding != c.seeding {
+		c.seeding, c.rounds = seeding, optimisticRounds // As rechoke does
+	}
+	preview := UnchokePreview{Seeding: seeding, Slots: dm.UploadSlots}
+	preview.Peers = c.preview(chokeCandidates(sessions, seeding), dm.UploadSlots)
+	dm.mu.Unlock()
+
+	ranked := make(map[string]bool)
+	for i := range preview.Peers {
+		p := &preview.Peers[i]
+		p.Unchoked = !sessions[p.Addr].AmChoking()
+		ranked[p.Addr] = true
+	}
+
+	var others []PeerChoke
+	for addr, session := range sessions {
+		if !ranked[addr] {
+			others = append(others, PeerChoke{Addr: addr, Unchoked: !session.AmChoking(), Reason: "not interested"})
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].Addr < others[j].Addr })
+	preview.Peers = append(preview.Peers, others...)
+
+	return preview
+}
+
+// preview ranks the interested candidates as choose does, without picking
+// an optimistic unchoke, and says why each would be unchoked or not
+func (c *choker) preview(candidates []chokeCandidate, slots int) []PeerChoke {
+	rankCandidates(candidates)
+
+	var peers []PeerChoke
+	add := func(cand chokeCandidate, willUnchoke bool, reason string) {
+		peers = append(peers, PeerChoke{
+			Addr:        cand.addr,
+			Rank:        len(peers) + 1,
+			Rate:        cand.rate,
+			WillUnchoke: willUnchoke,
+			Reason:      reason,
+		})
+	}
+
+	if slots <= 0 {
+		for _, cand := range candidates {
+			add(cand, true, "unlimited upload slots")
+		}
+		return peers
+	}
+
+	regular := slots - 1
+	if regular > len(candidates) {
+		regular = len(candidates)
+	}
+	for _, cand := range candidates[:regular] {
+		add(cand, true, "regular slot")
+	}
+
+	rest := candidates[regular:]
+	keeps := c.keepsOptimistic(rest)
+	var next string
+	if !keeps && len(rest) > 0 && (c.seeding || len(rest) == 1) {
+		next = c.nextTurn(rest)
+	}
+
+	for _, cand := range rest {
+		switch {
+		case keeps && cand.addr == c.optimistic:
+			add(cand, true, fmt.Sprintf("optimistic unchoke, round %d of %d", c.rounds+1, optimisticRounds))
+		case keeps:
+			add(cand, false, "outranked")
+		case cand.addr == next && c.seeding:
+			add(cand, true, "optimistic unchoke, longest without one")
+		case cand.addr == next:
+			add(cand, true, "optimistic unchoke, the only peer left")
+		case !c.seeding:
+			add(cand, false, fmt.Sprintf("outranked, 1 in %d chance of the optimistic unchoke", len(rest)))
+		default:
+			add(cand, false, "outranked, waiting for a turn at the optimistic unchoke")
+		}
+	}
+	return peers
+}
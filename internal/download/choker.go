@@ -0,0 +1,215 @@
+package download
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// DefaultUploadSlots is the number of interested peers unchoked at a time,
+// one of them optimistically
+const DefaultUploadSlots = 4
+
+const (
+	// chokeInterval is the time between two choking rounds
+	chokeInterval = 10 * time.Second
+
+	// optimisticRounds is the number of rounds the optimistic unchoke
+	// stays with the same peer
+	optimisticRounds = 3
+
+	// chokeRateWindow is the rolling window peers are ranked by, long
+	// enough that a peer pausing for a moment keeps its slot
+	chokeRateWindow = 30 * time.Second
+)
+
+// chokeCandidate is an interested peer competing for an upload slot
+type chokeCandidate struct {
+	addr        string
+	rate        int64 // Bytes per second the peer is ranked by
+	connectedAt time.Time
+}
+
+// choker hands out upload slots. While downloading it plays tit-for-tat:
+// the peers that sent us data fastest over the last chokeRateWindow are
+// unchoked, plus one optimistic unchoke picked at random from the others so
+// new peers get a chance to prove themselves. Once we seed nobody sends us data, so peers
+// are ranked by how fast they download from us instead, and the optimistic
+// slot rotates through the peers it hasn't been given to for the longest,
+// newest connections first, spreading the pieces to peers that just joined.
+type choker struct {
+	seeding    bool                 // Which ranking the previous round used
+	optimistic string               // Address of the optimistically unchoked peer
+	rounds     int                  // Rounds the optimistic unchoke has stayed with it
+	tried      map[string]time.Time // When peers last got the optimistic unchoke while seeding
+}
+
+// choose returns the peers to unchoke out of the interested candidates,
+// given the number of upload slots
+func (c *choker) choose(candidates []chokeCandidate, slots int, now time.Time) map[string]bool {
+	unchoke := make(map[string]bool)
+
+	rankCandidates(candidates)
+	regular := slots - 1
+	if regular > len(candidates) {
+		regular = len(candidates)
+	}
+	for _, cand := range candidates[:regular] {
+		unchoke[cand.addr] = true
+	}
+
+	rest := candidates[regular:]
+	if len(rest) == 0 {
+		c.optimistic = ""
+		return unchoke
+	}
+
+	if c.keepsOptimistic(rest) {
+		c.rounds++
+		unchoke[c.optimistic] = true
+		return unchoke
+	}
+
+	c.optimistic = c.pickOptimistic(rest, now)
+	c.rounds = 1
+	unchoke[c.optimistic] = true
+	return unchoke
+}
+
+// rankCandidates sorts the candidates best first: fastest, then newest
+// connection
+func rankCandidates(candidates []chokeCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.rate != b.rate {
+			return a.rate > b.rate
+		}
+		if !a.connectedAt.Equal(b.connectedAt) {
+			return a.connectedAt.After(b.connectedAt)
+		}
+		return a.addr < b.addr
+	})
+}
+
+// keepsOptimistic reports whether the optimistic unchoke stays for another
+// round: for a few rounds unless its peer left, lost interest or earned a
+// regular slot
+func (c *choker) keepsOptimistic(rest []chokeCandidate) bool {
+	for _, cand := range rest {
+		if cand.addr == c.optimistic && c.rounds < optimisticRounds {
+			return true
+		}
+	}
+	return false
+}
+
+// pickOptimistic picks the peer to unchoke optimistically: any of them at
+// random while downloading, the one whose turn it is while seeding
+func (c *choker) pickOptimistic(rest []chokeCandidate, now time.Time) string {
+	if !c.seeding {
+		return rest[rand.Intn(len(rest))].addr
+	}
+
+	if c.tried == nil {
+		c.tried = make(map[string]time.Time)
+	}
+
+	addr := c.nextTurn(rest)
+	c.tried[addr] = now
+	return addr
+}
+
+// nextTurn returns the peer whose turn for the optimistic unchoke it is
+// while seeding: the one that had it longest ago
+func (c *choker) nextTurn(rest []chokeCandidate) string {
+	// rest is sorted newest connection first among peers of equal rate, so
+	// ties go to the newest peer that never had a turn
+	best := rest[0]
+	for _, cand := range rest[1:] {
+		if c.tried[cand.addr].Before(c.tried[best.addr]) ||
+			(c.tried[cand.addr].Equal(c.tried[best.addr]) && cand.connectedAt.After(best.connectedAt)) {
+			best = cand
+		}
+	}
+	return best.addr
+}
+
+// rechoke runs a choking round: it ranks the interested peers by their
+// rolling transfer rates and unchokes the winners, choking everyone else
+func (dm *DownloadManager) rechoke(now time.Time) {
+	if dm.UploadSlots <= 0 || dm.seedingStopped() {
+		return
+	}
+
+	seeding := dm.SeedOnly || dm.PieceManager.WantedComplete()
+	sessions := dm.PeerPool.GetPeers()
+
+	dm.mu.Lock()
+	c := &dm.choker
+	if seeding != c.seeding {
+		if seeding {
+			fmt.Printf("Seeding, unchoking the peers that download from us fastest\n")
+		} else {
+			fmt.Printf("Downloading, unchoking the peers that upload to us fastest\n")
+		}
+		c.seeding = seeding
+		c.rounds = optimisticRounds // The optimistic unchoke moves on too
+	}
+
+	unchoke := c.choose(chokeCandidates(sessions, seeding), dm.UploadSlots, now)
+	for addr := range c.tried {
+		if _, ok := sessions[addr]; !ok {
+			delete(c.tried, addr)
+		}
+	}
+	dm.mu.Unlock()
+
+	for addr, session := range sessions {
+		var err error
+		if unchoke[addr] {
+			err = session.Unchoke()
+		} else {
+			err = session.Choke()
+		}
+		if err != nil {
+			fmt.Printf("Failed to update choking of %s: %v\n", addr, err)
+		}
+	}
+}
+
+// chokeCandidates returns the interested peers, ranked by how fast they
+// upload to us or, while seeding, download from us
+func chokeCandidates(sessions map[string]*peer.Session, seeding bool) []chokeCandidate {
+	var candidates []chokeCandidate
+	for addr, session := range sessions {
+		if !session.PeerInterested() {
+			continue
+		}
+
+		rates := session.Rate(chokeRateWindow)
+		cand := chokeCandidate{addr: addr, rate: rates.DownloadRate, connectedAt: session.ConnectedAt()}
+		if seeding {
+			cand.rate = rates.UploadRate
+		}
+		candidates = append(candidates, cand)
+	}
+	return candidates
+}
+
+// chokeWorker runs a choking round every chokeInterval
+func (dm *DownloadManager) chokeWorker() {
+	ticker := time.NewTicker(chokeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			dm.rechoke(now)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package download
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChokerLeechingTitForTat(t *testing.T) {
+	c := &choker{}
+	now := time.Now()
+	candidates := []chokeCandidate{
+		{addr: "slow", rate: 10},
+		{addr: "fast", rate: 300},
+		{addr: "medium", rate: 200},
+		{addr: "idle1"},
+		{addr: "idle2"},
+	}
+
+	unchoke := c.choose(candidates, 3, now)
+	if len(unchoke) != 3 || !unchoke["fast"] || !unchoke["medium"] {
+		t.Fatalf("choose() = %v, want fast, medium and one optimistic unchoke", unchoke)
+	}
+	optimistic := c.optimistic
+	if optimistic == "fast" || optimistic == "medium" || !unchoke[optimistic] {
+		t.Fatalf("optimistic unchoke = %q, want one of the slower peers", optimistic)
+	}
+
+	// The optimistic unchoke stays for optimisticRounds rounds
+	for i := 1; i < optimisticRounds; i++ {
+		if unchoke := c.choose(candidates, 3, now); !unchoke[optimistic] {
+			t.Fatalf("round %d dropped the optimistic unchoke %q", i+1, optimistic)
+		}
+	}
+
+	// Every interested peer fits
+	if unchoke := c.choose(candidates[:2], 3, now); len(unchoke) != 2 {
+		t.Errorf("choose() = %v with two candidates, want both", unchoke)
+	}
+}
+
+func TestChokerSeedingRotatesNewestFirst(t *testing.T) {
+	c := &choker{seeding: true}
+	start := time.Now()
+	candidates := func() []chokeCandidate {
+		return []chokeCandidate{
+			{addr: "downloader", rate: 500, connectedAt: start},
+			{addr: "old", connectedAt: start.Add(time.Second)},
+			{addr: "newer", connectedAt: start.Add(2 * time.Second)},
+			{addr: "newest", connectedAt: start.Add(3 * time.Second)},
+		}
+	}
+
+	var order []string
+	now := start
+	for i := 0; i < 3*optimisticRounds; i++ {
+		unchoke := c.choose(candidates(), 2, now)
+		if !unchoke["downloader"] || len(unchoke) != 2 {
+			t.Fatalf("choose() = %v, want the fastest downloader and one more", unchoke)
+		}
+		if len(order) == 0 || order[len(order)-1] != c.optimistic {
+			order = append(order, c.optimistic)
+		}
+		now = now.Add(chokeInterval)
+	}
+
+	want := []string{"newest", "newer", "old"}
+	if len(order) != len(want) || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+		t.Errorf("optimistic unchokes went to %q, want %q", order, want)
+	}
+
+	// Everyone had a turn, so the one who waited longest is next
+	if c.choose(candidates(), 2, now); c.optimistic != "newest" {
+		t.Errorf("optimistic unchoke = %q after a full rotation, want newest", c.optimistic)
+	}
+}
+
+func TestChokerPreview(t *testing.T) {
+	start := time.Now()
+	candidates := func() []chokeCandidate {
+		return []chokeCandidate{
+			{addr: "slow", rate: 10, connectedAt: start},
+			{addr: "fast", rate: 300, connectedAt: start},
+			{addr: "idle1", connectedAt: start.Add(time.Second)},
+			{addr: "idle2", connectedAt: start.Add(2 * time.Second)},
+		}
+	}
+
+	// While seeding the preview predicts every round exactly
+	c := &choker{seeding: true}
+	now := start
+	for round := 0; round < 2*optimisticRounds; round++ {
+		preview := c.preview(candidates(), 3)
+		unchoke := c.choose(candidates(), 3, now)
+
+		if len(preview) != 4 || preview[0].Addr != "fast" || preview[0].Rank != 1 || preview[0].Reason != "regular slot" {
+			t.Fatalf("round %d: preview = %+v, want fast ranked first in a regular slot", round+1, preview)
+		}
+		for _, p := range preview {
+			if p.WillUnchoke != unchoke[p.Addr] {
+				t.Errorf("round %d: preview of %s = %+v, but choose() = %v", round+1, p.Addr, p, unchoke)
+			}
+		}
+		now = now.Add(chokeInterval)
+	}
+
+	// While downloading the optimistic unchoke is random once it moves on
+	c = &choker{}
+	c.choose(candidates(), 3, start)
+	preview := c.preview(candidates(), 3)
+	for _, p := range preview[2:] {
+		if p.Addr == c.optimistic {
+			if !p.WillUnchoke || p.Reason != "optimistic unchoke, round 2 of 3" {
+				t.Errorf("preview of the optimistic unchoke = %+v", p)
+			}
+		} else if p.WillUnchoke {
+			t.Errorf("preview of %s = %+v, want it to stay choked", p.Addr, p)
+		}
+	}
+
+	c.rounds = optimisticRounds
+	for _, p := range c.preview(candidates(), 3)[2:] {
+		if p.WillUnchoke || p.Reason != "outranked, 1 in 2 chance of the optimistic unchoke" {
+			t.Errorf("preview of %s once the optimistic unchoke moves on = %+v", p.Addr, p)
+		}
+	}
+
+	for _, p := range c.preview(candidates(), 0) {
+		if !p.WillUnchoke {
+			t.Errorf("preview of %s without a slot limit = %+v, want it unchoked", p.Addr, p)
+		}
+	}
+}
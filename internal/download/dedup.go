@@ -0,0 +1,155 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// DedupIndex remembers the files of completed torrents so that identical
+// files in torrents added later are linked to the existing copy instead of
+// being downloaded and stored twice. Candidate files are matched by length
+// and confirmed against the new torrent's piece hashes.
+type DedupIndex struct {
+	files map[int64][]string // file length -> paths of complete files
+	mu    sync.Mutex
+}
+
+// NewDedupIndex creates an empty index
+func NewDedupIndex() *DedupIndex {
+	return &DedupIndex{
+		files: make(map[int64][]string),
+	}
+}
+
+// AddStorage registers every file of a completed torrent
+func (d *DedupIndex) AddStorage(fs *FileStorage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, length := range fileLengths(fs.Torrent) {
+		path := fs.filePath(i)
+
+		known := false
+		for _, existing := range d.files[length] {
+			if existing == path {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			d.files[length] = append(d.files[length], path)
+		}
+	}
+}
+
+// LinkInto links files of torrentFile saved as name below basepath ("" for
+// its Info.Name) to identical files in the index. Files are cloned with a
+// reflink where the filesystem supports it and hard-linked otherwise. It
+// returns the number of files linked.
+func (d *DedupIndex) LinkInto(torrentFile *torrent.TorrentFile, basepath, name string) (int, error) {
+	target := &FileStorage{Torrent: torrentFile, BasePath: basepath, Name: name}
+	if abs, err := filepath.Abs(basepath); err == nil {
+		target.BasePath = abs
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	linked := 0
+	var fileStart int64
+	for i, length := range fileLengths(torrentFile) {
+		path := target.filePath(i)
+
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			for _, candidate := range d.files[length] {
+				if !matchesPieces(torrentFile, candidate, fileStart, length) {
+					continue
+				}
+
+				if err := linkFile(candidate, path); err != nil {
+					return linked, err
+				}
+
+				linked++
+				break
+			}
+		}
+
+		fileStart += length
+	}
+
+	return linked, nil
+}
+
+// matchesPieces checks the file at path against the pieces of torrentFile
+// lying entirely within the file that starts at fileStart. Files that don't
+// contain a whole piece cannot be confirmed and never match.
+func matchesPieces(torrentFile *torrent.TorrentFile, path string, fileStart, length int64) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	pieceLength := torrentFile.Info.PieceLength
+	first := int((fileStart + pieceLength - 1) / pieceLength)
+
+	checked := 0
+	for index := first; index < torrentFile.NumPieces(); index++ {
+		pieceStart := int64(index) * pieceLength
+		size := torrentFile.PieceSize(index)
+		if pieceStart+size > fileStart+length {
+			break
+		}
+
+		data := make([]byte, size)
+		if _, err := file.ReadAt(data, pieceStart-fileStart); err != nil {
+			return false
+		}
+
+		if hashSum(data) != torrentFile.PiecesHash[index] {
+			return false
+		}
+		checked++
+	}
+
+	return checked > 0
+}
+
+// linkFile makes dst share the data of src, preferring a copy-on-write
+// clone so later writes to one file don't affect the other
+func linkFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", dst, err)
+	}
+
+	if err := reflink(src, dst); err == nil {
+		return nil
+	}
+
+	if err := os.Link(src, dst); err != nil {
+		return fmt.Errorf("failed to link '%s' to '%s': %w", dst, src, err)
+	}
+
+	return nil
+}
+
+// fileLengths returns the length of every file in the torrent
+func fileLengths(torrentFile *torrent.TorrentFile) []int64 {
+	if !torrentFile.Info.IsDirectory {
+		return []int64{torrentFile.Info.Length}
+	}
+
+	lengths := make([]int64, len(torrentFile.Info.Files))
+	for i, file := range torrentFile.Info.Files {
+		lengths[i] = file.Length
+	}
+
+	return lengths
+}
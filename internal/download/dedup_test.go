@@ -0,0 +1,68 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestDedupIndexLinkInto(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4) // 64 bytes
+
+	// A single-file torrent with 16 byte pieces
+	hashes := make([][20]byte, 4)
+	for i := range hashes {
+		hashes[i] = sha1.Sum(data[i*16 : (i+1)*16])
+	}
+
+	original := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 16, Name: "original.bin", Length: 64},
+		PiecesHash: hashes,
+	}
+
+	dir := t.TempDir()
+	fs, err := NewFileStorage(original, dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	for i := range hashes {
+		if err := fs.WritePiece(i, data[i*16:(i+1)*16]); err != nil {
+			t.Fatalf("WritePiece(%d) error = %v", i, err)
+		}
+	}
+	fs.Close()
+
+	index := NewDedupIndex()
+	index.AddStorage(fs)
+
+	// The same payload under another name is linked
+	copyTorrent := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 16, Name: "copy.bin", Length: 64},
+		PiecesHash: hashes,
+	}
+
+	linked, err := index.LinkInto(copyTorrent, dir, "")
+	if err != nil || linked != 1 {
+		t.Fatalf("LinkInto() = %d, %v, want 1, nil", linked, err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "copy.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("linked file content = %q, %v", got, err)
+	}
+
+	// A payload of the same length with different hashes is not linked
+	other := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 16, Name: "other.bin", Length: 64},
+		PiecesHash: make([][20]byte, 4),
+	}
+
+	linked, err = index.LinkInto(other, dir, "")
+	if err != nil || linked != 0 {
+		t.Errorf("LinkInto() = %d, %v, want 0, nil", linked, err)
+	}
+}
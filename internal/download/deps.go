@@ -0,0 +1,47 @@
+package download
+
+import (
+	"context"
+	"net"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// The download manager reaches the network and the disk only through these
+// interfaces. NewDownloadManager fills them with the real implementations;
+// tests replace them before Start to simulate slow trackers, peers and
+// disks without either.
+
+// Announcer contacts trackers; *tracker.Client is the default
+type Announcer interface {
+	Announce(ctx context.Context, trackerURL string, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error)
+}
+
+// PeerPool manages the peer connections; *peer.Pool is the default
+type PeerPool interface {
+	Connect(peers []tracker.Peer, maxConnections int) int
+	Listen(addr string) (net.Listener, error)
+	SetMaxSessions(n int)
+	SetOnSessionOpened(callback func(*peer.Session))
+	GetConnectedPeers() int
+	GetPeers() map[string]*peer.Session
+	GetUnchokedSessions() []*peer.Session
+	GetSessionsWithoutPiece(pieceIndex int) []*peer.Session
+	BroadcastHave(pieceIndex int)
+	Ban(addr string)
+	IsBanned(addr string) bool
+	CloseAll()
+	Stats() peer.ConnStats
+}
+
+// Storage holds the downloaded data; *FileStorage is the default
+type Storage interface {
+	BufferBlocks(pieceIndex int, blocks [][]byte) error
+	Flush() error
+	Sync() error
+	ReadPiece(pieceIndex int, length int) ([]byte, error)
+	CheckFileSizes() error
+	DiskStats() DiskStats
+	Close() error
+}
@@ -0,0 +1,92 @@
+package download
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DiskStats counts the work a FileStorage has done. Comparing the write
+// rate and latency with the download rate shows whether the network or
+// the disk holds a download back.
+type DiskStats struct {
+	Writes       int64         // Write calls made to files
+	BytesWritten int64         // Bytes written to files
+	WriteTime    time.Duration // Time spent in writes
+	Syncs        int64         // Times the files were flushed to stable storage
+	SyncTime     time.Duration // Time spent in fsync
+	LastSync     time.Duration // Duration of the most recent fsync
+	QueuedPieces int           // Verified pieces waiting in the write buffer
+	QueuedBytes  int64         // Bytes waiting in the write buffer
+}
+
+// diskCounters are updated by storage and read by the stats worker without
+// waiting for a slow write to finish
+type diskCounters struct {
+	writes       atomic.Int64
+	bytesWritten atomic.Int64
+	writeTime    atomic.Int64
+	syncs        atomic.Int64
+	syncTime     atomic.Int64
+	lastSync     atomic.Int64
+	queuedPieces atomic.Int64
+	queuedBytes  atomic.Int64
+}
+
+// countWrite records a write of n bytes that took d
+func (c *diskCounters) countWrite(n int, d time.Duration) {
+	c.writes.Add(1)
+	c.bytesWritten.Add(int64(n))
+	c.writeTime.Add(int64(d))
+}
+
+// countSync records an fsync of all files that took d
+func (c *diskCounters) countSync(d time.Duration) {
+	c.syncs.Add(1)
+	c.syncTime.Add(int64(d))
+	c.lastSync.Store(int64(d))
+}
+
+// setQueued records the contents of the write buffer
+func (c *diskCounters) setQueued(pieces, bytes int) {
+	c.queuedPieces.Store(int64(pieces))
+	c.queuedBytes.Store(int64(bytes))
+}
+
+// snapshot returns the current counts
+func (c *diskCounters) snapshot() DiskStats {
+	return DiskStats{
+		Writes:       c.writes.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+		WriteTime:    time.Duration(c.writeTime.Load()),
+		Syncs:        c.syncs.Load(),
+		SyncTime:     time.Duration(c.syncTime.Load()),
+		LastSync:     time.Duration(c.lastSync.Load()),
+		QueuedPieces: int(c.queuedPieces.Load()),
+		QueuedBytes:  c.queuedBytes.Load(),
+	}
+}
+
+// DiskStats returns the work the storage has done so far
+func (fs *FileStorage) DiskStats() DiskStats {
+	return fs.disk.snapshot()
+}
+
+// Sync flushes the written data of every file to stable storage
+func (fs *FileStorage) Sync() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	start := time.Now()
+	for i, file := range fs.Files {
+		if file == nil {
+			continue
+		}
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("%w: failed to sync file %d: %v", ErrStorageUnavailable, i, err)
+		}
+	}
+	fs.disk.countSync(time.Since(start))
+
+	return nil
+}
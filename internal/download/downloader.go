@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 
 var (
 	ErrDownloadCancelled = errors.New("download cancelled")
+	ErrDeadlineExceeded  = errors.New("download deadline exceeded")
 )
 
 // Stats contains download statistics
@@ -22,40 +24,218 @@ type Stats struct {
 	Uploaded        int64         // Bytes uploaded
 	DownloadSpeed   int64         // Bytes per second
 	UploadSpeed     int64         // Bytes per second
+	PayloadRead     int64         // Block data received from peers
+	PayloadWritten  int64         // Block data sent to peers
+	OverheadRead    int64         // Protocol bytes received from peers (headers, keep-alives, haves, ...)
+	OverheadWritten int64         // Protocol bytes sent to peers
+	RawDownloadRate int64         // Bytes per second received from peers, payload and overhead
+	RawUploadRate   int64         // Bytes per second sent to peers, payload and overhead
 	PiecesCompleted int           // Number of completed pieces
 	PiecesTotal     int           // Total number of pieces
 	Progress        float64       // Download progress percentage
 	ActivePeers     int           // Number of connected peers
+	KnownPeers      int           // Number of peers found by the peer sources that haven't aged out
+	SkippedTiers    int           // Tracker tiers skipped after failing repeatedly
+	Availability    float64       // Distributed copies among connected peers, see DownloadManager.Availability
 	State           string        // Current state
 	TimeRemaining   time.Duration // Estimated time remaining
+	StuckPieces     int           // Pieces that failed RetryPolicy.WarnAfter times or more
+	PiecesChecked   int           // Pieces hashed by the latest check of the data on disk
+	Unverified      int           // Pieces trusted from resume data and not yet re-verified
+
+	Disk             DiskStats     // Work done by the storage so far
+	DiskWriteRate    int64         // Bytes per second written to disk
+	DiskWriteLatency time.Duration // Average duration of the writes since the last update
+	InFlightBytes    int64         // Piece data requested or received and not yet on disk
+	Throttled        bool          // Block requests are paused until the disk catches up
+	PartialBytes     int64         // Memory taken by incomplete pieces
 }
 
 // DownloadManager coordinates the entire download process
 type DownloadManager struct {
 	Torrent      *torrent.TorrentFile
 	PeerID       [20]byte
-	PeerPool     *peer.Pool
+	PeerPool     PeerPool
+	Tracker      Announcer
 	PieceManager *PieceManager
-	Storage      *FileStorage
+	Storage      Storage // Files in the download path unless set before Start
 	Stats        Stats
 
-	maxPeers     int
+	// Metainfo is the .torrent file of the torrent when it is known as
+	// bytes, such as the one built from the metadata of a magnet link, so
+	// it can be exported
+	Metainfo []byte
+
+	maxPeers     int // Target number of connected peers
 	pieceTimeout time.Duration
 	downloadPath string
+	saveName     string // Name the torrent is saved as below downloadPath, "" for Info.Name
+	displayName  string // Name shown to the user, "" for Info.Name
+	listenPort   int
+	trackerID    string // Tracker ID to send back to the tracker
+	trackerIndex int    // Position of the tracker announced to in trackerList
+	externalIP   net.IP // Our address as seen by the tracker
 
 	activePieces  map[int]string    // pieceIndex -> peerAddr
 	pieceTimeouts map[int]time.Time // pieceIndex -> timeout
-
-	cancel context.CancelFunc
-	ctx    context.Context
-	mu     sync.Mutex
+	hashFailures  *hashFailureTracker
+	tuner         *peerTuner
+	pieceFailures map[int]int // pieceIndex -> failed verifications since it last verified
+	readAhead     *ReadAhead
+	webSeeds      []*webSeed
+	startedAt     time.Time
+	storageErr    error // Set while downloading is paused by a storage failure
+	badPieces     []int // Pieces of the existing data that failed the check, set before the workers start
+	listener      net.Listener
+	stats         *statsPublisher
+	uploadCache   *uploadCache
+	throttled     map[int]throttledPiece // Pieces waiting for the in-flight budget
+	stall         stallState
+	starvation    starvationState
+	choker        choker
+	trusted       map[int]bool // Pieces marked complete from TrustedPieces and not yet re-verified
+	reverifyQueue []int        // Trusted pieces in the order they are re-verified
+	rechecking    bool         // ForceRecheck is hashing the data on disk
+
+	candidates    *peerCandidates  // Peers found by every source, merged
+	reannounce    chan struct{}    // Signals the peer manager to announce immediately
+	forceAnnounce chan struct{}    // Like reannounce, ignoring the tracker's minimum interval
+	events        *announceEvents  // Events each tracker has acknowledged
+	health        *trackerHealth   // Tiers that keep failing are skipped
+	backoffs      *trackerBackoffs // How long each tracker asked us to wait
+
+	cancel      context.CancelFunc
+	ctx         context.Context
+	mu          sync.Mutex
+	releaseOnce sync.Once // Shutdown closes the listener and storage once
 
 	// Callbacks
 	OnPieceCompleted   func(index int)
 	OnPeerConnected    func(addr string)
 	OnPeerDisconnected func(addr string)
 	OnDownloadComplete func()
-	OnStatsUpdated     func(stats Stats)
+	OnVerifiedComplete func()
+	OnStorageError     func(err error)
+	OnTrackerError     func(err error)
+	OnSeedingStopped   func()
+	OnAborted          func(err error) // The StartContext context ended before the download completed
+	OnPieceStuck       func(index, failures int)
+	OnStalled          func(err error)          // Pieces stayed missing from the swarm for StallTimeout, see ErrTorrentUnavailable
+	OnStatsUpdated     func(stats Stats)        // Called from its own goroutine, never under dm.mu
+	OnCheckProgress    func(checked, total int) // Pieces hashed so far by a check of the data on disk
+	OnPieceTimings     func(t PieceTimings)     // Steps of every downloaded piece from its first block to the have messages
+	OnDisplayNameSet   func(name string)        // SetDisplayName changed the display name, "" when it was cleared
+
+	// SlowPieceThreshold logs the steps of pieces taking longer than this
+	// from their last block to the have messages (0 never does)
+	SlowPieceThreshold time.Duration
+
+	// StatsInterval is the minimum time between OnStatsUpdated calls;
+	// updates in between are coalesced into the latest one
+	StatsInterval time.Duration
+
+	// CheckWorkers is the number of pieces hashed at once when checking the
+	// data on disk (0 uses one per CPU)
+	CheckWorkers int
+
+	// VerifyOnComplete re-hashes the data on disk once every piece has been downloaded
+	VerifyOnComplete bool
+
+	// AssumeData points the download at data that already exists in the
+	// download path; pieces are verified from disk and seeded right away
+	AssumeData bool
+
+	// Repair checks the data in the download path like AssumeData, but
+	// creates missing files and resizes wrong-sized ones instead of
+	// refusing them, then downloads only the pieces that failed the check
+	Repair bool
+
+	// TrustedPieces, when set, are the pieces an earlier run completed.
+	// They are marked complete without hashing the data on disk, which
+	// takes the place of the check AssumeData and SeedOnly would run, and
+	// are re-verified one every ReverifyInterval while seeding. A trusted
+	// piece is also verified before it is first uploaded.
+	TrustedPieces    peer.Bitfield
+	ReverifyInterval time.Duration
+
+	// PeerSources are consulted for peers in addition to the tracker
+	PeerSources []PeerSource
+
+	// SeedOnly never requests pieces: the download starts from verified
+	// local data and only uploads. NoSeed drops every connection and stops
+	// announcing once the download completes.
+	SeedOnly bool
+	NoSeed   bool
+
+	// AutoTunePeers adjusts the peer target to the achieved throughput
+	// within the bounds of PeerTuning instead of keeping it fixed
+	AutoTunePeers bool
+	PeerTuning    PeerTuning
+
+	// DisableTracker never contacts the tracker, for direct transfers
+	// between peers added by hand
+	DisableTracker bool
+
+	// ListenAddr, when set, accepts incoming peer connections on this address
+	ListenAddr string
+
+	// Dedup, when set, links files identical to ones in earlier torrents
+	// instead of downloading them, and records this torrent's files once complete
+	Dedup *DedupIndex
+
+	// WebSeeds (BEP 19) and HTTPSeeds (BEP 17) are HTTP sources used
+	// alongside peers as decided by WebSeedPolicy
+	WebSeeds      []string
+	HTTPSeeds     []string
+	WebSeedPolicy WebSeedPolicy
+
+	// WriteBufferSize is the number of bytes of verified pieces buffered in
+	// memory and written together (0 writes every piece immediately)
+	WriteBufferSize int
+
+	// InFlightBudget is the number of bytes of piece data that may be
+	// requested or waiting for the disk at once; beyond it no new blocks
+	// are requested until writes catch up (0 is unlimited). It should be
+	// larger than WriteBufferSize.
+	InFlightBudget int64
+
+	// PartialPieceBudget is the memory incomplete pieces may take up; beyond
+	// it pieces that already hold blocks are finished before new ones are
+	// started (0 is unlimited)
+	PartialPieceBudget int64
+
+	// UploadSlots is the number of interested peers whose requests we
+	// serve at a time, chosen every round by tit-for-tat while downloading
+	// and by upload speed while seeding (0 serves every interested peer)
+	UploadSlots int
+
+	// UploadCacheSize is the number of bytes of uploaded pieces kept in
+	// memory; cached pieces are suggested to Fast extension peers
+	UploadCacheSize int
+
+	// Sequential downloads pieces roughly in order, as picked within
+	// SequentialWindow; streaming with EnableStreaming implies it
+	Sequential       bool
+	SequentialWindow SequentialWindow
+
+	// BandwidthWeight is this torrent's share of the global rate limits
+	// while other torrents compete for them, relative to the default of 1
+	BandwidthWeight float64
+
+	// StallTimeout, when set, moves the download to the "Stalled (missing
+	// pieces)" state once the connected peers have lacked a piece we need
+	// and nothing was completed for this long. StopWhenStalled then also
+	// stops the download.
+	StallTimeout    time.Duration
+	StopWhenStalled bool
+
+	// NameCollisions decides where the torrent is saved when another
+	// torrent with the same name was saved to the download path before
+	NameCollisions NameCollisionPolicy
+
+	// GeoIP, when set, returns the ISO country code of a peer address for
+	// GetPeerStats, or "" when unknown
+	GeoIP func(ip net.IP) string
 }
 
 // NewDownloadManager creates a new download manager
@@ -72,15 +252,36 @@ func NewDownloadManager(
 	}
 
 	return &DownloadManager{
-		Torrent:       torrentFile,
-		PeerID:        peerID,
-		PeerPool:      peer.NewPool(torrentFile.InfoHash, peerID),
-		PieceManager:  NewPieceManager(torrentFile),
-		downloadPath:  downloadPath,
-		maxPeers:      maxPeers,
-		pieceTimeout:  5 * time.Minute,
-		activePieces:  make(map[int]string),
-		pieceTimeouts: make(map[int]time.Time),
+		Torrent:            torrentFile,
+		PeerID:             peerID,
+		PeerPool:           peer.NewPool(torrentFile.InfoHash, peerID),
+		Tracker:            tracker.NewClient(peerID, 6881),
+		PieceManager:       NewPieceManager(torrentFile),
+		downloadPath:       downloadPath,
+		maxPeers:           maxPeers,
+		listenPort:         6881,
+		candidates:         newPeerCandidates(),
+		reannounce:         make(chan struct{}, 1),
+		forceAnnounce:      make(chan struct{}, 1),
+		events:             newAnnounceEvents(),
+		health:             newTrackerHealth(),
+		backoffs:           newTrackerBackoffs(),
+		pieceTimeout:       5 * time.Minute,
+		WebSeeds:           append([]string(nil), torrentFile.URLList...),
+		HTTPSeeds:          append([]string(nil), torrentFile.HTTPSeeds...),
+		WebSeedPolicy:      DefaultWebSeedPolicy(),
+		PeerTuning:         DefaultPeerTuning(),
+		SequentialWindow:   DefaultSequentialWindow(),
+		StatsInterval:      DefaultStatsInterval,
+		UploadCacheSize:    DefaultUploadCacheSize,
+		UploadSlots:        DefaultUploadSlots,
+		InFlightBudget:     DefaultInFlightBudget,
+		PartialPieceBudget: DefaultPartialPieceBudget,
+		stats:              newStatsPublisher(),
+		activePieces:       make(map[int]string),
+		pieceTimeouts:      make(map[int]time.Time),
+		hashFailures:       newHashFailureTracker(),
+		pieceFailures:      make(map[int]int),
 		Stats: Stats{
 			PiecesTotal: torrentFile.NumPieces(),
 			State:       "Initializing",
@@ -90,13 +291,107 @@ func NewDownloadManager(
 
 // Start begins the download process
 func (dm *DownloadManager) Start() error {
-	// Create storage
+	return dm.StartContext(context.Background())
+}
+
+// StartContext begins the download like Start, and aborts it if ctx is
+// cancelled or its deadline passes before the download completes. The
+// download is then stopped and OnAborted receives ErrDeadlineExceeded or
+// ErrDownloadCancelled. Once complete, seeding is not limited by ctx.
+func (dm *DownloadManager) StartContext(ctx context.Context) error {
+	// Stay out of the files of other torrents with the same name; existing
+	// data is used where it is
+	if dm.Storage == nil && !dm.AssumeData && !dm.SeedOnly && !dm.Repair {
+		dir, name, err := ResolveSaveName(dm.downloadPath, dm.Torrent, dm.NameCollisions)
+		if err != nil {
+			return fmt.Errorf("failed to pick a save name: %w", err)
+		}
+		dm.downloadPath, dm.saveName = dir, name
+	}
+
+	// Link files we already have from other torrents
+	linked := 0
+	if dm.Dedup != nil && !dm.AssumeData && !dm.Repair && dm.TrustedPieces == nil && dm.Storage == nil {
+		var err error
+		linked, err = dm.Dedup.LinkInto(dm.Torrent, dm.downloadPath, dm.saveName)
+		if err != nil {
+			fmt.Printf("Failed to link duplicate files: %v\n", err)
+		}
+	}
+
+	// Create storage, unless it was supplied
 	var err error
-	dm.Storage, err = NewFileStorage(dm.Torrent, dm.downloadPath)
-	if err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
+	if dm.Storage == nil {
+		var fs *FileStorage
+		if dm.AssumeData || dm.SeedOnly {
+			fs, err = OpenExistingStorage(dm.Torrent, dm.downloadPath)
+		} else {
+			fs, err = NewFileStorageAs(dm.Torrent, dm.downloadPath, dm.saveName)
+		}
+		if err == nil && dm.Repair {
+			if err = fs.ResizeFiles(); err != nil {
+				fs.Close()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to initialize storage: %w", err)
+		}
+		fs.BufferLimit = dm.WriteBufferSize
+		dm.Storage = fs
+	}
+
+	if dm.TrustedPieces != nil && !dm.Repair {
+		trusted, err := dm.trustResume()
+		if err != nil {
+			dm.Storage.Close()
+			return fmt.Errorf("failed to load resume data: %w", err)
+		}
+		fmt.Printf("Resumed %d of %d pieces without checking, verifying them in the background\n", trusted, dm.Torrent.NumPieces())
+	} else if dm.AssumeData || dm.SeedOnly {
+		good, err := dm.checkExistingData()
+		if err != nil {
+			dm.Storage.Close()
+			return fmt.Errorf("failed to check existing data: %w", err)
+		}
+		fmt.Printf("Existing data has %d of %d pieces\n", good, dm.Torrent.NumPieces())
+	} else if dm.Repair {
+		good, err := dm.checkExistingData()
+		if err != nil {
+			dm.Storage.Close()
+			return fmt.Errorf("failed to check existing data: %w", err)
+		}
+		fmt.Printf("Existing data has %d of %d pieces, repairing %d\n", good, dm.Torrent.NumPieces(), dm.Torrent.NumPieces()-good)
+	} else if linked > 0 {
+		good, err := dm.checkExistingData()
+		if err != nil {
+			dm.Storage.Close()
+			return fmt.Errorf("failed to check linked files: %w", err)
+		}
+		fmt.Printf("Linked %d files from other torrents (%d pieces)\n", linked, good)
 	}
 
+	dm.uploadCache = newUploadCache(dm.UploadCacheSize)
+	peer.SetTorrentWeight(dm.Torrent.InfoHash, dm.BandwidthWeight)
+	dm.PeerPool.SetOnSessionOpened(dm.sessionOpened)
+
+	// Accept incoming peers and announce the port we actually listen on
+	if dm.ListenAddr != "" {
+		dm.PeerPool.SetMaxSessions(dm.MaxPeers())
+		dm.listener, err = dm.PeerPool.Listen(dm.ListenAddr)
+		if err != nil {
+			dm.Storage.Close()
+			return err
+		}
+
+		dm.mu.Lock()
+		dm.listenPort = dm.listener.Addr().(*net.TCPAddr).Port
+		dm.mu.Unlock()
+		fmt.Printf("Listening for peers on %s\n", dm.listener.Addr())
+	}
+
+	dm.initWebSeeds()
+	dm.startedAt = time.Now()
+
 	// Create context with cancellation
 	dm.ctx, dm.cancel = context.WithCancel(context.Background())
 
@@ -104,83 +399,304 @@ func (dm *DownloadManager) Start() error {
 	go dm.peerManagerWorker()
 	go dm.pieceManagerWorker()
 	go dm.statsWorker()
+	go dm.chokeWorker()
+	if len(dm.trusted) > 0 {
+		go dm.reverifyWorker()
+	}
+	go dm.stats.run(dm.StatsInterval, dm.deliverStats)
+	go dm.abortOnDone(ctx)
 
-	dm.updateState("Started")
+	if dm.SeedOnly {
+		dm.updateState("Seeding")
+	} else {
+		dm.updateState("Started")
+	}
 
 	return nil
 }
 
-// Stop stops the download process
-func (dm *DownloadManager) Stop() {
-	if dm.cancel != nil {
-		dm.cancel()
+// abortOnDone stops the download when ctx ends before it completes
+func (dm *DownloadManager) abortOnDone(ctx context.Context) {
+	if ctx.Done() == nil {
+		return
 	}
 
-	if dm.Storage != nil {
-		dm.Storage.Close()
+	select {
+	case <-dm.ctx.Done():
+		return
+	case <-ctx.Done():
+	}
+
+	if dm.PieceManager.WantedComplete() {
+		return
+	}
+
+	err := ErrDownloadCancelled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = ErrDeadlineExceeded
+	}
+	err = fmt.Errorf("%w with %d of %d pieces", err, dm.PieceManager.DownloadedCount(), dm.PieceManager.PieceCount())
+
+	fmt.Printf("Aborting download: %v\n", err)
+	dm.Stop()
+	dm.updateState("Aborted")
+
+	if dm.OnAborted != nil {
+		dm.OnAborted(err)
 	}
+}
 
-	dm.updateState("Stopped")
+// Stop stops the download process and tells the tracker we left the
+// swarm, however long that takes; see Shutdown to bound it
+func (dm *DownloadManager) Stop() {
+	dm.Shutdown(context.Background(), nil)
 }
 
-// peerManagerWorker manages peer connections
+// peerManagerWorker connects to the peers found by every peer source
 func (dm *DownloadManager) peerManagerWorker() {
-	trackerInterval := 30 * time.Second
-	trackerTicker := time.NewTicker(trackerInterval)
-	defer trackerTicker.Stop()
+	var sources []PeerSource
+	if !dm.DisableTracker {
+		sources = append(sources, newTrackerSource(dm))
+	}
+	sources = append(sources, dm.PeerSources...)
+
+	found := make(chan PeerInfo)
+	for _, source := range sources {
+		if err := source.Start(dm.ctx); err != nil {
+			fmt.Printf("Failed to start peer source: %v\n", err)
+			continue
+		}
+
+		go func(peers <-chan PeerInfo) {
+			for {
+				select {
+				case <-dm.ctx.Done():
+					return
+				case info, ok := <-peers:
+					if !ok {
+						return // The source is done
+					}
+					select {
+					case found <- info:
+					case <-dm.ctx.Done():
+						return
+					}
+				}
+			}
+		}(source.Peers())
+	}
+
+	// Peers imported before the start are dialed right away
+	dm.connectCandidates()
 
-	// Initial peer discovery
-	dm.discoverPeers()
+	ticker := time.NewTicker(candidateCheckInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-dm.ctx.Done():
 			return
-		case <-trackerTicker.C:
-			dm.discoverPeers()
+		case info := <-found:
+			// Merge everything that is already queued in one go
+			batch := []PeerInfo{info}
+		drain:
+			for {
+				select {
+				case info := <-found:
+					batch = append(batch, info)
+				default:
+					break drain
+				}
+			}
+
+			dm.candidates.add(batch, time.Now())
+			dm.connectCandidates()
+		case <-ticker.C:
+			if dropped := dm.candidates.expire(time.Now(), candidateMaxAge); dropped > 0 {
+				fmt.Printf("Dropped %d stale peers\n", dropped)
+			}
+			dm.connectCandidates()
+		}
+	}
+}
+
+// requestAnnounce asks the tracker source to announce to the tracker without
+// waiting for the next interval
+func (dm *DownloadManager) requestAnnounce() {
+	select {
+	case dm.reannounce <- struct{}{}:
+	default:
+		// An announce is already pending
+	}
+}
+
+// SavePath returns the file or directory the torrent is saved to, which
+// differs from its name when NameCollisions moved it
+func (dm *DownloadManager) SavePath() string {
+	name := dm.saveName
+	if name == "" {
+		name = dm.Torrent.Info.Name
+	}
+	return hostPathRules.join(dm.downloadPath, name)
+}
+
+// DisplayName returns the name the torrent is shown as, which is its name
+// in the metainfo unless SetDisplayName overrode it
+func (dm *DownloadManager) DisplayName() string {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.displayName == "" {
+		return dm.Torrent.Info.Name
+	}
+	return dm.displayName
+}
+
+// SetDisplayName overrides the name the torrent is shown as, without
+// renaming its files; "" goes back to its name in the metainfo
+func (dm *DownloadManager) SetDisplayName(name string) {
+	if name == dm.Torrent.Info.Name {
+		name = ""
+	}
+
+	dm.mu.Lock()
+	changed := name != dm.displayName
+	dm.displayName = name
+	dm.mu.Unlock()
+
+	if changed && dm.OnDisplayNameSet != nil {
+		dm.OnDisplayNameSet(name)
+	}
+}
+
+// ListenPort returns the port announced to trackers
+func (dm *DownloadManager) ListenPort() int {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.listenPort
+}
+
+// SetListenPort changes the port announced to trackers, for example after
+// a port mapping changed. If the port differs from the current one every
+// tracker that saw us join is told right away so peers can keep connecting
+// to us.
+func (dm *DownloadManager) SetListenPort(port int) {
+	dm.mu.Lock()
+	changed := port != dm.listenPort
+	dm.listenPort = port
+	dm.mu.Unlock()
+
+	if changed && dm.ctx != nil {
+		fmt.Printf("Listen port changed to %d, re-announcing\n", port)
+		go dm.announcePort()
+	}
+}
+
+// announcePort re-announces to every tracker that saw us join, with the
+// current listen port. Trackers that haven't get it with their started.
+func (dm *DownloadManager) announcePort() {
+	for _, url := range dm.events.joinedTrackers() {
+		if _, err := dm.announceTo(dm.ctx, url, ""); err != nil {
+			fmt.Printf("Tracker error: %v\n", err)
 		}
 	}
 }
 
-// discoverPeers discovers new peers from the tracker
-func (dm *DownloadManager) discoverPeers() {
-	dm.updateState("Discovering peers")
+// announce contacts the current tracker and returns its response
+func (dm *DownloadManager) announce(ctx context.Context, event string) (*tracker.AnnounceResponse, error) {
+	return dm.announceTo(ctx, dm.trackerURL(), event)
+}
+
+// announceTo contacts a tracker and returns its response, giving up when
+// ctx is cancelled. Only the current tracker is sent, and hands out, a
+// tracker ID.
+func (dm *DownloadManager) announceTo(ctx context.Context, url, event string) (*tracker.AnnounceResponse, error) {
+	port := dm.ListenPort()
+	current := url == dm.trackerURL()
 
-	// Create tracker client
-	trackerClient := tracker.NewClient(dm.PeerID, 6881)
+	dm.mu.Lock()
+	trackerID := ""
+	if current {
+		trackerID = dm.trackerID
+	}
+	numWant := 0
+	if dm.starvation.wantPeers {
+		numWant = starvationNumWant
+	}
+	dm.mu.Unlock()
 
 	// Prepare announce request
 	req := &tracker.AnnounceRequest{
 		InfoHash:   dm.Torrent.InfoHash,
 		PeerID:     dm.PeerID,
-		Port:       6881,
+		Port:       port,
 		Uploaded:   dm.Stats.Uploaded,
 		Downloaded: dm.Stats.Downloaded,
-		Left:       dm.Torrent.TotalLength() - dm.Stats.Downloaded,
+		Left:       dm.PieceManager.BytesLeft(),
 		Compact:    true,
-		Event:      "",
+		Event:      event,
+		TrackerID:  trackerID,
+		NumWant:    numWant,
 	}
 
 	// Contact tracker
-	resp, err := trackerClient.Announce(dm.Torrent.Announce, req)
+	sent := time.Now()
+	resp, err := dm.Tracker.Announce(ctx, url, req)
+	tier := trackerTier(dm.Torrent, url)
 	if err != nil {
-		fmt.Printf("Tracker error: %v\n", err)
+		dm.backoffs.failed(url, sent)
+		if skip := dm.health.failed(tier, time.Now()); skip > 0 {
+			fmt.Printf("Trackers of tier %d keep failing, skipping them for %v\n", tier, skip)
+		}
+		return nil, err
+	}
+	dm.health.succeeded(tier)
+	dm.backoffs.succeeded(url, sent, resp)
+
+	dm.events.sent(url, event, dm.PieceManager.IsComplete())
+
+	if resp.WarningMessage != "" {
+		fmt.Printf("Tracker warning: %s\n", resp.WarningMessage)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if resp.TrackerID != "" && current {
+		dm.trackerID = resp.TrackerID
+	}
+	dm.starvation.wantPeers = false
+
+	if resp.ExternalIP != nil && !resp.ExternalIP.Equal(dm.externalIP) {
+		fmt.Printf("Tracker reports our external IP as %s\n", resp.ExternalIP)
+		dm.externalIP = resp.ExternalIP
+	}
+
+	return resp, nil
+}
+
+// ExternalIP returns our address as last reported by the tracker, or nil
+func (dm *DownloadManager) ExternalIP() net.IP {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.externalIP
+}
+
+// connectPeers connects to new peers until maxPeers are connected
+func (dm *DownloadManager) connectPeers(peers []tracker.Peer) {
+	if dm.seedingStopped() {
 		return
 	}
 
-	// Connect to new peers
 	currentPeers := dm.PeerPool.GetConnectedPeers()
-	neededPeers := dm.maxPeers - currentPeers
+	neededPeers := dm.MaxPeers() - currentPeers
 
 	if neededPeers > 0 {
 		// Try to connect to peers
-		connected := dm.PeerPool.Connect(resp.Peers, neededPeers)
+		connected := dm.PeerPool.Connect(peers, neededPeers)
 		if connected > 0 {
 			fmt.Printf("Connected to %d new peers\n", connected)
 		}
 	}
-
-	dm.updateState("Downloading")
 }
 
 // pieceManagerWorker manages piece downloads
@@ -188,21 +704,70 @@ func (dm *DownloadManager) pieceManagerWorker() {
 	pieceTicker := time.NewTicker(1 * time.Second)
 	defer pieceTicker.Stop()
 
+	// Bound how long buffered pieces stay in memory
+	flushTicker := time.NewTicker(5 * time.Second)
+	defer flushTicker.Stop()
+
 	for {
 		select {
 		case <-dm.ctx.Done():
 			return
 		case <-pieceTicker.C:
 			dm.managePieceDownloads()
+			dm.manageWebSeeds()
+		case <-flushTicker.C:
+			dm.flushStorage()
 		}
 	}
 }
 
+// flushStorage writes buffered pieces to disk, pausing the download when
+// storage fails and resuming it once a retry succeeds
+func (dm *DownloadManager) flushStorage() {
+	err := dm.Storage.Flush()
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if err != nil {
+		dm.pauseForStorageError(err)
+		return
+	}
+
+	if dm.storageErr != nil {
+		fmt.Printf("Storage recovered, resuming download\n")
+		dm.storageErr = nil
+		dm.setState("Downloading")
+	}
+}
+
+// pauseForStorageError stops requesting pieces until buffered pieces can be
+// written again; callers must hold dm.mu
+func (dm *DownloadManager) pauseForStorageError(err error) {
+	if dm.storageErr != nil {
+		dm.storageErr = err
+		return
+	}
+
+	fmt.Printf("Storage error, pausing download: %v\n", err)
+	dm.storageErr = err
+	dm.setState("Paused (storage error)")
+
+	if dm.OnStorageError != nil {
+		dm.OnStorageError(err)
+	}
+}
+
 // managePieceDownloads coordinates piece downloads
 func (dm *DownloadManager) managePieceDownloads() {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
+	// Don't fetch more data while it can't be written
+	if dm.storageErr != nil || dm.SeedOnly {
+		return
+	}
+
 	// Check for completed or timed out pieces
 	now := time.Now()
 	for pieceIndex, timeout := range dm.pieceTimeouts {
@@ -214,9 +779,21 @@ func (dm *DownloadManager) managePieceDownloads() {
 			dm.PieceManager.ResetPiece(pieceIndex)
 			delete(dm.activePieces, pieceIndex)
 			delete(dm.pieceTimeouts, pieceIndex)
+			delete(dm.throttled, pieceIndex)
+			dm.retryLater(pieceIndex)
 		}
 	}
 
+	// Let the disk catch up before asking for more data
+	dm.resumeThrottled()
+	if dm.overBudget() {
+		return
+	}
+
+	// Ask for more peers when those we have keep having nothing for us
+	idle := false
+	defer func() { dm.checkStarved(idle, now) }()
+
 	// Get all unchoked peer sessions
 	unchokedSessions := dm.PeerPool.GetUnchokedSessions()
 	if len(unchokedSessions) == 0 {
@@ -243,7 +820,7 @@ func (dm *DownloadManager) managePieceDownloads() {
 	}
 
 	// Try to download pieces
-	for _, session := range unchokedSessions {
+	for i, session := range unchokedSessions {
 		if len(dm.activePieces) >= maxConcurrent {
 			break
 		}
@@ -261,17 +838,68 @@ func (dm *DownloadManager) managePieceDownloads() {
 			continue
 		}
 
-		// Pick a piece to download
-		pieceToDownload := dm.PieceManager.PickPiece(bitfields, "rarest_first")
+		// Finish the pieces already in memory before starting new ones
+		// while they use up the memory budget; with nothing downloading,
+		// a new piece may start so the download can't stall
+		var pieceToDownload *Piece
+		if dm.overMemoryBudget() {
+			pieceToDownload = dm.PieceManager.PickPartial(bitfields[i])
+			if pieceToDownload == nil && len(dm.activePieces) > 0 {
+				continue
+			}
+		}
+
 		if pieceToDownload == nil {
+			pieceToDownload = dm.pickPiece(bitfields[i], bitfields)
+		}
+
+		if pieceToDownload == nil {
+			idle = true
 			continue
 		}
 
+		// Pieces that failed verification must come from a single peer
+		// that did not contribute to the failed attempt, while there is one
+		if dm.hashFailures.isSuspect(pieceToDownload.Index) {
+			if _, active := dm.activePieces[pieceToDownload.Index]; active {
+				continue
+			}
+
+			var holders []string
+			for j, other := range unchokedSessions {
+				if bitfields[j].HasPiece(pieceToDownload.Index) {
+					holders = append(holders, other.GetAddr())
+				}
+			}
+			if !dm.hashFailures.canServe(pieceToDownload.Index, session.GetAddr(), holders, now) {
+				dm.PieceManager.ResetPiece(pieceToDownload.Index)
+				continue
+			}
+		}
+
 		// Start downloading the piece
 		dm.downloadPieceFromPeer(pieceToDownload, session)
 	}
 }
 
+// pickPiece picks a new piece to download from a peer with the given
+// bitfield, serving the streaming window first; callers must hold dm.mu
+func (dm *DownloadManager) pickPiece(bitfield peer.Bitfield, bitfields []peer.Bitfield) *Piece {
+	start := 0
+	if dm.readAhead != nil {
+		if piece := dm.PieceManager.PickFirstAvailable(bitfield, dm.readAhead.Wanted()); piece != nil {
+			return piece
+		}
+		start = dm.readAhead.Position()
+	}
+
+	if dm.Sequential || dm.readAhead != nil {
+		return dm.PieceManager.PickSequential(bitfield, bitfields, start, dm.SequentialWindow)
+	}
+
+	return dm.PieceManager.PickPiece(bitfields, "rarest_first")
+}
+
 // downloadPieceFromPeer initiates a piece download from a specific peer
 func (dm *DownloadManager) downloadPieceFromPeer(piece *Piece, session *peer.Session) {
 	// Register piece as active
@@ -284,8 +912,8 @@ func (dm *DownloadManager) downloadPieceFromPeer(piece *Piece, session *peer.Ses
 		dm.processReceivedBlock(receivedPiece, piece, session)
 	})
 
-	// Request the first block
-	dm.requestNextBlock(piece, session)
+	// Fill the request pipeline
+	dm.requestBlocks(piece, session)
 }
 
 // processReceivedBlock handles a received block from a peer
@@ -303,7 +931,7 @@ func (dm *DownloadManager) processReceivedBlock(
 	}
 
 	// Add the block to the piece
-	err := dm.PieceManager.AddBlock(receivedPiece.Index, receivedPiece.Begin, receivedPiece.Block)
+	err := dm.PieceManager.AddBlock(receivedPiece.Index, receivedPiece.Begin, receivedPiece.Block, session.GetAddr())
 	if err != nil {
 		fmt.Printf("Error adding block: %v\n", err)
 		return
@@ -312,81 +940,160 @@ func (dm *DownloadManager) processReceivedBlock(
 	// Update stats
 	dm.Stats.Downloaded += int64(len(receivedPiece.Block))
 
-	// Check if the piece is complete
-	// Continue from internal/download/downloader.go
-	// processReceivedBlock continued...
-
 	// Check if the piece is complete
 	if piece.IsComplete() {
-		// Verify the piece
-		if piece.Verify() {
-			fmt.Printf("Piece %d completed and verified\n", piece.Index)
+		dm.finishPiece(piece)
+	} else {
+		// Keep the request pipeline full
+		dm.requestBlocks(piece, session)
+	}
+}
 
-			// Mark the piece as completed
-			err := dm.PieceManager.MarkPieceCompleted(piece.Index)
-			if err != nil {
-				fmt.Printf("Error marking piece as completed: %v\n", err)
-				return
-			}
+// finishPiece verifies a piece whose blocks have all arrived. Verified
+// pieces are written to disk and announced to peers; corrupt pieces are
+// discarded and the peers that sent them are banned. Callers must hold dm.mu.
+func (dm *DownloadManager) finishPiece(piece *Piece) {
+	timings := startTimings(piece)
+	defer dm.reportTimings(timings)
+
+	// Verify the piece
+	timings.Verified = piece.Verify()
+	timings.VerifyEnd = time.Now()
+	if timings.Verified {
+		fmt.Printf("Piece %d completed and verified\n", piece.Index)
+
+		// Ban peers whose blocks differ from the verified copy
+		dm.banPeers(dm.hashFailures.pieceVerified(piece))
+		delete(dm.pieceFailures, piece.Index)
+
+		// Mark the piece as completed
+		err := dm.PieceManager.MarkPieceCompleted(piece.Index)
+		if err != nil {
+			fmt.Printf("Error marking piece as completed: %v\n", err)
+			return
+		}
 
-			// Write the piece to disk
-			pieceData := piece.AssembleData()
-			err = dm.Storage.WritePiece(piece.Index, pieceData)
-			if err != nil {
-				fmt.Printf("Error writing piece to disk: %v\n", err)
-				return
-			}
+		// Write the blocks to disk where they are, without assembling
+		// the piece; a failed write keeps the piece buffered so it is
+		// retried once storage recovers
+		if err := dm.Storage.BufferBlocks(piece.Index, piece.BlockData()); err != nil {
+			dm.pauseForStorageError(err)
+		}
+		timings.WriteEnd = time.Now()
 
-			// Update stats
-			dm.Stats.PiecesCompleted++
-			dm.Stats.Progress = float64(dm.Stats.PiecesCompleted) / float64(dm.Stats.PiecesTotal) * 100
+		// Update stats
+		dm.Stats.PiecesCompleted, dm.Stats.PiecesTotal = dm.PieceManager.WantedCount()
+		dm.Stats.Progress = dm.PieceManager.Progress() * 100
 
-			// Cleanup
-			delete(dm.activePieces, piece.Index)
-			delete(dm.pieceTimeouts, piece.Index)
+		// Cleanup
+		delete(dm.activePieces, piece.Index)
+		delete(dm.pieceTimeouts, piece.Index)
 
-			// Notify completion
-			if dm.OnPieceCompleted != nil {
-				dm.OnPieceCompleted(piece.Index)
+		// Notify completion
+		if dm.OnPieceCompleted != nil {
+			dm.OnPieceCompleted(piece.Index)
+		}
+
+		// Check if every wanted piece is complete
+		if dm.PieceManager.WantedComplete() {
+			err := dm.Storage.Flush()
+			if err == nil {
+				err = dm.Storage.Sync()
+			}
+			if err != nil {
+				dm.pauseForStorageError(err)
 			}
 
-			// Check if entire download is complete
-			if dm.PieceManager.IsComplete() {
-				dm.updateState("Complete")
-				if dm.OnDownloadComplete != nil {
-					dm.OnDownloadComplete()
-				}
+			dm.setState("Complete")
+			if fs, ok := dm.Storage.(*FileStorage); ok && dm.Dedup != nil {
+				dm.Dedup.AddStorage(fs)
+			}
+			if dm.OnDownloadComplete != nil {
+				dm.OnDownloadComplete()
 			}
 
-			// Send have message to all peers
-			dm.PeerPool.BroadcastHave(piece.Index)
-		} else {
-			fmt.Printf("Piece %d failed verification\n", piece.Index)
+			// Report the completion right away; without seeding it goes
+			// out together with the stopped event
+			if dm.NoSeed {
+				dm.stopSeeding()
+			} else {
+				dm.requestAnnounce()
+			}
 
-			// Reset the piece
-			dm.PieceManager.ResetPiece(piece.Index)
-			delete(dm.activePieces, piece.Index)
-			delete(dm.pieceTimeouts, piece.Index)
+			if dm.VerifyOnComplete {
+				go dm.verifyOnComplete()
+			}
 		}
+
+		// Send have message to all peers
+		timings.HaveStart = time.Now()
+		dm.PeerPool.BroadcastHave(piece.Index)
+		timings.HaveEnd = time.Now()
 	} else {
-		// Request next block
-		dm.requestNextBlock(piece, session)
+		fmt.Printf("Piece %d failed verification\n", piece.Index)
+
+		// Identify the peers that sent bad data
+		dm.banPeers(dm.hashFailures.pieceFailed(piece, time.Now()))
+		dm.pieceFailures[piece.Index]++
+
+		// Reset the piece and discard the corrupt data
+		dm.PieceManager.ResetPiece(piece.Index)
+		piece.ClearBlocks()
+		delete(dm.activePieces, piece.Index)
+		delete(dm.pieceTimeouts, piece.Index)
+		dm.retryLater(piece.Index)
 	}
 }
 
-// requestNextBlock requests the next block from a peer
-func (dm *DownloadManager) requestNextBlock(piece *Piece, session *peer.Session) {
-	// Get next block to request
-	block := piece.NextRequest()
-	if block == nil {
-		return
+// retryLater backs off a piece that timed out or failed verification and
+// warns once it has failed too often; callers must hold dm.mu
+func (dm *DownloadManager) retryLater(pieceIndex int) {
+	failures, delay := dm.PieceManager.RecordFailure(pieceIndex)
+	fmt.Printf("Retrying piece %d in %v (failed %d times)\n", pieceIndex, delay, failures)
+
+	if failures == dm.PieceManager.Retry.WarnAfter {
+		fmt.Printf("Warning: piece %d keeps failing, the swarm may not be able to complete it\n", pieceIndex)
+		if dm.OnPieceStuck != nil {
+			dm.OnPieceStuck(pieceIndex, failures)
+		}
 	}
+}
 
-	// Request the block
-	err := session.RequestBlock(piece.Index, block.Begin, block.Length)
-	if err != nil {
-		fmt.Printf("Error requesting block: %v\n", err)
-		return
+// banPeers bans peers that sent corrupt data
+func (dm *DownloadManager) banPeers(addrs []string) {
+	for _, addr := range addrs {
+		fmt.Printf("Banning peer %s for sending corrupt data\n", addr)
+		dm.PeerPool.Ban(addr)
+
+		if dm.OnPeerDisconnected != nil {
+			dm.OnPeerDisconnected(addr)
+		}
+	}
+}
+
+// requestBlocks requests blocks of a piece from a peer until as many are
+// outstanding as the peer's pipeline allows, or holds the requests back
+// while the in-flight budget is used up
+func (dm *DownloadManager) requestBlocks(piece *Piece, session *peer.Session) {
+	depth := pipelineDepth(session)
+	for piece.Outstanding() < depth {
+		if dm.overBudget() {
+			dm.throttle(piece, session)
+			return
+		}
+
+		// Get next block to request
+		block := piece.NextRequest()
+		if block == nil {
+			return
+		}
+
+		// Request the block
+		err := session.RequestBlock(piece.Index, block.Begin, block.Length)
+		if err != nil {
+			fmt.Printf("Error requesting block: %v\n", err)
+			return
+		}
 	}
 }
 
@@ -395,7 +1102,10 @@ func (dm *DownloadManager) statsWorker() {
 	statsTicker := time.NewTicker(1 * time.Second)
 	defer statsTicker.Stop()
 
-	var lastDownloaded int64
+	tuneTicker := time.NewTicker(dm.PeerTuning.Interval)
+	defer tuneTicker.Stop()
+
+	var last Stats
 	var lastTime time.Time = time.Now()
 
 	for {
@@ -403,41 +1113,84 @@ func (dm *DownloadManager) statsWorker() {
 		case <-dm.ctx.Done():
 			return
 		case <-statsTicker.C:
-			dm.updateStats(lastDownloaded, lastTime)
-			lastDownloaded = dm.Stats.Downloaded
+			last = dm.updateStats(last, lastTime)
 			lastTime = time.Now()
+			if err := dm.checkStalled(lastTime); err != nil {
+				dm.handleStalled(err)
+			}
+		case <-tuneTicker.C:
+			if dm.AutoTunePeers {
+				dm.tunePeers()
+			}
 		}
 	}
 }
 
-// updateStats updates download statistics
-func (dm *DownloadManager) updateStats(lastDownloaded int64, lastTime time.Time) {
+// updateStats updates download statistics and returns them
+func (dm *DownloadManager) updateStats(last Stats, lastTime time.Time) Stats {
+	conn := dm.PeerPool.Stats()
+
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
+	dm.Stats.PayloadRead = conn.PayloadRead
+	dm.Stats.PayloadWritten = conn.PayloadWritten
+	dm.Stats.OverheadRead = conn.OverheadRead
+	dm.Stats.OverheadWritten = conn.OverheadWritten
+
 	currentTime := time.Now()
 	timeDiff := currentTime.Sub(lastTime).Seconds()
 
 	if timeDiff > 0 {
-		byteDiff := dm.Stats.Downloaded - lastDownloaded
-		dm.Stats.DownloadSpeed = int64(float64(byteDiff) / timeDiff)
+		rate := func(current, previous int64) int64 {
+			return int64(float64(current-previous) / timeDiff)
+		}
+
+		dm.Stats.DownloadSpeed = rate(dm.Stats.Downloaded, last.Downloaded)
+		dm.Stats.UploadSpeed = rate(dm.Stats.Uploaded, last.Uploaded)
+		dm.Stats.RawDownloadRate = rate(conn.PayloadRead+conn.OverheadRead, last.PayloadRead+last.OverheadRead)
+		dm.Stats.RawUploadRate = rate(conn.PayloadWritten+conn.OverheadWritten, last.PayloadWritten+last.OverheadWritten)
+	}
+
+	if dm.Storage != nil {
+		disk := dm.Storage.DiskStats()
+		if timeDiff > 0 {
+			dm.Stats.DiskWriteRate = int64(float64(disk.BytesWritten-last.Disk.BytesWritten) / timeDiff)
+		}
+
+		dm.Stats.DiskWriteLatency = 0
+		if writes := disk.Writes - last.Disk.Writes; writes > 0 {
+			dm.Stats.DiskWriteLatency = (disk.WriteTime - last.Disk.WriteTime) / time.Duration(writes)
+		}
+		dm.Stats.Disk = disk
 	}
 
+	dm.Stats.InFlightBytes = dm.inFlightBytes()
+	dm.Stats.Throttled = len(dm.throttled) > 0
+	dm.Stats.PartialBytes = dm.PieceManager.PartialBytes()
 	dm.Stats.ActivePeers = dm.PeerPool.GetConnectedPeers()
-	dm.Stats.PiecesCompleted = dm.PieceManager.DownloadedCount()
-	dm.Stats.Progress = dm.PieceManager.Progress()
+	dm.Stats.KnownPeers = dm.candidates.count()
+	dm.Stats.SkippedTiers = dm.health.skipped(time.Now())
+	dm.Stats.Unverified = len(dm.trusted)
+	dm.Stats.Availability = dm.Availability()
+	if dm.Stats.State == "No peers found" && dm.Stats.ActivePeers > 0 {
+		dm.setState("Downloading")
+	}
+	dm.Stats.PiecesCompleted, dm.Stats.PiecesTotal = dm.PieceManager.WantedCount()
+	dm.Stats.Progress = dm.PieceManager.Progress() * 100
+	dm.Stats.StuckPieces = dm.PieceManager.StuckPieces()
 
 	// Calculate time remaining
 	if dm.Stats.DownloadSpeed > 0 {
-		bytesLeft := dm.Torrent.TotalLength() - dm.Stats.Downloaded
+		bytesLeft := dm.PieceManager.BytesLeft()
 		secondsLeft := float64(bytesLeft) / float64(dm.Stats.DownloadSpeed)
 		dm.Stats.TimeRemaining = time.Duration(secondsLeft) * time.Second
 	}
 
 	// Notify stats update
-	if dm.OnStatsUpdated != nil {
-		dm.OnStatsUpdated(dm.Stats)
-	}
+	dm.stats.publish(dm.Stats)
+
+	return dm.Stats
 }
 
 // updateState updates the current state
@@ -445,11 +1198,21 @@ func (dm *DownloadManager) updateState(state string) {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
+	dm.setState(state)
+}
+
+// setState is updateState for callers that already hold dm.mu
+func (dm *DownloadManager) setState(state string) {
 	dm.Stats.State = state
 
 	// Notify stats update
+	dm.stats.publish(dm.Stats)
+}
+
+// deliverStats hands a stats snapshot to the OnStatsUpdated callback
+func (dm *DownloadManager) deliverStats(stats Stats) {
 	if dm.OnStatsUpdated != nil {
-		dm.OnStatsUpdated(dm.Stats)
+		dm.OnStatsUpdated(stats)
 	}
 }
 
@@ -461,7 +1224,8 @@ func (dm *DownloadManager) GetStats() Stats {
 	return dm.Stats
 }
 
-// IsComplete returns true if the download is complete
+// IsComplete returns true if the download is complete, which for a piece
+// or byte range means every piece in the range has been downloaded
 func (dm *DownloadManager) IsComplete() bool {
-	return dm.PieceManager.IsComplete()
+	return dm.PieceManager.WantedComplete()
 }
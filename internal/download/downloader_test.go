@@ -0,0 +1,249 @@
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/leaktest"
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+func TestStartContextDeadline(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.DisableTracker = true
+
+	aborted := make(chan error, 1)
+	dm.OnAborted = func(err error) { aborted <- err }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := dm.StartContext(ctx); err != nil {
+		t.Fatalf("StartContext() error = %v", err)
+	}
+
+	select {
+	case err := <-aborted:
+		if !errors.Is(err, ErrDeadlineExceeded) {
+			t.Errorf("OnAborted(%v), want ErrDeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download not aborted at its deadline")
+	}
+
+	if state := dm.GetStats().State; state != "Aborted" {
+		t.Errorf("State = %q, want Aborted", state)
+	}
+}
+
+// fakeAnnouncer answers announces with fixed peers and records the events
+type fakeAnnouncer struct {
+	peers   []tracker.Peer
+	mu      sync.Mutex
+	events  []string
+	urls    []string
+	numWant int // NumWant of the latest announce
+	ports   []int
+}
+
+func (a *fakeAnnouncer) Announce(ctx context.Context, trackerURL string, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, req.Event)
+	a.urls = append(a.urls, trackerURL)
+	a.numWant = req.NumWant
+	a.ports = append(a.ports, req.Port)
+	return &tracker.AnnounceResponse{Interval: 1800, Peers: a.peers}, nil
+}
+
+// fakePool records the peers it is asked to connect to and never connects
+type fakePool struct {
+	connect chan []tracker.Peer
+}
+
+func (p *fakePool) Connect(peers []tracker.Peer, maxConnections int) int {
+	p.connect <- peers
+	return 0
+}
+func (p *fakePool) Listen(addr string) (net.Listener, error)               { return nil, errors.New("not listening") }
+func (p *fakePool) SetMaxSessions(n int)                                   {}
+func (p *fakePool) SetOnSessionOpened(callback func(*peer.Session))        {}
+func (p *fakePool) GetConnectedPeers() int                                 { return 0 }
+func (p *fakePool) GetPeers() map[string]*peer.Session                     { return nil }
+func (p *fakePool) GetUnchokedSessions() []*peer.Session                   { return nil }
+func (p *fakePool) GetSessionsWithoutPiece(pieceIndex int) []*peer.Session { return nil }
+func (p *fakePool) BroadcastHave(pieceIndex int)                           {}
+func (p *fakePool) Ban(addr string)                                        {}
+func (p *fakePool) IsBanned(addr string) bool                              { return false }
+func (p *fakePool) CloseAll()                                              {}
+func (p *fakePool) Stats() peer.ConnStats                                  { return peer.ConnStats{} }
+
+// fakeStorage keeps pieces in memory and fails writes with writeErr
+type fakeStorage struct {
+	writeErr error
+	pieces   map[int][]byte
+}
+
+func (s *fakeStorage) BufferBlocks(pieceIndex int, blocks [][]byte) error {
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	s.pieces[pieceIndex] = data
+	return nil
+}
+func (s *fakeStorage) Flush() error          { return s.writeErr }
+func (s *fakeStorage) Sync() error           { return nil }
+func (s *fakeStorage) CheckFileSizes() error { return nil }
+func (s *fakeStorage) DiskStats() DiskStats  { return DiskStats{} }
+func (s *fakeStorage) Close() error          { return nil }
+func (s *fakeStorage) ReadPiece(pieceIndex int, length int) ([]byte, error) {
+	return s.pieces[pieceIndex], nil
+}
+
+func TestDownloadManagerInjectedTrackerAndPool(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &fakeAnnouncer{peers: []tracker.Peer{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}}}
+	pool := &fakePool{connect: make(chan []tracker.Peer, 1)}
+	dm.Tracker = announcer
+	dm.PeerPool = pool
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+
+	if err := dm.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	select {
+	case peers := <-pool.connect:
+		if len(peers) != 1 || peers[0].Port != 6881 {
+			t.Errorf("Connect(%v), want the announced peer", peers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("announced peer never reached the pool")
+	}
+
+	dm.Stop()
+
+	announcer.mu.Lock()
+	defer announcer.mu.Unlock()
+	if len(announcer.events) < 2 || announcer.events[0] != "started" || announcer.events[len(announcer.events)-1] != "stopped" {
+		t.Errorf("announced events %q, want started first and stopped last", announcer.events)
+	}
+}
+
+func TestSetListenPortReannounces(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:     "http://a.example/announce",
+		AnnounceList: [][]string{{"http://a.example/announce"}, {"http://b.example/announce"}},
+		Info:         torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash:   make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &fakeAnnouncer{}
+	dm.Tracker = announcer
+	dm.PeerPool = &fakePool{connect: make(chan []tracker.Peer, 16)}
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+	dm.SetListenPort(6881)
+
+	if err := dm.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer dm.Stop()
+
+	// announced reports whether url was announced to with port
+	announced := func(url string, port int) bool {
+		announcer.mu.Lock()
+		defer announcer.mu.Unlock()
+		for i := range announcer.urls {
+			if announcer.urls[i] == url && announcer.ports[i] == port {
+				return true
+			}
+		}
+		return false
+	}
+
+	waitFor(t, func() bool { return dm.events.joined("http://a.example/announce") })
+	// An earlier failover left b in the swarm too
+	dm.events.sent("http://b.example/announce", "started", false)
+
+	dm.SetListenPort(7000)
+	waitFor(t, func() bool {
+		return announced("http://a.example/announce", 7000) && announced("http://b.example/announce", 7000)
+	})
+}
+
+func TestDownloadManagerStorageError(t *testing.T) {
+	data := []byte("abcd")
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: [][20]byte{sha1.Sum(data), {}},
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.PeerPool = &fakePool{}
+	dm.Storage = &fakeStorage{writeErr: ErrStorageUnavailable}
+
+	var reported error
+	dm.OnStorageError = func(err error) { reported = err }
+
+	if err := dm.PieceManager.AddBlock(0, 0, data, "peer"); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+
+	dm.mu.Lock()
+	dm.finishPiece(dm.PieceManager.Pieces[0])
+	dm.mu.Unlock()
+
+	if !errors.Is(reported, ErrStorageUnavailable) {
+		t.Errorf("OnStorageError(%v), want ErrStorageUnavailable", reported)
+	}
+	if state := dm.GetStats().State; state != "Paused (storage error)" {
+		t.Errorf("State = %q, want paused", state)
+	}
+}
+
+func TestDownloadManagerStartStopLeavesNoGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	// Adding and removing torrents repeatedly must not pile up goroutines
+	for i := 0; i < 3; i++ {
+		dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+		dm.Tracker = &fakeAnnouncer{}
+		dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+		dm.ListenAddr = "127.0.0.1:0"
+		dm.PeerSources = append(dm.PeerSources, NewManualSource())
+
+		if err := dm.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		dm.Stop()
+	}
+}
@@ -0,0 +1,92 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCheckInProgress is returned by ForceRecheck while a recheck is running
+var ErrCheckInProgress = errors.New("data check already in progress")
+
+// ForceReannounce announces to the tracker right away, without waiting for
+// the tracker's minimum interval, and tries tiers that were skipped for
+// failing again. Use it to find peers after changing trackers.
+func (dm *DownloadManager) ForceReannounce() {
+	dm.health.reset()
+
+	select {
+	case dm.forceAnnounce <- struct{}{}:
+	default:
+		// An announce is already pending
+	}
+}
+
+// ForceRecheck hashes the data on disk again, for example after its files
+// were edited or copied in. Complete pieces that fail the check are
+// downloaded again; missing pieces that pass are marked complete and
+// announced to peers. Pieces being downloaded are left alone. It returns
+// once the check is done.
+func (dm *DownloadManager) ForceRecheck() error {
+	dm.mu.Lock()
+	if dm.rechecking {
+		dm.mu.Unlock()
+		return ErrCheckInProgress
+	}
+	dm.rechecking = true
+	state := dm.Stats.State
+	dm.setState("Checking")
+	dm.mu.Unlock()
+
+	// Pieces completed while the check runs are written after it read them
+	had := dm.PieceManager.Bitfield()
+	badPieces, err := dm.VerifyData()
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	dm.rechecking = false
+	if err != nil {
+		dm.setState(state)
+		return err
+	}
+
+	bad := make(map[int]bool, len(badPieces))
+	for _, index := range badPieces {
+		bad[index] = true
+	}
+
+	// Every trusted piece was just verified
+	dm.trusted = nil
+
+	lost, found := 0, 0
+	for i := 0; i < dm.Torrent.NumPieces(); i++ {
+		switch {
+		case bad[i] && had.HasPiece(i):
+			dm.PieceManager.ResetPiece(i)
+			dm.PieceManager.Pieces[i].ClearBlocks()
+			lost++
+		case !bad[i] && !dm.PieceManager.HasPiece(i) && dm.activePieces[i] == "":
+			dm.PieceManager.Pieces[i].ClearBlocks()
+			if err := dm.PieceManager.MarkPieceHave(i); err != nil {
+				return err
+			}
+			dm.PeerPool.BroadcastHave(i)
+			found++
+		}
+	}
+
+	fmt.Printf("Recheck found %d corrupt and %d new pieces\n", lost, found)
+
+	switch {
+	case dm.SeedOnly:
+		dm.setState(state)
+	case lost > 0:
+		dm.setState("Downloading")
+	case dm.PieceManager.WantedComplete():
+		dm.setState("Complete")
+	default:
+		dm.setState(state)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package download
+
+import (
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestForceReannounce(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	now := time.Now()
+	for i := 0; i < tierMaxFailures; i++ {
+		dm.health.failed(0, now)
+	}
+
+	dm.ForceReannounce()
+	dm.ForceReannounce()
+	if n := len(dm.forceAnnounce); n != 1 {
+		t.Errorf("%d forced announces pending, want 1", n)
+	}
+	if !dm.health.usable(0, now) {
+		t.Error("ForceReannounce() kept skipping the failing tier")
+	}
+}
+
+func TestForceRecheck(t *testing.T) {
+	pieces := [][]byte{[]byte("aaaa"), []byte("bbbb"), []byte("cccc")}
+	hashes := make([][20]byte, len(pieces))
+	for i, data := range pieces {
+		hashes[i] = sha1.Sum(data)
+	}
+
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 12},
+		PiecesHash: hashes,
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.PeerPool = &fakePool{}
+	dm.PieceManager.MarkPieceHave(0)
+	dm.PieceManager.MarkPieceHave(1)
+
+	// Piece 0 was edited on disk and piece 2 was copied in
+	dm.Storage = &fakeStorage{pieces: map[int][]byte{0: []byte("xxxx"), 1: pieces[1], 2: pieces[2]}}
+
+	if err := dm.ForceRecheck(); err != nil {
+		t.Fatalf("ForceRecheck() error = %v", err)
+	}
+
+	if dm.PieceManager.HasPiece(0) {
+		t.Error("corrupt piece 0 is still complete")
+	}
+	if !dm.PieceManager.HasPiece(1) || !dm.PieceManager.HasPiece(2) {
+		t.Error("pieces 1 and 2 should be complete")
+	}
+	if state := dm.GetStats().State; state != "Downloading" {
+		t.Errorf("State = %q, want Downloading", state)
+	}
+
+	dm.rechecking = true
+	if err := dm.ForceRecheck(); err != ErrCheckInProgress {
+		t.Errorf("ForceRecheck() during a check error = %v, want ErrCheckInProgress", err)
+	}
+}
@@ -0,0 +1,74 @@
+package download
+
+import (
+	"crypto/sha1"
+	"hash"
+	"sync"
+)
+
+// Hasher computes the SHA-1 digest used to verify pieces. Hashing is the
+// main CPU cost of a fast download, so alternative implementations (for
+// example an assembly-optimized SHA-1) can be plugged in with SetHasher.
+type Hasher interface {
+	Sum(data []byte) [20]byte
+}
+
+// StreamHasher is a Hasher that can also hash a piece block by block as the
+// blocks arrive, so verifying needs no assembled copy of the piece
+type StreamHasher interface {
+	Hasher
+	New() hash.Hash
+}
+
+// SHA1Hasher is the default Hasher backed by crypto/sha1, which already uses
+// the SHA CPU extensions where the platform provides them
+type SHA1Hasher struct{}
+
+// Sum returns the SHA-1 digest of data
+func (SHA1Hasher) Sum(data []byte) [20]byte {
+	return sha1.Sum(data)
+}
+
+// New returns a SHA-1 digest to stream blocks into
+func (SHA1Hasher) New() hash.Hash {
+	return sha1.New()
+}
+
+var (
+	hasherMu    sync.RWMutex
+	pieceHasher Hasher = SHA1Hasher{}
+)
+
+// SetHasher replaces the Hasher used for piece verification. Passing nil
+// restores the default crypto/sha1 implementation.
+func SetHasher(h Hasher) {
+	hasherMu.Lock()
+	defer hasherMu.Unlock()
+
+	if h == nil {
+		h = SHA1Hasher{}
+	}
+	pieceHasher = h
+}
+
+// hashSum hashes data with the configured Hasher
+func hashSum(data []byte) [20]byte {
+	hasherMu.RLock()
+	h := pieceHasher
+	hasherMu.RUnlock()
+
+	return h.Sum(data)
+}
+
+// newDigest returns a streaming digest from the configured Hasher, or nil
+// if it only hashes whole buffers
+func newDigest() hash.Hash {
+	hasherMu.RLock()
+	h := pieceHasher
+	hasherMu.RUnlock()
+
+	if sh, ok := h.(StreamHasher); ok {
+		return sh.New()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package download
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+)
+
+// countingHasher wraps SHA1Hasher and counts calls
+type countingHasher struct {
+	calls int
+}
+
+func (h *countingHasher) Sum(data []byte) [20]byte {
+	h.calls++
+	return sha1.Sum(data)
+}
+
+func TestSetHasher(t *testing.T) {
+	h := &countingHasher{}
+	SetHasher(h)
+	defer SetHasher(nil)
+
+	data := make([]byte, BlockSize)
+	piece := NewPiece(0, sha1.Sum(data), len(data))
+	if err := piece.AddBlock(0, data, "peer"); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+
+	if !piece.Verify() {
+		t.Errorf("Verify() = false, want true")
+	}
+
+	if h.calls != 1 {
+		t.Errorf("custom hasher called %d times, want 1", h.calls)
+	}
+}
+
+func TestPieceStreamingVerify(t *testing.T) {
+	data := make([]byte, 3*BlockSize+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	piece := NewPiece(0, sha1.Sum(data), len(data))
+
+	// Blocks arriving out of order are hashed once the gap before them fills
+	for _, i := range []int{2, 0, 3, 1} {
+		block := piece.Blocks[i]
+		if err := piece.AddBlock(block.Begin, data[block.Begin:block.Begin+block.Length], "peer"); err != nil {
+			t.Fatalf("AddBlock() error = %v", err)
+		}
+	}
+	if !piece.summed {
+		t.Fatal("digest not finished when the last block arrived")
+	}
+	if !piece.Verify() {
+		t.Error("Verify() = false, want true")
+	}
+
+	// A corrupt block fails, and clearing the piece starts a new digest
+	piece.ClearBlocks()
+	for _, block := range piece.Blocks {
+		blockData := append([]byte(nil), data[block.Begin:block.Begin+block.Length]...)
+		if block.Index == 1 {
+			blockData[0] ^= 0xff
+		}
+		piece.AddBlock(block.Begin, blockData, "peer")
+	}
+	if piece.Verify() {
+		t.Error("Verify() = true with a corrupt block")
+	}
+}
+
+func BenchmarkHasher(b *testing.B) {
+	for _, size := range []int{BlockSize, 256 * 1024, 4 * 1024 * 1024} {
+		data := make([]byte, size)
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				hashSum(data)
+			}
+		})
+	}
+}
+
+func BenchmarkPieceVerify(b *testing.B) {
+	const pieceLength = 1024 * 1024
+
+	data := make([]byte, pieceLength)
+	piece := NewPiece(0, sha1.Sum(data), pieceLength)
+
+	// Hashing happens as blocks arrive, so that is measured too
+	b.SetBytes(pieceLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		piece.ClearBlocks()
+		for _, block := range piece.Blocks {
+			piece.AddBlock(block.Begin, data[block.Begin:block.Begin+block.Length], "peer")
+		}
+		piece.Verify()
+	}
+}
@@ -0,0 +1,168 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// mappedFiles are the files of a FileStorage mapped into memory, so a hash
+// check reads pieces without copying them or taking the storage lock. The
+// files must not be truncated while mapped.
+type mappedFiles struct {
+	fs   *FileStorage
+	data [][]byte // One mapping per file
+}
+
+// mapFiles maps every file read-only. Platforms without mmap, and files
+// too large for the address space, return an error; read pieces with
+// ReadPiece instead.
+func (fs *FileStorage) mapFiles() (*mappedFiles, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	m := &mappedFiles{fs: fs, data: make([][]byte, len(fs.Files))}
+	for i, length := range fs.fileLengths() {
+		if fs.Files[i] == nil {
+			m.close()
+			return nil, fmt.Errorf("file %d is not open", i)
+		}
+
+		data, err := mmapFile(fs.Files[i], length)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.data[i] = data
+	}
+
+	return m, nil
+}
+
+// readPiece returns a piece from the mappings. A piece within one file is
+// returned without copying; the slice is only valid until close.
+func (m *mappedFiles) readPiece(pieceIndex int, length int) ([]byte, error) {
+	offset := int64(pieceIndex) * m.fs.Torrent.Info.PieceLength
+	spans := m.fs.spans(offset, length)
+
+	if len(spans) == 1 && spans[0].Length == length {
+		span := spans[0]
+		return m.data[span.FileIndex][span.FileOffset : span.FileOffset+int64(length)], nil
+	}
+
+	data := make([]byte, length)
+	for _, span := range spans {
+		copy(data[span.DataOffset:span.DataOffset+span.Length], m.data[span.FileIndex][span.FileOffset:])
+	}
+
+	return data, nil
+}
+
+// close unmaps every file
+func (m *mappedFiles) close() {
+	for i, data := range m.data {
+		munmap(data)
+		m.data[i] = nil
+	}
+}
+
+// hashCheckResult is the outcome of hashing one piece
+type hashCheckResult struct {
+	index int
+	good  bool
+	err   error
+}
+
+// checkPieces hashes the pieces at indexes, which are in order, read with
+// read on CheckWorkers goroutines and returns the indexes of the pieces
+// that don't match the metainfo, in order. OnCheckProgress is called as
+// pieces are done.
+func (dm *DownloadManager) checkPieces(read func(pieceIndex int, length int) ([]byte, error), pieces []int) ([]int, error) {
+	total := len(pieces)
+	workers := dm.CheckWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > total {
+		workers = total
+	}
+
+	indexes := make(chan int)
+	results := make(chan hashCheckResult)
+	done := make(chan struct{})
+	defer close(done)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result := hashCheckResult{index: i}
+
+				data, err := read(i, int(dm.Torrent.PieceSize(i)))
+				if err != nil {
+					result.err = err
+				} else {
+					hash := hashSum(data)
+					result.good = bytes.Equal(hash[:], dm.Torrent.PiecesHash[i][:])
+				}
+
+				select {
+				case results <- result:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexes)
+		for _, i := range pieces {
+			select {
+			case indexes <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	bad := make([]bool, dm.Torrent.NumPieces())
+	checked := 0
+	for result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		bad[result.index] = !result.good
+		checked++
+		dm.checkProgress(checked, total)
+	}
+
+	var badPieces []int
+	for _, i := range pieces {
+		if bad[i] {
+			badPieces = append(badPieces, i)
+		}
+	}
+
+	return badPieces, nil
+}
+
+// checkProgress records how far a hash check has got
+func (dm *DownloadManager) checkProgress(checked, total int) {
+	dm.mu.Lock()
+	dm.Stats.PiecesChecked = checked
+	dm.mu.Unlock()
+
+	if dm.OnCheckProgress != nil {
+		dm.OnCheckProgress(checked, total)
+	}
+}
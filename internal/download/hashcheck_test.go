@@ -0,0 +1,80 @@
+package download
+
+import (
+	"crypto/sha1"
+	"reflect"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestVerifyDataConcurrent(t *testing.T) {
+	// Ten pieces of 8 bytes over three files, so some pieces span files
+	payload := make([]byte, 76)
+	for i := range payload {
+		payload[i] = byte('a' + i%26)
+	}
+	piece := func(i int) []byte { return payload[i*8 : int(min(int64(i*8+8), int64(len(payload))))] }
+
+	var hashes [][20]byte
+	for i := 0; i*8 < len(payload); i++ {
+		hashes = append(hashes, sha1.Sum(piece(i)))
+	}
+
+	torrentFile := &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: 8,
+			Name:        "test_dir",
+			IsDirectory: true,
+			Files: []torrent.FileDict{
+				{Length: 30, Path: []string{"a.bin"}},
+				{Length: 0, Path: []string{"empty.bin"}},
+				{Length: 46, Path: []string{"b.bin"}},
+			},
+		},
+		PiecesHash: hashes,
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	for i := range hashes {
+		data := piece(i)
+		if i == 3 || i == 9 {
+			data = []byte("corrupt!")[:len(data)]
+		}
+		if err := fs.WritePiece(i, data); err != nil {
+			t.Fatalf("WritePiece(%d) error = %v", i, err)
+		}
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.Storage = fs
+	dm.CheckWorkers = 4
+
+	var progress []int
+	dm.OnCheckProgress = func(checked, total int) {
+		if total != len(hashes) {
+			t.Errorf("OnCheckProgress total = %d, want %d", total, len(hashes))
+		}
+		progress = append(progress, checked)
+	}
+
+	bad, err := dm.VerifyData()
+	if err != nil {
+		t.Fatalf("VerifyData() error = %v", err)
+	}
+	if !reflect.DeepEqual(bad, []int{3, 9}) {
+		t.Errorf("VerifyData() = %v, want [3 9]", bad)
+	}
+
+	if len(progress) != len(hashes) || progress[len(progress)-1] != len(hashes) {
+		t.Errorf("OnCheckProgress reported %v, want 1 to %d", progress, len(hashes))
+	}
+	if checked := dm.GetStats().PiecesChecked; checked != len(hashes) {
+		t.Errorf("PiecesChecked = %d, want %d", checked, len(hashes))
+	}
+}
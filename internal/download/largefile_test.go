@@ -0,0 +1,105 @@
+package download
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+const (
+	gb               = int64(1) << 30
+	largePieceLength = 32 << 20
+)
+
+// largeTorrent returns a torrent of a 5GB file and a small one in 32MB
+// pieces, so its offsets don't fit in 32 bits
+func largeTorrent() *torrent.TorrentFile {
+	total := 5*gb + largePieceLength/2
+	return &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: largePieceLength,
+			Name:        "large",
+			IsDirectory: true,
+			Files: []torrent.FileDict{
+				{Length: 5 * gb, Path: []string{"big.bin"}},
+				{Length: largePieceLength / 2, Path: []string{"small.bin"}},
+			},
+		},
+		PiecesHash: make([][20]byte, (total+largePieceLength-1)/largePieceLength),
+	}
+}
+
+func TestPieceManagerLargeTorrent(t *testing.T) {
+	torrentFile := largeTorrent()
+	pm := NewPieceManager(torrentFile)
+
+	if n := pm.PieceCount(); n != 161 {
+		t.Fatalf("PieceCount() = %d, want 161", n)
+	}
+	if n := len(pm.Pieces[0].Blocks); n != largePieceLength/BlockSize {
+		t.Errorf("first piece has %d blocks, want %d", n, largePieceLength/BlockSize)
+	}
+	if last := pm.Pieces[160]; last.Length != largePieceLength/2 {
+		t.Errorf("last piece length = %d, want %d", last.Length, largePieceLength/2)
+	}
+	if left := pm.BytesLeft(); left != torrentFile.TotalLength() {
+		t.Errorf("BytesLeft() = %d, want %d", left, torrentFile.TotalLength())
+	}
+
+	first, last, err := PieceRangeForBytes(torrentFile, 5*gb-1, 2)
+	if err != nil || first != 159 || last != 160 {
+		t.Errorf("PieceRangeForBytes(5GB-1, 2) = %d, %d, %v, want 159, 160", first, last, err)
+	}
+}
+
+func TestFileSpansBeyond4GB(t *testing.T) {
+	torrentFile := largeTorrent()
+
+	// The last full piece of the big file ends exactly at 5GB
+	spans := fileSpans(torrentFile, 159*largePieceLength, largePieceLength)
+	if len(spans) != 1 || spans[0].FileOffset != 5*gb-largePieceLength || spans[0].Length != largePieceLength {
+		t.Errorf("fileSpans(piece 159) = %+v, want one span at the end of the big file", spans)
+	}
+
+	// A range straddling the two files
+	spans = fileSpans(torrentFile, 5*gb-BlockSize, 2*BlockSize)
+	want := []fileSpan{
+		{FileIndex: 0, FileOffset: 5*gb - BlockSize, DataOffset: 0, Length: BlockSize},
+		{FileIndex: 1, FileOffset: 0, DataOffset: BlockSize, Length: BlockSize},
+	}
+	if len(spans) != len(want) || spans[0] != want[0] || spans[1] != want[1] {
+		t.Errorf("fileSpans(5GB-16KB, 32KB) = %+v, want %+v", spans, want)
+	}
+}
+
+func TestFileStorageLargePieceBeyond4GB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a sparse file larger than 4GB")
+	}
+
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: largePieceLength, Name: "large.bin", Length: 4*gb + largePieceLength},
+		PiecesHash: make([][20]byte, 4*gb/largePieceLength+1),
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	index := len(torrentFile.PiecesHash) - 1
+	data := bytes.Repeat([]byte("0123456789abcdef"), largePieceLength/16)
+	if err := fs.WritePiece(index, data); err != nil {
+		t.Skipf("WritePiece() error = %v; the file system may not support large sparse files", err)
+	}
+
+	got, err := fs.ReadPiece(index, len(data))
+	if err != nil {
+		t.Fatalf("ReadPiece() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("ReadPiece() returned different data than was written past 4GB")
+	}
+}
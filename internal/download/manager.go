@@ -19,7 +19,12 @@ type PieceManager struct {
 	InProgress map[int]bool
 	Missing    map[int]bool
 	Completed  int
+	Retry      RetryPolicy
+	retries    map[int]*pieceRetry // pieceIndex -> failures since it last verified
 	mu         sync.RWMutex
+
+	wantedPieces map[int]bool // Pieces to download, nil for all of them
+
 }
 
 // NewPieceManager creates a new piece manager
@@ -44,6 +49,8 @@ func NewPieceManager(torrentFile *torrent.TorrentFile) *PieceManager {
 		InProgress: make(map[int]bool),
 		Missing:    missing,
 		Completed:  0,
+		Retry:      DefaultRetryPolicy(),
+		retries:    make(map[int]*pieceRetry),
 	}
 }
 
@@ -55,7 +62,7 @@ func (pm *PieceManager) PieceCount() int {
 // DownloadedCount returns the number of downloaded pieces
 func (pm *PieceManager) DownloadedCount() int {
 	pm.mu.RLock()
-	defer pm.mu.Unlock()
+	defer pm.mu.RUnlock()
 
 	return pm.Completed
 }
@@ -76,10 +83,11 @@ func (pm *PieceManager) PickPiece(peersBitfield []peer.Bitfield, strategy string
 		}
 	}
 
-	// Filter out pieces that are already downloaded
+	// Filter out pieces that are already downloaded or backing off
+	now := time.Now()
 	var candidates []int
 	for pieceIndex := range available {
-		if !pm.Downloaded[pieceIndex] {
+		if !pm.Downloaded[pieceIndex] && pm.wanted(pieceIndex) && !pm.backingOff(pieceIndex, now) {
 			candidates = append(candidates, pieceIndex)
 		}
 	}
@@ -124,6 +132,76 @@ func (pm *PieceManager) PickPiece(peersBitfield []peer.Bitfield, strategy string
 	return nil
 }
 
+// PickFirstAvailable selects the first piece in indexes that is still
+// missing and present in the given bitfield, or any missing piece when the
+// bitfield is nil. It is used to honour explicit priorities such as the
+// read-ahead window of a streaming reader.
+func (pm *PieceManager) PickFirstAvailable(bitfield peer.Bitfield, indexes []int) *Piece {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, pieceIndex := range indexes {
+		if pieceIndex < 0 || pieceIndex >= len(pm.Pieces) {
+			continue
+		}
+
+		if pm.Downloaded[pieceIndex] || !pm.wanted(pieceIndex) || pm.InProgress[pieceIndex] || pm.backingOff(pieceIndex, time.Now()) || (bitfield != nil && !bitfield.HasPiece(pieceIndex)) {
+			continue
+		}
+
+		pm.InProgress[pieceIndex] = true
+		delete(pm.Missing, pieceIndex)
+		return pm.Pieces[pieceIndex]
+	}
+
+	return nil
+}
+
+// HasPiece returns true if a piece has been downloaded and verified
+func (pm *PieceManager) HasPiece(pieceIndex int) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.Downloaded[pieceIndex]
+}
+
+// MarkPieceHave marks a piece as present after it was verified against the
+// data on disk rather than downloaded into memory
+func (pm *PieceManager) MarkPieceHave(pieceIndex int) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pieceIndex < 0 || pieceIndex >= len(pm.Pieces) {
+		return fmt.Errorf("invalid piece index: %d", pieceIndex)
+	}
+
+	if pm.Downloaded[pieceIndex] {
+		return nil
+	}
+
+	pm.Downloaded[pieceIndex] = true
+	delete(pm.InProgress, pieceIndex)
+	delete(pm.Missing, pieceIndex)
+	delete(pm.retries, pieceIndex)
+	pm.Completed++
+	pm.Pieces[pieceIndex].State = PieceStateComplete
+
+	return nil
+}
+
+// Bitfield returns a bitfield of the pieces we have
+func (pm *PieceManager) Bitfield() peer.Bitfield {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	bf := make(peer.Bitfield, (len(pm.Pieces)+7)/8)
+	for pieceIndex := range pm.Downloaded {
+		bf.SetPiece(pieceIndex)
+	}
+
+	return bf
+}
+
 // MarkPieceCompleted marks a piece as successfully downloaded and verified
 func (pm *PieceManager) MarkPieceCompleted(pieceIndex int) error {
 	pm.mu.Lock()
@@ -150,6 +228,7 @@ func (pm *PieceManager) MarkPieceCompleted(pieceIndex int) error {
 	// Mark as download
 	pm.Downloaded[pieceIndex] = true
 	delete(pm.InProgress, pieceIndex)
+	delete(pm.retries, pieceIndex)
 	pm.Completed++
 
 	// Update the piece state
@@ -158,8 +237,8 @@ func (pm *PieceManager) MarkPieceCompleted(pieceIndex int) error {
 	return nil
 }
 
-// AddBlock adds a downloaded block to its corresponding piece
-func (pm *PieceManager) AddBlock(pieceIndex, begin int, data []byte) error {
+// AddBlock adds a block downloaded from source to its corresponding piece
+func (pm *PieceManager) AddBlock(pieceIndex, begin int, data []byte, source string) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
@@ -168,27 +247,42 @@ func (pm *PieceManager) AddBlock(pieceIndex, begin int, data []byte) error {
 	}
 
 	piece := pm.Pieces[pieceIndex]
-	return piece.AddBlock(begin, data)
+	return piece.AddBlock(begin, data, source)
 }
 
 // IsComplete returns true if all pieces have been downloaded
 func (pm *PieceManager) IsComplete() bool {
 	pm.mu.RLock()
-	defer pm.mu.Unlock()
+	defer pm.mu.RUnlock()
 
 	return len(pm.Pieces) == pm.Completed
 }
 
-// Progress returns the download progress as a percentage (0.0 to 1.0)
+// Progress returns the download progress of the wanted pieces as a
+// percentage (0.0 to 1.0)
 func (pm *PieceManager) Progress() float64 {
+	done, total := pm.WantedCount()
+	if total == 0 {
+		return 0.0
+	}
+
+	return float64(done) / float64(total)
+}
+
+// BytesLeft returns the bytes of the wanted pieces not yet downloaded and
+// verified, the amount trackers expect as "left"
+func (pm *PieceManager) BytesLeft() int64 {
 	pm.mu.RLock()
-	defer pm.mu.Unlock()
+	defer pm.mu.RUnlock()
 
-	if len(pm.Pieces) == 0 {
-		return 0.0
+	var left int64
+	for i := range pm.Pieces {
+		if pm.wanted(i) && !pm.Downloaded[i] {
+			left += pm.Torrent.PieceSize(i)
+		}
 	}
 
-	return float64(pm.Completed) / float64(len(pm.Pieces))
+	return left
 }
 
 // ResetPiece resets a piece to the "not downloaded" state
@@ -203,12 +297,12 @@ func (pm *PieceManager) ResetPiece(pieceIndex int) error {
 	piece := pm.Pieces[pieceIndex]
 	piece.ResetRequests()
 
-	delete(pm.InProgress, pieceIndex)
 	delete(pm.InProgress, pieceIndex)
 
 	pm.Missing[pieceIndex] = true
 
-	if piece.GetState() == PieceStateComplete {
+	if pm.Downloaded[pieceIndex] {
+		delete(pm.Downloaded, pieceIndex)
 		pm.Completed--
 	}
 
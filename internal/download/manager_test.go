@@ -0,0 +1,93 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestPieceManagerBytesLeft(t *testing.T) {
+	pm := NewPieceManager(&torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 10},
+		PiecesHash: make([][20]byte, 3),
+	})
+
+	if got := pm.BytesLeft(); got != 10 {
+		t.Errorf("BytesLeft() = %d, want 10", got)
+	}
+
+	// Only verified pieces count, whatever was received for the others
+	pm.MarkPieceHave(2)
+	pm.AddBlock(0, 0, []byte("junk"), "peer")
+	if got := pm.BytesLeft(); got != 8 {
+		t.Errorf("BytesLeft() = %d after the short last piece, want 8", got)
+	}
+
+	pm.MarkPieceHave(0)
+	pm.MarkPieceHave(1)
+	if got := pm.BytesLeft(); got != 0 {
+		t.Errorf("BytesLeft() = %d when complete, want 0", got)
+	}
+}
+
+func TestPieceManagerBytesLeftRange(t *testing.T) {
+	pm := NewPieceManager(&torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 14},
+		PiecesHash: make([][20]byte, 4),
+	})
+
+	// Only pieces 1 and 3 are wanted, the last one short
+	first, last, err := PieceRangeForBytes(pm.Torrent, 6, 8)
+	if err != nil {
+		t.Fatalf("PieceRangeForBytes() error = %v", err)
+	}
+	if err := pm.SetWantedPieces(first, last); err != nil {
+		t.Fatalf("SetWantedPieces() error = %v", err)
+	}
+	if got := pm.BytesLeft(); got != 10 {
+		t.Errorf("BytesLeft() = %d for pieces 1-3, want 10", got)
+	}
+
+	// Pieces outside the range do not count, downloaded or not
+	pm.MarkPieceHave(0)
+	pm.MarkPieceHave(3)
+	if got := pm.BytesLeft(); got != 8 {
+		t.Errorf("BytesLeft() = %d with the short last piece downloaded, want 8", got)
+	}
+}
+
+func TestPieceManagerRetryBackoff(t *testing.T) {
+	pm := NewPieceManager(&torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	})
+	pm.Retry = RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, WarnAfter: 3}
+
+	// Each failure doubles the wait, up to the maximum
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if failures, delay := pm.RecordFailure(0); failures != i+1 || delay != want {
+			t.Errorf("RecordFailure() = %d, %v, want %d, %v", failures, delay, i+1, want)
+		}
+	}
+
+	if got := pm.StuckPieces(); got != 1 {
+		t.Errorf("StuckPieces() = %d, want 1", got)
+	}
+
+	// The backed off piece is passed over while it waits
+	all := peer.Bitfield{0xc0}
+	if piece := pm.PickPiece([]peer.Bitfield{all}, "sequential"); piece == nil || piece.Index != 1 {
+		t.Fatalf("PickPiece() = %v, want piece 1", piece)
+	}
+	if piece := pm.PickFirstAvailable(all, []int{0}); piece != nil {
+		t.Errorf("PickFirstAvailable() = piece %d while it backs off", piece.Index)
+	}
+
+	// Verifying the piece forgets its failures
+	pm.MarkPieceHave(0)
+	if got := pm.Retries(0); got != 0 {
+		t.Errorf("Retries(0) = %d after verifying, want 0", got)
+	}
+}
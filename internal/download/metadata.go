@@ -0,0 +1,110 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// metadataWorkers is the number of peers asked for the metadata at once
+const metadataWorkers = 8
+
+// ErrNoMetadataPeers is returned when no peer found for a magnet link sent
+// its metadata
+var ErrNoMetadataPeers = errors.New("no peer sent the metadata")
+
+// FetchMetadata downloads the info dictionary of a magnet link from the
+// peers it lists and those its trackers return, and returns it as a
+// .torrent file. Nothing else is downloaded.
+func FetchMetadata(ctx context.Context, magnet *torrent.Magnet, peerID [20]byte, announcer Announcer, port int) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	addrs := make(chan string)
+	go func() {
+		defer close(addrs)
+		seen := make(map[string]bool)
+		send := func(addr string) bool {
+			if seen[addr] {
+				return true
+			}
+			seen[addr] = true
+			select {
+			case addrs <- addr:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, addr := range magnet.Peers {
+			if !send(addr) {
+				return
+			}
+		}
+
+		// The size is unknown until the metadata arrives; announcing
+		// something left keeps trackers from taking us for a seed
+		req := &tracker.AnnounceRequest{
+			InfoHash: magnet.InfoHash,
+			PeerID:   peerID,
+			Port:     port,
+			Left:     1,
+			Compact:  true,
+		}
+		for _, url := range magnet.Trackers {
+			resp, err := announcer.Announce(ctx, url, req)
+			if err != nil {
+				fmt.Printf("Tracker %s: %v\n", url, err)
+				continue
+			}
+			for _, p := range resp.Peers {
+				if !send(p.String()) {
+					return
+				}
+			}
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		metadata []byte
+		lastErr  error
+		wg       sync.WaitGroup
+	)
+	for range metadataWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				info, err := peer.FetchMetadata(ctx, addr, magnet.InfoHash, peerID)
+
+				mu.Lock()
+				if err == nil && metadata == nil {
+					metadata = info
+					cancel()
+				} else if err != nil && ctx.Err() == nil {
+					lastErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if metadata == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if lastErr != nil {
+			return nil, fmt.Errorf("%w, last error: %v", ErrNoMetadataPeers, lastErr)
+		}
+		return nil, fmt.Errorf("%w: no peers found", ErrNoMetadataPeers)
+	}
+	return magnet.Metainfo(metadata)
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package download
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform; pieces are read instead
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// munmap unmaps a mapping made by mmapFile
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package download
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of a file read-only
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	if int64(int(size)) != size {
+		return nil, errors.ErrUnsupported // Larger than the address space
+	}
+
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap unmaps a mapping made by mmapFile
+func munmap(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,74 @@
+package download
+
+import (
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// DefaultPartialPieceBudget is the memory incomplete pieces may take up
+// before no new pieces are started
+const DefaultPartialPieceBudget = 256 * 1024 * 1024
+
+// PartialBytes returns the memory taken by incomplete pieces once their
+// blocks have all arrived: the full length of every piece that is being
+// downloaded or holds blocks from an earlier attempt
+func (pm *PieceManager) PartialBytes() int64 {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var n int64
+	for i, piece := range pm.Pieces {
+		if pm.Downloaded[i] {
+			continue
+		}
+		if pm.InProgress[i] || piece.downloadedBytes() > 0 {
+			n += int64(piece.Length)
+		}
+	}
+
+	return n
+}
+
+// PickPartial selects the missing piece in the given bitfield that holds
+// the most downloaded data, so finishing it frees its memory soonest. It
+// returns nil when no such piece has any blocks yet.
+func (pm *PieceManager) PickPartial(bitfield peer.Bitfield) *Piece {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	now := time.Now()
+	var best *Piece
+	bestBytes := 0
+	for i, piece := range pm.Pieces {
+		if pm.Downloaded[i] || pm.InProgress[i] || !pm.wanted(i) || pm.backingOff(i, now) || !bitfield.HasPiece(i) {
+			continue
+		}
+
+		if n := piece.downloadedBytes(); n > bestBytes {
+			best, bestBytes = piece, n
+		}
+	}
+
+	if best != nil {
+		pm.InProgress[best.Index] = true
+		delete(pm.Missing, best.Index)
+	}
+
+	return best
+}
+
+// downloadedBytes returns the number of bytes received so far
+func (p *Piece) downloadedBytes() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Downloaded
+}
+
+// overMemoryBudget reports whether incomplete pieces take up so much memory
+// that pieces already holding data should be finished before new ones are
+// started; callers must hold dm.mu
+func (dm *DownloadManager) overMemoryBudget() bool {
+	return dm.PartialPieceBudget > 0 && dm.PieceManager.PartialBytes() >= dm.PartialPieceBudget
+}
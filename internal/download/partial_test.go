@@ -0,0 +1,54 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestPickPartialPrefersMostDownloaded(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4 * BlockSize, Name: "test.bin", Length: 12 * BlockSize},
+		PiecesHash: make([][20]byte, 3),
+	}
+	pm := NewPieceManager(torrentFile)
+
+	all := make(peer.Bitfield, 1)
+	for i := 0; i < 3; i++ {
+		all.SetPiece(i)
+	}
+
+	if piece := pm.PickPartial(all); piece != nil {
+		t.Fatalf("PickPartial() = piece %d without any downloaded data, want nil", piece.Index)
+	}
+
+	// Blocks left behind by attempts that timed out
+	for _, b := range []struct{ piece, begin int }{{0, 0}, {2, 0}, {2, BlockSize}} {
+		if err := pm.AddBlock(b.piece, b.begin, make([]byte, BlockSize), "peer"); err != nil {
+			t.Fatalf("AddBlock() error = %v", err)
+		}
+	}
+
+	if n := pm.PartialBytes(); n != 8*BlockSize {
+		t.Errorf("PartialBytes() = %d, want %d", n, 8*BlockSize)
+	}
+
+	piece := pm.PickPartial(all)
+	if piece == nil || piece.Index != 2 {
+		t.Fatalf("PickPartial() = %v, want piece 2", piece)
+	}
+
+	// A piece being downloaded is not picked twice
+	piece = pm.PickPartial(all)
+	if piece == nil || piece.Index != 0 {
+		t.Fatalf("PickPartial() = %v, want piece 0", piece)
+	}
+
+	// The peer must have the piece
+	pm.ResetPiece(0)
+	none := make(peer.Bitfield, 1)
+	if piece := pm.PickPartial(none); piece != nil {
+		t.Errorf("PickPartial() = piece %d the peer doesn't have, want nil", piece.Index)
+	}
+}
@@ -0,0 +1,102 @@
+package download
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxPath is the length from which Windows requires the \\?\ prefix.
+// Directories are limited to MAX_PATH (260) minus room for an 8.3 file name.
+const maxPath = 248
+
+// reservedNames are device names Windows refuses to use as file names,
+// with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// pathRules maps torrent path components to paths on disk. The Windows
+// rules are selected by a field rather than a build tag so they can be
+// tested on any platform.
+type pathRules struct {
+	windows bool
+}
+
+// hostPathRules are the rules for the platform we are running on
+var hostPathRules = pathRules{windows: runtime.GOOS == "windows"}
+
+// sanitize makes a single path component from the metainfo safe to use as a
+// file name. Components that would escape the download directory are
+// neutralized on every platform; on Windows reserved device names, invalid
+// characters and trailing dots and spaces are replaced as well.
+func (r pathRules) sanitize(component string) string {
+	component = strings.Map(func(c rune) rune {
+		if c == '/' || c == 0 {
+			return '_'
+		}
+		if r.windows && (c < 32 || strings.ContainsRune(`<>:"\|?*`, c)) {
+			return '_'
+		}
+		return c
+	}, component)
+
+	if component == "" || component == "." || component == ".." {
+		return "_" + component
+	}
+
+	if !r.windows {
+		return component
+	}
+
+	// Windows silently drops trailing dots and spaces
+	if trimmed := strings.TrimRight(component, ". "); trimmed != component {
+		component = trimmed + "_"
+	}
+
+	base := component
+	if dot := strings.IndexByte(base, '.'); dot >= 0 {
+		base = base[:dot]
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		component = "_" + component
+	}
+
+	return component
+}
+
+// join builds the path of a torrent file or directory below base
+func (r pathRules) join(base string, components ...string) string {
+	elems := make([]string, 0, len(components)+1)
+	elems = append(elems, base)
+	for _, component := range components {
+		elems = append(elems, r.sanitize(component))
+	}
+
+	return r.longPath(filepath.Join(elems...))
+}
+
+// longPath adds the \\?\ prefix Windows needs to open absolute paths longer
+// than MAX_PATH. Relative paths are returned unchanged as the prefix disables
+// relative path resolution.
+func (r pathRules) longPath(path string) string {
+	if !r.windows || len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+
+	// UNC paths: \\server\share\... becomes \\?\UNC\server\share\...
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + path[2:]
+	}
+
+	// Drive paths: C:\...
+	if len(path) >= 3 && path[1] == ':' && path[2] == '\\' {
+		return `\\?\` + path
+	}
+
+	return path
+}
@@ -0,0 +1,64 @@
+package download
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathRulesSanitize(t *testing.T) {
+	tests := []struct {
+		component string
+		windows   bool
+		want      string
+	}{
+		{"file.txt", false, "file.txt"},
+		{"..", false, "_.."},
+		{".", false, "_."},
+		{"", false, "_"},
+		{"a/b", false, "a_b"},
+		{"CON", false, "CON"},
+		{"what?.txt", false, "what?.txt"},
+		// Windows rules
+		{"file.txt", true, "file.txt"},
+		{"..", true, "_.."},
+		{"CON", true, "_CON"},
+		{"nul.txt", true, "_nul.txt"},
+		{"com1", true, "_com1"},
+		{"CONSOLE", true, "CONSOLE"},
+		{"what?.txt", true, "what_.txt"},
+		{`a<b>c:d"e\f|g*h`, true, "a_b_c_d_e_f_g_h"},
+		{"trailing. ", true, "trailing_"},
+		{"tab\tname", true, "tab_name"},
+	}
+
+	for _, tt := range tests {
+		got := pathRules{windows: tt.windows}.sanitize(tt.component)
+		if got != tt.want {
+			t.Errorf("sanitize(%q, windows=%v) = %q, want %q", tt.component, tt.windows, got, tt.want)
+		}
+	}
+}
+
+func TestPathRulesLongPath(t *testing.T) {
+	long := strings.Repeat("a", maxPath)
+
+	tests := []struct {
+		path    string
+		windows bool
+		want    string
+	}{
+		{`C:\short`, true, `C:\short`},
+		{`C:\` + long, true, `\\?\C:\` + long},
+		{`\\server\share\` + long, true, `\\?\UNC\server\share\` + long},
+		{`\\?\C:\` + long, true, `\\?\C:\` + long},
+		{`relative\` + long, true, `relative\` + long},
+		{"/" + long, false, "/" + long},
+	}
+
+	for _, tt := range tests {
+		got := pathRules{windows: tt.windows}.longPath(tt.path)
+		if got != tt.want {
+			t.Errorf("longPath(%.20q..., windows=%v) = %.30q..., want %.30q...", tt.path, tt.windows, got, tt.want)
+		}
+	}
+}
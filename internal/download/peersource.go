@@ -0,0 +1,278 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// PeerInfo is a peer found by a PeerSource
+type PeerInfo struct {
+	Peer   tracker.Peer
+	Source string // Name of the source that found the peer, e.g. "tracker"
+}
+
+// PeerSource finds peers for a torrent. The download manager starts every
+// source when the download starts and connects to the peers they send
+// until the context is cancelled. Trackers are always used; further
+// sources such as DHT, PEX, LSD or a bootstrap API are added through
+// DownloadManager.PeerSources.
+type PeerSource interface {
+	Start(ctx context.Context) error
+	Peers() <-chan PeerInfo
+}
+
+// defaultTrackerInterval is used until the tracker sends its own interval
+const defaultTrackerInterval = 30 * time.Second
+
+// noPeersRetry is the delay before re-announcing after a tracker returned no
+// peers, doubled for every further empty response up to the regular interval
+const noPeersRetry = 30 * time.Second
+
+// trackerSource announces to the torrent's tracker on the interval the
+// tracker asks for, and whenever a re-announce is requested as long as the
+// tracker's minimum interval allows it. While downloading, a tracker that
+// returns no peers is re-announced to sooner and the next backup tracker
+// from the announce list is tried.
+type trackerSource struct {
+	dm    *DownloadManager
+	peers chan PeerInfo
+
+	interval     time.Duration // Time between regular announces
+	minInterval  time.Duration // Announces are never more frequent than this
+	lastAnnounce time.Time
+	empty        int // Consecutive announces that returned no peers
+	failed       int // Consecutive announces that failed
+}
+
+// newTrackerSource creates the tracker peer source of a download
+func newTrackerSource(dm *DownloadManager) *trackerSource {
+	return &trackerSource{
+		dm:       dm,
+		peers:    make(chan PeerInfo, 200),
+		interval: defaultTrackerInterval,
+	}
+}
+
+// Start begins announcing in the background
+func (s *trackerSource) Start(ctx context.Context) error {
+	go s.run(ctx)
+	return nil
+}
+
+// Peers returns the peers returned by the tracker
+func (s *trackerSource) Peers() <-chan PeerInfo {
+	return s.peers
+}
+
+// run announces until ctx is cancelled
+func (s *trackerSource) run(ctx context.Context) {
+	// Initial peer discovery, once the tracker allows it if an earlier
+	// run was asked to wait
+	wait := s.resume(time.Now())
+	if wait > 0 {
+		fmt.Printf("Tracker backoff left by the last run: announcing in %v\n", wait.Round(time.Second))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.announce(ctx)
+			timer.Reset(s.nextDelay())
+		case <-s.dm.reannounce:
+			// Announce as soon as the tracker's minimum interval allows
+			timer.Reset(time.Until(s.lastAnnounce.Add(s.minInterval)))
+		case <-s.dm.forceAnnounce:
+			s.failed = 0
+			timer.Reset(0)
+		}
+	}
+}
+
+// resume takes over the backoff an earlier run left the current tracker
+// in, restored with RestoreTrackerBackoffs, and returns how long to wait
+// before announcing to it
+func (s *trackerSource) resume(now time.Time) time.Duration {
+	b, ok := s.dm.backoffs.get(s.dm.trackerURL())
+	if !ok {
+		return 0
+	}
+
+	s.lastAnnounce = b.LastAnnounce
+	s.failed = b.Failures
+	s.minInterval = b.MinInterval
+	if b.Interval > 0 {
+		s.interval = b.Interval
+	}
+	return b.wait(now)
+}
+
+// announce contacts the tracker and sends the peers it returns
+func (s *trackerSource) announce(ctx context.Context) {
+	if s.dm.seedingStopped() {
+		return
+	}
+
+	// Seeding downloads keep their state
+	downloading := !s.dm.SeedOnly && !s.dm.PieceManager.WantedComplete()
+	if downloading {
+		s.dm.updateState("Discovering peers")
+	}
+
+	s.lastAnnounce = time.Now()
+	resp, err := s.dm.announce(ctx, s.dm.nextEvent())
+	if err != nil {
+		fmt.Printf("Tracker error: %v\n", err)
+		if s.dm.OnTrackerError != nil {
+			s.dm.OnTrackerError(err)
+		}
+
+		// Retry soon, with the next tracker if there is one
+		s.failed++
+		if next, ok := s.dm.nextTracker(); ok {
+			fmt.Printf("Trying tracker %s\n", next)
+		}
+		return
+	}
+	s.failed = 0
+
+	if resp.Interval > 0 {
+		s.interval = time.Duration(resp.Interval) * time.Second
+	}
+	if resp.MinInterval > 0 {
+		s.minInterval = time.Duration(resp.MinInterval) * time.Second
+	}
+
+	if downloading && len(resp.Peers) == 0 {
+		s.noPeers()
+	} else {
+		s.empty = 0
+		if downloading {
+			s.dm.updateState("Downloading")
+		}
+	}
+
+	for _, p := range resp.Peers {
+		select {
+		case s.peers <- PeerInfo{Peer: p, Source: "tracker"}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// noPeers handles an announce that returned no peers while downloading. The
+// state says so unless other sources keep us connected, and the next
+// announce goes to the next backup tracker. There is no DHT to fall back on
+// beyond the trackers; other PeerSources keep running meanwhile.
+func (s *trackerSource) noPeers() {
+	s.empty++
+
+	if s.dm.PeerPool.GetConnectedPeers() == 0 {
+		s.dm.updateState("No peers found")
+	} else {
+		s.dm.updateState("Downloading")
+	}
+
+	if next, ok := s.dm.nextTracker(); ok {
+		fmt.Printf("Tracker returned no peers, trying %s\n", next)
+	}
+}
+
+// nextDelay returns the time until the next regular announce, shortened
+// while trackers fail or return no peers but never below the minimum interval
+func (s *trackerSource) nextDelay() time.Duration {
+	retries := s.empty
+	if s.failed > retries {
+		retries = s.failed
+	}
+	if retries == 0 {
+		return s.interval
+	}
+	return retryDelay(retries, s.interval, s.minInterval)
+}
+
+// retryDelay returns the wait before announcing again after retries failed
+// or empty announces: noPeersRetry, doubled for every further retry up to
+// interval, and never below minInterval
+func retryDelay(retries int, interval, minInterval time.Duration) time.Duration {
+	delay := noPeersRetry
+	for i := 1; i < retries && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		delay = interval
+	}
+	if delay < minInterval {
+		delay = minInterval
+	}
+	return delay
+}
+
+// ManualSource is a peer source for peers added by hand, for example from
+// the command line
+type ManualSource struct {
+	peers chan PeerInfo
+}
+
+// NewManualSource creates an empty manual peer source
+func NewManualSource() *ManualSource {
+	return &ManualSource{peers: make(chan PeerInfo, 100)}
+}
+
+// Start implements PeerSource; manual peers need no background work
+func (s *ManualSource) Start(ctx context.Context) error {
+	return nil
+}
+
+// Peers returns the peers added with Add
+func (s *ManualSource) Peers() <-chan PeerInfo {
+	return s.peers
+}
+
+// Add queues a peer given as host:port
+func (s *ManualSource) Add(addr string) error {
+	p, err := parsePeerAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.peers <- PeerInfo{Peer: p, Source: "manual"}:
+		return nil
+	default:
+		return fmt.Errorf("too many pending manual peers")
+	}
+}
+
+// parsePeerAddr parses a peer given as host:port, resolving host names
+func parsePeerAddr(addr string) (tracker.Peer, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return tracker.Peer{}, fmt.Errorf("invalid peer address '%s': %w", addr, err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return tracker.Peer{}, fmt.Errorf("invalid peer port in '%s'", addr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return tracker.Peer{}, fmt.Errorf("cannot resolve peer '%s': %v", addr, err)
+		}
+		ip = ips[0]
+	}
+
+	return tracker.Peer{IP: ip, Port: port}, nil
+}
@@ -0,0 +1,79 @@
+package download
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+func TestManualSourceAdd(t *testing.T) {
+	source := NewManualSource()
+
+	if err := source.Add("192.0.2.1:6881"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	info := <-source.Peers()
+	if info.Peer.String() != "192.0.2.1:6881" || info.Source != "manual" {
+		t.Errorf("Peers() = %+v, want 192.0.2.1:6881 from manual", info)
+	}
+
+	for _, addr := range []string{"192.0.2.1", "192.0.2.1:0", "192.0.2.1:http"} {
+		if err := source.Add(addr); err == nil {
+			t.Errorf("Add(%q) succeeded, want error", addr)
+		}
+	}
+}
+
+func TestTrackerSourceNoPeers(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:     "http://a.invalid/announce",
+		AnnounceList: [][]string{{"http://a.invalid/announce"}, {"http://b.invalid/announce"}},
+		Info:         torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash:   make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &fakeAnnouncer{}
+	dm.Tracker = announcer
+	dm.PeerPool = &fakePool{}
+
+	s := newTrackerSource(dm)
+	s.announce(context.Background())
+
+	if state := dm.GetStats().State; state != "No peers found" {
+		t.Errorf("state = %q after an empty announce, want No peers found", state)
+	}
+	if delay := s.nextDelay(); delay != noPeersRetry {
+		t.Errorf("nextDelay() = %v after one empty announce, want %v", delay, noPeersRetry)
+	}
+
+	// The backup tracker is next, and the retry backs off
+	s.announce(context.Background())
+	if delay := s.nextDelay(); delay != 2*noPeersRetry {
+		t.Errorf("nextDelay() = %v after two empty announces, want %v", delay, 2*noPeersRetry)
+	}
+
+	// The minimum interval still applies
+	s.minInterval = time.Hour
+	s.interval = 2 * time.Hour
+	if delay := s.nextDelay(); delay != time.Hour {
+		t.Errorf("nextDelay() = %v, want the minimum interval", delay)
+	}
+
+	want := []string{"http://a.invalid/announce", "http://b.invalid/announce"}
+	if len(announcer.urls) != 2 || announcer.urls[0] != want[0] || announcer.urls[1] != want[1] {
+		t.Errorf("announced to %q, want %q", announcer.urls, want)
+	}
+
+	// Peers reset the backoff
+	announcer.peers = []tracker.Peer{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}}
+	s.announce(context.Background())
+	if delay := s.nextDelay(); delay != s.interval || dm.GetStats().State != "Downloading" {
+		t.Errorf("nextDelay() = %v, state %q after peers, want the interval and Downloading", delay, dm.GetStats().State)
+	}
+}
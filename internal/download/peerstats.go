@@ -0,0 +1,79 @@
+package download
+
+import (
+	"net"
+	"sort"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// PeerStats reports a connected peer and the traffic exchanged with it
+type PeerStats struct {
+	Addr    string
+	Country string            // ISO country code when GeoIP is set
+	State   peer.SessionState // Whether the peer chokes or snubs us
+	Client  string            // Client name and version from the extended handshake, "" when unknown
+	Rates   []peer.PeerRates  // Block data over each of peer.RateWindows
+	peer.ConnStats
+}
+
+// GetPeerStats returns every connected peer, sorted by address
+func (dm *DownloadManager) GetPeerStats() []PeerStats {
+	var stats []PeerStats
+	for addr, session := range dm.PeerPool.GetPeers() {
+		var client string
+		if h := session.ExtendedHandshake(); h != nil {
+			client = h.Version
+		}
+
+		stats = append(stats, PeerStats{
+			Addr:      addr,
+			Country:   dm.peerCountry(addr),
+			State:     session.State(),
+			Client:    client,
+			Rates:     session.Rates(),
+			ConnStats: session.Stats(),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// CountPeersByCountry returns the number of connected peers per country;
+// peers GeoIP doesn't know are counted under ""
+func (dm *DownloadManager) CountPeersByCountry() map[string]int {
+	counts := make(map[string]int)
+	for _, p := range dm.GetPeerStats() {
+		counts[p.Country]++
+	}
+	return counts
+}
+
+// CountPeersByState returns the number of connected peers in each session state
+func (dm *DownloadManager) CountPeersByState() map[peer.SessionState]int {
+	counts := make(map[peer.SessionState]int)
+	for _, p := range dm.GetPeerStats() {
+		counts[p.State]++
+	}
+	return counts
+}
+
+// peerCountry looks up the country of a peer address with GeoIP
+func (dm *DownloadManager) peerCountry(addr string) string {
+	if dm.GeoIP == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+
+	return dm.GeoIP(ip)
+}
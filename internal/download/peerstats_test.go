@@ -0,0 +1,36 @@
+package download
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPeerCountry(t *testing.T) {
+	dm := &DownloadManager{}
+	if got := dm.peerCountry("192.0.2.1:6881"); got != "" {
+		t.Errorf("peerCountry() without GeoIP = %q, want empty", got)
+	}
+
+	dm.GeoIP = func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return "NL"
+		}
+		return ""
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1:6881", "NL"},
+		{"198.51.100.1:6881", ""},
+		{"[2001:db8::1]:6881", ""},
+		{"webrtc://peer", ""},
+	}
+
+	for _, tt := range tests {
+		if got := dm.peerCountry(tt.addr); got != tt.want {
+			t.Errorf("peerCountry(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,11 @@ package download
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"errors"
 	"fmt"
+	"hash"
 	"sync"
+	"time"
 )
 
 const (
@@ -32,6 +33,7 @@ type Block struct {
 	Begin  int    // Offset within the piece
 	Length int    // Length of the block
 	Data   []byte // Block data (nil if not downloaded)
+	Source string // Address of the peer that supplied the data
 }
 
 // Piece represents a piece of the torrent
@@ -44,6 +46,16 @@ type Piece struct {
 	Downloaded int          // Number of bytes downloaded
 	Requested  map[int]bool // Tracks which blocks have been requested
 	mu         sync.RWMutex // Mutex for concurrent access
+
+	// Blocks are hashed in order as they arrive, so the digest is ready
+	// when the last block lands
+	digest hash.Hash // Running digest, nil until the first block is hashed
+	hashed int       // Number of leading blocks fed to digest
+	sum    [20]byte  // Digest of the whole piece once summed is set
+	summed bool
+
+	firstBlock time.Time // Arrival of the first block
+	lastBlock  time.Time // Arrival of the block that completed the piece
 }
 
 // NewPiece creates a new piece
@@ -94,8 +106,8 @@ func (p *Piece) MarkRequested(blockIndex int) {
 	}
 }
 
-// AddBlock adds a downloaded block to the piece
-func (p *Piece) AddBlock(begin int, data []byte) error {
+// AddBlock adds a block downloaded from source to the piece
+func (p *Piece) AddBlock(begin int, data []byte, source string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -107,9 +119,23 @@ func (p *Piece) AddBlock(begin int, data []byte) error {
 				return fmt.Errorf("block length mistmatch: got %d, expected: %d", len(data), block.Length)
 			}
 
+			// Ignore duplicates so Downloaded stays accurate
+			if block.Data != nil {
+				return nil
+			}
+
 			// Add data
+			now := time.Now()
+			if p.Downloaded == 0 {
+				p.firstBlock = now
+			}
 			p.Blocks[i].Data = data
+			p.Blocks[i].Source = source
 			p.Downloaded += len(data)
+			p.hashBlocks()
+			if p.isComplete() {
+				p.lastBlock = now
+			}
 
 			return nil
 		}
@@ -118,20 +144,62 @@ func (p *Piece) AddBlock(begin int, data []byte) error {
 	return fmt.Errorf("no block found with begin offset %d", begin)
 }
 
+// hashBlocks feeds the blocks following the ones already hashed into the
+// digest, stopping at the first gap; callers must hold p.mu
+func (p *Piece) hashBlocks() {
+	if p.summed {
+		return
+	}
+	if p.digest == nil {
+		if p.digest = newDigest(); p.digest == nil {
+			return // Verify hashes the assembled piece instead
+		}
+	}
+
+	for p.hashed < len(p.Blocks) && p.Blocks[p.hashed].Data != nil {
+		p.digest.Write(p.Blocks[p.hashed].Data)
+		p.hashed++
+	}
+
+	if p.hashed == len(p.Blocks) {
+		copy(p.sum[:], p.digest.Sum(nil))
+		p.summed = true
+		p.digest = nil
+	}
+}
+
 // IsComplete returns true if all blocks have been downloaded
 func (p *Piece) IsComplete() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	return p.isComplete()
+}
+
+// isComplete is IsComplete without locking; callers must hold p.mu
+func (p *Piece) isComplete() bool {
 	return p.Length == p.Downloaded
 }
 
+// blockTimes returns when the first and the last block of the piece arrived
+func (p *Piece) blockTimes() (first, last time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.firstBlock, p.lastBlock
+}
+
 // AssembleData assembles all block data into a single byte slice
 func (p *Piece) AssembleData() []byte {
 	p.mu.RLock()
-	defer p.mu.Unlock()
+	defer p.mu.RUnlock()
+
+	return p.assembleData()
+}
 
-	if !p.IsComplete() {
+// assembleData is AssembleData without locking; callers must hold p.mu
+func (p *Piece) assembleData() []byte {
+	if !p.isComplete() {
 		return nil
 	}
 
@@ -146,22 +214,39 @@ func (p *Piece) AssembleData() []byte {
 	return data
 }
 
-// Verify checks if the piece data matches the expected hash
-func (p *Piece) Verify() bool {
+// BlockData returns the data of every block in order, without copying it,
+// or nil if the piece is incomplete. The buffers must not be modified.
+func (p *Piece) BlockData() [][]byte {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if !p.IsComplete() {
-		return false
+	if !p.isComplete() {
+		return nil
 	}
 
-	data := p.AssembleData()
+	blocks := make([][]byte, len(p.Blocks))
+	for i, block := range p.Blocks {
+		blocks[i] = block.Data
+	}
+
+	return blocks
+}
 
-	if data == nil {
+// Verify checks if the piece data matches the expected hash
+func (p *Piece) Verify() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.isComplete() {
 		return false
 	}
+	if p.summed {
+		return bytes.Equal(p.Hash[:], p.sum[:])
+	}
 
-	hash := sha1.Sum(data)
+	data := p.assembleData()
+
+	hash := hashSum(data)
 	return bytes.Equal(p.Hash[:], hash[:])
 }
 
@@ -171,7 +256,7 @@ func (p *Piece) NextRequest() *Block {
 	defer p.mu.Unlock()
 
 	for _, block := range p.Blocks {
-		if block.Data != nil && !p.Requested[block.Index] {
+		if block.Data == nil && !p.Requested[block.Index] {
 			p.Requested[block.Index] = true
 			return block
 		}
@@ -198,3 +283,40 @@ func (p *Piece) ResetRequests() {
 		p.State = PieceStateNone
 	}
 }
+
+// Contributors returns the distinct peer addresses that supplied blocks of the piece
+func (p *Piece) Contributors() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var sources []string
+	for _, block := range p.Blocks {
+		if block.Data == nil || seen[block.Source] {
+			continue
+		}
+
+		seen[block.Source] = true
+		sources = append(sources, block.Source)
+	}
+
+	return sources
+}
+
+// ClearBlocks discards all downloaded block data so the piece can be fetched again
+func (p *Piece) ClearBlocks() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, block := range p.Blocks {
+		block.Data = nil
+		block.Source = ""
+	}
+
+	p.Downloaded = 0
+	p.digest = nil
+	p.hashed = 0
+	p.summed = false
+	p.Requested = make(map[int]bool)
+	p.State = PieceStateNone
+}
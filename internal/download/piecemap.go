@@ -0,0 +1,88 @@
+package download
+
+// PieceMapState is the state of a piece as shown in a piece map
+type PieceMapState byte
+
+const (
+	PieceMissing    PieceMapState = iota // Not downloaded yet
+	PieceInProgress                      // Being downloaded
+	PieceComplete                        // Downloaded and verified
+	PieceFailed                          // Failed verification and not yet re-downloaded
+)
+
+// pieceMapChars renders each state in PieceMap.String
+var pieceMapChars = [...]byte{'.', '>', '#', 'x'}
+
+// PieceMap holds one state per piece, compact enough to render piece maps
+// in a UI on every stats update
+type PieceMap []PieceMapState
+
+// PieceMap returns the current state of every piece
+func (dm *DownloadManager) PieceMap() PieceMap {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	pieceMap := make(PieceMap, dm.Torrent.NumPieces())
+	for i := range pieceMap {
+		switch {
+		case dm.PieceManager.HasPiece(i):
+			pieceMap[i] = PieceComplete
+		case dm.activePieces[i] != "":
+			pieceMap[i] = PieceInProgress
+		case dm.pieceFailures[i] > 0:
+			pieceMap[i] = PieceFailed
+		}
+	}
+
+	return pieceMap
+}
+
+// Count returns how many pieces are in the given state
+func (m PieceMap) Count(state PieceMapState) int {
+	n := 0
+	for _, s := range m {
+		if s == state {
+			n++
+		}
+	}
+	return n
+}
+
+// Downsample reduces the map to at most width cells for display. Each cell
+// covers a run of pieces and shows the least finished state in it, with
+// failures taking precedence so they stay visible.
+func (m PieceMap) Downsample(width int) PieceMap {
+	if width <= 0 || len(m) <= width {
+		return m
+	}
+
+	cells := make(PieceMap, width)
+	for c := range cells {
+		start := c * len(m) / width
+		end := (c + 1) * len(m) / width
+
+		cell := PieceComplete
+		for _, s := range m[start:end] {
+			if s == PieceFailed {
+				cell = PieceFailed
+				break
+			}
+			if s < cell {
+				cell = s
+			}
+		}
+		cells[c] = cell
+	}
+
+	return cells
+}
+
+// String renders the map with one character per piece: '.' missing,
+// '>' in progress, '#' complete and 'x' failed
+func (m PieceMap) String() string {
+	b := make([]byte, len(m))
+	for i, s := range m {
+		b[i] = pieceMapChars[s]
+	}
+	return string(b)
+}
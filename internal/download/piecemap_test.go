@@ -0,0 +1,28 @@
+package download
+
+import "testing"
+
+func TestPieceMapDownsample(t *testing.T) {
+	m := PieceMap{
+		PieceComplete, PieceComplete,
+		PieceComplete, PieceInProgress,
+		PieceMissing, PieceFailed,
+		PieceComplete, PieceComplete,
+	}
+
+	if got := m.String(); got != "###>.x##" {
+		t.Errorf("String() = %q, want %q", got, "###>.x##")
+	}
+
+	if got := m.Downsample(4).String(); got != "#>x#" {
+		t.Errorf("Downsample(4) = %q, want %q", got, "#>x#")
+	}
+
+	if got := m.Downsample(20); len(got) != len(m) {
+		t.Errorf("Downsample(20) has %d cells, want %d", len(got), len(m))
+	}
+
+	if got := m.Count(PieceComplete); got != 5 {
+		t.Errorf("Count(PieceComplete) = %d, want 5", got)
+	}
+}
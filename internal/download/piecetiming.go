@@ -0,0 +1,84 @@
+package download
+
+import (
+	"fmt"
+	"time"
+)
+
+// PieceTimings records when a downloaded piece went through each step from
+// its first block to the have messages announcing it, to show where
+// latency accumulates. A piece failing its hash check ends at VerifyEnd.
+type PieceTimings struct {
+	Index    int
+	Length   int
+	Peers    int  // Peers that sent blocks of the piece
+	Verified bool // Whether the piece passed its hash check
+
+	FirstBlock  time.Time // The first block arrived
+	LastBlock   time.Time // The block completing the piece arrived
+	VerifyStart time.Time // The piece got hold of the download manager to be verified
+	VerifyEnd   time.Time // The hash check finished
+	WriteEnd    time.Time // The blocks were written, or buffered with a write buffer
+	HaveStart   time.Time // Completion was handled and have messages started going out
+	HaveEnd     time.Time // Have messages went out to the connected peers
+}
+
+// Queued is how long the completed piece waited to be verified
+func (t PieceTimings) Queued() time.Duration {
+	return t.VerifyStart.Sub(t.LastBlock)
+}
+
+// Finish is the time from the last block to the have messages
+func (t PieceTimings) Finish() time.Duration {
+	if !t.Verified {
+		return t.VerifyEnd.Sub(t.LastBlock)
+	}
+	return t.HaveEnd.Sub(t.LastBlock)
+}
+
+// startTimings starts the timings of a piece whose blocks have all arrived
+func startTimings(piece *Piece) *PieceTimings {
+	t := &PieceTimings{
+		Index:       piece.Index,
+		Length:      piece.Length,
+		Peers:       len(piece.Contributors()),
+		VerifyStart: time.Now(),
+	}
+	t.FirstBlock, t.LastBlock = piece.blockTimes()
+
+	// Pieces completed from somewhere other than peers, such as web seeds
+	// without block times, start when they are verified
+	if t.LastBlock.IsZero() {
+		t.LastBlock = t.VerifyStart
+	}
+	if t.FirstBlock.IsZero() {
+		t.FirstBlock = t.LastBlock
+	}
+	return t
+}
+
+// reportTimings hands the timings of a piece to OnPieceTimings and logs
+// pieces slower than SlowPieceThreshold; callers must hold dm.mu
+func (dm *DownloadManager) reportTimings(t *PieceTimings) {
+	// Pieces that couldn't be marked complete never got further
+	if t.Verified && t.HaveEnd.IsZero() {
+		return
+	}
+
+	if dm.SlowPieceThreshold > 0 && t.Finish() > dm.SlowPieceThreshold {
+		if t.Verified {
+			fmt.Printf("Slow piece %d: %v from last block to haves (queued %v, verify %v, write %v, completion %v, have %v)\n",
+				t.Index, t.Finish().Round(time.Millisecond), t.Queued().Round(time.Millisecond),
+				t.VerifyEnd.Sub(t.VerifyStart).Round(time.Millisecond), t.WriteEnd.Sub(t.VerifyEnd).Round(time.Millisecond),
+				t.HaveStart.Sub(t.WriteEnd).Round(time.Millisecond), t.HaveEnd.Sub(t.HaveStart).Round(time.Millisecond))
+		} else {
+			fmt.Printf("Slow piece %d: %v from last block to failed verification (queued %v, verify %v)\n",
+				t.Index, t.Finish().Round(time.Millisecond), t.Queued().Round(time.Millisecond),
+				t.VerifyEnd.Sub(t.VerifyStart).Round(time.Millisecond))
+		}
+	}
+
+	if dm.OnPieceTimings != nil {
+		dm.OnPieceTimings(*t)
+	}
+}
@@ -0,0 +1,61 @@
+package download
+
+import (
+	"crypto/sha1"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestFinishPieceReportsTimings(t *testing.T) {
+	data := []byte("abcd")
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: [][20]byte{sha1.Sum(data), {}},
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.PeerPool = &fakePool{}
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+
+	var reported []PieceTimings
+	dm.OnPieceTimings = func(t PieceTimings) { reported = append(reported, t) }
+
+	dm.PieceManager.AddBlock(0, 0, data, "peer")
+	dm.PieceManager.AddBlock(1, 0, []byte("abcx"), "peer")
+	dm.mu.Lock()
+	dm.finishPiece(dm.PieceManager.Pieces[0])
+	dm.finishPiece(dm.PieceManager.Pieces[1])
+	dm.mu.Unlock()
+
+	if len(reported) != 2 {
+		t.Fatalf("OnPieceTimings called %d times, want 2", len(reported))
+	}
+
+	good := reported[0]
+	if !good.Verified || good.Index != 0 || good.Peers != 1 {
+		t.Errorf("timings = %+v, want verified piece 0 from 1 peer", good)
+	}
+	steps := []struct {
+		name string
+		at   int64
+	}{
+		{"first block", good.FirstBlock.UnixNano()},
+		{"last block", good.LastBlock.UnixNano()},
+		{"verify start", good.VerifyStart.UnixNano()},
+		{"verify end", good.VerifyEnd.UnixNano()},
+		{"write end", good.WriteEnd.UnixNano()},
+		{"have start", good.HaveStart.UnixNano()},
+		{"have end", good.HaveEnd.UnixNano()},
+	}
+	for i := 1; i < len(steps); i++ {
+		if steps[i].at < steps[i-1].at {
+			t.Errorf("%s before %s", steps[i].name, steps[i-1].name)
+		}
+	}
+
+	bad := reported[1]
+	if bad.Verified || bad.VerifyEnd.IsZero() || !bad.WriteEnd.IsZero() {
+		t.Errorf("timings of a corrupt piece = %+v, want them to end at the hash check", bad)
+	}
+}
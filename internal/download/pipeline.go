@@ -0,0 +1,43 @@
+package download
+
+import "github.com/piyushgupta53/go-torrent/internal/peer"
+
+const (
+	// defaultPipelineDepth is the number of block requests kept outstanding
+	// with a peer that didn't say how many requests it queues
+	defaultPipelineDepth = 5
+
+	// maxPipelineDepth caps the requests outstanding with a single peer,
+	// however many it queues
+	maxPipelineDepth = 64
+)
+
+// pipelineDepth returns the number of block requests to keep outstanding
+// with a peer: as many as its extended handshake says it queues ("reqq"),
+// so requests beyond what it keeps aren't dropped, up to maxPipelineDepth
+func pipelineDepth(session *peer.Session) int {
+	reqq := session.RequestQueue()
+	switch {
+	case reqq <= 0:
+		return defaultPipelineDepth
+	case reqq > maxPipelineDepth:
+		return maxPipelineDepth
+	default:
+		return reqq
+	}
+}
+
+// Outstanding returns the number of blocks requested and not yet received
+func (p *Piece) Outstanding() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := 0
+	for _, block := range p.Blocks {
+		if block.Data == nil && p.Requested[block.Index] {
+			n++
+		}
+	}
+
+	return n
+}
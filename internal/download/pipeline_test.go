@@ -0,0 +1,21 @@
+package download
+
+import "testing"
+
+func TestPieceOutstanding(t *testing.T) {
+	piece := NewPiece(0, [20]byte{}, 3*BlockSize)
+
+	for i := 0; i < 2; i++ {
+		piece.NextRequest()
+	}
+	if n := piece.Outstanding(); n != 2 {
+		t.Errorf("Outstanding() = %d after two requests, want 2", n)
+	}
+
+	if err := piece.AddBlock(0, make([]byte, BlockSize), "peer"); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+	if n := piece.Outstanding(); n != 1 {
+		t.Errorf("Outstanding() = %d after a block arrived, want 1", n)
+	}
+}
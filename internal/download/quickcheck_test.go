@@ -0,0 +1,106 @@
+package download
+
+import (
+	"crypto/sha1"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestSamplePieces(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		total   int
+		percent float64
+		want    int
+	}{
+		{1000, 5, 50},
+		{1000, 0.01, 2}, // Never fewer than the first and the last
+		{999, 10, 100},  // Rounded up
+		{10, 100, 10},
+		{1, 50, 1},
+	}
+	for _, tt := range tests {
+		pieces := samplePieces(tt.total, tt.percent, r)
+		if len(pieces) != tt.want {
+			t.Errorf("samplePieces(%d, %g) picked %d pieces, want %d", tt.total, tt.percent, len(pieces), tt.want)
+			continue
+		}
+		if pieces[0] != 0 || pieces[len(pieces)-1] != tt.total-1 {
+			t.Errorf("samplePieces(%d, %g) = %v, want the first and last piece", tt.total, tt.percent, pieces)
+		}
+		if !sort.IntsAreSorted(pieces) {
+			t.Errorf("samplePieces(%d, %g) = %v, want them in order", tt.total, tt.percent, pieces)
+		}
+		for i := 1; i < len(pieces); i++ {
+			if pieces[i] == pieces[i-1] {
+				t.Errorf("samplePieces(%d, %g) picked piece %d twice", tt.total, tt.percent, pieces[i])
+			}
+		}
+	}
+}
+
+func TestQuickCheck(t *testing.T) {
+	// Twenty pieces of 4 bytes, the last one corrupt
+	payload := make([]byte, 80)
+	for i := range payload {
+		payload[i] = byte('a' + i%26)
+	}
+
+	var hashes [][20]byte
+	for i := 0; i < 20; i++ {
+		hashes = append(hashes, sha1.Sum(payload[i*4:i*4+4]))
+	}
+
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 80},
+		PiecesHash: hashes,
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	for i := range hashes {
+		data := payload[i*4 : i*4+4]
+		if i == 19 {
+			data = []byte("bad!")
+		}
+		if err := fs.WritePiece(i, data); err != nil {
+			t.Fatalf("WritePiece(%d) error = %v", i, err)
+		}
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.Storage = fs
+
+	var progressTotal int
+	dm.OnCheckProgress = func(checked, total int) { progressTotal = total }
+
+	result, err := dm.QuickCheck(25)
+	if err != nil {
+		t.Fatalf("QuickCheck() error = %v", err)
+	}
+	if result.Total != 20 || len(result.Checked) != 5 || progressTotal != 5 {
+		t.Errorf("QuickCheck(25) checked %d of %d pieces (progress total %d), want 5 of 20", len(result.Checked), result.Total, progressTotal)
+	}
+	if !reflect.DeepEqual(result.Bad, []int{19}) {
+		t.Errorf("QuickCheck(25) bad pieces = %v, want [19]", result.Bad)
+	}
+	if intact := result.Intact(); intact != 80 {
+		t.Errorf("Intact() = %g, want 80", intact)
+	}
+	if have := dm.PieceManager.DownloadedCount(); have != 0 {
+		t.Errorf("QuickCheck() marked %d pieces complete, want none", have)
+	}
+
+	if _, err := dm.QuickCheck(0); err == nil {
+		t.Error("QuickCheck(0) succeeded, want an error")
+	}
+}
@@ -0,0 +1,116 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+var (
+	ErrInvalidRange = errors.New("invalid range")
+)
+
+// SetWantedPieces restricts the download to the pieces first through last,
+// for example to preview part of a file or repair a region that failed
+// verification. Other pieces are never requested and the download is
+// complete once the wanted ones are; pieces we have are still served.
+func (pm *PieceManager) SetWantedPieces(first, last int) error {
+	if first < 0 || last < first || last >= len(pm.Pieces) {
+		return fmt.Errorf("%w: pieces %d-%d of %d", ErrInvalidRange, first, last, len(pm.Pieces))
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.wantedPieces = make(map[int]bool, last-first+1)
+	for i := first; i <= last; i++ {
+		pm.wantedPieces[i] = true
+	}
+	return nil
+}
+
+// IsWanted returns true if a piece is to be downloaded
+func (pm *PieceManager) IsWanted(pieceIndex int) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.wanted(pieceIndex)
+}
+
+// wanted is IsWanted for callers that already hold pm.mu
+func (pm *PieceManager) wanted(pieceIndex int) bool {
+	return pm.wantedPieces == nil || pm.wantedPieces[pieceIndex]
+}
+
+// WantedComplete returns true once every wanted piece has been downloaded
+func (pm *PieceManager) WantedComplete() bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.wantedPieces == nil {
+		return len(pm.Pieces) == pm.Completed
+	}
+
+	for pieceIndex := range pm.wantedPieces {
+		if !pm.Downloaded[pieceIndex] {
+			return false
+		}
+	}
+	return true
+}
+
+// WantedCount returns the number of wanted pieces downloaded so far and the
+// number of wanted pieces
+func (pm *PieceManager) WantedCount() (int, int) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.wantedPieces == nil {
+		return pm.Completed, len(pm.Pieces)
+	}
+
+	done := 0
+	for pieceIndex := range pm.wantedPieces {
+		if pm.Downloaded[pieceIndex] {
+			done++
+		}
+	}
+	return done, len(pm.wantedPieces)
+}
+
+// PieceRangeForBytes returns the first and last piece holding length bytes
+// of the payload starting at offset
+func PieceRangeForBytes(t *torrent.TorrentFile, offset, length int64) (int, int, error) {
+	if offset < 0 || length <= 0 || offset+length > t.TotalLength() || t.Info.PieceLength <= 0 {
+		return 0, 0, fmt.Errorf("%w: %d bytes at offset %d of %d", ErrInvalidRange, length, offset, t.TotalLength())
+	}
+
+	first := int(offset / t.Info.PieceLength)
+	last := int((offset + length - 1) / t.Info.PieceLength)
+	return first, last, nil
+}
+
+// SetPieceRange downloads only the pieces first through last. It must be
+// called before Start.
+func (dm *DownloadManager) SetPieceRange(first, last int) error {
+	if err := dm.PieceManager.SetWantedPieces(first, last); err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.Stats.PiecesCompleted, dm.Stats.PiecesTotal = dm.PieceManager.WantedCount()
+	return nil
+}
+
+// SetByteRange downloads only the pieces holding length bytes of the
+// payload from offset. Whole pieces are downloaded, as only those can be
+// verified. It must be called before Start.
+func (dm *DownloadManager) SetByteRange(offset, length int64) error {
+	first, last, err := PieceRangeForBytes(dm.Torrent, offset, length)
+	if err != nil {
+		return err
+	}
+	return dm.SetPieceRange(first, last)
+}
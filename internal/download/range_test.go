@@ -0,0 +1,84 @@
+package download
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestPieceRangeForBytes(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 10},
+		PiecesHash: make([][20]byte, 3),
+	}
+
+	tests := []struct {
+		offset, length int64
+		first, last    int
+	}{
+		{0, 1, 0, 0},
+		{0, 4, 0, 0},
+		{3, 2, 0, 1},
+		{4, 6, 1, 2},
+		{9, 1, 2, 2},
+	}
+	for _, tt := range tests {
+		first, last, err := PieceRangeForBytes(torrentFile, tt.offset, tt.length)
+		if err != nil || first != tt.first || last != tt.last {
+			t.Errorf("PieceRangeForBytes(%d, %d) = %d, %d, %v, want %d, %d", tt.offset, tt.length, first, last, err, tt.first, tt.last)
+		}
+	}
+
+	for _, r := range [][2]int64{{-1, 2}, {0, 0}, {8, 3}} {
+		if _, _, err := PieceRangeForBytes(torrentFile, r[0], r[1]); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("PieceRangeForBytes(%d, %d) error = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+	}
+}
+
+func TestPieceManagerWantedPieces(t *testing.T) {
+	pm := NewPieceManager(&torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 16},
+		PiecesHash: make([][20]byte, 4),
+	})
+
+	if err := pm.SetWantedPieces(2, 4); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("SetWantedPieces(2, 4) error = %v, want ErrInvalidRange", err)
+	}
+	if err := pm.SetWantedPieces(1, 2); err != nil {
+		t.Fatalf("SetWantedPieces(1, 2) error = %v", err)
+	}
+
+	// Pieces outside the range are never picked
+	all := peer.Bitfield{0xf0}
+	if piece := pm.PickFirstAvailable(all, []int{0, 3}); piece != nil {
+		t.Errorf("PickFirstAvailable() = piece %d outside the range", piece.Index)
+	}
+	if piece := pm.PickSequential(all, []peer.Bitfield{all}, 0, SequentialWindow{Size: 1, Strict: true}); piece == nil || piece.Index != 1 {
+		t.Fatalf("PickSequential() = %v, want piece 1", piece)
+	}
+	if piece := pm.PickPiece([]peer.Bitfield{all}, "sequential"); piece == nil || piece.Index != 2 {
+		t.Fatalf("PickPiece() = %v, want piece 2", piece)
+	}
+	if piece := pm.PickPiece([]peer.Bitfield{all}, "sequential"); piece != nil && (piece.Index == 0 || piece.Index == 3) {
+		t.Errorf("PickPiece() = piece %d outside the range", piece.Index)
+	}
+
+	if pm.WantedComplete() {
+		t.Error("WantedComplete() before the range was downloaded")
+	}
+
+	pm.MarkPieceHave(1)
+	pm.MarkPieceHave(2)
+	if !pm.WantedComplete() || pm.IsComplete() {
+		t.Errorf("WantedComplete() = %v, IsComplete() = %v with the range downloaded, want true, false", pm.WantedComplete(), pm.IsComplete())
+	}
+	if done, total := pm.WantedCount(); done != 2 || total != 2 || pm.Progress() != 1 {
+		t.Errorf("WantedCount() = %d, %d, Progress() = %v, want 2, 2, 1", done, total, pm.Progress())
+	}
+	if left := pm.BytesLeft(); left != 0 {
+		t.Errorf("BytesLeft() = %d with the range downloaded, want 0", left)
+	}
+}
@@ -0,0 +1,224 @@
+package download
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotStarted     = errors.New("download not started")
+	ErrNegativeOffset = errors.New("negative read offset")
+)
+
+// ReadAhead serves reads of the torrent payload while it is still being
+// downloaded, for streaming players and FUSE mounts. The pieces in a window
+// ahead of the consumer position are requested before any others, complete
+// pieces in the window are prefetched from disk into memory, and cached
+// pieces behind the consumer are evicted.
+type ReadAhead struct {
+	dm          *DownloadManager
+	window      int            // Number of pieces to keep ahead of the consumer
+	position    int            // Piece index the consumer is currently reading
+	cache       map[int][]byte // pieceIndex -> piece data
+	prefetching bool
+	mu          sync.Mutex
+}
+
+// EnableStreaming switches the download to sequential order and returns a
+// ReadAhead that prioritizes the window pieces following the read position
+func (dm *DownloadManager) EnableStreaming(window int) *ReadAhead {
+	if window <= 0 {
+		window = 8
+	}
+
+	ra := &ReadAhead{
+		dm:     dm,
+		window: window,
+		cache:  make(map[int][]byte),
+	}
+
+	dm.mu.Lock()
+	dm.readAhead = ra
+	dm.mu.Unlock()
+
+	return ra
+}
+
+// Wanted returns the pieces in the read-ahead window that are not yet
+// downloaded, nearest first
+func (ra *ReadAhead) Wanted() []int {
+	ra.mu.Lock()
+	start, end := ra.position, ra.position+ra.window
+	ra.mu.Unlock()
+
+	var wanted []int
+	for i := start; i < end && i < ra.dm.Torrent.NumPieces(); i++ {
+		if !ra.dm.PieceManager.HasPiece(i) {
+			wanted = append(wanted, i)
+		}
+	}
+
+	return wanted
+}
+
+// Position returns the piece index the consumer is currently reading
+func (ra *ReadAhead) Position() int {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return ra.position
+}
+
+// ReadAt reads len(p) bytes of the payload starting at off, waiting for
+// the pieces involved to be downloaded
+func (ra *ReadAhead) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+
+	total := ra.dm.Torrent.TotalLength()
+	if off >= total {
+		return 0, io.EOF
+	}
+
+	pieceLength := ra.dm.Torrent.Info.PieceLength
+	n := 0
+
+	for n < len(p) && off < total {
+		index := int(off / pieceLength)
+		ra.seek(index)
+
+		data, err := ra.piece(index)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], data[off-int64(index)*pieceLength:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// seek moves the consumer position, evicting cached pieces outside the
+// window and starting a prefetch of the pieces ahead
+func (ra *ReadAhead) seek(index int) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	if index == ra.position && len(ra.cache) > 0 {
+		return
+	}
+	ra.position = index
+
+	for cached := range ra.cache {
+		if cached < ra.position || cached >= ra.position+ra.window {
+			delete(ra.cache, cached)
+		}
+	}
+
+	if !ra.prefetching {
+		ra.prefetching = true
+		go ra.prefetch()
+	}
+}
+
+// prefetch loads complete pieces in the window from disk into the cache
+func (ra *ReadAhead) prefetch() {
+	defer func() {
+		ra.mu.Lock()
+		ra.prefetching = false
+		ra.mu.Unlock()
+	}()
+
+	for _, index := range ra.windowIndexes() {
+		if !ra.dm.PieceManager.HasPiece(index) {
+			continue
+		}
+
+		ra.mu.Lock()
+		_, cached := ra.cache[index]
+		ra.mu.Unlock()
+		if cached {
+			continue
+		}
+
+		data, err := ra.dm.Storage.ReadPiece(index, int(ra.dm.Torrent.PieceSize(index)))
+		if err != nil {
+			return
+		}
+		ra.store(index, data)
+	}
+}
+
+// windowIndexes returns the piece indexes in the current window
+func (ra *ReadAhead) windowIndexes() []int {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	var indexes []int
+	for i := ra.position; i < ra.position+ra.window && i < ra.dm.Torrent.NumPieces(); i++ {
+		indexes = append(indexes, i)
+	}
+
+	return indexes
+}
+
+// store caches piece data if it is still inside the window
+func (ra *ReadAhead) store(index int, data []byte) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	if index >= ra.position && index < ra.position+ra.window {
+		ra.cache[index] = data
+	}
+}
+
+// piece returns the data of a piece from the cache or disk, waiting until
+// the piece has been downloaded
+func (ra *ReadAhead) piece(index int) ([]byte, error) {
+	ra.mu.Lock()
+	data, ok := ra.cache[index]
+	ra.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	if err := ra.waitForPiece(index); err != nil {
+		return nil, err
+	}
+
+	data, err := ra.dm.Storage.ReadPiece(index, int(ra.dm.Torrent.PieceSize(index)))
+	if err != nil {
+		return nil, err
+	}
+	ra.store(index, data)
+
+	return data, nil
+}
+
+// waitForPiece blocks until a piece is downloaded or the download stops
+func (ra *ReadAhead) waitForPiece(index int) error {
+	if ra.dm.ctx == nil {
+		return ErrNotStarted
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !ra.dm.PieceManager.HasPiece(index) {
+		select {
+		case <-ra.dm.ctx.Done():
+			return ErrDownloadCancelled
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
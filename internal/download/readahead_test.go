@@ -0,0 +1,154 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// newStreamingManager returns a started download of "0123456789" in pieces
+// of 4 bytes, with the given pieces already on the fake storage
+func newStreamingManager(t *testing.T, window int, have ...int) (*DownloadManager, *ReadAhead) {
+	t.Helper()
+
+	payload := []byte("0123456789")
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: int64(len(payload))},
+		PiecesHash: make([][20]byte, 3),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	storage := &fakeStorage{pieces: make(map[int][]byte)}
+	for _, index := range have {
+		end := min(int64(index+1)*4, int64(len(payload)))
+		storage.pieces[index] = payload[index*4 : end]
+		dm.PieceManager.MarkPieceHave(index)
+	}
+	dm.Storage = storage
+	dm.ctx, dm.cancel = context.WithCancel(context.Background())
+	t.Cleanup(dm.cancel)
+
+	return dm, dm.EnableStreaming(window)
+}
+
+// cached reports whether a piece is in the read-ahead cache
+func (ra *ReadAhead) cached(index int) bool {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	_, ok := ra.cache[index]
+	return ok
+}
+
+func TestReadAheadReadAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		off     int64
+		want    string
+		wantErr error
+	}{
+		{"inside a piece", 2, 1, "12", nil},
+		{"across piece edges", 6, 2, "234567", nil},
+		{"short last piece", 2, 8, "89", nil},
+		{"past the end", 4, 8, "89", io.EOF},
+		{"at the end", 4, 10, "", io.EOF},
+		{"negative offset", 4, -1, "", ErrNegativeOffset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ra := newStreamingManager(t, 2, 0, 1, 2)
+
+			p := make([]byte, tt.size)
+			n, err := ra.ReadAt(p, tt.off)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadAt() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := string(p[:n]); got != tt.want {
+				t.Errorf("ReadAt() read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAheadWindow(t *testing.T) {
+	_, ra := newStreamingManager(t, 2, 0, 1, 2)
+
+	// Complete pieces in the window are prefetched, later ones are not
+	ra.seek(0)
+	waitFor(t, func() bool { return ra.cached(0) && ra.cached(1) })
+	if ra.cached(2) {
+		t.Error("piece 2 prefetched outside the window")
+	}
+
+	// Moving the window evicts the pieces behind the consumer
+	ra.seek(2)
+	if ra.cached(0) || ra.cached(1) {
+		t.Error("pieces behind the consumer still cached after seek")
+	}
+	waitFor(t, func() bool { return ra.cached(2) })
+
+	if got := ra.Position(); got != 2 {
+		t.Errorf("Position() = %d, want 2", got)
+	}
+}
+
+func TestReadAheadWanted(t *testing.T) {
+	_, ra := newStreamingManager(t, 2, 0)
+
+	if got := ra.Wanted(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Wanted() = %v, want [1]", got)
+	}
+}
+
+func TestReadAheadCancelWhileWaiting(t *testing.T) {
+	dm, ra := newStreamingManager(t, 2, 0)
+
+	done := make(chan error, 1)
+	go func() {
+		p := make([]byte, 8)
+		n, err := ra.ReadAt(p, 0)
+		if !bytes.Equal(p[:n], []byte("0123")) {
+			err = errors.New("missing the downloaded piece")
+		}
+		done <- err
+	}()
+
+	dm.cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrDownloadCancelled) {
+			t.Errorf("ReadAt() error = %v, want ErrDownloadCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadAt() still waiting for a piece after cancel")
+	}
+}
+
+func TestReadAheadNotStarted(t *testing.T) {
+	dm, ra := newStreamingManager(t, 2)
+	dm.ctx = nil
+
+	if _, err := ra.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("ReadAt() error = %v, want ErrNotStarted", err)
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds pass
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package download
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request number
+const ficlone = 0x40049409
+
+// reflink creates dst as a copy-on-write clone of src on filesystems that
+// support it (btrfs, XFS, bcachefs)
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	out.Close()
+
+	if errno != 0 {
+		os.Remove(dst)
+		return errno
+	}
+
+	return nil
+}
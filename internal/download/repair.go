@@ -0,0 +1,22 @@
+package download
+
+// RepairReport describes a repair: the pieces of the existing data that
+// failed the check, and those of them downloaded and verified since
+type RepairReport struct {
+	Bad   []int
+	Fixed []int
+}
+
+// RepairReport returns what the check of the existing data found and what
+// has been repaired so far. The check is done before the download starts,
+// so it is safe to call from the callbacks.
+func (dm *DownloadManager) RepairReport() RepairReport {
+	report := RepairReport{Bad: append([]int(nil), dm.badPieces...)}
+	for _, index := range report.Bad {
+		if dm.PieceManager.HasPiece(index) {
+			report.Fixed = append(report.Fixed, index)
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultReverifyInterval is the time between trusted pieces re-hashed in
+// the background, which keeps the disk free for uploads
+const DefaultReverifyInterval = time.Second
+
+// trustResume marks the pieces in TrustedPieces as complete without hashing
+// them and queues them for re-verification in the background. It returns
+// the number of pieces marked.
+func (dm *DownloadManager) trustResume() (int, error) {
+	if err := dm.TrustedPieces.Validate(dm.Torrent.NumPieces()); err != nil {
+		return 0, fmt.Errorf("resume data doesn't match the torrent: %w", err)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	dm.trusted = make(map[int]bool)
+	dm.reverifyQueue = nil
+	for i := 0; i < dm.Torrent.NumPieces(); i++ {
+		if !dm.TrustedPieces.HasPiece(i) {
+			continue
+		}
+
+		if err := dm.PieceManager.MarkPieceHave(i); err != nil {
+			return len(dm.trusted), err
+		}
+		dm.trusted[i] = true
+		dm.reverifyQueue = append(dm.reverifyQueue, i)
+	}
+
+	return len(dm.trusted), nil
+}
+
+// reverifyWorker re-hashes one trusted piece every ReverifyInterval while
+// seeding and the disk has no writes queued, until every trusted piece
+// has been checked
+func (dm *DownloadManager) reverifyWorker() {
+	interval := dm.ReverifyInterval
+	if interval <= 0 {
+		interval = DefaultReverifyInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+			seeding := dm.SeedOnly || dm.PieceManager.WantedComplete()
+			if !seeding || dm.Storage.DiskStats().QueuedBytes > 0 {
+				continue
+			}
+
+			if !dm.reverifyNext() {
+				fmt.Printf("Background verification of resumed pieces complete\n")
+				return
+			}
+		}
+	}
+}
+
+// reverifyNext re-hashes the next trusted piece that hasn't been checked
+// yet. It returns false when none is left.
+func (dm *DownloadManager) reverifyNext() bool {
+	dm.mu.Lock()
+	index := -1
+	for index < 0 && len(dm.reverifyQueue) > 0 {
+		if next := dm.reverifyQueue[0]; dm.trusted[next] {
+			index = next
+		}
+		dm.reverifyQueue = dm.reverifyQueue[1:]
+	}
+	dm.mu.Unlock()
+
+	if index < 0 {
+		return false
+	}
+
+	data, err := dm.Storage.ReadPiece(index, int(dm.Torrent.PieceSize(index)))
+	if err != nil {
+		fmt.Printf("Error reading piece %d for verification: %v\n", index, err)
+		data = nil
+	}
+	dm.checkTrusted(index, data)
+
+	return true
+}
+
+// checkTrusted hashes the data of a piece that was trusted without being
+// verified. A piece that doesn't match is marked missing again. It
+// reports whether the piece may be uploaded; pieces that were verified
+// already are not hashed again.
+func (dm *DownloadManager) checkTrusted(index int, data []byte) bool {
+	dm.mu.Lock()
+	trusted := dm.trusted[index]
+	delete(dm.trusted, index)
+	dm.mu.Unlock()
+
+	if !trusted || hashSum(data) == dm.Torrent.PiecesHash[index] {
+		return true
+	}
+
+	fmt.Printf("Resumed piece %d failed verification, marking it missing\n", index)
+	dm.PieceManager.ResetPiece(index)
+	dm.PieceManager.Pieces[index].ClearBlocks()
+
+	if !dm.SeedOnly {
+		dm.updateState("Downloading")
+	}
+
+	return false
+}
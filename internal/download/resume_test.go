@@ -0,0 +1,65 @@
+package download
+
+import (
+	"crypto/sha1"
+	"errors"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestTrustResume(t *testing.T) {
+	pieces := [][]byte{[]byte("aaaa"), []byte("bbbb"), []byte("cccc")}
+	hashes := make([][20]byte, len(pieces))
+	for i, data := range pieces {
+		hashes[i] = sha1.Sum(data)
+	}
+
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 12},
+		PiecesHash: hashes,
+	}
+
+	// Piece 1 was corrupted on disk since it was saved
+	storage := &fakeStorage{pieces: map[int][]byte{0: pieces[0], 1: []byte("xxxx"), 2: pieces[2]}}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.Storage = storage
+	dm.uploadCache = newUploadCache(0)
+	dm.TrustedPieces = make(peer.Bitfield, 1)
+	for i := range pieces {
+		dm.TrustedPieces.SetPiece(i)
+	}
+
+	trusted, err := dm.trustResume()
+	if err != nil || trusted != 3 {
+		t.Fatalf("trustResume() = %d, %v, want 3", trusted, err)
+	}
+	if !dm.PieceManager.IsComplete() {
+		t.Fatal("trusted pieces are not complete")
+	}
+
+	// A trusted piece is checked before its first upload
+	if _, err := dm.uploadPiece(1); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("uploadPiece(1) error = %v, want ErrVerificationFailed", err)
+	}
+	if dm.PieceManager.HasPiece(1) {
+		t.Error("corrupt trusted piece is still complete")
+	}
+
+	for dm.reverifyNext() {
+	}
+	if !dm.PieceManager.HasPiece(0) || !dm.PieceManager.HasPiece(2) {
+		t.Error("good trusted pieces were marked missing")
+	}
+	if n := len(dm.trusted); n != 0 {
+		t.Errorf("%d pieces left to re-verify, want 0", n)
+	}
+
+	// Resume data for another torrent is refused
+	dm.TrustedPieces = make(peer.Bitfield, 2)
+	if _, err := dm.trustResume(); err == nil {
+		t.Error("trustResume() accepted a bitfield of the wrong length")
+	}
+}
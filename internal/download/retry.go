@@ -0,0 +1,92 @@
+package download
+
+import "time"
+
+// RetryPolicy controls how pieces that time out or fail verification are
+// retried. Each failure doubles the wait before the piece is picked again,
+// up to MaxBackoff, so a piece no peer can deliver doesn't spin forever.
+type RetryPolicy struct {
+	InitialBackoff time.Duration // Wait after the first failure
+	MaxBackoff     time.Duration // Longest wait between attempts
+	WarnAfter      int           // Failures after which the piece is reported stuck
+}
+
+// DefaultRetryPolicy returns the retry policy used unless configured otherwise
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		WarnAfter:      5,
+	}
+}
+
+// backoff returns the wait after the given number of failures
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < failures && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// pieceRetry is the retry state of a piece that has failed
+type pieceRetry struct {
+	failures  int
+	notBefore time.Time // The piece isn't picked again until then
+}
+
+// RecordFailure counts a timed out or corrupt attempt at a piece and backs
+// the piece off. It returns the failures so far and the wait before the
+// piece is picked again.
+func (pm *PieceManager) RecordFailure(pieceIndex int) (int, time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	retry, ok := pm.retries[pieceIndex]
+	if !ok {
+		retry = &pieceRetry{}
+		pm.retries[pieceIndex] = retry
+	}
+
+	retry.failures++
+	delay := pm.Retry.backoff(retry.failures)
+	retry.notBefore = time.Now().Add(delay)
+
+	return retry.failures, delay
+}
+
+// Retries returns how many times a piece has failed since it last verified
+func (pm *PieceManager) Retries(pieceIndex int) int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if retry, ok := pm.retries[pieceIndex]; ok {
+		return retry.failures
+	}
+	return 0
+}
+
+// StuckPieces returns the number of pieces that have failed at least
+// Retry.WarnAfter times
+func (pm *PieceManager) StuckPieces() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	stuck := 0
+	for _, retry := range pm.retries {
+		if pm.Retry.WarnAfter > 0 && retry.failures >= pm.Retry.WarnAfter {
+			stuck++
+		}
+	}
+	return stuck
+}
+
+// backingOff reports whether a failed piece is still waiting before its
+// next attempt; callers must hold pm.mu
+func (pm *PieceManager) backingOff(pieceIndex int, now time.Time) bool {
+	retry, ok := pm.retries[pieceIndex]
+	return ok && now.Before(retry.notBefore)
+}
@@ -0,0 +1,155 @@
+package download
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// NameCollisionPolicy decides where a torrent is saved when a different
+// torrent with the same name already uses the save directory
+type NameCollisionPolicy int
+
+const (
+	// NameCollisionSuffix saves the torrent as "name (2)", "name (3)", ...
+	// keeping a file extension at the end
+	NameCollisionSuffix NameCollisionPolicy = iota
+	// NameCollisionSubdir saves the torrent below a directory named after
+	// its info hash
+	NameCollisionSubdir
+	// NameCollisionShare saves both torrents to the same files
+	NameCollisionShare
+)
+
+// ParseNameCollisionPolicy parses "suffix", "subdir" or "share"
+func ParseNameCollisionPolicy(s string) (NameCollisionPolicy, error) {
+	switch s {
+	case "suffix":
+		return NameCollisionSuffix, nil
+	case "subdir":
+		return NameCollisionSubdir, nil
+	case "share":
+		return NameCollisionShare, nil
+	}
+	return 0, fmt.Errorf("unknown name collision policy %q (want suffix, subdir or share)", s)
+}
+
+// savedNamesFile lists, in each save directory, which torrent owns which
+// name below it
+const savedNamesFile = ".go-torrent-names"
+
+// savedName is where a torrent was saved, relative to the save directory
+type savedName struct {
+	Dir  string `json:"dir,omitempty"` // Subdirectory, for NameCollisionSubdir
+	Name string `json:"name"`
+}
+
+// ResolveSaveName picks where below dir a torrent is saved and records the
+// choice, so later torrents with the same name don't write into its files.
+// A torrent saved before keeps its location. It returns the directory and
+// the name to pass to NewFileStorageAs.
+func ResolveSaveName(dir string, t *torrent.TorrentFile, policy NameCollisionPolicy) (string, string, error) {
+	if policy == NameCollisionShare {
+		return dir, t.Info.Name, nil
+	}
+
+	owners, err := loadSavedNames(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	infoHash := hex.EncodeToString(t.InfoHash[:])
+	saved, known := owners[infoHash]
+	if !known {
+		taken := func(candidate savedName) bool {
+			for _, other := range owners {
+				if other == candidate {
+					return true
+				}
+			}
+			return false
+		}
+
+		saved = savedName{Name: t.Info.Name}
+		if taken(saved) {
+			fmt.Printf("Another torrent is saved as %s\n", t.Info.Name)
+
+			switch policy {
+			case NameCollisionSubdir:
+				saved.Dir = infoHash
+			default:
+				for n := 2; taken(saved); n++ {
+					saved.Name = numberedName(t.Info.Name, n, !t.Info.IsDirectory)
+				}
+			}
+		}
+
+		owners[infoHash] = saved
+		if err := saveSavedNames(dir, owners); err != nil {
+			return "", "", err
+		}
+	}
+
+	return filepath.Join(dir, saved.Dir), saved.Name, nil
+}
+
+// numberedName returns name with " (n)" added, before the extension of a file
+func numberedName(name string, n int, isFile bool) string {
+	ext := ""
+	if isFile {
+		ext = filepath.Ext(name)
+		if ext == name {
+			ext = "" // Dot files such as ".config"
+		}
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// loadSavedNames reads the owners of the names in dir, keyed by hex info hash
+func loadSavedNames(dir string) (map[string]savedName, error) {
+	owners := make(map[string]savedName)
+
+	data, err := os.ReadFile(filepath.Join(dir, savedNamesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return owners, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved names: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &owners); err != nil {
+		return nil, fmt.Errorf("failed to decode saved names: %w", err)
+	}
+
+	return owners, nil
+}
+
+// saveSavedNames writes the owners of the names in dir, replacing the file
+// atomically
+func saveSavedNames(dir string, owners map[string]savedName) error {
+	data, err := json.MarshalIndent(owners, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create save directory: %w", err)
+	}
+
+	path := filepath.Join(dir, savedNamesFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write saved names: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write saved names: %w", err)
+	}
+
+	return nil
+}
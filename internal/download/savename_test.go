@@ -0,0 +1,67 @@
+package download
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestResolveSaveName(t *testing.T) {
+	named := func(name string, hash byte, isDir bool) *torrent.TorrentFile {
+		return &torrent.TorrentFile{
+			Info:     torrent.InfoDict{Name: name, IsDirectory: isDir},
+			InfoHash: [20]byte{hash},
+		}
+	}
+
+	dir := t.TempDir()
+	first := named("movie.mkv", 1, false)
+	second := named("movie.mkv", 2, false)
+	third := named("movie.mkv", 3, false)
+
+	tests := []struct {
+		torrent  *torrent.TorrentFile
+		policy   NameCollisionPolicy
+		wantDir  string
+		wantName string
+	}{
+		{first, NameCollisionSuffix, dir, "movie.mkv"},
+		{second, NameCollisionSuffix, dir, "movie (2).mkv"},
+		{third, NameCollisionSubdir, filepath.Join(dir, "0300000000000000000000000000000000000000"), "movie.mkv"},
+		{named("movie.mkv", 4, true), NameCollisionSuffix, dir, "movie.mkv (2)"},
+		{named("movie.mkv", 5, false), NameCollisionShare, dir, "movie.mkv"},
+		// Torrents keep where they were saved the first time
+		{second, NameCollisionSubdir, dir, "movie (2).mkv"},
+		{first, NameCollisionSuffix, dir, "movie.mkv"},
+	}
+
+	for _, tt := range tests {
+		gotDir, gotName, err := ResolveSaveName(dir, tt.torrent, tt.policy)
+		if err != nil {
+			t.Fatalf("ResolveSaveName(%x) error = %v", tt.torrent.InfoHash[0], err)
+		}
+		if gotDir != tt.wantDir || gotName != tt.wantName {
+			t.Errorf("ResolveSaveName(%x) = %q, %q, want %q, %q", tt.torrent.InfoHash[0], gotDir, gotName, tt.wantDir, tt.wantName)
+		}
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name   string
+		isFile bool
+		want   string
+	}{
+		{"movie.mkv", true, "movie (3).mkv"},
+		{"archive.tar.gz", true, "archive.tar (3).gz"},
+		{".config", true, ".config (3)"},
+		{"album.v2", false, "album.v2 (3)"},
+	}
+
+	for _, tt := range tests {
+		if got := numberedName(tt.name, 3, tt.isFile); got != tt.want {
+			t.Errorf("numberedName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
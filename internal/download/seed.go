@@ -0,0 +1,203 @@
+package download
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// MaxRequestLength is the largest block we serve to a peer (128KB)
+const MaxRequestLength = 128 * 1024
+
+// OpenExistingStorage opens storage over data that already exists on disk,
+// for example data downloaded by another torrent with the same payload. It
+// refuses to touch files that are missing or have the wrong size so the
+// existing data is never truncated.
+func OpenExistingStorage(torrentFile *torrent.TorrentFile, basepath string) (*FileStorage, error) {
+	probe := &FileStorage{Torrent: torrentFile, BasePath: basepath}
+
+	numFiles := 1
+	if torrentFile.Info.IsDirectory {
+		numFiles = len(torrentFile.Info.Files)
+	}
+
+	for i := 0; i < numFiles; i++ {
+		expected := torrentFile.Info.Length
+		if torrentFile.Info.IsDirectory {
+			expected = torrentFile.Info.Files[i].Length
+		}
+
+		path := probe.filePath(i)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("existing data not found: %w", err)
+		}
+
+		if info.Size() != expected {
+			return nil, fmt.Errorf("existing file '%s' has size %d, expected %d", path, info.Size(), expected)
+		}
+	}
+
+	return NewFileStorage(torrentFile, basepath)
+}
+
+// checkExistingData hashes the data on disk and marks every piece that
+// matches the metainfo as complete. It returns the number of good pieces.
+func (dm *DownloadManager) checkExistingData() (int, error) {
+	dm.updateState("Checking")
+
+	badPieces, err := dm.VerifyData()
+	if err != nil {
+		return 0, err
+	}
+
+	dm.mu.Lock()
+	dm.badPieces = badPieces
+	dm.mu.Unlock()
+
+	bad := make(map[int]bool, len(badPieces))
+	for _, index := range badPieces {
+		bad[index] = true
+	}
+
+	good := 0
+	for i := 0; i < dm.Torrent.NumPieces(); i++ {
+		if bad[i] {
+			continue
+		}
+
+		if err := dm.PieceManager.MarkPieceHave(i); err != nil {
+			return good, err
+		}
+		good++
+	}
+
+	return good, nil
+}
+
+// sessionOpened prepares a new peer session: it announces the pieces we
+// have and starts serving the peer's requests
+func (dm *DownloadManager) sessionOpened(session *peer.Session) {
+	if dm.OnPeerConnected != nil {
+		dm.OnPeerConnected(session.GetAddr())
+	}
+
+	// Upload-only sessions never ask the peer for pieces
+	if dm.SeedOnly || dm.PieceManager.WantedComplete() {
+		session.SetInterested(false)
+	}
+
+	// Upload slots are handed out by the choker
+	if dm.UploadSlots > 0 {
+		session.SetUnchokeOnInterest(false)
+	}
+
+	// Peers can only connect back while we accept connections
+	if dm.listener != nil {
+		session.SetListenPort(dm.ListenPort())
+	}
+
+	// Multihomed peers tell us their other addresses
+	session.SetOnExtendedHandshake(func(*peer.ExtendedHandshake) {
+		dm.addListenAddrs(session)
+	})
+
+	session.SetLayout(peer.Layout{
+		NumPieces:   dm.Torrent.NumPieces(),
+		PieceLength: dm.Torrent.Info.PieceLength,
+		TotalLength: dm.Torrent.TotalLength(),
+	})
+
+	session.SetOnRequest(func(req *peer.Request) {
+		dm.handleRequest(session, req)
+	})
+
+	var err error
+	switch {
+	case session.SupportsFast() && dm.PieceManager.DownloadedCount() == 0:
+		err = session.SendHaveNone()
+	case session.SupportsFast() && dm.PieceManager.IsComplete():
+		err = session.SendHaveAll()
+	case dm.PieceManager.DownloadedCount() > 0:
+		err = session.SendBitfield(dm.PieceManager.Bitfield())
+	}
+	if err != nil {
+		fmt.Printf("Failed to send bitfield to %s: %v\n", session.GetAddr(), err)
+		return
+	}
+
+	// Steer the peer's requests towards pieces we already have in memory
+	dm.suggestPieces(session, dm.uploadCache.indexes()...)
+}
+
+// handleRequest serves a block request from a peer
+func (dm *DownloadManager) handleRequest(session *peer.Session, req *peer.Request) {
+	if !dm.PieceManager.HasPiece(req.Index) || dm.seedingStopped() || session.AmChoking() {
+		dm.rejectRequest(session, req)
+		return
+	}
+
+	pieceSize := int(dm.Torrent.PieceSize(req.Index))
+	if req.Length <= 0 || req.Length > MaxRequestLength || req.Begin < 0 || req.Begin+req.Length > pieceSize {
+		fmt.Printf("Ignoring invalid request from %s: piece %d, begin %d, length %d\n",
+			session.GetAddr(), req.Index, req.Begin, req.Length)
+		dm.rejectRequest(session, req)
+		return
+	}
+
+	data, err := dm.uploadPiece(req.Index)
+	if err != nil {
+		fmt.Printf("Error reading piece %d for upload: %v\n", req.Index, err)
+		dm.rejectRequest(session, req)
+		return
+	}
+
+	if err := session.SendPiece(req.Index, req.Begin, data[req.Begin:req.Begin+req.Length]); err != nil {
+		fmt.Printf("Error sending piece %d to %s: %v\n", req.Index, session.GetAddr(), err)
+		return
+	}
+
+	dm.mu.Lock()
+	dm.Stats.Uploaded += int64(req.Length)
+	dm.mu.Unlock()
+}
+
+// rejectRequest tells a Fast extension peer we won't serve its request,
+// which the extension requires instead of silently dropping it
+func (dm *DownloadManager) rejectRequest(session *peer.Session, req *peer.Request) {
+	if !session.SupportsFast() {
+		return
+	}
+
+	if err := session.RejectRequest(req); err != nil {
+		fmt.Printf("Failed to reject request from %s: %v\n", session.GetAddr(), err)
+	}
+}
+
+// seedingStopped reports whether a NoSeed download has completed, after
+// which we neither upload nor look for peers
+func (dm *DownloadManager) seedingStopped() bool {
+	return dm.NoSeed && dm.PieceManager.WantedComplete()
+}
+
+// stopSeeding drops every peer once a NoSeed download completes and tells
+// the tracker we have left the swarm
+func (dm *DownloadManager) stopSeeding() {
+	fmt.Printf("Download complete, disconnecting from peers (no seeding)\n")
+
+	if dm.listener != nil {
+		dm.listener.Close()
+	}
+
+	go func() {
+		dm.PeerPool.CloseAll()
+
+		dm.announceStopped(dm.ctx)
+
+		if dm.OnSeedingStopped != nil {
+			dm.OnSeedingStopped()
+		}
+	}()
+}
@@ -0,0 +1,220 @@
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// sessionPool hands out a single session, unchoked, and records the
+// connections it is asked for and whether it was closed
+type sessionPool struct {
+	fakePool
+	session *peer.Session
+
+	mu        sync.Mutex
+	connected int
+	closed    bool
+}
+
+func (p *sessionPool) GetUnchokedSessions() []*peer.Session {
+	if p.session == nil {
+		return nil
+	}
+	return []*peer.Session{p.session}
+}
+
+func (p *sessionPool) Connect(peers []tracker.Peer, maxConnections int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected += len(peers)
+	return 0
+}
+
+func (p *sessionPool) CloseAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// pipeSession opens a session for dm with a peer on the other end of a
+// pipe that has every piece and unchokes us. It returns the session and the
+// messages the peer receives.
+func pipeSession(t *testing.T, dm *DownloadManager) (*peer.Session, <-chan peer.MessageID) {
+	t.Helper()
+
+	ours, theirs := net.Pipe()
+	t.Cleanup(func() {
+		ours.Close()
+		theirs.Close()
+	})
+
+	handshake := make(chan error, 1)
+	go func() {
+		_, err := peer.DoHandshake(theirs, dm.Torrent.InfoHash, [20]byte{'p', 'e', 'e', 'r'})
+		handshake <- err
+	}()
+
+	session, err := peer.NewIncomingSession(ours, dm.Torrent.InfoHash, dm.PeerID)
+	if err != nil {
+		t.Fatalf("NewIncomingSession() error = %v", err)
+	}
+	if err := <-handshake; err != nil {
+		t.Fatalf("DoHandshake() error = %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	received := make(chan peer.MessageID, 100)
+	go func() {
+		for {
+			msg, err := peer.ReadMessage(theirs)
+			if err != nil {
+				return
+			}
+			if msg != nil {
+				received <- msg.ID
+			}
+		}
+	}()
+
+	dm.sessionOpened(session)
+	if err := session.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	go func() {
+		theirs.Write((&peer.Message{ID: peer.MsgBitfield, Payload: []byte{0xc0}}).Serialize())
+		theirs.Write((&peer.Message{ID: peer.MsgUnchoke}).Serialize())
+	}()
+	waitFor(t, func() bool { return !session.IsChoked() && session.HasPiece(1) })
+
+	return session, received
+}
+
+// sentRequest reports whether the peer receives a request within wait
+func sentRequest(received <-chan peer.MessageID, wait time.Duration) bool {
+	timeout := time.After(wait)
+	for {
+		select {
+		case id := <-received:
+			if id == peer.MsgRequest {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func TestSeedOnlyNeverRequests(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	for _, seedOnly := range []bool{false, true} {
+		dm := NewDownloadManager(torrentFile, [20]byte{'u', 's'}, t.TempDir(), 1)
+		dm.SeedOnly = seedOnly
+		dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+		dm.uploadCache = newUploadCache(0)
+		announcer := &fakeAnnouncer{}
+		dm.Tracker = announcer
+
+		session, received := pipeSession(t, dm)
+		dm.PeerPool = &sessionPool{session: session}
+
+		dm.managePieceDownloads()
+		if got := sentRequest(received, 200*time.Millisecond); got == seedOnly {
+			t.Errorf("SeedOnly = %v: requested blocks = %v, want %v", seedOnly, got, !seedOnly)
+		}
+
+		// An incomplete upload-only download tells the tracker it is paused
+		for i := 0; i < 2; i++ {
+			if _, err := dm.announce(context.Background(), dm.nextEvent()); err != nil {
+				t.Fatalf("announce() error = %v", err)
+			}
+		}
+		want := []string{"started", ""}
+		if seedOnly {
+			want = []string{"started", "paused"}
+		}
+		if !reflect.DeepEqual(announcer.events, want) {
+			t.Errorf("SeedOnly = %v: announced %q, want %q", seedOnly, announcer.events, want)
+		}
+	}
+}
+
+func TestNoSeedStopsOnceComplete(t *testing.T) {
+	data := []byte("abcdefgh")
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: [][20]byte{sha1.Sum(data[:4]), sha1.Sum(data[4:])},
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.NoSeed = true
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+	announcer := &fakeAnnouncer{peers: []tracker.Peer{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}}}
+	dm.Tracker = announcer
+	pool := &sessionPool{}
+	dm.PeerPool = pool
+
+	stopped := make(chan struct{})
+	dm.OnSeedingStopped = func() { close(stopped) }
+
+	source := newTrackerSource(dm)
+	source.announce(context.Background())
+
+	for i := 0; i < 2; i++ {
+		if err := dm.PieceManager.AddBlock(i, 0, data[i*4:i*4+4], "peer"); err != nil {
+			t.Fatalf("AddBlock() error = %v", err)
+		}
+		dm.mu.Lock()
+		dm.finishPiece(dm.PieceManager.Pieces[i])
+		dm.mu.Unlock()
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("seeding not stopped once the download completed")
+	}
+
+	pool.mu.Lock()
+	closed, connected := pool.closed, pool.connected
+	pool.mu.Unlock()
+	if !closed {
+		t.Error("peers not dropped once the download completed")
+	}
+
+	// The completion goes out with the stopped event and nothing follows
+	source.announce(context.Background())
+	dm.connectPeers(announcer.peers)
+
+	announcer.mu.Lock()
+	events := append([]string(nil), announcer.events...)
+	announcer.mu.Unlock()
+	if want := []string{"started", "completed", "stopped"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("announced %q, want %q", events, want)
+	}
+
+	pool.mu.Lock()
+	if pool.connected != connected {
+		t.Errorf("connected to %d more peers after seeding stopped", pool.connected-connected)
+	}
+	pool.mu.Unlock()
+
+	if !dm.seedingStopped() {
+		t.Error("seedingStopped() = false once complete")
+	}
+}
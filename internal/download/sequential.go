@@ -0,0 +1,92 @@
+package download
+
+import (
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// SequentialWindow controls piece selection in sequential mode. Only the
+// Size pieces from the first missing one are picked from, trading startup
+// latency for swarm health: Strict downloads them strictly in order, which
+// gets playback going soonest, while otherwise the rarest piece in the
+// window goes first so the swarm keeps rare pieces spread. Peers with
+// nothing in the window fall back to the pieces after it, and then to those
+// before the read position.
+type SequentialWindow struct {
+	Size   int  // Number of pieces picked from, at least 1
+	Strict bool // Pick in order instead of rarest first within the window
+}
+
+// DefaultSequentialWindow returns the window used unless configured otherwise
+func DefaultSequentialWindow() SequentialWindow {
+	return SequentialWindow{Size: 16}
+}
+
+// PickSequential selects the next piece in sequential mode for a peer with
+// the given bitfield. Rarity is counted over the bitfields of all peers.
+// The window starts at the first piece missing from start onwards.
+func (pm *PieceManager) PickSequential(bitfield peer.Bitfield, all []peer.Bitfield, start int, w SequentialWindow) *Piece {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if w.Size < 1 {
+		w.Size = 1
+	}
+
+	first := start
+	for first < len(pm.Pieces) && (pm.Downloaded[first] || !pm.wanted(first)) {
+		first++
+	}
+
+	now := time.Now()
+	pickable := func(i int) bool {
+		return !pm.Downloaded[i] && pm.wanted(i) && !pm.InProgress[i] && !pm.backingOff(i, now) && bitfield.HasPiece(i)
+	}
+
+	best := -1
+	if w.Strict {
+		for i := first; i < len(pm.Pieces) && i < first+w.Size; i++ {
+			if pickable(i) {
+				best = i
+				break
+			}
+		}
+	} else {
+		bestCount := 0
+		for i := first; i < len(pm.Pieces) && i < first+w.Size; i++ {
+			if !pickable(i) {
+				continue
+			}
+
+			count := 0
+			for _, bf := range all {
+				if bf.HasPiece(i) {
+					count++
+				}
+			}
+
+			// Ties go to the earlier piece
+			if best < 0 || count < bestCount {
+				best, bestCount = i, count
+			}
+		}
+	}
+
+	// Keep the peer busy with the closest piece past the window, then with
+	// the pieces before a read position. Nothing in the window is pickable,
+	// so it can be scanned again.
+	for n := 0; best < 0 && n < len(pm.Pieces); n++ {
+		if i := (first + n) % len(pm.Pieces); pickable(i) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return nil
+	}
+
+	pm.InProgress[best] = true
+	delete(pm.Missing, best)
+	return pm.Pieces[best]
+}
@@ -0,0 +1,67 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func newTestPieceManager(pieces int) *PieceManager {
+	return NewPieceManager(&torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: int64(4 * pieces)},
+		PiecesHash: make([][20]byte, pieces),
+	})
+}
+
+func TestPickSequential(t *testing.T) {
+	// Everyone has pieces 0-7, only we see piece 2 at a single peer
+	common := peer.Bitfield{0xdf}
+	ours := peer.Bitfield{0xff}
+	all := []peer.Bitfield{ours, common, common}
+
+	tests := []struct {
+		name   string
+		window SequentialWindow
+		start  int
+		want   []int
+	}{
+		{"strict", SequentialWindow{Size: 4, Strict: true}, 0, []int{0, 1, 2, 3, 4}},
+		{"rarest in window", SequentialWindow{Size: 4}, 0, []int{2, 0, 1, 3, 4}},
+		{"window of one", SequentialWindow{Size: 1}, 0, []int{0, 1, 2, 3}},
+		{"from the read position", SequentialWindow{Size: 2, Strict: true}, 5, []int{5, 6, 7, 0}},
+		{"window past the end", SequentialWindow{Size: 4, Strict: true}, 5, []int{5, 6, 7, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := newTestPieceManager(8)
+			for _, want := range tt.want {
+				piece := pm.PickSequential(ours, all, tt.start, tt.window)
+				if piece == nil || piece.Index != want {
+					t.Fatalf("PickSequential() = %v, want piece %d", piece, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPickSequentialSkipsDownloaded(t *testing.T) {
+	pm := newTestPieceManager(8)
+	pm.MarkPieceHave(0)
+	pm.MarkPieceHave(1)
+
+	// The window starts at the first missing piece, not at the start
+	bf := peer.Bitfield{0xff}
+	w := SequentialWindow{Size: 2, Strict: true}
+	for _, want := range []int{2, 3, 4} {
+		if piece := pm.PickSequential(bf, []peer.Bitfield{bf}, 0, w); piece == nil || piece.Index != want {
+			t.Fatalf("PickSequential() = %v, want piece %d", piece, want)
+		}
+	}
+
+	// Pieces the peer doesn't have are never picked
+	if piece := pm.PickSequential(peer.Bitfield{0xc0}, nil, 0, w); piece != nil {
+		t.Errorf("PickSequential() = piece %d the peer doesn't have", piece.Index)
+	}
+}
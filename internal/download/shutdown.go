@@ -0,0 +1,124 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// ErrShutdownForced is returned by Shutdown when its context ended before
+// the polite shutdown finished
+var ErrShutdownForced = errors.New("shutdown forced")
+
+// ShutdownStep is a step of Shutdown, reported to its progress callback
+type ShutdownStep int
+
+const (
+	ShutdownClosingPeers ShutdownStep = iota + 1 // Stopping the workers and closing the peer connections
+	ShutdownAnnouncing                           // Telling the tracker we left the swarm
+	ShutdownFlushing                             // Writing buffered pieces to disk and closing the files
+	ShutdownForced                               // The context ended, everything left is closed at once
+	ShutdownDone                                 // Everything is closed
+)
+
+func (s ShutdownStep) String() string {
+	switch s {
+	case ShutdownClosingPeers:
+		return "closing peer connections"
+	case ShutdownAnnouncing:
+		return "announcing to the tracker"
+	case ShutdownFlushing:
+		return "flushing data to disk"
+	case ShutdownForced:
+		return "forcing shutdown"
+	case ShutdownDone:
+		return "done"
+	default:
+		return fmt.Sprintf("ShutdownStep(%d)", int(s))
+	}
+}
+
+// Shutdown stops the download politely: it stops the workers, closes the
+// peer connections, tells the tracker we left the swarm, and flushes and
+// closes the files. When ctx ends first, e.g. because a tracker doesn't
+// answer, the stopped announces in flight are cancelled, whatever is left
+// is closed at once and ErrShutdownForced is returned; pieces already
+// being written to disk are still finished.
+// progress, when not nil, is called with each step in order, never
+// concurrently.
+func (dm *DownloadManager) Shutdown(ctx context.Context, progress func(step ShutdownStep)) error {
+	// Steps are reported under mu, and none after a final one: once
+	// forced, the steps the polite shutdown still runs are not reported
+	var mu sync.Mutex
+	var ended bool
+	report := func(step ShutdownStep, final bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ended {
+			return
+		}
+		ended = final
+		if progress != nil {
+			progress(step)
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		report(ShutdownClosingPeers, false)
+		if dm.cancel != nil {
+			dm.cancel()
+		}
+		// Closing the sessions ends their message loops and timers
+		dm.PeerPool.CloseAll()
+
+		report(ShutdownAnnouncing, false)
+		dm.announceStopped(ctx)
+
+		report(ShutdownFlushing, false)
+		dm.release()
+	}()
+
+	select {
+	case <-finished:
+		report(ShutdownDone, true)
+		return nil
+	case <-ctx.Done():
+	}
+
+	report(ShutdownForced, true)
+	if dm.cancel != nil {
+		dm.cancel()
+	}
+	dm.PeerPool.CloseAll()
+	dm.release()
+	if progress != nil {
+		progress(ShutdownDone)
+	}
+	return fmt.Errorf("%w: %w", ErrShutdownForced, ctx.Err())
+}
+
+// release closes the listener and the storage, flushing what is buffered,
+// and marks the download stopped. Only the first call does anything; a
+// forced shutdown waits for one already running.
+func (dm *DownloadManager) release() {
+	dm.releaseOnce.Do(func() {
+		if dm.listener != nil {
+			dm.listener.Close()
+		}
+
+		if dm.Storage != nil {
+			dm.Storage.Close()
+		}
+
+		peer.SetTorrentWeight(dm.Torrent.InfoHash, 0)
+		dm.updateState("Stopped")
+		dm.stats.close()
+	})
+}
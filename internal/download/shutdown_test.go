@@ -0,0 +1,100 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// stuckAnnouncer doesn't answer until released, or until the announce is
+// cancelled, which closes cancelled
+type stuckAnnouncer struct {
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func (a *stuckAnnouncer) Announce(ctx context.Context, trackerURL string, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+	select {
+	case <-a.release:
+		return &tracker.AnnounceResponse{}, nil
+	case <-ctx.Done():
+		close(a.cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	newJoined := func(announcer Announcer) (*DownloadManager, func(step ShutdownStep), *[]ShutdownStep) {
+		dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+		dm.Tracker = announcer
+		dm.PeerPool = &fakePool{}
+		dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+		dm.events.sent(torrentFile.Announce, "started", false)
+
+		var mu sync.Mutex
+		steps := &[]ShutdownStep{}
+		progress := func(step ShutdownStep) {
+			mu.Lock()
+			defer mu.Unlock()
+			*steps = append(*steps, step)
+		}
+		return dm, progress, steps
+	}
+
+	// A tracker that answers lets every step run
+	announcer := &fakeAnnouncer{}
+	dm, progress, steps := newJoined(announcer)
+	if err := dm.Shutdown(context.Background(), progress); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	want := []ShutdownStep{ShutdownClosingPeers, ShutdownAnnouncing, ShutdownFlushing, ShutdownDone}
+	if !reflect.DeepEqual(*steps, want) {
+		t.Errorf("steps = %v, want %v", *steps, want)
+	}
+	if len(announcer.events) != 1 || announcer.events[0] != "stopped" {
+		t.Errorf("announced %q, want stopped", announcer.events)
+	}
+	if state := dm.GetStats().State; state != "Stopped" {
+		t.Errorf("state = %q, want Stopped", state)
+	}
+
+	// A tracker that doesn't answer is given up on at the deadline
+	stuck := &stuckAnnouncer{release: make(chan struct{}), cancelled: make(chan struct{})}
+	defer close(stuck.release)
+	dm, progress, steps = newJoined(stuck)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := dm.Shutdown(ctx, progress)
+	if !errors.Is(err, ErrShutdownForced) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want ErrShutdownForced", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() took %v past its deadline", elapsed)
+	}
+	select {
+	case <-stuck.cancelled:
+	case <-time.After(time.Second):
+		t.Error("the stopped announce was not cancelled by the forced shutdown")
+	}
+	want = []ShutdownStep{ShutdownClosingPeers, ShutdownAnnouncing, ShutdownForced, ShutdownDone}
+	if !reflect.DeepEqual(*steps, want) {
+		t.Errorf("steps = %v, want %v", *steps, want)
+	}
+	if state := dm.GetStats().State; state != "Stopped" {
+		t.Errorf("state = %q after a forced shutdown, want Stopped", state)
+	}
+}
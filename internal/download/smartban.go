@@ -0,0 +1,135 @@
+package download
+
+import "time"
+
+// suspectTimeout is how long a suspect piece waits for a peer that didn't
+// contribute to the failed attempt before a contributor may fetch it alone
+const suspectTimeout = 2 * time.Minute
+
+// blockRecord remembers which peer supplied a block of a failed piece
+// and what the block hashed to
+type blockRecord struct {
+	Begin  int
+	Source string
+	Hash   [20]byte
+}
+
+// suspectPiece holds the evidence gathered from a failed piece attempt
+type suspectPiece struct {
+	blocks       []blockRecord
+	contributors map[string]bool
+	failedAt     time.Time // When the attempt failed
+}
+
+// hashFailureTracker identifies the peers responsible for pieces that fail
+// verification. When a failed piece was assembled from a single peer, that
+// peer is the offender. Otherwise the piece is re-downloaded from a single
+// peer that did not contribute to the failed attempt, and once it verifies
+// each recorded block is compared against the good copy to find the peers
+// that sent corrupt data.
+//
+// hashFailureTracker is not safe for concurrent use; the DownloadManager
+// only touches it while holding its mutex.
+type hashFailureTracker struct {
+	suspects map[int]*suspectPiece // pieceIndex -> evidence from the failed attempt
+}
+
+// newHashFailureTracker creates an empty tracker
+func newHashFailureTracker() *hashFailureTracker {
+	return &hashFailureTracker{
+		suspects: make(map[int]*suspectPiece),
+	}
+}
+
+// pieceFailed records a piece that failed verification at now and returns
+// the peers that can be blamed right away
+func (t *hashFailureTracker) pieceFailed(piece *Piece, now time.Time) []string {
+	piece.mu.RLock()
+	defer piece.mu.RUnlock()
+
+	suspect := &suspectPiece{contributors: make(map[string]bool), failedAt: now}
+	for _, block := range piece.Blocks {
+		if block.Data == nil {
+			continue
+		}
+
+		suspect.blocks = append(suspect.blocks, blockRecord{
+			Begin:  block.Begin,
+			Source: block.Source,
+			Hash:   hashSum(block.Data),
+		})
+		suspect.contributors[block.Source] = true
+	}
+
+	// A single contributor is necessarily the source of the bad data
+	if len(suspect.contributors) == 1 {
+		delete(t.suspects, piece.Index)
+		for source := range suspect.contributors {
+			return []string{source}
+		}
+	}
+
+	t.suspects[piece.Index] = suspect
+	return nil
+}
+
+// pieceVerified compares the blocks of a verified piece against the evidence
+// of an earlier failed attempt and returns the peers that sent corrupt blocks
+func (t *hashFailureTracker) pieceVerified(piece *Piece) []string {
+	suspect, ok := t.suspects[piece.Index]
+	if !ok {
+		return nil
+	}
+	delete(t.suspects, piece.Index)
+
+	piece.mu.RLock()
+	defer piece.mu.RUnlock()
+
+	good := make(map[int][20]byte, len(piece.Blocks))
+	for _, block := range piece.Blocks {
+		good[block.Begin] = hashSum(block.Data)
+	}
+
+	offenders := make(map[string]bool)
+	var result []string
+	for _, record := range suspect.blocks {
+		if good[record.Begin] == record.Hash || offenders[record.Source] {
+			continue
+		}
+
+		offenders[record.Source] = true
+		result = append(result, record.Source)
+	}
+
+	return result
+}
+
+// isSuspect reports whether a piece must be re-downloaded from a single peer
+func (t *hashFailureTracker) isSuspect(pieceIndex int) bool {
+	_, ok := t.suspects[pieceIndex]
+	return ok
+}
+
+// canServe reports whether addr may download the given piece at now.
+// Suspect pieces are only assigned to peers that did not contribute to the
+// failed attempt, unless none of holders, the peers that have the piece,
+// qualifies or suspectTimeout has passed since the failure. A contributor
+// then downloads the piece alone, so the download can't stall: if the
+// piece verifies, the blocks the other contributors sent are compared to
+// it, and if it fails again the contributor is blamed.
+func (t *hashFailureTracker) canServe(pieceIndex int, addr string, holders []string, now time.Time) bool {
+	suspect, ok := t.suspects[pieceIndex]
+	if !ok || !suspect.contributors[addr] {
+		return true
+	}
+	if now.Sub(suspect.failedAt) >= suspectTimeout {
+		return true
+	}
+
+	for _, holder := range holders {
+		if !suspect.contributors[holder] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// testPiece builds a piece of 4-byte blocks, block i holding data[i] and
+// sent by sources[i]
+func testPiece(index int, data []string, sources []string) *Piece {
+	piece := &Piece{Index: index}
+	for i := range data {
+		piece.Blocks = append(piece.Blocks, &Block{Index: i, Begin: i * 4, Length: 4, Data: []byte(data[i]), Source: sources[i]})
+	}
+	return piece
+}
+
+func TestHashFailureTrackerPieceFailed(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		sources []string
+		blamed  []string
+		suspect bool
+	}{
+		{"single contributor", []string{"a", "a", "a"}, []string{"a"}, false},
+		{"several contributors", []string{"a", "b", "a"}, nil, true},
+		{"missing blocks ignored", []string{"a", "", "b"}, nil, true},
+	}
+	for _, tt := range tests {
+		tracker := newHashFailureTracker()
+		piece := testPiece(7, []string{"bad!", "bad!", "bad!"}, tt.sources)
+		if tt.sources[1] == "" {
+			piece.Blocks[1].Data = nil
+		}
+
+		blamed := tracker.pieceFailed(piece, now)
+		if !reflect.DeepEqual(blamed, tt.blamed) {
+			t.Errorf("%s: pieceFailed() = %v, want %v", tt.name, blamed, tt.blamed)
+		}
+		if got := tracker.isSuspect(7); got != tt.suspect {
+			t.Errorf("%s: isSuspect() = %v, want %v", tt.name, got, tt.suspect)
+		}
+		if tracker.isSuspect(8) {
+			t.Errorf("%s: isSuspect() = true for another piece", tt.name)
+		}
+	}
+}
+
+func TestHashFailureTrackerPieceVerified(t *testing.T) {
+	tests := []struct {
+		name      string
+		failed    []string // Data of the failed attempt
+		sources   []string
+		offenders []string
+	}{
+		{"one corrupt block", []string{"good", "BAD!", "good"}, []string{"a", "b", "a"}, []string{"b"}},
+		{"corrupt blocks of two peers", []string{"BAD!", "BAD!", "good"}, []string{"a", "b", "c"}, []string{"a", "b"}},
+		{"one offender blamed once", []string{"BAD!", "good", "BAD!"}, []string{"a", "b", "a"}, []string{"a"}},
+		{"nothing differs", []string{"good", "good", "good"}, []string{"a", "b", "a"}, nil},
+	}
+	for _, tt := range tests {
+		tracker := newHashFailureTracker()
+		tracker.pieceFailed(testPiece(3, tt.failed, tt.sources), time.Now())
+
+		offenders := tracker.pieceVerified(testPiece(3, []string{"good", "good", "good"}, []string{"d", "d", "d"}))
+		sort.Strings(offenders)
+		if !reflect.DeepEqual(offenders, tt.offenders) {
+			t.Errorf("%s: pieceVerified() = %v, want %v", tt.name, offenders, tt.offenders)
+		}
+		if tracker.isSuspect(3) {
+			t.Errorf("%s: piece still suspect once verified", tt.name)
+		}
+	}
+
+	// Pieces that never failed blame nobody
+	if offenders := newHashFailureTracker().pieceVerified(testPiece(1, []string{"good"}, []string{"a"})); offenders != nil {
+		t.Errorf("pieceVerified() = %v for a piece that never failed, want none", offenders)
+	}
+}
+
+func TestHashFailureTrackerCanServe(t *testing.T) {
+	failed := time.Now()
+	tracker := newHashFailureTracker()
+	tracker.pieceFailed(testPiece(5, []string{"bad!", "bad!"}, []string{"a", "b"}), failed)
+
+	tests := []struct {
+		name    string
+		piece   int
+		addr    string
+		holders []string
+		now     time.Time
+		want    bool
+	}{
+		{"piece not suspect", 6, "a", []string{"a"}, failed, true},
+		{"peer didn't contribute", 5, "c", []string{"a", "c"}, failed, true},
+		{"contributor, another peer can serve", 5, "a", []string{"a", "c"}, failed, false},
+		{"contributor, only contributors have it", 5, "a", []string{"a", "b"}, failed, true},
+		{"contributor, no other holder", 5, "a", nil, failed, true},
+		{"contributor, timeout not over", 5, "a", []string{"a", "c"}, failed.Add(suspectTimeout - time.Second), false},
+		{"contributor, timeout over", 5, "a", []string{"a", "c"}, failed.Add(suspectTimeout), true},
+	}
+	for _, tt := range tests {
+		if got := tracker.canServe(tt.piece, tt.addr, tt.holders, tt.now); got != tt.want {
+			t.Errorf("%s: canServe() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// banningPool records the peers it is asked to ban
+type banningPool struct {
+	fakePool
+	banned []string
+}
+
+func (p *banningPool) Ban(addr string) { p.banned = append(p.banned, addr) }
+
+func TestFinishPieceBansAfterContributorFallback(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 2*BlockSize)
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: int64(len(data)), Name: "test.bin", Length: int64(len(data))},
+		PiecesHash: [][20]byte{sha1.Sum(data)},
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	pool := &banningPool{}
+	dm.PeerPool = pool
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+
+	// a sends a good block, b a corrupt one
+	dm.PieceManager.AddBlock(0, 0, data[:BlockSize], "a")
+	dm.PieceManager.AddBlock(0, BlockSize, bytes.Repeat([]byte("y"), BlockSize), "b")
+	dm.mu.Lock()
+	dm.finishPiece(dm.PieceManager.Pieces[0])
+	dm.mu.Unlock()
+	if !dm.hashFailures.isSuspect(0) || len(pool.banned) != 0 {
+		t.Fatalf("after the failure: suspect %v, banned %v, want suspect and nobody banned", dm.hashFailures.isSuspect(0), pool.banned)
+	}
+
+	// Only the contributors have the piece, so a fetches it alone
+	if !dm.hashFailures.canServe(0, "a", []string{"a", "b"}, time.Now()) {
+		t.Fatal("canServe() = false with only contributors holding the piece")
+	}
+	dm.PieceManager.AddBlock(0, 0, data[:BlockSize], "a")
+	dm.PieceManager.AddBlock(0, BlockSize, data[BlockSize:], "a")
+	dm.mu.Lock()
+	dm.finishPiece(dm.PieceManager.Pieces[0])
+	dm.mu.Unlock()
+
+	if !reflect.DeepEqual(pool.banned, []string{"b"}) || dm.hashFailures.isSuspect(0) {
+		t.Errorf("banned %v, suspect %v, want b banned and the piece cleared", pool.banned, dm.hashFailures.isSuspect(0))
+	}
+	if !dm.PieceManager.IsComplete() {
+		t.Error("download not complete")
+	}
+}
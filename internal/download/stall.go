@@ -0,0 +1,90 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTorrentUnavailable is reported when the connected peers lack pieces
+// we need and the download has made no progress for StallTimeout
+var ErrTorrentUnavailable = errors.New("torrent unavailable")
+
+// stallState tracks when the download last made progress
+type stallState struct {
+	completed  int       // Pieces completed when progress was last seen
+	progressAt time.Time // When progress was last seen
+	stalled    bool      // The download is in the stalled state
+}
+
+// unavailablePieces returns how many wanted pieces we lack that no
+// connected peer has. Pieces we hold count as available, so this is zero
+// exactly when the availability including our own copy is at least 1.0.
+func (dm *DownloadManager) unavailablePieces() int {
+	missing := 0
+	for i, n := range dm.PieceAvailability() {
+		if n == 0 && dm.PieceManager.wantedMissing(i) {
+			missing++
+		}
+	}
+	return missing
+}
+
+// wantedMissing returns true if a piece is wanted and not yet downloaded
+func (pm *PieceManager) wantedMissing(pieceIndex int) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.wanted(pieceIndex) && !pm.Downloaded[pieceIndex]
+}
+
+// checkStalled moves the download to the stalled state once pieces have
+// been missing from the swarm without any progress for StallTimeout, and
+// back out of it when progress resumes. It returns the error to report
+// when the download just stalled, or nil.
+func (dm *DownloadManager) checkStalled(now time.Time) error {
+	if dm.StallTimeout <= 0 || dm.SeedOnly || dm.PieceManager.WantedComplete() {
+		return nil
+	}
+
+	completed, _ := dm.PieceManager.WantedCount()
+	missing := dm.unavailablePieces()
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	s := &dm.stall
+	if s.progressAt.IsZero() || completed != s.completed || missing == 0 {
+		s.completed = completed
+		s.progressAt = now
+		if s.stalled {
+			fmt.Printf("Download is progressing again\n")
+			s.stalled = false
+			dm.setState("Downloading")
+		}
+		return nil
+	}
+
+	if s.stalled || now.Sub(s.progressAt) < dm.StallTimeout {
+		return nil
+	}
+
+	s.stalled = true
+	dm.setState("Stalled (missing pieces)")
+	return fmt.Errorf("%w: %d pieces are missing from the swarm and nothing was downloaded for %v",
+		ErrTorrentUnavailable, missing, dm.StallTimeout)
+}
+
+// handleStalled reports a stalled download and stops it if asked to
+func (dm *DownloadManager) handleStalled(err error) {
+	fmt.Printf("Download stalled: %v\n", err)
+
+	if dm.StopWhenStalled {
+		dm.Stop()
+		dm.updateState("Stalled (missing pieces)")
+	}
+
+	if dm.OnStalled != nil {
+		dm.OnStalled(err)
+	}
+}
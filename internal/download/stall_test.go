@@ -0,0 +1,53 @@
+package download
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestCheckStalled(t *testing.T) {
+	data := []byte("abcd")
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.PeerPool = &fakePool{} // No peers, so no piece is available
+	dm.StallTimeout = time.Minute
+
+	start := time.Now()
+	if err := dm.checkStalled(start); err != nil {
+		t.Fatalf("checkStalled() = %v at the start", err)
+	}
+	if err := dm.checkStalled(start.Add(30 * time.Second)); err != nil {
+		t.Fatalf("checkStalled() = %v before StallTimeout", err)
+	}
+
+	err := dm.checkStalled(start.Add(time.Minute))
+	if !errors.Is(err, ErrTorrentUnavailable) {
+		t.Fatalf("checkStalled() = %v after StallTimeout, want ErrTorrentUnavailable", err)
+	}
+	if state := dm.GetStats().State; state != "Stalled (missing pieces)" {
+		t.Errorf("State = %q, want stalled", state)
+	}
+
+	// Reported once, not on every check
+	if err := dm.checkStalled(start.Add(2 * time.Minute)); err != nil {
+		t.Errorf("checkStalled() = %v while already stalled", err)
+	}
+
+	// A completed piece is progress
+	dm.PieceManager.Pieces[0].Hash = hashSum(data)
+	dm.PieceManager.AddBlock(0, 0, data, "peer")
+	dm.PieceManager.MarkPieceCompleted(0)
+	if err := dm.checkStalled(start.Add(3 * time.Minute)); err != nil {
+		t.Errorf("checkStalled() = %v after progress", err)
+	}
+	if state := dm.GetStats().State; state != "Downloading" {
+		t.Errorf("State = %q after progress, want Downloading", state)
+	}
+}
@@ -0,0 +1,58 @@
+package download
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// starvationRounds is the number of piece rounds in a row in which an
+	// idle peer has nothing we need before more peers are asked for
+	starvationRounds = 10
+
+	// starvationRetry is the least time between two requests for more peers
+	starvationRetry = 2 * time.Minute
+
+	// starvationNumWant is the number of peers asked of the tracker while
+	// the connected peers lack the pieces we need
+	starvationNumWant = 200
+)
+
+// starvationState tracks how long the connected peers have had nothing to
+// offer us
+type starvationState struct {
+	rounds    int       // Consecutive rounds in which an idle peer had no piece for us
+	announced time.Time // When more peers were last asked for
+	wantPeers bool      // The next announce asks for starvationNumWant peers
+}
+
+// checkStarved is called after every piece round with whether an unchoked
+// peer had no piece for us. When that keeps happening because pieces we
+// need are missing from the connected peers, the tracker is announced to
+// right away asking for more peers instead of at the next interval. There
+// is no DHT to look up; other PeerSources keep running meanwhile. Callers
+// must hold dm.mu.
+func (dm *DownloadManager) checkStarved(idle bool, now time.Time) {
+	s := &dm.starvation
+	if !idle {
+		s.rounds = 0
+		return
+	}
+
+	s.rounds++
+	if s.rounds < starvationRounds || now.Sub(s.announced) < starvationRetry {
+		return
+	}
+
+	// Pieces that are only waiting to be retried don't need more peers
+	missing := dm.unavailablePieces()
+	if missing == 0 {
+		return
+	}
+
+	s.rounds = 0
+	s.announced = now
+	s.wantPeers = true
+	fmt.Printf("Connected peers lack %d pieces we need, asking for more peers\n", missing)
+	dm.requestAnnounce()
+}
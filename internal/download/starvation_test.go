@@ -0,0 +1,62 @@
+package download
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestCheckStarved(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://tracker.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &fakeAnnouncer{}
+	dm.Tracker = announcer
+	dm.PeerPool = &fakePool{} // No peers, so no piece is available
+
+	starved := func(now time.Time) {
+		for i := 0; i < starvationRounds; i++ {
+			dm.checkStarved(true, now)
+		}
+	}
+
+	// A round with something to download starts the count over
+	start := time.Now()
+	for i := 1; i < starvationRounds; i++ {
+		dm.checkStarved(true, start)
+	}
+	dm.checkStarved(false, start)
+	dm.checkStarved(true, start)
+	if len(dm.reannounce) != 0 {
+		t.Fatal("re-announce requested before starvationRounds rounds in a row")
+	}
+
+	starved(start)
+	if len(dm.reannounce) != 1 || !dm.starvation.wantPeers {
+		t.Fatal("no re-announce asking for more peers after starvationRounds rounds")
+	}
+
+	if _, err := dm.announce(context.Background(), ""); err != nil {
+		t.Fatalf("announce() error = %v", err)
+	}
+	if announcer.numWant != starvationNumWant || dm.starvation.wantPeers {
+		t.Errorf("announce asked for %d peers, want %d once", announcer.numWant, starvationNumWant)
+	}
+	<-dm.reannounce
+
+	// More peers are asked for at most every starvationRetry
+	starved(start.Add(time.Minute))
+	if len(dm.reannounce) != 0 {
+		t.Error("re-announce requested again before starvationRetry")
+	}
+	starved(start.Add(starvationRetry))
+	if len(dm.reannounce) != 1 {
+		t.Error("no re-announce after starvationRetry")
+	}
+}
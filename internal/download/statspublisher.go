@@ -0,0 +1,88 @@
+package download
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultStatsInterval is the default minimum time between stats callbacks
+const DefaultStatsInterval = 200 * time.Millisecond
+
+// statsPublisher delivers stats snapshots from its own goroutine, so a slow
+// consumer never blocks the code publishing them. Snapshots published while
+// the consumer is busy are coalesced into the latest one, and deliveries
+// are at least the minimum interval apart.
+type statsPublisher struct {
+	mu      sync.Mutex
+	latest  Stats
+	pending bool
+
+	notify    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newStatsPublisher creates a stats publisher
+func newStatsPublisher() *statsPublisher {
+	return &statsPublisher{
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// publish queues a snapshot, replacing any not yet delivered. It never blocks.
+func (p *statsPublisher) publish(stats Stats) {
+	p.mu.Lock()
+	p.latest = stats
+	p.pending = true
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending snapshot, if any
+func (p *statsPublisher) take() (Stats, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats, ok := p.latest, p.pending
+	p.pending = false
+	return stats, ok
+}
+
+// run delivers snapshots to deliver until the publisher is closed, then
+// delivers the last pending snapshot
+func (p *statsPublisher) run(minInterval time.Duration, deliver func(Stats)) {
+	var last time.Time
+	for {
+		select {
+		case <-p.closed:
+			if stats, ok := p.take(); ok {
+				deliver(stats)
+			}
+			return
+		case <-p.notify:
+		}
+
+		// Give later snapshots a chance to coalesce into this delivery
+		if wait := time.Until(last.Add(minInterval)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-p.closed:
+			}
+		}
+
+		if stats, ok := p.take(); ok {
+			deliver(stats)
+			last = time.Now()
+		}
+	}
+}
+
+// close stops the publisher after delivering the last pending snapshot
+func (p *statsPublisher) close() {
+	p.closeOnce.Do(func() { close(p.closed) })
+}
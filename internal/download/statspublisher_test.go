@@ -0,0 +1,62 @@
+package download
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsPublisherCoalesces(t *testing.T) {
+	p := newStatsPublisher()
+
+	var mu sync.Mutex
+	var delivered []Stats
+	finished := make(chan struct{})
+
+	go func() {
+		p.run(20*time.Millisecond, func(stats Stats) {
+			time.Sleep(5 * time.Millisecond) // slow consumer
+			mu.Lock()
+			delivered = append(delivered, stats)
+			mu.Unlock()
+		})
+		close(finished)
+	}()
+
+	// Publishing never waits for the consumer
+	start := time.Now()
+	for i := 1; i <= 1000; i++ {
+		p.publish(Stats{PiecesCompleted: i})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("publish blocked for %v", elapsed)
+	}
+
+	p.close()
+	<-finished
+
+	if len(delivered) == 0 || len(delivered) > 3 {
+		t.Fatalf("delivered %d snapshots, want 1 to 3", len(delivered))
+	}
+	if got := delivered[len(delivered)-1].PiecesCompleted; got != 1000 {
+		t.Errorf("last snapshot PiecesCompleted = %d, want 1000", got)
+	}
+}
+
+func TestStatsPublisherMinInterval(t *testing.T) {
+	p := newStatsPublisher()
+
+	times := make(chan time.Time, 10)
+	go p.run(50*time.Millisecond, func(Stats) { times <- time.Now() })
+	defer p.close()
+
+	p.publish(Stats{})
+	first := <-times
+
+	p.publish(Stats{})
+	second := <-times
+
+	if gap := second.Sub(first); gap < 50*time.Millisecond {
+		t.Errorf("deliveries %v apart, want at least 50ms", gap)
+	}
+}
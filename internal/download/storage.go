@@ -1,30 +1,61 @@
 package download
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/piyushgupta53/go-torrent/internal/torrent"
 )
 
+var (
+	ErrStorageUnavailable = errors.New("storage unavailable")
+)
+
 type FileStorage struct {
 	Torrent  *torrent.TorrentFile
 	BasePath string
 	Files    []*os.File
-	mu       sync.Mutex
+
+	// Name is the file or directory below BasePath the torrent is saved
+	// as; Info.Name unless set
+	Name string
+
+	// BufferLimit is the number of bytes of completed pieces BufferPiece
+	// holds in memory before flushing them to disk (0 disables buffering)
+	BufferLimit int
+
+	pending      map[int][][]byte // pieceIndex -> blocks waiting to be flushed
+	pendingBytes int
+	disk         diskCounters
+	mu           sync.Mutex
 }
 
 // NewFileStorage creates a new file storage handler
 func NewFileStorage(torrentFile *torrent.TorrentFile, basepath string) (*FileStorage, error) {
+	return NewFileStorageAs(torrentFile, basepath, "")
+}
+
+// NewFileStorageAs is NewFileStorage for a torrent saved under another
+// name than its Info.Name, such as one picked by ResolveSaveName; an empty
+// name keeps Info.Name
+func NewFileStorageAs(torrentFile *torrent.TorrentFile, basepath, name string) (*FileStorage, error) {
 	if basepath == "" {
 		basepath = "."
 	}
 
+	// Long path support needs an absolute path
+	if abs, err := filepath.Abs(basepath); err == nil {
+		basepath = abs
+	}
+
 	fs := &FileStorage{
 		Torrent:  torrentFile,
 		BasePath: basepath,
+		Name:     name,
 	}
 
 	// Create the target directory structure
@@ -44,7 +75,7 @@ func NewFileStorage(torrentFile *torrent.TorrentFile, basepath string) (*FileSto
 func (fs *FileStorage) createDirectories() error {
 	if fs.Torrent.Info.IsDirectory {
 		// Create the base directory
-		dirPath := filepath.Join(fs.BasePath, fs.Torrent.Info.Name)
+		dirPath := hostPathRules.join(fs.BasePath, fs.name())
 		if err := os.MkdirAll(dirPath, 0755); err != nil {
 			return fmt.Errorf("failed to create directory '%s': %w", dirPath, err)
 		}
@@ -56,7 +87,7 @@ func (fs *FileStorage) createDirectories() error {
 				continue
 			}
 
-			subPath := filepath.Join(append([]string{dirPath}, file.Path[:len(file.Path)-1]...)...)
+			subPath := hostPathRules.join(fs.BasePath, append([]string{fs.name()}, file.Path[:len(file.Path)-1]...)...)
 			if err := os.MkdirAll(subPath, 0755); err != nil {
 				return fmt.Errorf("failed to create directory '%s': %w", subPath, err)
 			}
@@ -77,10 +108,10 @@ func (fs *FileStorage) openFiles() error {
 		fs.Files = make([]*os.File, len(fs.Torrent.Info.Files))
 
 		for i, fileInfo := range fs.Torrent.Info.Files {
-			filePath := filepath.Join(append([]string{fs.BasePath, fs.Torrent.Info.Name}, fileInfo.Path...)...)
+			filePath := fs.filePath(i)
 
 			// Create the file (truncate if exists)
-			file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+			file, err := openFile(filePath, true)
 			if err != nil {
 				fs.closeFiles()
 				return fmt.Errorf("failed to open file '%s': %w", filePath, err)
@@ -99,10 +130,10 @@ func (fs *FileStorage) openFiles() error {
 		// Single-file mode
 		fs.Files = make([]*os.File, 1)
 
-		filePath := filepath.Join(fs.BasePath, fs.Torrent.Info.Name)
+		filePath := fs.filePath(0)
 
 		// Open file
-		file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+		file, err := openFile(filePath, true)
 		if err != nil {
 			file.Close()
 			return fmt.Errorf("failed to set file size for '%s': %w", filePath, err)
@@ -114,6 +145,68 @@ func (fs *FileStorage) openFiles() error {
 	return nil
 }
 
+// name returns the file or directory the torrent is saved as
+func (fs *FileStorage) name() string {
+	if fs.Name != "" {
+		return fs.Name
+	}
+	return fs.Torrent.Info.Name
+}
+
+// filePath returns the path on disk of the file at index i
+func (fs *FileStorage) filePath(i int) string {
+	if !fs.Torrent.Info.IsDirectory {
+		return hostPathRules.join(fs.BasePath, fs.name())
+	}
+
+	return hostPathRules.join(fs.BasePath, append([]string{fs.name()}, fs.Torrent.Info.Files[i].Path...)...)
+}
+
+// reopenFile closes and reopens the file at index i, recovering from
+// handles invalidated by NFS timeouts or remounted disks. A file that has
+// gone missing is not recreated, since its downloaded data is lost.
+func (fs *FileStorage) reopenFile(i int) error {
+	if fs.Files[i] != nil {
+		fs.Files[i].Close()
+		fs.Files[i] = nil
+	}
+
+	path := fs.filePath(i)
+	file, err := openFile(path, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: file '%s' is missing", ErrStorageUnavailable, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed to reopen file '%s': %v", ErrStorageUnavailable, path, err)
+	}
+
+	fs.Files[i] = file
+	return nil
+}
+
+// writeAt writes data to the file at index i, reopening the file and
+// retrying once if the write fails; callers must hold fs.mu
+func (fs *FileStorage) writeAt(i int, data []byte, offset int64) error {
+	start := time.Now()
+	defer func() { fs.disk.countWrite(len(data), time.Since(start)) }()
+
+	if fs.Files[i] != nil {
+		if _, err := fs.Files[i].WriteAt(data, offset); err == nil {
+			return nil
+		}
+	}
+
+	if err := fs.reopenFile(i); err != nil {
+		return err
+	}
+
+	if _, err := fs.Files[i].WriteAt(data, offset); err != nil {
+		return fmt.Errorf("%w: failed to write to file %d: %v", ErrStorageUnavailable, i, err)
+	}
+
+	return nil
+}
+
 func (fs *FileStorage) closeFiles() {
 	for i, file := range fs.Files {
 		if file != nil {
@@ -123,66 +216,187 @@ func (fs *FileStorage) closeFiles() {
 	}
 }
 
+// fileSpan describes the part of a file covered by a range of torrent data
+type fileSpan struct {
+	FileIndex  int   // Index into Files
+	FileOffset int64 // Offset within the file
+	DataOffset int   // Offset within the data range
+	Length     int   // Number of bytes in the span
+}
+
+// spans maps length bytes starting at the given torrent offset to the files that hold them
+func (fs *FileStorage) spans(offset int64, length int) []fileSpan {
+	return fileSpans(fs.Torrent, offset, length)
+}
+
+// fileSpans maps length bytes starting at the given torrent offset to the
+// files of a torrent that hold them
+func fileSpans(t *torrent.TorrentFile, offset int64, length int) []fileSpan {
+	// Handle the single file case
+	if !t.Info.IsDirectory {
+		return []fileSpan{{FileIndex: 0, FileOffset: offset, DataOffset: 0, Length: length}}
+	}
+
+	// Handle the multi-file case
+	var result []fileSpan
+	var fileOffset int64
+	end := offset + int64(length)
+
+	for i, fileInfo := range t.Info.Files {
+		fileEnd := fileOffset + fileInfo.Length
+
+		// Calculate overlap between the range and the file
+		overlapStart := max(offset, fileOffset)
+		overlapEnd := min(end, fileEnd)
+
+		if overlapEnd > overlapStart {
+			result = append(result, fileSpan{
+				FileIndex:  i,
+				FileOffset: overlapStart - fileOffset,
+				DataOffset: int(overlapStart - offset),
+				Length:     int(overlapEnd - overlapStart),
+			})
+		}
+
+		if fileEnd >= end {
+			break
+		}
+
+		fileOffset = fileEnd
+	}
+
+	return result
+}
+
 // WritePiece writes a piece to the appropriate files
 func (fs *FileStorage) WritePiece(pieceIndex int, data []byte) error {
+	return fs.WriteBlocks(pieceIndex, [][]byte{data})
+}
+
+// WriteBlocks writes a piece given as its consecutive blocks, such as the
+// block buffers of a Piece, straight to their offsets without assembling
+// them into one buffer first
+func (fs *FileStorage) WriteBlocks(pieceIndex int, blocks [][]byte) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Calculate the piece offset in the overall torrent data
-	pieceOffset := int64(pieceIndex) * fs.Torrent.Info.PieceLength
+	return fs.writeBlocks(int64(pieceIndex)*fs.Torrent.Info.PieceLength, blocks)
+}
 
-	// Handle the single file case
-	if !fs.Torrent.Info.IsDirectory {
-		_, err := fs.Files[0].WriteAt(data, pieceOffset)
-		return err
+// writeBlocks writes consecutive buffers starting at an offset in the
+// overall torrent data; callers must hold fs.mu
+func (fs *FileStorage) writeBlocks(offset int64, blocks [][]byte) error {
+	for _, data := range blocks {
+		for _, span := range fs.spans(offset, len(data)) {
+			if err := fs.writeAt(span.FileIndex, data[span.DataOffset:span.DataOffset+span.Length], span.FileOffset); err != nil {
+				return err
+			}
+		}
+		offset += int64(len(data))
 	}
 
-	// Handle the multi-file case
-	var bytesWritten int
-	var fileOffset int64
+	return nil
+}
 
-	for i, fileInfo := range fs.Torrent.Info.Files {
-		// Check if this file contains part of the piece
-		if pieceOffset >= fileOffset && pieceOffset < fileOffset+fileInfo.Length || fileOffset >= pieceOffset && fileOffset < pieceOffset+int64(len(data)) {
+// blocksLen returns the total length of a piece's blocks
+func blocksLen(blocks [][]byte) int {
+	n := 0
+	for _, data := range blocks {
+		n += len(data)
+	}
+	return n
+}
 
-			// Calculate overlap between piece and file
-			overlapStart := max(pieceOffset, fileOffset)
-			overlapEnd := min(pieceOffset+int64(len(data)), fileOffset+fileInfo.Length)
-			overlapSize := overlapEnd - overlapStart
+// ReadPiece reads a piece of the given length back from the files
+func (fs *FileStorage) ReadPiece(pieceIndex int, length int) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-			if overlapSize < 0 {
-				continue
-			}
+	// Serve pieces that have not been flushed yet from memory
+	if buffered, ok := fs.pending[pieceIndex]; ok && blocksLen(buffered) == length {
+		data := make([]byte, 0, length)
+		for _, block := range buffered {
+			data = append(data, block...)
+		}
+		return data, nil
+	}
 
-			// Calculate offsets for writing
-			fileWriteOffset := overlapStart - fileOffset
-			pieceReadOffset := int(overlapStart - pieceOffset)
+	pieceOffset := int64(pieceIndex) * fs.Torrent.Info.PieceLength
+	data := make([]byte, length)
 
-			// Write the data
-			_, err := fs.Files[i].WriteAt(data[pieceReadOffset:pieceReadOffset+int(overlapSize)], fileWriteOffset)
-			if err != nil {
-				return fmt.Errorf("failed to write to file %d: %w", i, err)
-			}
+	for _, span := range fs.spans(pieceOffset, length) {
+		_, err := fs.Files[span.FileIndex].ReadAt(data[span.DataOffset:span.DataOffset+span.Length], span.FileOffset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from file %d: %w", span.FileIndex, err)
+		}
+	}
 
-			bytesWritten += int(overlapSize)
+	return data, nil
+}
 
-			if bytesWritten >= len(data) {
-				break
-			}
+// CheckFileSizes verifies that every file on disk has the size listed in the metainfo
+func (fs *FileStorage) CheckFileSizes() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	expected := fs.fileLengths()
+	for i, file := range fs.Files {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file %d: %w", i, err)
 		}
 
-		fileOffset += fileInfo.Length
+		if info.Size() != expected[i] {
+			return fmt.Errorf("file '%s' has size %d, expected %d", file.Name(), info.Size(), expected[i])
+		}
 	}
 
 	return nil
 }
 
-// Close closes all open files and cleans up resources
+// ResizeFiles gives every file the size the torrent expects, cutting off
+// extra data and extending short files with zeros, so data that was
+// truncated or appended to can be checked and repaired in place
+func (fs *FileStorage) ResizeFiles() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	expected := fs.fileLengths()
+	for i, file := range fs.Files {
+		if err := file.Truncate(expected[i]); err != nil {
+			return fmt.Errorf("failed to set file size for '%s': %w", file.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// fileLengths returns the size of each file in the torrent
+func (fs *FileStorage) fileLengths() []int64 {
+	if !fs.Torrent.Info.IsDirectory {
+		return []int64{fs.Torrent.Info.Length}
+	}
+
+	lengths := make([]int64, len(fs.Torrent.Info.Files))
+	for i, fileInfo := range fs.Torrent.Info.Files {
+		lengths[i] = fileInfo.Length
+	}
+	return lengths
+}
+
+// Close flushes buffered pieces to stable storage, closes all open files
+// and cleans up resources
 func (fs *FileStorage) Close() error {
+	err := fs.Flush()
+	if err == nil {
+		err = fs.Sync()
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	fs.closeFiles()
-	return nil
+	return err
 }
 
 // Helper functions
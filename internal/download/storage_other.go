@@ -0,0 +1,15 @@
+//go:build !windows
+
+package download
+
+import "os"
+
+// openFile opens a torrent file for reading and writing, creating it if
+// create is set
+func openFile(path string, create bool) (*os.File, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	return os.OpenFile(path, flag, 0644)
+}
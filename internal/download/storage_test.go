@@ -0,0 +1,228 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestFileStorageReadWritePiece(t *testing.T) {
+	// Two pieces of 8 bytes spread over three files, the second piece
+	// spanning a file boundary
+	torrentFile := &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: 8,
+			Name:        "test_dir",
+			IsDirectory: true,
+			Files: []torrent.FileDict{
+				{Length: 5, Path: []string{"a.txt"}},
+				{Length: 6, Path: []string{"sub", "b.txt"}},
+				{Length: 4, Path: []string{"c.txt"}},
+			},
+		},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	pieces := [][]byte{
+		[]byte("01234567"),
+		[]byte("89abcde"),
+	}
+
+	for i, data := range pieces {
+		if err := fs.WritePiece(i, data); err != nil {
+			t.Fatalf("WritePiece(%d) error = %v", i, err)
+		}
+	}
+
+	for i, want := range pieces {
+		got, err := fs.ReadPiece(i, len(want))
+		if err != nil {
+			t.Fatalf("ReadPiece(%d) error = %v", i, err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("ReadPiece(%d) = %q, want %q", i, got, want)
+		}
+	}
+
+	if err := fs.CheckFileSizes(); err != nil {
+		t.Errorf("CheckFileSizes() error = %v", err)
+	}
+}
+
+func TestFileStorageWriteBlocks(t *testing.T) {
+	// Blocks of 3 bytes straddling the boundaries of files of 5 and 7 bytes
+	torrentFile := &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: 12,
+			Name:        "test_dir",
+			IsDirectory: true,
+			Files: []torrent.FileDict{
+				{Length: 5, Path: []string{"a.txt"}},
+				{Length: 7, Path: []string{"b.txt"}},
+			},
+		},
+		PiecesHash: make([][20]byte, 1),
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	blocks := [][]byte{[]byte("012"), []byte("345"), []byte("678"), []byte("9ab")}
+	if err := fs.WriteBlocks(0, blocks); err != nil {
+		t.Fatalf("WriteBlocks() error = %v", err)
+	}
+
+	got, err := fs.ReadPiece(0, 12)
+	if err != nil || string(got) != "0123456789ab" {
+		t.Errorf("ReadPiece(0) = %q, %v, want %q", got, err, "0123456789ab")
+	}
+}
+
+func TestFileStorageBufferPiece(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info: torrent.InfoDict{
+			PieceLength: 4,
+			Name:        "test.bin",
+			Length:      12,
+		},
+		PiecesHash: make([][20]byte, 3),
+	}
+
+	fs, err := NewFileStorage(torrentFile, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	fs.BufferLimit = 12
+
+	// Out of order pieces stay buffered until the limit is reached
+	for _, index := range []int{2, 0} {
+		if err := fs.BufferPiece(index, bytes.Repeat([]byte{byte('a' + index)}, 4)); err != nil {
+			t.Fatalf("BufferPiece(%d) error = %v", index, err)
+		}
+	}
+
+	if len(fs.pending) != 2 {
+		t.Fatalf("pending pieces = %d, want 2", len(fs.pending))
+	}
+
+	if disk := fs.DiskStats(); disk.QueuedPieces != 2 || disk.QueuedBytes != 8 || disk.BytesWritten != 0 {
+		t.Errorf("DiskStats() = %+v, want 2 pieces (8 bytes) queued and nothing written", disk)
+	}
+
+	// Buffered pieces are readable before they are flushed
+	got, err := fs.ReadPiece(2, 4)
+	if err != nil || !bytes.Equal(got, []byte("cccc")) {
+		t.Errorf("ReadPiece(2) = %q, %v, want %q", got, err, "cccc")
+	}
+
+	if err := fs.BufferPiece(1, []byte("bbbb")); err != nil {
+		t.Fatalf("BufferPiece(1) error = %v", err)
+	}
+
+	if len(fs.pending) != 0 || fs.pendingBytes != 0 {
+		t.Fatalf("buffer not flushed: %d pieces, %d bytes", len(fs.pending), fs.pendingBytes)
+	}
+
+	// The three consecutive pieces went out as one write
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if disk := fs.DiskStats(); disk.QueuedPieces != 0 || disk.Writes != 1 || disk.BytesWritten != 12 || disk.Syncs != 1 {
+		t.Errorf("DiskStats() = %+v, want one 12 byte write, one sync and nothing queued", disk)
+	}
+
+	for i, want := range []string{"aaaa", "bbbb", "cccc"} {
+		got, err := fs.ReadPiece(i, 4)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadPiece(%d) = %q, %v, want %q", i, got, err, want)
+		}
+	}
+}
+
+func TestFileStorageResizeFiles(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 12},
+		PiecesHash: make([][20]byte, 3),
+	}
+
+	// A truncated file keeps what it has and reads zeros after it
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.bin"), []byte("abcdef"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewFileStorage(torrentFile, dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.CheckFileSizes(); err == nil {
+		t.Fatal("CheckFileSizes() succeeded on a truncated file")
+	}
+
+	if err := fs.ResizeFiles(); err != nil {
+		t.Fatalf("ResizeFiles() error = %v", err)
+	}
+	if err := fs.CheckFileSizes(); err != nil {
+		t.Errorf("CheckFileSizes() error = %v after ResizeFiles", err)
+	}
+
+	data, err := fs.ReadPiece(1, 4)
+	if err != nil || !bytes.Equal(data, []byte("ef\x00\x00")) {
+		t.Errorf("ReadPiece(1) = %q, %v, want the kept data padded with zeros", data, err)
+	}
+}
+
+func TestFileStorageWriteReopensFile(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dir := t.TempDir()
+	fs, err := NewFileStorage(torrentFile, dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer fs.Close()
+
+	// A stale handle is reopened and the write retried
+	fs.Files[0].Close()
+	if err := fs.WritePiece(1, []byte("wxyz")); err != nil {
+		t.Fatalf("WritePiece() error = %v with a stale handle", err)
+	}
+	data, err := fs.ReadPiece(1, 4)
+	if err != nil || !bytes.Equal(data, []byte("wxyz")) {
+		t.Errorf("ReadPiece(1) = %q, %v, want the retried write", data, err)
+	}
+
+	// A file that vanished is reported, not recreated empty
+	path := filepath.Join(dir, "test.bin")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	fs.Files[0].Close()
+	if err := fs.WritePiece(0, []byte("abcd")); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("WritePiece() error = %v for a missing file, want ErrStorageUnavailable", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("missing file recreated by the retry (stat error %v)", err)
+	}
+}
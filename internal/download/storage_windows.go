@@ -0,0 +1,39 @@
+//go:build windows
+
+package download
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFile opens a torrent file for reading and writing, creating it if
+// create is set. Unlike os.OpenFile it allows other processes to read, write and
+// delete the file while it is open, so media players and virus scanners
+// can access partially downloaded files.
+func openFile(path string, create bool) (*os.File, error) {
+	disposition := uint32(syscall.OPEN_EXISTING)
+	if create {
+		disposition = syscall.OPEN_ALWAYS
+	}
+
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	handle, err := syscall.CreateFile(
+		pathp,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil,
+		disposition,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,133 @@
+package download
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// DefaultUploadCacheSize is the default memory used to cache pieces being
+// uploaded (16MB)
+const DefaultUploadCacheSize = 16 * 1024 * 1024
+
+// uploadCache keeps the pieces we recently uploaded in memory, so the
+// requests for the other blocks of a piece, from the same or other peers,
+// don't each read the piece from disk. The least recently used pieces are
+// evicted first.
+type uploadCache struct {
+	capacity int
+	size     int
+	pieces   map[int][]byte
+	order    []int // Least recently used first
+	mu       sync.Mutex
+}
+
+// newUploadCache creates an upload cache holding up to capacity bytes
+func newUploadCache(capacity int) *uploadCache {
+	return &uploadCache{
+		capacity: capacity,
+		pieces:   make(map[int][]byte),
+	}
+}
+
+// get returns a cached piece and marks it as recently used
+func (c *uploadCache) get(index int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.pieces[index]
+	if ok {
+		c.touch(index)
+	}
+	return data, ok
+}
+
+// put caches a piece, evicting the least recently used pieces to make room
+func (c *uploadCache) put(index int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(data) > c.capacity {
+		return
+	}
+
+	if old, ok := c.pieces[index]; ok {
+		c.size -= len(old)
+		c.touch(index)
+	} else {
+		c.order = append(c.order, index)
+	}
+	c.pieces[index] = data
+	c.size += len(data)
+
+	for c.size > c.capacity {
+		evicted := c.order[0]
+		c.order = c.order[1:]
+		c.size -= len(c.pieces[evicted])
+		delete(c.pieces, evicted)
+	}
+}
+
+// indexes returns the cached pieces
+func (c *uploadCache) indexes() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.order...)
+}
+
+// touch moves a piece to the most recently used end; c.mu must be held
+func (c *uploadCache) touch(index int) {
+	for i, cached := range c.order {
+		if cached == index {
+			c.order = append(append(c.order[:i:i], c.order[i+1:]...), index)
+			return
+		}
+	}
+}
+
+// uploadPiece returns the data of a piece we serve, from the upload cache
+// when possible. A piece read from disk is suggested to the peers that lack
+// it, so their requests go to pieces already in memory.
+func (dm *DownloadManager) uploadPiece(index int) ([]byte, error) {
+	if data, ok := dm.uploadCache.get(index); ok {
+		return data, nil
+	}
+
+	data, err := dm.Storage.ReadPiece(index, int(dm.Torrent.PieceSize(index)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resumed pieces are checked before the first upload
+	if !dm.checkTrusted(index, data) {
+		return nil, fmt.Errorf("%w: piece %d", ErrVerificationFailed, index)
+	}
+
+	dm.uploadCache.put(index, data)
+
+	for _, session := range dm.PeerPool.GetSessionsWithoutPiece(index) {
+		dm.suggestPieces(session, index)
+	}
+
+	return data, nil
+}
+
+// suggestPieces sends Suggest Piece messages to a Fast extension peer for
+// the given pieces that it lacks
+func (dm *DownloadManager) suggestPieces(session *peer.Session, indexes ...int) {
+	if !session.SupportsFast() {
+		return
+	}
+
+	for _, index := range indexes {
+		if session.HasPiece(index) {
+			continue
+		}
+
+		if err := session.SuggestPiece(index); err != nil {
+			fmt.Printf("Failed to suggest piece %d to %s: %v\n", index, session.GetAddr(), err)
+			return
+		}
+	}
+}
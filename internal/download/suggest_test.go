@@ -0,0 +1,43 @@
+package download
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUploadCacheEviction(t *testing.T) {
+	cache := newUploadCache(30)
+
+	cache.put(0, make([]byte, 10))
+	cache.put(1, make([]byte, 10))
+	cache.put(2, make([]byte, 10))
+
+	// Using piece 0 makes piece 1 the least recently used
+	if _, ok := cache.get(0); !ok {
+		t.Fatal("get(0) missed")
+	}
+
+	cache.put(3, make([]byte, 10))
+
+	if _, ok := cache.get(1); ok {
+		t.Error("get(1) hit, want least recently used piece evicted")
+	}
+	if got, want := cache.indexes(), []int{2, 0, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexes() = %v, want %v", got, want)
+	}
+
+	// Pieces larger than the cache are never cached
+	cache.put(4, make([]byte, 31))
+	if _, ok := cache.get(4); ok {
+		t.Error("get(4) hit for a piece larger than the cache")
+	}
+}
+
+func TestUploadCacheDisabled(t *testing.T) {
+	cache := newUploadCache(0)
+	cache.put(0, make([]byte, 10))
+
+	if _, ok := cache.get(0); ok {
+		t.Error("get(0) hit on a disabled cache")
+	}
+}
@@ -0,0 +1,129 @@
+package download
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+// TrackerBackoff is how long a tracker asked us to wait between announces
+// and how its last announces went. It is saved with the session so that a
+// restart doesn't announce again before the tracker allows: not within its
+// minimum interval of the last announce, and not before the retry delay
+// after failed announces is over.
+type TrackerBackoff struct {
+	URL          string
+	LastAnnounce time.Time     // When the last announce was sent
+	Interval     time.Duration // The tracker's regular interval, 0 until it sent one
+	MinInterval  time.Duration // The tracker's minimum interval, 0 until it sent one
+	Failures     int           // Consecutive failed announces
+}
+
+// wait returns how long after now the tracker may be announced to again
+func (b TrackerBackoff) wait(now time.Time) time.Duration {
+	delay := b.MinInterval
+	if b.Failures > 0 {
+		interval := b.Interval
+		if interval == 0 {
+			interval = defaultTrackerInterval
+		}
+		delay = retryDelay(b.Failures, interval, b.MinInterval)
+	}
+
+	if wait := b.LastAnnounce.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// trackerBackoffs records the backoff of every tracker announced to
+type trackerBackoffs struct {
+	mu       sync.Mutex
+	trackers map[string]*TrackerBackoff
+}
+
+// newTrackerBackoffs creates an empty record
+func newTrackerBackoffs() *trackerBackoffs {
+	return &trackerBackoffs{trackers: make(map[string]*TrackerBackoff)}
+}
+
+// tracker returns the backoff of a tracker; callers must hold b.mu
+func (b *trackerBackoffs) tracker(url string) *TrackerBackoff {
+	t, ok := b.trackers[url]
+	if !ok {
+		t = &TrackerBackoff{URL: url}
+		b.trackers[url] = t
+	}
+	return t
+}
+
+// succeeded records an announce sent at sent that the tracker answered
+// with resp
+func (b *trackerBackoffs) succeeded(url string, sent time.Time, resp *tracker.AnnounceResponse) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.tracker(url)
+	t.LastAnnounce = sent
+	t.Failures = 0
+	if resp.Interval > 0 {
+		t.Interval = time.Duration(resp.Interval) * time.Second
+	}
+	if resp.MinInterval > 0 {
+		t.MinInterval = time.Duration(resp.MinInterval) * time.Second
+	}
+}
+
+// failed records an announce sent at sent that failed
+func (b *trackerBackoffs) failed(url string, sent time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.tracker(url)
+	t.LastAnnounce = sent
+	t.Failures++
+}
+
+// get returns the backoff of a tracker, if it was announced to or restored
+func (b *trackerBackoffs) get(url string) (TrackerBackoff, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.trackers[url]
+	if !ok {
+		return TrackerBackoff{}, false
+	}
+	return *t, true
+}
+
+// TrackerBackoffs returns the backoff of every tracker announced to, in
+// order of URL, to be saved with the session
+func (dm *DownloadManager) TrackerBackoffs() []TrackerBackoff {
+	dm.backoffs.mu.Lock()
+	defer dm.backoffs.mu.Unlock()
+
+	backoffs := make([]TrackerBackoff, 0, len(dm.backoffs.trackers))
+	for _, t := range dm.backoffs.trackers {
+		backoffs = append(backoffs, *t)
+	}
+	sort.Slice(backoffs, func(i, j int) bool { return backoffs[i].URL < backoffs[j].URL })
+	return backoffs
+}
+
+// RestoreTrackerBackoffs loads the backoffs saved by an earlier run, before
+// Start. The first announce then waits until the tracker allows it and
+// failed announces keep backing off where they left off.
+func (dm *DownloadManager) RestoreTrackerBackoffs(backoffs []TrackerBackoff) {
+	dm.backoffs.mu.Lock()
+	defer dm.backoffs.mu.Unlock()
+
+	for _, b := range backoffs {
+		if b.URL == "" {
+			continue
+		}
+		t := b
+		dm.backoffs.trackers[b.URL] = &t
+	}
+}
@@ -0,0 +1,100 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
+)
+
+func TestTrackerBackoffWait(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		backoff TrackerBackoff
+		want    time.Duration
+	}{
+		{"never announced", TrackerBackoff{}, 0},
+		{"within the minimum interval", TrackerBackoff{LastAnnounce: now.Add(-10 * time.Minute), MinInterval: 30 * time.Minute}, 20 * time.Minute},
+		{"minimum interval over", TrackerBackoff{LastAnnounce: now.Add(-time.Hour), MinInterval: 30 * time.Minute}, 0},
+		{"no minimum interval", TrackerBackoff{LastAnnounce: now, Interval: 30 * time.Minute}, 0},
+		{"after failures", TrackerBackoff{LastAnnounce: now, Interval: 30 * time.Minute, Failures: 3}, 4 * noPeersRetry},
+		{"failures up to the interval", TrackerBackoff{LastAnnounce: now, Interval: 30 * time.Minute, Failures: 20}, 30 * time.Minute},
+		{"failures within the minimum interval", TrackerBackoff{LastAnnounce: now, MinInterval: 10 * time.Minute, Failures: 1}, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := tt.backoff.wait(now); got != tt.want {
+			t.Errorf("%s: wait() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// backoffAnnouncer answers with a minimum interval, or fails
+type backoffAnnouncer struct {
+	err error
+}
+
+func (a *backoffAnnouncer) Announce(ctx context.Context, trackerURL string, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &tracker.AnnounceResponse{Interval: 3600, MinInterval: 1800}, nil
+}
+
+func TestTrackerBackoffAcrossRestarts(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce:   "http://a.invalid/announce",
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	announcer := &backoffAnnouncer{}
+	dm.Tracker = announcer
+	if _, err := dm.announce(context.Background(), "started"); err != nil {
+		t.Fatalf("announce() error = %v", err)
+	}
+
+	saved := dm.TrackerBackoffs()
+	if len(saved) != 1 || saved[0].URL != torrentFile.Announce || saved[0].MinInterval != 30*time.Minute || saved[0].Interval != time.Hour {
+		t.Fatalf("TrackerBackoffs() = %+v, want the tracker's intervals", saved)
+	}
+
+	// The next run waits out the minimum interval before its first announce
+	restarted := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	restarted.RestoreTrackerBackoffs(saved)
+	s := newTrackerSource(restarted)
+	if wait := s.resume(saved[0].LastAnnounce.Add(time.Minute)); wait != 29*time.Minute {
+		t.Errorf("resume() = %v a minute after the last announce, want 29m", wait)
+	}
+	if s.interval != time.Hour || s.minInterval != 30*time.Minute {
+		t.Errorf("resumed intervals %v and %v, want 1h and 30m", s.interval, s.minInterval)
+	}
+
+	// Failures carry over, so retries keep backing off
+	announcer.err = errors.New("tracker down")
+	for i := 0; i < 2; i++ {
+		dm.announce(context.Background(), "")
+	}
+	saved = dm.TrackerBackoffs()
+	if saved[0].Failures != 2 {
+		t.Fatalf("Failures = %d after two failed announces, want 2", saved[0].Failures)
+	}
+
+	restarted = NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	restarted.RestoreTrackerBackoffs(saved)
+	s = newTrackerSource(restarted)
+	if wait := s.resume(saved[0].LastAnnounce); wait != 30*time.Minute || s.failed != 2 {
+		t.Errorf("resume() = %v with %d failures, want 30m with 2", wait, s.failed)
+	}
+
+	// Nothing saved, nothing to wait for
+	s = newTrackerSource(NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1))
+	if wait := s.resume(time.Now()); wait != 0 {
+		t.Errorf("resume() = %v without a saved backoff, want 0", wait)
+	}
+}
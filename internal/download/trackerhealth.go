@@ -0,0 +1,127 @@
+package download
+
+import (
+	"sync"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+const (
+	// tierMaxFailures is the number of consecutive failed announces after
+	// which a tier is skipped
+	tierMaxFailures = 3
+
+	// tierInitialSkip is how long a failing tier is skipped at first; each
+	// failed probe after that doubles it up to tierMaxSkip
+	tierInitialSkip = 5 * time.Minute
+	tierMaxSkip     = 2 * time.Hour
+)
+
+// trackerHealth tracks the announces to each tier of a torrent's trackers.
+// Tiers that keep failing are skipped for a while so old torrents listing
+// dozens of dead trackers don't wait for each of them to time out. Once the
+// wait is over the tier is tried again: one success brings it back, one
+// failure skips it for twice as long.
+type trackerHealth struct {
+	mu    sync.Mutex
+	tiers map[int]*tierHealth
+}
+
+// tierHealth is the announce record of one tier
+type tierHealth struct {
+	failures  int           // Consecutive failed announces
+	skip      time.Duration // How long the tier was skipped last
+	skipUntil time.Time     // The tier is skipped until then
+}
+
+// newTrackerHealth creates an empty health record
+func newTrackerHealth() *trackerHealth {
+	return &trackerHealth{tiers: make(map[int]*tierHealth)}
+}
+
+// tier returns the record of a tier; callers must hold h.mu
+func (h *trackerHealth) tier(tier int) *tierHealth {
+	t, ok := h.tiers[tier]
+	if !ok {
+		t = &tierHealth{}
+		h.tiers[tier] = t
+	}
+	return t
+}
+
+// succeeded records a successful announce to a tier
+func (h *trackerHealth) succeeded(tier int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.tiers, tier)
+}
+
+// failed records a failed announce to a tier. It returns how long the tier
+// is now skipped, or 0 if it is still used.
+func (h *trackerHealth) failed(tier int, now time.Time) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.tier(tier)
+	t.failures++
+	if t.failures < tierMaxFailures {
+		return 0
+	}
+
+	switch {
+	case t.skip == 0:
+		t.skip = tierInitialSkip
+	case 2*t.skip < tierMaxSkip:
+		t.skip *= 2
+	default:
+		t.skip = tierMaxSkip
+	}
+	t.skipUntil = now.Add(t.skip)
+	return t.skip
+}
+
+// usable reports whether a tier may be announced to
+func (h *trackerHealth) usable(tier int, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.tiers[tier]
+	return !ok || !now.Before(t.skipUntil)
+}
+
+// skipped returns the number of tiers currently skipped
+func (h *trackerHealth) skipped(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for _, t := range h.tiers {
+		if now.Before(t.skipUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+// reset forgets every failure, so skipped tiers are tried again
+func (h *trackerHealth) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tiers = make(map[int]*tierHealth)
+}
+
+// trackerTier returns the announce-list tier (BEP 12) of a tracker, or -1
+// for an announce URL that isn't in the list
+func trackerTier(t *torrent.TorrentFile, url string) int {
+	for i, tier := range t.AnnounceList {
+		for _, u := range tier {
+			if u == url {
+				return i
+			}
+		}
+	}
+	return -1
+}
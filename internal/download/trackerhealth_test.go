@@ -0,0 +1,82 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestTrackerHealthSkipsFailingTier(t *testing.T) {
+	h := newTrackerHealth()
+	now := time.Now()
+
+	for i := 1; i < tierMaxFailures; i++ {
+		if skip := h.failed(0, now); skip != 0 {
+			t.Fatalf("failed() = %v after %d failures, want 0", skip, i)
+		}
+	}
+	if skip := h.failed(0, now); skip != tierInitialSkip {
+		t.Fatalf("failed() = %v, want %v", skip, tierInitialSkip)
+	}
+	if h.usable(0, now) || !h.usable(1, now) {
+		t.Error("usable() should be false for the failing tier only")
+	}
+	if n := h.skipped(now); n != 1 {
+		t.Errorf("skipped() = %d, want 1", n)
+	}
+
+	// The probe after the wait fails, so the tier is skipped for longer
+	now = now.Add(tierInitialSkip)
+	if !h.usable(0, now) {
+		t.Fatal("usable() = false once the skip is over")
+	}
+	if skip := h.failed(0, now); skip != 2*tierInitialSkip {
+		t.Errorf("failed() = %v after a failed probe, want %v", skip, 2*tierInitialSkip)
+	}
+	for i := 0; i < 10; i++ {
+		h.failed(0, now)
+	}
+	if skip := h.failed(0, now); skip != tierMaxSkip {
+		t.Errorf("failed() = %v, want at most %v", skip, tierMaxSkip)
+	}
+
+	// One success brings the tier back
+	h.succeeded(0)
+	if !h.usable(0, now) || h.skipped(now) != 0 {
+		t.Error("tier still skipped after a successful announce")
+	}
+	if skip := h.failed(0, now); skip != 0 {
+		t.Errorf("failed() = %v after a success, want 0", skip)
+	}
+}
+
+func TestNextTrackerSkipsFailingTier(t *testing.T) {
+	torrentFile := &torrent.TorrentFile{
+		Announce: "http://a.invalid/announce",
+		AnnounceList: [][]string{
+			{"http://a.invalid/announce"},
+			{"http://b.invalid/announce"},
+			{"http://c.invalid/announce"},
+		},
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 8},
+		PiecesHash: make([][20]byte, 2),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	for i := 0; i < tierMaxFailures; i++ {
+		dm.health.failed(1, time.Now())
+	}
+
+	if url, ok := dm.nextTracker(); !ok || url != "http://c.invalid/announce" {
+		t.Errorf("nextTracker() = %q, %v, want the tracker after the skipped tier", url, ok)
+	}
+
+	// With every other tier skipped the next tracker is tried anyway
+	for i := 0; i < tierMaxFailures; i++ {
+		dm.health.failed(0, time.Now())
+	}
+	if url, ok := dm.nextTracker(); !ok || url != "http://a.invalid/announce" {
+		t.Errorf("nextTracker() = %q, %v, want http://a.invalid/announce", url, ok)
+	}
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrVerificationFailed = errors.New("verification failed")
+)
+
+// VerifyData re-reads every piece from disk and checks it against the
+// metainfo hashes, and checks that each file has the expected size. Pieces
+// are hashed on CheckWorkers goroutines. It returns the indexes of the
+// pieces that failed the check.
+func (dm *DownloadManager) VerifyData() ([]int, error) {
+	pieces := make([]int, dm.Torrent.NumPieces())
+	for i := range pieces {
+		pieces[i] = i
+	}
+	return dm.verifyPieces(pieces)
+}
+
+// verifyPieces re-reads the pieces at indexes, which are in order, from
+// disk as VerifyData does and returns those that failed the check
+func (dm *DownloadManager) verifyPieces(pieces []int) ([]int, error) {
+	if dm.Storage == nil {
+		return nil, fmt.Errorf("%w: storage is not initialized", ErrVerificationFailed)
+	}
+
+	if err := dm.Storage.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	if err := dm.Storage.CheckFileSizes(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	// Hash straight from the page cache where the files can be mapped
+	read := dm.Storage.ReadPiece
+	if fs, ok := dm.Storage.(*FileStorage); ok {
+		if mapped, err := fs.mapFiles(); err == nil {
+			defer mapped.close()
+			read = mapped.readPiece
+		}
+	}
+
+	badPieces, err := dm.checkPieces(read, pieces)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	return badPieces, nil
+}
+
+// verifyOnComplete runs the final verification pass after the last piece has
+// been written. Pieces that fail are marked missing again so they are
+// re-downloaded; when everything checks out OnVerifiedComplete is fired.
+func (dm *DownloadManager) verifyOnComplete() {
+	dm.updateState("Verifying")
+
+	allBad, err := dm.VerifyData()
+	if err != nil {
+		fmt.Printf("Final verification failed: %v\n", err)
+		dm.updateState("Verification failed")
+		return
+	}
+
+	// Pieces outside a piece or byte range were never downloaded
+	var badPieces []int
+	for _, index := range allBad {
+		if dm.PieceManager.IsWanted(index) {
+			badPieces = append(badPieces, index)
+		}
+	}
+
+	if len(badPieces) > 0 {
+		fmt.Printf("Final verification found %d corrupt pieces, re-downloading\n", len(badPieces))
+
+		for _, index := range badPieces {
+			dm.PieceManager.ResetPiece(index)
+			dm.PieceManager.Pieces[index].ClearBlocks()
+		}
+
+		dm.updateState("Downloading")
+		return
+	}
+
+	dm.updateState("Verified")
+	if dm.OnVerifiedComplete != nil {
+		dm.OnVerifiedComplete()
+	}
+}
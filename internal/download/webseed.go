@@ -0,0 +1,375 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/peer"
+)
+
+// WebSeedPolicy decides when pieces are fetched over HTTP from web seeds
+// (BEP 19 url-list) or HTTP seeds (BEP 17 httpseeds) instead of from peers.
+// Peers are always preferred; web seeds only fill in when the swarm is too
+// slow or nobody we are connected to has a piece.
+type WebSeedPolicy struct {
+	SlowSwarmRate int64         // Swarm download rate in bytes/s below which web seeds are used
+	StarvedAfter  time.Duration // How long after starting before web seeds may be used
+	MaxActive     int           // Concurrent piece requests per web seed
+	MaxFailures   int           // Failures after which a web seed is no longer used
+}
+
+// DefaultWebSeedPolicy returns the policy used when none is configured
+func DefaultWebSeedPolicy() WebSeedPolicy {
+	return WebSeedPolicy{
+		SlowSwarmRate: 50 * 1024,
+		StarvedAfter:  30 * time.Second,
+		MaxActive:     2,
+		MaxFailures:   5,
+	}
+}
+
+// WebSeedStats reports the transfer accounting of a single web seed
+type WebSeedStats struct {
+	URL        string
+	Downloaded int64 // Bytes downloaded from the seed
+	Rate       int64 // Bytes per second of the most recent piece
+	Failures   int   // Failed piece requests
+}
+
+// webSeed is a web seed or HTTP seed together with its accounting
+type webSeed struct {
+	URL        string
+	HTTPSeed   bool // BEP 17 seed rather than a BEP 19 web seed
+	active     int
+	downloaded int64
+	rate       int64
+	failures   int
+	retryAt    time.Time // When a busy HTTP seed may be asked again
+}
+
+// seedBusyError is returned when a BEP 17 HTTP seed answers 503 with the
+// number of seconds to wait before asking again
+type seedBusyError struct {
+	wait time.Duration
+}
+
+func (e *seedBusyError) Error() string {
+	return fmt.Sprintf("seed busy, retry in %v", e.wait)
+}
+
+// webSeedClient is shared by all web seed requests
+var webSeedClient = &http.Client{Timeout: 60 * time.Second}
+
+// SetWebSeedProxy routes every web seed connection through dial, for
+// example a SOCKS5 proxy, ignoring proxy settings from the environment
+func SetWebSeedProxy(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	webSeedClient.Transport = &http.Transport{DialContext: dial}
+}
+
+// initWebSeeds creates the web seed list from the configured URLs
+func (dm *DownloadManager) initWebSeeds() {
+	for _, u := range dm.WebSeeds {
+		dm.webSeeds = append(dm.webSeeds, &webSeed{URL: u})
+	}
+	for _, u := range dm.HTTPSeeds {
+		dm.webSeeds = append(dm.webSeeds, &webSeed{URL: u, HTTPSeed: true})
+	}
+}
+
+// GetWebSeedStats returns the accounting of every web seed
+func (dm *DownloadManager) GetWebSeedStats() []WebSeedStats {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	stats := make([]WebSeedStats, len(dm.webSeeds))
+	for i, seed := range dm.webSeeds {
+		stats[i] = WebSeedStats{
+			URL:        seed.URL,
+			Downloaded: seed.downloaded,
+			Rate:       seed.rate,
+			Failures:   seed.failures,
+		}
+	}
+
+	return stats
+}
+
+// manageWebSeeds hands pieces to web seeds according to the policy
+func (dm *DownloadManager) manageWebSeeds() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if len(dm.webSeeds) == 0 || dm.storageErr != nil || dm.SeedOnly || dm.overBudget() {
+		return
+	}
+
+	policy := dm.WebSeedPolicy
+	now := time.Now()
+	if now.Sub(dm.startedAt) < policy.StarvedAfter {
+		return
+	}
+
+	seeds := dm.usableWebSeeds(now)
+	if len(seeds) == 0 {
+		return
+	}
+
+	var swarmHas func(pieceIndex int) bool
+	if dm.Stats.DownloadSpeed >= policy.SlowSwarmRate {
+		swarmHas = peersHave(dm.PeerPool.GetPeers())
+	}
+	candidates := dm.webSeedCandidates(swarmHas)
+
+	for _, seed := range seeds {
+		for seed.active < policy.MaxActive {
+			// Suspect pieces follow the same single-source rule as peers
+			next := -1
+			for i, index := range candidates {
+				if !dm.hashFailures.isSuspect(index) || dm.hashFailures.canServe(index, seed.URL, dm.webSeedHolders(index, seeds), now) {
+					next = i
+					break
+				}
+			}
+			if next < 0 {
+				break
+			}
+
+			piece := dm.PieceManager.PickFirstAvailable(nil, candidates[next:next+1])
+			candidates = append(candidates[:next], candidates[next+1:]...)
+			if piece == nil {
+				continue
+			}
+
+			seed.active++
+			dm.activePieces[piece.Index] = seed.URL
+			dm.pieceTimeouts[piece.Index] = time.Now().Add(dm.pieceTimeout)
+			go dm.fetchFromWebSeed(seed, piece)
+		}
+	}
+}
+
+// usableWebSeeds returns the seeds that may be given a piece at now,
+// fastest and most reliable first; callers must hold dm.mu
+func (dm *DownloadManager) usableWebSeeds(now time.Time) []*webSeed {
+	policy := dm.WebSeedPolicy
+
+	seeds := make([]*webSeed, 0, len(dm.webSeeds))
+	for _, seed := range dm.webSeeds {
+		if seed.failures < policy.MaxFailures && seed.active < policy.MaxActive &&
+			!now.Before(seed.retryAt) && !dm.PeerPool.IsBanned(seed.URL) {
+			seeds = append(seeds, seed)
+		}
+	}
+
+	sort.SliceStable(seeds, func(i, j int) bool {
+		if seeds[i].failures != seeds[j].failures {
+			return seeds[i].failures < seeds[j].failures
+		}
+		return seeds[i].rate > seeds[j].rate
+	})
+
+	return seeds
+}
+
+// webSeedHolders returns the seeds and connected peers that can serve a
+// piece; callers must hold dm.mu
+func (dm *DownloadManager) webSeedHolders(pieceIndex int, seeds []*webSeed) []string {
+	holders := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		holders = append(holders, seed.URL)
+	}
+	for addr, session := range dm.PeerPool.GetPeers() {
+		if session.HasPiece(pieceIndex) {
+			holders = append(holders, addr)
+		}
+	}
+
+	return holders
+}
+
+// webSeedCandidates returns the missing pieces web seeds may fetch: every
+// piece when the swarm is slow and swarmHas is nil, otherwise only pieces
+// swarmHas reports no connected peer has. Callers must hold dm.mu.
+func (dm *DownloadManager) webSeedCandidates(swarmHas func(pieceIndex int) bool) []int {
+	var candidates []int
+	for i := 0; i < dm.Torrent.NumPieces(); i++ {
+		if _, active := dm.activePieces[i]; active || dm.PieceManager.HasPiece(i) {
+			continue
+		}
+
+		if swarmHas == nil || !swarmHas(i) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	return candidates
+}
+
+// peersHave returns a function reporting whether any of sessions has a piece
+func peersHave(sessions map[string]*peer.Session) func(pieceIndex int) bool {
+	return func(pieceIndex int) bool {
+		for _, session := range sessions {
+			if session.HasPiece(pieceIndex) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// fetchFromWebSeed downloads a whole piece from a web seed
+func (dm *DownloadManager) fetchFromWebSeed(seed *webSeed, piece *Piece) {
+	start := time.Now()
+
+	var data []byte
+	var err error
+	if seed.HTTPSeed {
+		data, err = dm.fetchHTTPSeedPiece(seed.URL, piece)
+	} else {
+		data, err = dm.fetchWebSeedPiece(seed.URL, piece)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	seed.active--
+	delete(dm.pieceTimeouts, piece.Index)
+
+	if err != nil {
+		fmt.Printf("Web seed %s failed for piece %d: %v\n", seed.URL, piece.Index, err)
+		var busy *seedBusyError
+		if errors.As(err, &busy) {
+			seed.retryAt = time.Now().Add(busy.wait)
+		} else {
+			seed.failures++
+		}
+		delete(dm.activePieces, piece.Index)
+		dm.PieceManager.ResetPiece(piece.Index)
+		return
+	}
+
+	seed.downloaded += int64(len(data))
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		seed.rate = int64(float64(len(data)) / elapsed)
+	}
+	dm.Stats.Downloaded += int64(len(data))
+
+	for _, block := range piece.Blocks {
+		if err := piece.AddBlock(block.Begin, data[block.Begin:block.Begin+block.Length], seed.URL); err != nil {
+			fmt.Printf("Error adding web seed block: %v\n", err)
+		}
+	}
+
+	dm.finishPiece(piece)
+}
+
+// fetchWebSeedPiece downloads a piece from a BEP 19 web seed using range
+// requests against the files that hold it
+func (dm *DownloadManager) fetchWebSeedPiece(seedURL string, piece *Piece) ([]byte, error) {
+	data := make([]byte, piece.Length)
+	offset := int64(piece.Index) * dm.Torrent.Info.PieceLength
+
+	for _, span := range fileSpans(dm.Torrent, offset, piece.Length) {
+		req, err := http.NewRequestWithContext(dm.ctx, http.MethodGet, dm.webSeedFileURL(seedURL, span.FileIndex), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", span.FileOffset, span.FileOffset+int64(span.Length)-1))
+
+		resp, err := webSeedClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := data[span.DataOffset : span.DataOffset+span.Length]
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			_, err = io.ReadFull(resp.Body, buf)
+		case http.StatusOK:
+			// Servers ignoring the range send the whole file
+			if _, err = io.CopyN(io.Discard, resp.Body, span.FileOffset); err == nil {
+				_, err = io.ReadFull(resp.Body, buf)
+			}
+		default:
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// webSeedFileURL builds the URL of a file on a BEP 19 web seed
+func (dm *DownloadManager) webSeedFileURL(seedURL string, fileIndex int) string {
+	if !dm.Torrent.Info.IsDirectory && !strings.HasSuffix(seedURL, "/") {
+		return seedURL
+	}
+
+	if !strings.HasSuffix(seedURL, "/") {
+		seedURL += "/"
+	}
+
+	elems := []string{url.PathEscape(dm.Torrent.Info.Name)}
+	if dm.Torrent.Info.IsDirectory {
+		for _, elem := range dm.Torrent.Info.Files[fileIndex].Path {
+			elems = append(elems, url.PathEscape(elem))
+		}
+	}
+
+	return seedURL + strings.Join(elems, "/")
+}
+
+// fetchHTTPSeedPiece downloads a piece from a BEP 17 HTTP seed
+func (dm *DownloadManager) fetchHTTPSeedPiece(seedURL string, piece *Piece) ([]byte, error) {
+	u, err := url.Parse(seedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	query.Set("info_hash", string(dm.Torrent.InfoHash[:]))
+	query.Set("piece", strconv.Itoa(piece.Index))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(dm.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := webSeedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 503 means the seed is busy; the body holds the seconds to wait
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 16))
+		if seconds, err := strconv.Atoi(strings.TrimSpace(string(body))); err == nil && seconds > 0 {
+			return nil, &seedBusyError{wait: time.Duration(seconds) * time.Second}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data := make([]byte, piece.Length)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
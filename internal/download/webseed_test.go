@@ -0,0 +1,252 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// newWebSeedManager returns a started download of "0123456789" in pieces of
+// 4 bytes, saved as test.bin
+func newWebSeedManager(t *testing.T) *DownloadManager {
+	t.Helper()
+
+	torrentFile := &torrent.TorrentFile{
+		Info:       torrent.InfoDict{PieceLength: 4, Name: "test.bin", Length: 10},
+		PiecesHash: make([][20]byte, 3),
+	}
+
+	dm := NewDownloadManager(torrentFile, [20]byte{}, t.TempDir(), 1)
+	dm.PeerPool = &fakePool{}
+	dm.Storage = &fakeStorage{pieces: make(map[int][]byte)}
+	dm.ctx, dm.cancel = context.WithCancel(context.Background())
+	t.Cleanup(dm.cancel)
+
+	return dm
+}
+
+func TestWebSeedCandidates(t *testing.T) {
+	tests := []struct {
+		name     string
+		swarmHas func(int) bool
+		want     []int
+	}{
+		{"slow swarm takes every missing piece", nil, []int{1, 2}},
+		{"only pieces no peer has", func(i int) bool { return i == 1 }, []int{2}},
+		{"swarm has everything", func(int) bool { return true }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newWebSeedManager(t)
+			dm.PieceManager.MarkPieceHave(0)
+
+			got := dm.webSeedCandidates(tt.swarmHas)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("webSeedCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Pieces already fetched by someone are skipped
+	dm := newWebSeedManager(t)
+	dm.activePieces[1] = "http://seed.invalid/"
+	if got := dm.webSeedCandidates(nil); fmt.Sprint(got) != "[0 2]" {
+		t.Errorf("webSeedCandidates() = %v with piece 1 active, want [0 2]", got)
+	}
+}
+
+// bannedSeedPool reports a single banned address
+type bannedSeedPool struct {
+	fakePool
+	banned string
+}
+
+func (p *bannedSeedPool) IsBanned(addr string) bool { return addr == p.banned }
+
+func TestUsableWebSeeds(t *testing.T) {
+	now := time.Now()
+
+	dm := newWebSeedManager(t)
+	dm.PeerPool = &bannedSeedPool{banned: "banned"}
+	dm.WebSeedPolicy = WebSeedPolicy{MaxActive: 2, MaxFailures: 3}
+	dm.webSeeds = []*webSeed{
+		{URL: "slow", rate: 10},
+		{URL: "flaky", rate: 1000, failures: 1},
+		{URL: "fast", rate: 100},
+		{URL: "failed", failures: 3},
+		{URL: "full", active: 2},
+		{URL: "busy", retryAt: now.Add(time.Minute)},
+		{URL: "banned"},
+	}
+
+	var got []string
+	for _, seed := range dm.usableWebSeeds(now) {
+		got = append(got, seed.URL)
+	}
+	if want := "fast slow flaky"; strings.Join(got, " ") != want {
+		t.Errorf("usableWebSeeds() = %v, want %s", got, want)
+	}
+}
+
+func TestFetchWebSeedPiece(t *testing.T) {
+	const payload = "0123456789"
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr bool
+	}{
+		{"partial content", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "test.bin", time.Time{}, strings.NewReader(payload))
+		}, "4567", false},
+		{"whole file without range support", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(payload))
+		}, "4567", false},
+		{"not found", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			dm := newWebSeedManager(t)
+			data, err := dm.fetchWebSeedPiece(server.URL+"/files/", dm.PieceManager.Pieces[1])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchWebSeedPiece() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.want {
+				t.Errorf("fetchWebSeedPiece() = %q, want %q", data, tt.want)
+			}
+			if path != "/files/test.bin" {
+				t.Errorf("requested %s, want /files/test.bin", path)
+			}
+		})
+	}
+}
+
+func TestFetchHTTPSeedPiece(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		want     string
+		wantBusy time.Duration
+		wantErr  bool
+	}{
+		{"piece", http.StatusOK, "4567", "4567", 0, false},
+		{"busy with delay", http.StatusServiceUnavailable, "30\n", "", 30 * time.Second, true},
+		{"busy without delay", http.StatusServiceUnavailable, "", "", 0, true},
+		{"error status", http.StatusInternalServerError, "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var piece string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				piece = r.URL.Query().Get("piece")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			dm := newWebSeedManager(t)
+			data, err := dm.fetchHTTPSeedPiece(server.URL+"/seed", dm.PieceManager.Pieces[1])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchHTTPSeedPiece() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.want || piece != "1" {
+				t.Errorf("fetchHTTPSeedPiece() = %q for piece %q, want %q for piece 1", data, piece, tt.want)
+			}
+
+			var busy *seedBusyError
+			if errors.As(err, &busy) != (tt.wantBusy > 0) || (busy != nil && busy.wait != tt.wantBusy) {
+				t.Errorf("fetchHTTPSeedPiece() error = %v, want a busy delay of %v", err, tt.wantBusy)
+			}
+		})
+	}
+}
+
+func TestFetchFromBusyHTTPSeed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("60"))
+	}))
+	defer server.Close()
+
+	dm := newWebSeedManager(t)
+	seed := &webSeed{URL: server.URL, HTTPSeed: true, active: 1}
+	dm.activePieces[1] = seed.URL
+
+	dm.fetchFromWebSeed(seed, dm.PieceManager.Pieces[1])
+
+	// A busy seed waits out the delay without counting a failure
+	if seed.failures != 0 || time.Until(seed.retryAt) < 50*time.Second {
+		t.Errorf("busy seed: failures %d, retry in %v, want no failure and a retry in about 60s", seed.failures, time.Until(seed.retryAt))
+	}
+	if _, active := dm.activePieces[1]; active {
+		t.Error("piece still assigned to the busy seed")
+	}
+}
+
+func TestManageWebSeedsSuspectPiece(t *testing.T) {
+	tests := []struct {
+		name  string
+		seeds []string
+		want  map[int]string // pieceIndex -> seed it is assigned to
+	}{
+		{"blamed seed skips the piece", []string{"a", "b"}, map[int]string{0: "b", 1: "a"}},
+		{"blamed seed is the only holder", []string{"a"}, map[int]string{0: "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Seeds never answer, so pieces stay assigned
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
+
+			dm := newWebSeedManager(t)
+			dm.WebSeedPolicy = WebSeedPolicy{SlowSwarmRate: 1 << 30, MaxActive: 1, MaxFailures: 5}
+			for i, name := range tt.seeds {
+				// Earlier seeds are faster, so a is offered pieces first
+				dm.webSeeds = append(dm.webSeeds, &webSeed{URL: server.URL + "/" + name, rate: int64(100 - i)})
+			}
+
+			// Seed a and a peer both sent blocks of the piece that failed
+			dm.hashFailures.suspects[0] = &suspectPiece{
+				contributors: map[string]bool{server.URL + "/a": true, "10.0.0.1:6881": true},
+				failedAt:     time.Now(),
+			}
+
+			dm.manageWebSeeds()
+			dm.mu.Lock()
+			for index, name := range tt.want {
+				if got := dm.activePieces[index]; got != server.URL+"/"+name {
+					t.Errorf("piece %d assigned to %q, want seed %s", index, got, name)
+				}
+			}
+			dm.mu.Unlock()
+
+			dm.cancel()
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package download
+
+import (
+	"sort"
+)
+
+// BufferPiece queues a verified piece for writing. Pieces are held in memory
+// until BufferLimit bytes have accumulated and are then written with Flush,
+// which merges adjacent pieces into larger sequential writes. With a
+// BufferLimit of zero the piece is written immediately. A piece that cannot
+// be written is kept in the buffer so a later Flush can retry it.
+func (fs *FileStorage) BufferPiece(pieceIndex int, data []byte) error {
+	return fs.BufferBlocks(pieceIndex, [][]byte{data})
+}
+
+// BufferBlocks is BufferPiece for a piece given as its consecutive blocks,
+// which are kept as they are rather than copied into one buffer
+func (fs *FileStorage) BufferBlocks(pieceIndex int, blocks [][]byte) error {
+	if fs.BufferLimit <= 0 {
+		err := fs.WriteBlocks(pieceIndex, blocks)
+		if err != nil {
+			fs.mu.Lock()
+			fs.addPending(pieceIndex, blocks)
+			fs.mu.Unlock()
+		}
+		return err
+	}
+
+	fs.mu.Lock()
+	fs.addPending(pieceIndex, blocks)
+	full := fs.pendingBytes >= fs.BufferLimit
+	fs.mu.Unlock()
+
+	if full {
+		return fs.Flush()
+	}
+
+	return nil
+}
+
+// addPending adds a piece to the write buffer; callers must hold fs.mu
+func (fs *FileStorage) addPending(pieceIndex int, blocks [][]byte) {
+	if fs.pending == nil {
+		fs.pending = make(map[int][][]byte)
+	}
+
+	if old, exists := fs.pending[pieceIndex]; exists {
+		fs.pendingBytes -= blocksLen(old)
+	}
+
+	fs.pending[pieceIndex] = blocks
+	fs.pendingBytes += blocksLen(blocks)
+	fs.disk.setQueued(len(fs.pending), fs.pendingBytes)
+}
+
+// Flush writes all buffered pieces to disk. Runs of consecutive pieces are
+// written as a single contiguous range so each file sees as few, large,
+// offset-ordered writes as possible.
+func (fs *FileStorage) Flush() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if len(fs.pending) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, 0, len(fs.pending))
+	for index := range fs.pending {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for start := 0; start < len(indexes); {
+		// Extend the run while pieces are consecutive
+		end := start + 1
+		for end < len(indexes) && indexes[end] == indexes[end-1]+1 {
+			end++
+		}
+
+		// A lone piece is written block by block; a run is merged into
+		// one buffer, trading a copy for a single large write
+		run := fs.pending[indexes[start]]
+		if end-start > 1 {
+			size := 0
+			for _, index := range indexes[start:end] {
+				size += blocksLen(fs.pending[index])
+			}
+
+			merged := make([]byte, 0, size)
+			for _, index := range indexes[start:end] {
+				for _, block := range fs.pending[index] {
+					merged = append(merged, block...)
+				}
+			}
+			run = [][]byte{merged}
+		}
+
+		offset := int64(indexes[start]) * fs.Torrent.Info.PieceLength
+		if err := fs.writeBlocks(offset, run); err != nil {
+			return err
+		}
+
+		// Only drop pieces once they are safely on disk
+		for _, index := range indexes[start:end] {
+			fs.pendingBytes -= blocksLen(fs.pending[index])
+			delete(fs.pending, index)
+		}
+		fs.disk.setQueued(len(fs.pending), fs.pendingBytes)
+
+		start = end
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+//go:build geoip
+
+// Package geoip looks up the country of IP addresses in a MaxMind DB
+// (GeoLite2/GeoIP2 Country or City) file. It reads the MMDB format
+// directly so no third party module is needed, and is only built with the
+// geoip build tag.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+var (
+	ErrInvalidDatabase = errors.New("invalid MaxMind database")
+	ErrNotFound        = errors.New("address not in database")
+)
+
+// metadataMarker precedes the metadata map at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the gap between the search tree and the data
+const dataSectionSeparator = 16
+
+// Reader looks up addresses in a MaxMind database held in memory
+type Reader struct {
+	buf        []byte
+	data       []byte // Data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // Node where IPv4 lookups start in an IPv6 tree
+}
+
+// Open loads a MaxMind database file
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(buf)
+}
+
+// New parses a MaxMind database from its contents
+func New(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+
+	meta, _, err := decode(buf[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{buf: buf}
+	for key, field := range map[string]*uint{"node_count": &r.nodeCount, "record_size": &r.recordSize, "ip_version": &r.ipVersion} {
+		v, ok := metaMap[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidDatabase, key)
+		}
+		*field = uint(v)
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, fmt.Errorf("%w: search tree larger than file", ErrInvalidDatabase)
+	}
+	r.data = buf[treeSize+dataSectionSeparator : i]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Country returns the ISO 3166 country code of an address
+func (r *Reader) Country(ip net.IP) (string, error) {
+	record, err := r.lookup(ip)
+	if err != nil {
+		return "", err
+	}
+
+	m, _ := record.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := m[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok {
+			return code, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// lookup walks the search tree for ip and decodes its data record
+func (r *Reader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := ip.To16()
+
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	if node <= r.nodeCount {
+		return nil, ErrNotFound
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+
+	value, _, err := decode(r.data, offset)
+	return value, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (r *Reader) record(node, bit uint) uint {
+	base := node * r.recordSize / 4
+	b := r.buf[base : base+r.recordSize/4]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// The middle byte holds the high nibble of both records
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Data section field types
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+// decode decodes the field at offset in a data section and returns it with
+// the offset following it. Integers decode as uint64 (int32 as int64).
+func decode(data []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	ctrl := data[offset]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == typePointer {
+		target, next, err := decodePointer(data, ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decode(data, target)
+		return value, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(data)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + int(data[offset])
+		offset++
+	}
+
+	size, offset, err := decodeSize(data, ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+
+			m[k], offset, err = decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+
+	case typeArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			a[i], offset, err = decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errors.New("field exceeds data section")
+	}
+	b := data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64, typeUint128:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c) // uint128 values keep their low 64 bits
+		}
+		return v, offset, nil
+	case typeInt32:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown field type %d", typ)
+	}
+}
+
+// decodeSize decodes the payload size that follows a control byte
+func decodeSize(data []byte, ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(data)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+
+	var extra uint
+	for _, c := range data[offset : offset+n] {
+		extra = extra<<8 | uint(c)
+	}
+
+	switch size {
+	case 29:
+		size = 29 + extra
+	case 30:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+
+	return size, offset + n, nil
+}
+
+// decodePointer decodes a pointer to another field of the data section
+func decodePointer(data []byte, ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(data)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+
+	var target uint
+	if n < 4 {
+		target = uint(ctrl & 0x7)
+	}
+	for _, c := range data[offset : offset+n] {
+		target = target<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+
+	return target, offset + n, nil
+}
@@ -0,0 +1,77 @@
+//go:build geoip
+
+package geoip
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// str encodes a string field of the MMDB data section
+func str(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+// testDatabase builds an IPv4 database with one node: 0.0.0.0/1 is in
+// Germany and 128.0.0.0/1 is not in the database
+func testDatabase() []byte {
+	const nodeCount = 1
+
+	var db []byte
+	db = append(db, 0, 0, nodeCount+16, 0, 0, nodeCount) // left: data at 0, right: empty
+	db = append(db, make([]byte, 16)...)
+
+	// {"country": {"iso_code": "DE"}}
+	db = append(db, 0xe1)
+	db = append(db, str("country")...)
+	db = append(db, 0xe1)
+	db = append(db, str("iso_code")...)
+	db = append(db, str("DE")...)
+
+	db = append(db, metadataMarker...)
+	db = append(db, 0xe3)
+	db = append(db, str("node_count")...)
+	db = append(db, 0xc1, nodeCount)
+	db = append(db, str("record_size")...)
+	db = append(db, 0xa1, 24)
+	db = append(db, str("ip_version")...)
+	db = append(db, 0xa1, 4)
+
+	return db
+}
+
+func TestCountry(t *testing.T) {
+	r, err := New(testDatabase())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got, err := r.Country(net.ParseIP("1.2.3.4")); err != nil || got != "DE" {
+		t.Errorf("Country(1.2.3.4) = %q, %v, want DE", got, err)
+	}
+
+	if _, err := r.Country(net.ParseIP("200.1.2.3")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Country(200.1.2.3) error = %v, want %v", err, ErrNotFound)
+	}
+
+	if _, err := r.Country(net.ParseIP("2001:db8::1")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Country(2001:db8::1) error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New([]byte("not a database")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("New() error = %v, want %v", err, ErrInvalidDatabase)
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	// A string at offset 0 and a pointer to it at offset 3
+	data := append(str("DE"), 0x20, 0x00)
+
+	value, next, err := decode(data, 3)
+	if err != nil || value != "DE" || next != 5 {
+		t.Errorf("decode() = %v, %d, %v, want DE, 5", value, next, err)
+	}
+}
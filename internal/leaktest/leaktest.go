@@ -0,0 +1,88 @@
+// Package leaktest finds goroutines that outlive a test, in the spirit of
+// goleak without the dependency.
+package leaktest
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Timeout is how long Check waits for goroutines to finish
+var Timeout = 5 * time.Second
+
+// Check records the running goroutines and returns a function that fails
+// the test if goroutines started since are still running once they had
+// Timeout to finish. Use it as defer leaktest.Check(t)().
+func Check(t testing.TB) func() {
+	t.Helper()
+	before := goroutines()
+
+	return func() {
+		t.Helper()
+
+		var leaked []string
+		deadline := time.Now().Add(Timeout)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok && !ignored(stack) {
+					leaked = append(leaked, stack)
+				}
+			}
+
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(leaked) > 0 {
+			sort.Strings(leaked)
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	}
+}
+
+// goroutines returns the stacks of all goroutines by their header line,
+// e.g. "goroutine 7"
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		header, _, _ := strings.Cut(stack, " [")
+		stacks[header] = stack
+	}
+	return stacks
+}
+
+// ignored reports whether a goroutine belongs to the runtime or the test
+// framework rather than to the code under test
+func ignored(stack string) bool {
+	for _, frame := range []string{
+		"testing.RunTests",
+		"testing.(*T).Run",
+		"testing.tRunner",
+		"runtime.goexit0",
+		"os/signal.signal_recv",
+		"created by runtime.gc",
+		"runtime.MHeap_Scavenger",
+		"leaktest.goroutines",
+	} {
+		if strings.Contains(stack, frame) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+// Package notify shows desktop notifications with the notification service
+// of the OS: the D-Bus notification daemon through notify-send on Linux and
+// the BSDs, Notification Center through osascript on macOS and toasts
+// through PowerShell on Windows.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// timeout bounds how long showing a notification may take
+const timeout = 5 * time.Second
+
+// Environment variables passing the notification to scripts, which saves
+// quoting it for the script language
+const (
+	titleEnv   = "GO_TORRENT_NOTIFY_TITLE"
+	messageEnv = "GO_TORRENT_NOTIFY_MESSAGE"
+)
+
+// Notifier shows desktop notifications
+type Notifier interface {
+	Notify(title, message string) error
+}
+
+// commandNotifier shows notifications by running a command of the OS
+type commandNotifier struct {
+	path string
+}
+
+// New returns the notifier of this OS. It fails when the command that
+// shows notifications isn't installed.
+func New() (Notifier, error) {
+	path, err := exec.LookPath(notifyCommand)
+	if err != nil {
+		return nil, fmt.Errorf("desktop notifications need %s: %w", notifyCommand, err)
+	}
+
+	return &commandNotifier{path: path}, nil
+}
+
+// Notify shows a notification and returns once the OS took it
+func (n *commandNotifier) Notify(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.path, commandArgs(title, message)...)
+	cmd.Env = append(os.Environ(), titleEnv+"="+title, messageEnv+"="+message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", notifyCommand, err, out)
+	}
+	return nil
+}
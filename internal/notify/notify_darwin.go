@@ -0,0 +1,10 @@
+//go:build darwin
+
+package notify
+
+// notifyCommand runs AppleScript, which posts to Notification Center
+const notifyCommand = "osascript"
+
+func commandArgs(title, message string) []string {
+	return []string{"-e", `display notification (system attribute "` + messageEnv + `") with title (system attribute "` + titleEnv + `")`}
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package notify
+
+// notifyCommand sends notifications to the D-Bus notification daemon
+const notifyCommand = "notify-send"
+
+func commandArgs(title, message string) []string {
+	return []string{"--app-name=go-torrent", "--", title, message}
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake notification command is a shell script")
+	}
+
+	// A fake notification command recording what it was given
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" \"$" + titleEnv + "\" \"$" + messageEnv + "\" > " + out + "\n"
+	if err := os.WriteFile(filepath.Join(dir, notifyCommand), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	notifier, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := notifier.Notify("Download complete", `"album" is ready`); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Download complete\n") || !strings.Contains(string(data), "\"album\" is ready\n") {
+		t.Errorf("%s got:\n%s", notifyCommand, data)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := New(); err == nil {
+		t.Errorf("New() without %s succeeded", notifyCommand)
+	}
+}
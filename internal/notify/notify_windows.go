@@ -0,0 +1,24 @@
+//go:build windows
+
+package notify
+
+// notifyCommand runs a script showing a toast through the WinRT API
+const notifyCommand = "powershell.exe"
+
+// toastScript shows a toast with the title and message from the
+// environment, under the name of PowerShell since go-torrent isn't
+// registered as an app
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastTemplateType]::ToastText02
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent($template)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:` + titleEnv + `)) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:` + messageEnv + `)) | Out-Null
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+func commandArgs(title, message string) []string {
+	return []string{"-NoProfile", "-NonInteractive", "-Command", toastScript}
+}
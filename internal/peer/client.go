@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,19 +13,31 @@ type Client struct {
 	Conn     net.Conn
 	PeerID   [20]byte
 	InfoHash [20]byte
-	Choked   bool
 	Bitfield Bitfield
+	Fast     bool // Both sides support the Fast extension
+	Extended bool // Both sides support the extension protocol (BEP 10)
+	HaveAll  bool // The peer announced it has every piece
+	counters connCounters
+	lastSent atomic.Int64                      // Unix nanoseconds of the last message sent
+	extended atomic.Pointer[ExtendedHandshake] // The peer's extended handshake
 }
 
 // NewClient creates a new peer connection
 func NewClient(peerAddr string, infoHash, ourPeerID [20]byte) (*Client, error) {
+	return connectClient(peerAddr, infoHash, ourPeerID, func(SessionState) {})
+}
+
+// connectClient is NewClient, calling advance as each stage of setting up
+// the connection begins
+func connectClient(peerAddr string, infoHash, ourPeerID [20]byte, advance func(SessionState)) (*Client, error) {
 	// Set timeout for connection
-	conn, err := net.DialTimeout("tcp", peerAddr, 30*time.Second)
+	conn, err := dialPeer(peerAddr, 30*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to peer %s: %w", peerAddr, err)
 	}
 
 	// Perform handshake
+	advance(StateHandshaking)
 	peerHandshake, err := DoHandshake(conn, infoHash, ourPeerID)
 	if err != nil {
 		conn.Close()
@@ -35,10 +48,13 @@ func NewClient(peerAddr string, infoHash, ourPeerID [20]byte) (*Client, error) {
 		Conn:     conn,
 		PeerID:   peerHandshake.PeerID,
 		InfoHash: infoHash,
-		Choked:   true,
+		Fast:     peerHandshake.SupportsFast(),
+		Extended: peerHandshake.SupportsExtended(),
 	}
+	client.countHandshake()
 
 	// Read bitfield if peer sends it
+	advance(StateBitfield)
 	if err := client.readBitfield(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to read bitfield: %w", err)
@@ -47,6 +63,41 @@ func NewClient(peerAddr string, infoHash, ourPeerID [20]byte) (*Client, error) {
 	return client, nil
 }
 
+// NewIncomingClient wraps a connection a peer opened to us
+func NewIncomingClient(conn net.Conn, infoHash, ourPeerID [20]byte) (*Client, error) {
+	peerHandshake, err := AcceptHandshake(conn, infoHash, ourPeerID)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed with %s: %w", conn.RemoteAddr(), err)
+	}
+
+	client := &Client{
+		Conn:     conn,
+		PeerID:   peerHandshake.PeerID,
+		InfoHash: infoHash,
+		Fast:     peerHandshake.SupportsFast(),
+		Extended: peerHandshake.SupportsExtended(),
+	}
+	client.countHandshake()
+
+	return client, nil
+}
+
+// countHandshake records the handshakes exchanged when connecting
+func (c *Client) countHandshake() {
+	c.counters.overheadRead.Add(handshakeLength)
+	c.counters.overheadWritten.Add(handshakeLength)
+}
+
+// Stats returns the bytes exchanged with the peer
+func (c *Client) Stats() ConnStats {
+	return c.counters.snapshot()
+}
+
+// Rates returns the block data exchanged with the peer over a rolling window
+func (c *Client) Rates(window time.Duration) PeerRates {
+	return c.counters.rates.over(time.Now(), window)
+}
+
 // readBitfield reads the initial bitfield message if present
 func (c *Client) readBitfield() error {
 	// Set a short timeout for the bitfield message
@@ -63,13 +114,21 @@ func (c *Client) readBitfield() error {
 		return err
 	}
 
+	c.counters.countRead(msg)
+
 	if msg == nil {
 		// Keep-alive message
 		return nil
 	}
 
-	if msg.ID == MsgBitfield {
+	switch msg.ID {
+	case MsgBitfield:
 		c.Bitfield = Bitfield(msg.Payload)
+	case MsgHaveAll:
+		c.HaveAll = true
+	case MsgExtended:
+		// Peers may send their extended handshake before the bitfield
+		return c.handleExtended(msg.Payload)
 	}
 
 	return nil
@@ -77,8 +136,14 @@ func (c *Client) readBitfield() error {
 
 // SendMessage sends a message to the peer
 func (c *Client) SendMessage(msg *Message) error {
+	if msg.ID == MsgPiece {
+		UploadLimiter.Wait(c.InfoHash, len(msg.Payload))
+	}
+
 	c.Conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
-	_, err := c.Conn.Write(msg.Serialize())
+	n, err := c.Conn.Write(msg.Serialize())
+	c.counters.countWritten(msg, n)
+	c.lastSent.Store(time.Now().UnixNano())
 	return err
 }
 
@@ -92,6 +157,11 @@ func (c *Client) SendNotInterested() error {
 	return c.SendMessage(&Message{ID: MsgNotInterested})
 }
 
+// SendChoke sends a choke message
+func (c *Client) SendChoke() error {
+	return c.SendMessage(&Message{ID: MsgChoke})
+}
+
 // SendUnchoke sends an unchoke message
 func (c *Client) SendUnchoke() error {
 	return c.SendMessage(&Message{ID: MsgUnchoke})
@@ -116,12 +186,64 @@ func (c *Client) SendHave(index int) error {
 	})
 }
 
+// SendPiece sends a block of piece data in response to a request
+func (c *Client) SendPiece(index, begin int, block []byte) error {
+	return c.SendMessage(&Message{
+		ID:      MsgPiece,
+		Payload: SerializePiece(index, begin, block),
+	})
+}
+
+// SendBitfield sends a bitfield message announcing the pieces we have
+func (c *Client) SendBitfield(bf Bitfield) error {
+	return c.SendMessage(&Message{
+		ID:      MsgBitfield,
+		Payload: bf,
+	})
+}
+
+// SendSuggestPiece suggests a piece for the peer to download from us
+func (c *Client) SendSuggestPiece(index int) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return c.SendMessage(&Message{
+		ID:      MsgSuggestPiece,
+		Payload: payload,
+	})
+}
+
+// SendHaveAll tells a Fast extension peer we have every piece
+func (c *Client) SendHaveAll() error {
+	return c.SendMessage(&Message{ID: MsgHaveAll})
+}
+
+// SendHaveNone tells a Fast extension peer we have no pieces
+func (c *Client) SendHaveNone() error {
+	return c.SendMessage(&Message{ID: MsgHaveNone})
+}
+
+// SendRejectRequest tells a Fast extension peer we won't serve a request
+func (c *Client) SendRejectRequest(index, begin, length int) error {
+	return c.SendMessage(&Message{
+		ID:      MsgRejectRequest,
+		Payload: SerializeRequest(index, begin, length),
+	})
+}
+
 // SendKeepAlive sends a keep-alive message
 func (c *Client) SendKeepAlive() error {
-	_, err := c.Conn.Write(make([]byte, 4))
+	c.Conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	n, err := c.Conn.Write(make([]byte, 4))
+	c.counters.countWritten(nil, n)
+	c.lastSent.Store(time.Now().UnixNano())
 	return err
 }
 
+// LastSent returns when we last sent the peer a message
+func (c *Client) LastSent() time.Time {
+	return time.Unix(0, c.lastSent.Load())
+}
+
 // Close closes the connection to the peer
 func (c *Client) Close() error {
 	return c.Conn.Close()
@@ -130,5 +252,17 @@ func (c *Client) Close() error {
 // Read reads a message from the peer
 func (c *Client) Read() (*Message, error) {
 	c.Conn.SetReadDeadline(time.Now().Add(3 * time.Minute))
-	return ReadMessage(c.Conn)
+
+	msg, err := ReadMessage(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+
+	c.counters.countRead(msg)
+
+	// Holding back the next read slows the peer down through TCP flow control
+	if msg != nil && msg.ID == MsgPiece {
+		DownloadLimiter.Wait(c.InfoHash, len(msg.Payload))
+	}
+	return msg, nil
 }
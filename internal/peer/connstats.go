@@ -0,0 +1,91 @@
+package peer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/wire"
+)
+
+// handshakeLength is the size of a BitTorrent handshake on the wire
+const handshakeLength = wire.HandshakeLength
+
+// pieceHeaderLength is the index and begin fields in front of a block
+const pieceHeaderLength = 8
+
+// ConnStats counts the bytes exchanged with peers. Payload is the block
+// data carried by piece messages, which is what trackers expect in the
+// uploaded and downloaded counters; overhead is everything else on the
+// wire: handshakes, message headers, keep-alives, haves, requests and so on.
+type ConnStats struct {
+	PayloadRead     int64
+	PayloadWritten  int64
+	OverheadRead    int64
+	OverheadWritten int64
+}
+
+// Add returns the sum of two sets of counters
+func (s ConnStats) Add(o ConnStats) ConnStats {
+	return ConnStats{
+		PayloadRead:     s.PayloadRead + o.PayloadRead,
+		PayloadWritten:  s.PayloadWritten + o.PayloadWritten,
+		OverheadRead:    s.OverheadRead + o.OverheadRead,
+		OverheadWritten: s.OverheadWritten + o.OverheadWritten,
+	}
+}
+
+// connCounters holds the live counters of a connection
+type connCounters struct {
+	payloadRead     atomic.Int64
+	payloadWritten  atomic.Int64
+	overheadRead    atomic.Int64
+	overheadWritten atomic.Int64
+	rates           transferRates // Blocks per second, for rolling rates
+}
+
+// snapshot returns the current counter values
+func (c *connCounters) snapshot() ConnStats {
+	return ConnStats{
+		PayloadRead:     c.payloadRead.Load(),
+		PayloadWritten:  c.payloadWritten.Load(),
+		OverheadRead:    c.overheadRead.Load(),
+		OverheadWritten: c.overheadWritten.Load(),
+	}
+}
+
+// messagePayload returns how many bytes of a message are block data
+func messagePayload(msg *Message) int {
+	if msg == nil || msg.ID != MsgPiece || len(msg.Payload) < pieceHeaderLength {
+		return 0
+	}
+	return len(msg.Payload) - pieceHeaderLength
+}
+
+// countRead records a message read from the peer
+func (c *connCounters) countRead(msg *Message) {
+	wire := 4
+	if msg != nil {
+		wire += 1 + len(msg.Payload)
+	}
+
+	payload := messagePayload(msg)
+	c.payloadRead.Add(int64(payload))
+	c.overheadRead.Add(int64(wire - payload))
+	if payload > 0 {
+		c.rates.add(time.Now(), true, payload)
+	}
+}
+
+// countWritten records n bytes of a message written to the peer
+func (c *connCounters) countWritten(msg *Message, n int) {
+	payload := messagePayload(msg)
+	if payload > n {
+		payload = n
+	}
+
+	c.payloadWritten.Add(int64(payload))
+	c.overheadWritten.Add(int64(n - payload))
+	if payload > 0 {
+		c.rates.add(time.Now(), false, payload)
+	}
+}
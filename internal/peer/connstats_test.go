@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnStatsSeparatesOverhead(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	sender := &Client{Conn: a}
+	receiver := &Client{Conn: b}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		sender.SendPiece(0, 0, make([]byte, 100))
+		sender.SendHave(1)
+		sender.SendKeepAlive()
+	}()
+
+	for i := 0; i < 3; i++ {
+		if _, err := receiver.Read(); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+
+	<-sent
+
+	// piece: 4 length + 1 id + 8 header + 100 block; have: 9; keep-alive: 4
+	want := ConnStats{PayloadWritten: 100, OverheadWritten: 13 + 9 + 4}
+	if got := sender.Stats(); got != want {
+		t.Errorf("sender Stats() = %+v, want %+v", got, want)
+	}
+
+	want = ConnStats{PayloadRead: 100, OverheadRead: 13 + 9 + 4}
+	if got := receiver.Stats(); got != want {
+		t.Errorf("receiver Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTransferRatesWindows(t *testing.T) {
+	var r transferRates
+	now := time.Unix(1000, 0)
+
+	// 20 seconds of 10KB/s received, then 5 seconds of 1KB/s sent
+	for i := 0; i < 20; i++ {
+		r.add(now.Add(time.Duration(i-29)*time.Second), true, 10*1024)
+	}
+	for i := 0; i < 5; i++ {
+		r.add(now.Add(time.Duration(i-4)*time.Second), false, 512)
+		r.add(now.Add(time.Duration(i-4)*time.Second), false, 512)
+	}
+
+	short := r.over(now, 10*time.Second)
+	if short.BlocksReceived != 0 || short.BlocksSent != 10 || short.UploadRate != 512 {
+		t.Errorf("over(10s) = %+v, want 10 blocks sent at 512 B/s", short)
+	}
+
+	medium := r.over(now, 30*time.Second)
+	want := PeerRates{Window: 30 * time.Second, BlocksReceived: 20, BlocksSent: 10, DownloadRate: 20 * 10 * 1024 / 30, UploadRate: 5 * 1024 / 30}
+	if medium != want {
+		t.Errorf("over(30s) = %+v, want %+v", medium, want)
+	}
+
+	// A minute later the buckets have been overwritten or aged out
+	r.add(now.Add(time.Minute), true, 100)
+	if late := r.over(now.Add(time.Minute), time.Minute); late.BlocksReceived != 1 || late.BlocksSent != 0 {
+		t.Errorf("over(60s) a minute later = %+v, want only the new block", late)
+	}
+}
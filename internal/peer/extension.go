@@ -0,0 +1,75 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/piyushgupta53/go-torrent/internal/wire"
+)
+
+// ExtendedHandshake is the handshake of the extension protocol (BEP 10)
+type ExtendedHandshake = wire.ExtendedHandshake
+
+// ClientVersion identifies us in extended handshakes, matching the client
+// and version in our peer ID
+const ClientVersion = "GoTorrent 0.0.1"
+
+// MaxRequestQueue is the number of requests we tell peers we queue
+const MaxRequestQueue = 250
+
+var (
+	clientVersionMu sync.RWMutex
+	clientVersion   = ClientVersion
+)
+
+// SetClientVersion sets the client name and version sent in extended
+// handshakes from now on; "" leaves it out so peers can't tell our client
+func SetClientVersion(version string) {
+	clientVersionMu.Lock()
+	defer clientVersionMu.Unlock()
+	clientVersion = version
+}
+
+// SendExtendedHandshake tells an extension protocol peer who we are. A
+// port of 0 leaves out the listen port, for peers that can't connect to us.
+func (c *Client) SendExtendedHandshake(port int, yourIP net.IP) error {
+	clientVersionMu.RLock()
+	version := clientVersion
+	clientVersionMu.RUnlock()
+
+	h := &ExtendedHandshake{
+		Version:  version,
+		RequestQ: MaxRequestQueue,
+		Port:     port,
+		YourIP:   yourIP,
+	}
+	return c.SendMessage(&Message{ID: MsgExtended, Payload: h.Serialize()})
+}
+
+// handleExtended processes an extended message from the peer
+func (c *Client) handleExtended(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty extended message")
+	}
+
+	// We don't offer any extension messages, so the handshake is the only
+	// one peers may send us
+	if payload[0] != wire.ExtendedHandshakeID {
+		return fmt.Errorf("unknown extended message %d", payload[0])
+	}
+
+	h, err := wire.ParseExtendedHandshake(payload)
+	if err != nil {
+		return err
+	}
+
+	c.extended.Store(h)
+	return nil
+}
+
+// ExtendedHandshake returns the extended handshake the peer sent, or nil
+// if it hasn't sent one
+func (c *Client) ExtendedHandshake() *ExtendedHandshake {
+	return c.extended.Load()
+}
@@ -2,6 +2,7 @@ package peer
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -9,17 +10,49 @@ import (
 // MessageHandler handles incoming messages from a peer
 type MessageHandler struct {
 	client    *Client
+	fsm       *stateMachine
 	pieces    map[int]bool
 	mu        sync.RWMutex
 	onUnchoke func()
 	onPiece   func(*Piece)
+	onRequest func(*Request)
+	onExit    func() // Called when the message loop ends
+
+	onExtended func(*ExtendedHandshake)
+	layout     Layout // The torrent's pieces, zero when unknown
+
+	// Guards the fields below, and keeps choke and unchoke messages in
+	// order without holding up readers of the peer's pieces
+	chokeMu           sync.Mutex
+	peerInterested    bool // The peer wants our pieces
+	amChoking         bool // We don't serve the peer's requests
+	unchokeOnInterest bool // Interested peers are unchoked right away
 }
 
-// NewMessageHandler creates a new message handler
+// NewMessageHandler creates a new message handler for a peer that is
+// choking us
 func NewMessageHandler(client *Client) *MessageHandler {
+	return newMessageHandler(client, newStateMachine(StateChoked))
+}
+
+// newMessageHandler creates a message handler that moves a session's state
+// machine as the peer chokes and unchokes us
+func newMessageHandler(client *Client, fsm *stateMachine) *MessageHandler {
+	pieces := make(map[int]bool)
+
+	// Keep the bitfield the peer sent right after the handshake
+	for i := 0; i < len(client.Bitfield)*8; i++ {
+		if client.Bitfield.HasPiece(i) {
+			pieces[i] = true
+		}
+	}
+
 	return &MessageHandler{
-		client: client,
-		pieces: make(map[int]bool),
+		client:            client,
+		fsm:               fsm,
+		pieces:            pieces,
+		amChoking:         true,
+		unchokeOnInterest: true,
 	}
 }
 
@@ -28,8 +61,13 @@ func (h *MessageHandler) Start() {
 	go h.messageLoop()
 }
 
-// messageLoop continuously reads and processes messages
+// messageLoop continuously reads and processes messages until the
+// connection fails
 func (h *MessageHandler) messageLoop() {
+	if h.onExit != nil {
+		defer h.onExit()
+	}
+
 	for {
 		msg, err := h.client.Read()
 		if err != nil {
@@ -39,6 +77,11 @@ func (h *MessageHandler) messageLoop() {
 
 		if err := h.handleMessage(msg); err != nil {
 			fmt.Printf("Error handling message: %v\n", err)
+
+			// A peer that gets the torrent wrong can't be trusted with it
+			if errors.Is(err, ErrInvalidBitfield) || errors.Is(err, ErrOutOfRange) {
+				return
+			}
 		}
 	}
 }
@@ -51,8 +94,12 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 	}
 
 	switch msg.ID {
+	case MsgChoke:
+		h.fsm.transition(StateChoked)
+		fmt.Println("Peer choked us")
+
 	case MsgUnchoke:
-		h.client.Choked = false
+		h.fsm.transition(StateUnchoked)
 		fmt.Println("Peer unchoked us")
 		if h.onUnchoke != nil {
 			h.onUnchoke()
@@ -60,11 +107,21 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 
 	case MsgInterested:
 		fmt.Println("Peer is interested")
-		// For now, we can unchoke them
-		return h.client.SendUnchoke()
+		h.chokeMu.Lock()
+		h.peerInterested = true
+		unchoke := h.unchokeOnInterest
+		h.chokeMu.Unlock()
+
+		// Without a choker every interested peer gets an upload slot
+		if unchoke {
+			return h.setChoking(false)
+		}
 
 	case MsgNotInterested:
 		fmt.Println("Peer is not interested")
+		h.chokeMu.Lock()
+		h.peerInterested = false
+		h.chokeMu.Unlock()
 
 	case MsgHave:
 		if len(msg.Payload) != 4 {
@@ -72,12 +129,18 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 		}
 
 		pieceIndex := int(binary.BigEndian.Uint32(msg.Payload))
+		if err := h.checkIndex(pieceIndex); err != nil {
+			return fmt.Errorf("invalid have: %w", err)
+		}
 		h.mu.Lock()
 		h.pieces[pieceIndex] = true
 		h.mu.Unlock()
 		fmt.Printf("Peer has piece %d\n", pieceIndex)
 
 	case MsgBitfield:
+		if err := h.checkBitfield(Bitfield(msg.Payload)); err != nil {
+			return err
+		}
 		h.client.Bitfield = Bitfield(msg.Payload)
 		fmt.Printf("Received bitfield (%d bytes)\n", len(msg.Payload))
 
@@ -95,16 +158,25 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 		if err != nil {
 			return fmt.Errorf("invalid request: %w", err)
 		}
+		if err := h.checkBlock(req.Index, req.Begin, req.Length); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
 
 		fmt.Printf("Peer requested piece %d, begin %d, length %d\n",
 			req.Index, req.Begin, req.Length)
-		// We would need to handle uploading here
+		if h.onRequest != nil {
+			h.onRequest(req)
+		}
 
 	case MsgPiece:
 		piece, err := ParsePiece(msg.Payload)
 		if err != nil {
 			return fmt.Errorf("invalid piece: %w", err)
 		}
+		if err := h.checkBlock(piece.Index, piece.Begin, len(piece.Block)); err != nil {
+			return fmt.Errorf("invalid piece: %w", err)
+		}
+		h.fsm.received()
 		fmt.Printf("Received piece %d, begin %d, length %d\n",
 			piece.Index, piece.Begin, len(piece.Block))
 		if h.onPiece != nil {
@@ -116,9 +188,37 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 		if err != nil {
 			return fmt.Errorf("invalid cancel: %w", err)
 		}
+		if err := h.checkBlock(req.Index, req.Begin, req.Length); err != nil {
+			return fmt.Errorf("invalid cancel: %w", err)
+		}
 		fmt.Printf("Peer cancelled request for piece %d, begin %d, length %d\n",
 			req.Index, req.Begin, req.Length)
 
+	case MsgHaveAll:
+		h.mu.Lock()
+		h.client.HaveAll = true
+		h.mu.Unlock()
+		fmt.Println("Peer has all pieces")
+
+	case MsgHaveNone:
+		fmt.Println("Peer has no pieces")
+
+	case MsgExtended:
+		if err := h.client.handleExtended(msg.Payload); err != nil {
+			return err
+		}
+		ext := h.client.ExtendedHandshake()
+		if ext.Version != "" {
+			fmt.Printf("Peer runs %s\n", ext.Version)
+		}
+		if h.onExtended != nil {
+			h.onExtended(ext)
+		}
+
+	case MsgSuggestPiece, MsgRejectRequest, MsgAllowedFast:
+		// Advisory; we pick pieces and time out requests on our own
+		fmt.Printf("Peer sent %s\n", msg)
+
 	default:
 		fmt.Printf("Unknown message type: %d\n", msg.ID)
 	}
@@ -126,24 +226,80 @@ func (h *MessageHandler) handleMessage(msg *Message) error {
 	return nil
 }
 
+// checkBitfield validates a bitfield from the peer once the torrent's
+// layout is known
+func (h *MessageHandler) checkBitfield(bf Bitfield) error {
+	if h.layout.NumPieces == 0 {
+		return nil
+	}
+	return h.layout.CheckBitfield(bf)
+}
+
+// checkIndex validates a piece index from the peer once the torrent's
+// layout is known
+func (h *MessageHandler) checkIndex(index int) error {
+	if h.layout.NumPieces == 0 {
+		return nil
+	}
+	return h.layout.CheckIndex(index)
+}
+
+// checkBlock validates the piece index and offsets of a block from the
+// peer once the torrent's layout is known
+func (h *MessageHandler) checkBlock(index, begin, length int) error {
+	if h.layout.NumPieces == 0 {
+		return nil
+	}
+	return h.layout.CheckBlock(index, begin, length)
+}
+
 // HasPiece returns true if the peer has a specific piece
 func (h *MessageHandler) HasPiece(index int) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.pieces[index]
+	return h.client.HaveAll || h.pieces[index]
 }
 
 // RequestPiece requests a block from the peer
 func (h *MessageHandler) RequestPiece(index, begin, length int) error {
-	if h.client.Choked {
-		return fmt.Errorf("cannot request piece: we are choked")
+	if state, _ := h.fsm.get(); state != StateUnchoked && state != StateSnubbed {
+		return fmt.Errorf("cannot request piece: peer is %s", state)
 	}
 
 	if !h.HasPiece(index) {
 		return fmt.Errorf("peer doesn't have piece %d", index)
 	}
 
-	return h.client.SendRequest(index, begin, length)
+	if err := h.client.SendRequest(index, begin, length); err != nil {
+		return err
+	}
+
+	h.fsm.requested()
+	return nil
+}
+
+// setChoking chokes or unchokes the peer, sending a message only when that
+// changes whether we serve its requests
+func (h *MessageHandler) setChoking(choke bool) error {
+	h.chokeMu.Lock()
+	defer h.chokeMu.Unlock()
+
+	if h.amChoking == choke {
+		return nil
+	}
+
+	var err error
+	if choke {
+		err = h.client.SendChoke()
+	} else {
+		err = h.client.SendUnchoke()
+	}
+	if err != nil {
+		return err
+	}
+
+	h.amChoking = choke
+	return nil
 }
 
 // SetOnUnchoke sets the callback for when we're unchoked
@@ -155,3 +311,8 @@ func (h *MessageHandler) SetOnUnchoke(callback func()) {
 func (h *MessageHandler) SetOnPiece(callback func(*Piece)) {
 	h.onPiece = callback
 }
+
+// SetOnRequest sets the callback for when the peer requests a block from us
+func (h *MessageHandler) SetOnRequest(callback func(*Request)) {
+	h.onRequest = callback
+}
@@ -1,92 +1,40 @@
 package peer
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/wire"
 )
 
 // Handshake represents a BitTorrent handshake message
-type Handshake struct {
-	ProtocolLen byte
-	Protocol    [19]byte
-	Reserved    [8]byte
-	InfoHash    [20]byte
-	PeerID      [20]byte
-}
+type Handshake = wire.Handshake
 
-// New creates a new handshake message
+// NewHandshake creates a new handshake message
 func NewHandshake(infoHash, peerID [20]byte) *Handshake {
-	return &Handshake{
-		ProtocolLen: 19,
-		Protocol:    [19]byte{'B', 'i', 't', 'T', 'o', 'r', 'r', 'e', 'n', 't', ' ', 'p', 'r', 'o', 't', 'o', 'c', 'o', 'l'},
-		Reserved:    [8]byte{0, 0, 0, 0, 0, 0, 0, 0}, // No extensions for now
-		InfoHash:    infoHash,
-		PeerID:      peerID,
-	}
-}
-
-// Serialize converts the handshake to bytes for sending
-func (h *Handshake) Serialize() []byte {
-	buf := make([]byte, 68)
-
-	buf[0] = h.ProtocolLen
-	copy(buf[1:20], h.Protocol[:])
-	copy(buf[20:28], h.Reserved[:])
-	copy(buf[28:48], h.InfoHash[:])
-	copy(buf[48:68], h.PeerID[:])
-
-	return buf
+	return wire.NewHandshake(infoHash, peerID)
 }
 
 // Read reads a handshake from an io.Reader
 func Read(r io.Reader) (*Handshake, error) {
-	lengthBuf := make([]byte, 1)
+	buf := make([]byte, wire.HandshakeLength)
 
-	_, err := io.ReadFull(r, lengthBuf)
-	if err != nil {
+	// Give up on other protocols before waiting for a whole handshake
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
 		return nil, err
 	}
-
-	protocolLen := lengthBuf[0]
-	if protocolLen != 19 {
-		return nil, fmt.Errorf("invalid protocol length: %d", protocolLen)
+	if err := wire.CheckProtocolLength(buf[0]); err != nil {
+		return nil, err
 	}
 
 	// Read the rest of the handshake
-	buf := make([]byte, 67)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
+	if _, err := io.ReadFull(r, buf[1:]); err != nil {
 		return nil, err
 	}
 
-	handshake := &Handshake{
-		ProtocolLen: protocolLen,
-	}
-
-	copy(handshake.Protocol[:], buf[0:19])
-	copy(handshake.Reserved[:], buf[19:27])
-	copy(handshake.InfoHash[:], buf[27:47])
-	copy(handshake.PeerID[:], buf[47:67])
-
-	// Verify protocol string
-	expectedProtocol := "BitTorrent protocol"
-	if string(handshake.Protocol[:]) != expectedProtocol {
-		return nil, fmt.Errorf("invalid protocol: %s", string(handshake.Protocol[:]))
-	}
-
-	return handshake, nil
-}
-
-// Validate checks if the handshake is valid for our torrent
-func (h *Handshake) Validate(expectedInfoHash [20]byte) error {
-	if !bytes.Equal(h.InfoHash[:], expectedInfoHash[:]) {
-		return fmt.Errorf("info hash mismatch: got %x, want %x", h.InfoHash, expectedInfoHash)
-	}
-
-	return nil
+	return wire.DecodeHandshake(buf)
 }
 
 // DoHandshake performs a complete handshake with a peer
@@ -115,3 +63,25 @@ func DoHandshake(conn net.Conn, infoHash, peerID [20]byte) (*Handshake, error) {
 
 	return peerHandshake, nil
 }
+
+// AcceptHandshake answers the handshake of a peer that connected to us. The
+// peer speaks first, so we only reply once we know it wants our torrent.
+func AcceptHandshake(conn net.Conn, infoHash, peerID [20]byte) (*Handshake, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	peerHandshake, err := Read(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+
+	if err := peerHandshake.Validate(infoHash); err != nil {
+		return nil, fmt.Errorf("handshake validation failed: %w", err)
+	}
+
+	if _, err := conn.Write(NewHandshake(infoHash, peerID).Serialize()); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	return peerHandshake, nil
+}
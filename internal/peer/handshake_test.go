@@ -3,6 +3,7 @@ package peer
 
 import (
 	"bytes"
+	"net"
 	"testing"
 )
 
@@ -64,3 +65,59 @@ func TestHandshakeValidation(t *testing.T) {
 		t.Errorf("Validate() error = nil, want error")
 	}
 }
+
+func TestAcceptHandshake(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3}
+	ourID := [20]byte{'s', 'e', 'e', 'd'}
+	theirID := [20]byte{'l', 'e', 'e', 'c', 'h'}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	type result struct {
+		handshake *Handshake
+		err       error
+	}
+	dialed := make(chan result, 1)
+	go func() {
+		h, err := DoHandshake(client, infoHash, theirID)
+		dialed <- result{h, err}
+	}()
+
+	accepted, err := AcceptHandshake(server, infoHash, ourID)
+	if err != nil {
+		t.Fatalf("AcceptHandshake() error = %v", err)
+	}
+	if accepted.PeerID != theirID {
+		t.Errorf("AcceptHandshake() peer ID = %q, want %q", accepted.PeerID, theirID)
+	}
+
+	res := <-dialed
+	if res.err != nil {
+		t.Fatalf("DoHandshake() error = %v", res.err)
+	}
+	if res.handshake.PeerID != ourID {
+		t.Errorf("DoHandshake() peer ID = %q, want %q", res.handshake.PeerID, ourID)
+	}
+}
+
+func TestHandshakeSupportsFast(t *testing.T) {
+	h := NewHandshake([20]byte{}, [20]byte{})
+	if !h.SupportsFast() {
+		t.Error("SupportsFast() = false for our handshake, want true")
+	}
+
+	parsed, err := Read(bytes.NewReader(h.Serialize()))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !parsed.SupportsFast() {
+		t.Error("SupportsFast() = false after round trip, want true")
+	}
+
+	h.Reserved = [8]byte{}
+	if h.SupportsFast() {
+		t.Error("SupportsFast() = true without the reserved bit")
+	}
+}
@@ -0,0 +1,107 @@
+package peer
+
+import (
+	"net"
+	"time"
+)
+
+// connectionAttemptDelay is how long an attempt gets before the next
+// address is tried in parallel (RFC 8305 section 5)
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// NewSessionRace connects to a peer reachable at several addresses, for
+// example over both IPv4 and IPv6. Attempts are started one after another,
+// alternating address families and starting with IPv6, each
+// connectionAttemptDelay after the previous one or as soon as it fails.
+// The first session to complete the handshake wins and the others are
+// closed.
+func NewSessionRace(addrs []string, infoHash, ourPeerID [20]byte) (*Session, error) {
+	if len(addrs) == 1 {
+		return NewSession(addrs[0], infoHash, ourPeerID)
+	}
+
+	addrs = interleaveFamilies(addrs)
+
+	type result struct {
+		session *Session
+		err     error
+	}
+	results := make(chan result, len(addrs))
+
+	next, pending := 0, 0
+	startNext := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			session, err := NewSession(addr, infoHash, ourPeerID)
+			results <- result{session, err}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connectionAttemptDelay)
+			}
+
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close the attempts that lost the race as they finish
+				go func(remaining int) {
+					for i := 0; i < remaining; i++ {
+						if r := <-results; r.session != nil {
+							r.session.Close()
+						}
+					}
+				}(pending)
+
+				return r.session, nil
+			}
+
+			lastErr = r.err
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// interleaveFamilies orders addresses alternating between IPv6 and IPv4,
+// starting with IPv6, keeping the order within each family
+func interleaveFamilies(addrs []string) []string {
+	var v6, v4 []string
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); err == nil && ip != nil && ip.To4() == nil {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+
+	ordered := make([]string, 0, len(addrs))
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			ordered = append(ordered, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			ordered = append(ordered, v4[0])
+			v4 = v4[1:]
+		}
+	}
+
+	return ordered
+}
@@ -0,0 +1,71 @@
+package peer
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	addrs := []string{"192.0.2.1:1", "192.0.2.2:2", "[2001:db8::1]:3"}
+	want := []string{"[2001:db8::1]:3", "192.0.2.1:1", "192.0.2.2:2"}
+
+	if got := interleaveFamilies(addrs); !reflect.DeepEqual(got, want) {
+		t.Errorf("interleaveFamilies() = %v, want %v", got, want)
+	}
+}
+
+// listenPeer starts a peer that accepts one connection; when stall is set
+// it never answers the handshake
+func listenPeer(t *testing.T, infoHash [20]byte, stall bool) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if stall {
+			time.Sleep(2 * time.Second)
+			return
+		}
+
+		if _, err := AcceptHandshake(conn, infoHash, [20]byte{'p'}); err != nil {
+			return
+		}
+		conn.Write((&Message{ID: MsgBitfield, Payload: []byte{0x80}}).Serialize())
+		time.Sleep(time.Second)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestNewSessionRace(t *testing.T) {
+	infoHash := [20]byte{9}
+	slow := listenPeer(t, infoHash, true)
+	fast := listenPeer(t, infoHash, false)
+
+	start := time.Now()
+	session, err := NewSessionRace([]string{slow, fast}, infoHash, [20]byte{'u'})
+	if err != nil {
+		t.Fatalf("NewSessionRace() error = %v", err)
+	}
+	defer session.Close()
+
+	if session.GetAddr() != fast {
+		t.Errorf("NewSessionRace() connected to %s, want %s", session.GetAddr(), fast)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("NewSessionRace() took %v, want the fast peer right after the attempt delay", elapsed)
+	}
+}
@@ -0,0 +1,80 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/leaktest"
+)
+
+// pipeSession returns a session over one end of a pipe whose other end
+// discards everything we send, and that other end
+func pipeSession(addr string) (*Session, net.Conn) {
+	a, b := net.Pipe()
+	go func() {
+		for {
+			if _, err := ReadMessage(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	fsm := newStateMachine(StateBitfield)
+	client := &Client{Conn: a}
+	return &Session{
+		client:     client,
+		handler:    newMessageHandler(client, fsm),
+		fsm:        fsm,
+		addr:       addr,
+		interested: true,
+		closed:     make(chan struct{}),
+	}, b
+}
+
+func TestPoolCloseAllLeavesNoGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	p := NewPool([20]byte{}, [20]byte{})
+	var remotes []net.Conn
+	for _, addr := range []string{"192.0.2.1:6881", "192.0.2.2:6881", "192.0.2.3:6881"} {
+		s, remote := pipeSession(addr)
+		remotes = append(remotes, remote)
+		if !p.addSession(s) {
+			t.Fatalf("addSession(%s) failed", addr)
+		}
+	}
+
+	p.CloseAll()
+	for _, remote := range remotes {
+		remote.Close()
+	}
+
+	if n := p.GetConnectedPeers(); n != 0 {
+		t.Errorf("GetConnectedPeers() = %d after CloseAll, want 0", n)
+	}
+}
+
+func TestPeerDisconnectClosesSession(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	p := NewPool([20]byte{}, [20]byte{})
+	s, remote := pipeSession("192.0.2.1:6881")
+	if !p.addSession(s) {
+		t.Fatal("addSession() failed")
+	}
+
+	// The peer going away ends the session and takes it out of the pool
+	remote.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for p.GetConnectedPeers() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.GetConnectedPeers(); n != 0 {
+		t.Fatalf("GetConnectedPeers() = %d after the peer disconnected, want 0", n)
+	}
+	if state := s.State(); state != StateClosing {
+		t.Errorf("State() = %s after the peer disconnected, want closing", state)
+	}
+}
@@ -0,0 +1,132 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/wire"
+)
+
+// ErrNoMetadata is returned by peers that can't send the info dictionary
+var ErrNoMetadata = errors.New("peer doesn't offer the metadata")
+
+// utMetadataID is the extended message ID we ask peers to send metadata
+// messages with
+const utMetadataID = 1
+
+// metadataTimeout bounds fetching the info dictionary from one peer
+const metadataTimeout = time.Minute
+
+// FetchMetadata downloads the info dictionary of a torrent known only by
+// its info hash, as for magnet links, from a peer supporting the metadata
+// extension (BEP 9). The dictionary is checked against the info hash.
+func FetchMetadata(ctx context.Context, peerAddr string, infoHash, ourPeerID [20]byte) ([]byte, error) {
+	conn, err := dialPeer(peerAddr, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", peerAddr, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	peerHandshake, err := DoHandshake(conn, infoHash, ourPeerID)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed with %s: %w", peerAddr, err)
+	}
+	if !peerHandshake.SupportsExtended() {
+		return nil, fmt.Errorf("%s: %w", peerAddr, ErrNoMetadata)
+	}
+	conn.SetDeadline(time.Now().Add(metadataTimeout))
+
+	clientVersionMu.RLock()
+	version := clientVersion
+	clientVersionMu.RUnlock()
+
+	h := &ExtendedHandshake{
+		Extensions: map[string]int{"ut_metadata": utMetadataID},
+		Version:    version,
+		RequestQ:   MaxRequestQueue,
+	}
+	if _, err := conn.Write((&Message{ID: MsgExtended, Payload: h.Serialize()}).Serialize()); err != nil {
+		return nil, fmt.Errorf("failed to send extended handshake to %s: %w", peerAddr, err)
+	}
+
+	var metadata []byte
+	var received []bool
+	remaining := 0
+	for metadata == nil || remaining > 0 {
+		msg, err := ReadMessage(conn)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from %s: %w", peerAddr, err)
+		}
+		if msg == nil || msg.ID != MsgExtended || len(msg.Payload) == 0 {
+			continue
+		}
+
+		// Request every piece once the peer tells us the size
+		if msg.Payload[0] == wire.ExtendedHandshakeID {
+			if metadata != nil {
+				continue
+			}
+			theirs, err := wire.ParseExtendedHandshake(msg.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", peerAddr, err)
+			}
+			id, size := theirs.Extensions["ut_metadata"], theirs.MetadataSize
+			if id == 0 || size == 0 {
+				return nil, fmt.Errorf("%s: %w", peerAddr, ErrNoMetadata)
+			}
+
+			metadata = make([]byte, size)
+			remaining = (size + wire.MetadataPieceSize - 1) / wire.MetadataPieceSize
+			received = make([]bool, remaining)
+			var requests bytes.Buffer
+			for piece := range remaining {
+				req := &wire.MetadataMessage{Type: wire.MetadataRequest, Piece: piece}
+				requests.Write((&Message{ID: MsgExtended, Payload: req.Serialize(byte(id))}).Serialize())
+			}
+			if _, err := conn.Write(requests.Bytes()); err != nil {
+				return nil, fmt.Errorf("failed to request the metadata from %s: %w", peerAddr, err)
+			}
+			continue
+		}
+
+		if msg.Payload[0] != utMetadataID || metadata == nil {
+			continue
+		}
+		m, err := wire.ParseMetadataMessage(msg.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", peerAddr, err)
+		}
+		switch m.Type {
+		case wire.MetadataReject:
+			return nil, fmt.Errorf("%s rejected metadata piece %d: %w", peerAddr, m.Piece, ErrNoMetadata)
+		case wire.MetadataData:
+		default:
+			continue
+		}
+
+		begin := m.Piece * wire.MetadataPieceSize
+		if m.TotalSize != len(metadata) || m.Piece >= len(received) ||
+			len(m.Data) != min(wire.MetadataPieceSize, len(metadata)-begin) {
+			return nil, fmt.Errorf("%s sent metadata piece %d that doesn't fit %d bytes of metadata", peerAddr, m.Piece, len(metadata))
+		}
+		if !received[m.Piece] {
+			copy(metadata[begin:], m.Data)
+			received[m.Piece] = true
+			remaining--
+		}
+	}
+
+	if sha1.Sum(metadata) != infoHash {
+		return nil, fmt.Errorf("metadata from %s doesn't match the info hash", peerAddr)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,91 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/wire"
+)
+
+// serveMetadata accepts one connection and sends metadata the way a peer
+// supporting ut_metadata does, rejecting the requests when reject is set
+func serveMetadata(t *testing.T, metadata []byte, reject bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	infoHash := sha1.Sum(metadata)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := AcceptHandshake(conn, infoHash, [20]byte{'s'}); err != nil {
+			return
+		}
+		const theirID = 7
+		h := &ExtendedHandshake{Extensions: map[string]int{"ut_metadata": theirID}, MetadataSize: len(metadata)}
+		conn.Write((&Message{ID: MsgBitfield, Payload: []byte{0xff}}).Serialize())
+		conn.Write((&Message{ID: MsgExtended, Payload: h.Serialize()}).Serialize())
+
+		var ours int
+		for {
+			msg, err := ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			if msg == nil || msg.ID != MsgExtended {
+				continue
+			}
+			if msg.Payload[0] == wire.ExtendedHandshakeID {
+				theirs, _ := wire.ParseExtendedHandshake(msg.Payload)
+				ours = theirs.Extensions["ut_metadata"]
+				continue
+			}
+
+			req, err := wire.ParseMetadataMessage(msg.Payload)
+			if err != nil || msg.Payload[0] != theirID {
+				return
+			}
+			resp := &wire.MetadataMessage{Type: wire.MetadataReject, Piece: req.Piece}
+			if !reject {
+				begin := req.Piece * wire.MetadataPieceSize
+				end := min(begin+wire.MetadataPieceSize, len(metadata))
+				resp = &wire.MetadataMessage{Type: wire.MetadataData, Piece: req.Piece, TotalSize: len(metadata), Data: metadata[begin:end]}
+			}
+			conn.Write((&Message{ID: MsgExtended, Payload: resp.Serialize(byte(ours))}).Serialize())
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestFetchMetadata(t *testing.T) {
+	metadata := append([]byte("d4:name"), bytes.Repeat([]byte("x"), 2*wire.MetadataPieceSize)...)
+	addr := serveMetadata(t, metadata, false)
+
+	got, err := FetchMetadata(context.Background(), addr, sha1.Sum(metadata), [20]byte{'c'})
+	if err != nil {
+		t.Fatalf("FetchMetadata() error = %v", err)
+	}
+	if !bytes.Equal(got, metadata) {
+		t.Errorf("FetchMetadata() = %d bytes, want the %d bytes served", len(got), len(metadata))
+	}
+}
+
+func TestFetchMetadataRejected(t *testing.T) {
+	metadata := []byte("d4:name4:teste")
+	addr := serveMetadata(t, metadata, true)
+
+	if _, err := FetchMetadata(context.Background(), addr, sha1.Sum(metadata), [20]byte{'c'}); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("FetchMetadata() error = %v, want ErrNoMetadata", err)
+	}
+}
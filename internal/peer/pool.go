@@ -1,7 +1,10 @@
 package peer
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +16,14 @@ type Pool struct {
 	InfoHash  [20]byte
 	OurPeerID [20]byte
 	Sessions  map[string]*Session
+	banned    map[string]bool
+	maxIn     int       // Incoming connections are refused beyond this many sessions
+	closed    ConnStats // Traffic of sessions no longer in the pool
 	mu        sync.Mutex
+
+	// OnSessionOpened is called for every new session after the handshake and
+	// before the session starts, so callbacks and our bitfield go out first
+	OnSessionOpened func(*Session)
 }
 
 // NewPool creates a new peer connection pool
@@ -22,47 +32,53 @@ func NewPool(infoHash, ourPeerID [20]byte) *Pool {
 		InfoHash:  infoHash,
 		OurPeerID: ourPeerID,
 		Sessions:  make(map[string]*Session),
+		banned:    make(map[string]bool),
 	}
 }
 
-// Connect attempts to connect to a list of peers
+// Connect attempts to connect to a list of peers. Addresses that belong
+// to the same peer, recognised by its peer ID, are raced against each other
+// so dual-stack peers are reached over whichever family works best.
 func (p *Pool) Connect(peers []tracker.Peer, maxConnections int) int {
 	connected := 0
 
+	ids := make(map[string][20]byte)
 	for _, peer := range peers {
+		ids[peer.String()] = peer.ID
+	}
+
+	for _, addrs := range groupPeerAddrs(peers) {
 		if connected >= maxConnections {
 			break
 		}
 
-		peerAddr := peer.String()
-
-		// Skip if already connected
+		// Skip if already connected, over any of the peer's addresses, or banned
 		p.mu.Lock()
-		if _, exists := p.Sessions[peerAddr]; exists {
-			p.mu.Unlock()
-			continue
+		var candidates []string
+		for _, addr := range addrs {
+			if _, exists := p.Sessions[addr]; !exists && !p.banned[addr] {
+				candidates = append(candidates, addr)
+			}
 		}
+		known := p.hasPeerID(ids[addrs[0]])
 		p.mu.Unlock()
 
+		if len(candidates) < len(addrs) || len(candidates) == 0 || known {
+			continue
+		}
+
 		// Try to connect
-		session, err := NewSession(peerAddr, p.InfoHash, p.OurPeerID)
+		session, err := NewSessionRace(candidates, p.InfoHash, p.OurPeerID)
 		if err != nil {
-			fmt.Printf("Failed to connect to peer %s: %v\n", peerAddr, err)
+			fmt.Printf("Failed to connect to peer %s: %v\n", strings.Join(candidates, ", "), err)
 			continue
 		}
 
-		// Start the session
-		if err := session.Start(); err != nil {
-			fmt.Printf("Failed to start session with %s: %v\n", peerAddr, err)
-			session.Close()
+		if !p.addSession(session) {
 			continue
 		}
 
-		p.mu.Lock()
-		p.Sessions[peerAddr] = session
-		p.mu.Unlock()
-
-		fmt.Printf("Successfully connected to peer %s\n", peerAddr)
+		fmt.Printf("Successfully connected to peer %s\n", session.GetAddr())
 		connected++
 
 		// Small delay between connection attempts
@@ -72,6 +88,161 @@ func (p *Pool) Connect(peers []tracker.Peer, maxConnections int) int {
 	return connected
 }
 
+// groupPeerAddrs groups the addresses of peers that share a peer ID, in the
+// order the peers were given. Peers without an ID stand alone.
+func groupPeerAddrs(peers []tracker.Peer) [][]string {
+	var groups [][]string
+	byID := make(map[[20]byte]int)
+
+	for _, peer := range peers {
+		addr := peer.String()
+		if peer.ID == ([20]byte{}) {
+			groups = append(groups, []string{addr})
+			continue
+		}
+
+		if i, ok := byID[peer.ID]; ok {
+			groups[i] = append(groups[i], addr)
+			continue
+		}
+
+		byID[peer.ID] = len(groups)
+		groups = append(groups, []string{addr})
+	}
+
+	return groups
+}
+
+// hasPeerID reports whether a session with the given peer ID is open, for
+// example over another of the peer's addresses; callers must hold p.mu
+func (p *Pool) hasPeerID(id [20]byte) bool {
+	if id == ([20]byte{}) {
+		return false
+	}
+
+	for _, session := range p.Sessions {
+		if session.client.PeerID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOnSessionOpened sets OnSessionOpened
+func (p *Pool) SetOnSessionOpened(callback func(*Session)) {
+	p.OnSessionOpened = callback
+}
+
+// addSession starts a new session and adds it to the pool
+func (p *Pool) addSession(session *Session) bool {
+	if p.OnSessionOpened != nil {
+		p.OnSessionOpened(session)
+	}
+
+	// Sessions leave the pool when they close, including when the peer
+	// drops the connection
+	session.setOnClose(func() { p.forget(session) })
+
+	p.mu.Lock()
+	p.Sessions[session.GetAddr()] = session
+	p.mu.Unlock()
+
+	// Start the session
+	if err := session.Start(); err != nil {
+		fmt.Printf("Failed to start session with %s: %v\n", session.GetAddr(), err)
+		session.Close()
+		return false
+	}
+
+	return true
+}
+
+// forget removes a session that closed from the pool
+func (p *Pool) forget(session *Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Sessions[session.GetAddr()] == session {
+		p.closed = p.closed.Add(session.Stats())
+		delete(p.Sessions, session.GetAddr())
+	}
+}
+
+// SetMaxSessions limits how many sessions incoming connections may fill
+func (p *Pool) SetMaxSessions(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxIn = n
+}
+
+// Listen accepts incoming peer connections on addr until the returned
+// listener is closed. Connections beyond SetMaxSessions are refused.
+func (p *Pool) Listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Printf("Failed to accept peer connection: %v\n", err)
+				}
+				return
+			}
+
+			go p.accept(conn)
+		}
+	}()
+
+	return listener, nil
+}
+
+// accept performs the handshake with an incoming peer and adds its session
+func (p *Pool) accept(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	p.mu.Lock()
+	refuse := (p.maxIn > 0 && len(p.Sessions) >= p.maxIn) || p.bannedHost(host)
+	p.mu.Unlock()
+	if refuse {
+		conn.Close()
+		return
+	}
+
+	tuneConn(conn)
+
+	conn, err := wrapSwarmTLS(conn, false, 30*time.Second)
+	if err != nil {
+		fmt.Printf("Incoming peer %s failed: %v\n", host, err)
+		return
+	}
+
+	session, err := NewIncomingSession(conn, p.InfoHash, p.OurPeerID)
+	if err != nil {
+		fmt.Printf("Incoming peer %s failed: %v\n", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	if p.addSession(session) {
+		fmt.Printf("Accepted connection from peer %s\n", session.GetAddr())
+	}
+}
+
+// bannedHost reports whether any address on host has been banned, since
+// incoming peers connect from an ephemeral port; callers must hold p.mu
+func (p *Pool) bannedHost(host string) bool {
+	for addr := range p.banned {
+		if h, _, err := net.SplitHostPort(addr); err == nil && h == host {
+			return true
+		}
+	}
+	return false
+}
+
 // GetConnectedPeers returns the number of connected peers
 func (p *Pool) GetConnectedPeers() int {
 	p.mu.Lock()
@@ -117,33 +288,88 @@ func (p *Pool) GetSessionsWithPiece(pieceIndex int) []*Session {
 	return sessions
 }
 
+// GetSessionsWithoutPiece returns all sessions that lack a specific piece
+func (p *Pool) GetSessionsWithoutPiece(pieceIndex int) []*Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var sessions []*Session
+	for _, session := range p.Sessions {
+		if !session.HasPiece(pieceIndex) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions
+}
+
 // CloseSession closes a connection to a specific peer
 func (p *Pool) CloseSession(addr string) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	session, exists := p.Sessions[addr]
+	p.mu.Unlock()
+
+	if exists {
+		session.Close()
+	}
+}
+
+// Ban closes the session with a peer and refuses future connections to it
+func (p *Pool) Ban(addr string) {
+	p.mu.Lock()
+	p.banned[addr] = true
+	session, exists := p.Sessions[addr]
+	p.mu.Unlock()
 
-	if session, exists := p.Sessions[addr]; exists {
+	if exists {
 		session.Close()
-		delete(p.Sessions, addr)
 	}
 }
 
+// IsBanned returns whether a peer has been banned
+func (p *Pool) IsBanned(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.banned[addr]
+}
+
 // CloseAll closes all peer connections
 func (p *Pool) CloseAll() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	sessions := make([]*Session, 0, len(p.Sessions))
+	for _, session := range p.Sessions {
+		sessions = append(sessions, session)
+	}
+	p.mu.Unlock()
 
-	for addr, session := range p.Sessions {
+	for _, session := range sessions {
 		session.Close()
-		delete(p.Sessions, addr)
 	}
 }
 
-// GetPeers returns all peer sessions
+// Stats returns the traffic exchanged with all peers since the pool was created
+func (p *Pool) Stats() ConnStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := p.closed
+	for _, session := range p.Sessions {
+		total = total.Add(session.Stats())
+	}
+
+	return total
+}
+
+// GetPeers returns a copy of all peer sessions by address
 func (p *Pool) GetPeers() map[string]*Session {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.Sessions
+
+	peers := make(map[string]*Session, len(p.Sessions))
+	for addr, session := range p.Sessions {
+		peers[addr] = session
+	}
+	return peers
 }
 
 // BroadcastHave sends a have message to all peers
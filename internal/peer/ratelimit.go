@@ -0,0 +1,239 @@
+package peer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the bytes per second passed
+// through it. A rate of zero means unlimited. The rate can be changed while
+// connections are using the limiter.
+//
+// When the bucket runs dry, waiting transfers are served by weighted fair
+// queuing between torrents: each torrent gets bandwidth in proportion to its
+// weight, so one busy swarm can't starve the others, while bandwidth a
+// torrent doesn't use goes to those that want more.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64     // Bytes per second, 0 for unlimited
+	tokens float64   // Bytes that can pass without waiting, negative when in debt
+	last   time.Time // When tokens were last refilled
+
+	weights  map[[20]byte]float64 // Torrents not listed have weight 1
+	finish   map[[20]byte]float64 // Virtual finish time of each torrent's last transfer
+	vtime    float64              // Virtual time: finish time of the last transfer served
+	queue    waitQueue
+	dispatch *time.Timer
+}
+
+// waiter is a transfer waiting for bandwidth
+type waiter struct {
+	n     int
+	tag   float64 // Virtual finish time; the lowest is served first
+	seq   uint64  // Arrival order, breaking ties between equal tags
+	ready chan struct{}
+}
+
+// NewRateLimiter creates a limiter passing rate bytes per second
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		last:    time.Now(),
+		weights: make(map[[20]byte]float64),
+		finish:  make(map[[20]byte]float64),
+	}
+}
+
+// DownloadLimiter and UploadLimiter are shared by every peer connection of
+// the process, limiting piece data received and sent respectively
+var (
+	DownloadLimiter = NewRateLimiter(0)
+	UploadLimiter   = NewRateLimiter(0)
+)
+
+// SetRateLimits sets the global download and upload limits in bytes per
+// second, 0 meaning unlimited
+func SetRateLimits(download, upload int64) {
+	DownloadLimiter.SetRate(download)
+	UploadLimiter.SetRate(upload)
+}
+
+// SetTorrentWeight sets the share of the global limits a torrent gets
+// while torrents compete for bandwidth, relative to the default weight of 1.
+// A weight of zero or less restores the default.
+func SetTorrentWeight(infoHash [20]byte, weight float64) {
+	DownloadLimiter.SetWeight(infoHash, weight)
+	UploadLimiter.SetWeight(infoHash, weight)
+}
+
+// SetRate changes the limit, 0 meaning unlimited
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+	l.dispatchLocked()
+}
+
+// Rate returns the limit in bytes per second, 0 meaning unlimited
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// SetWeight sets the relative share of a torrent, 0 or less meaning the
+// default of 1
+func (l *RateLimiter) SetWeight(infoHash [20]byte, weight float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if weight <= 0 {
+		delete(l.weights, infoHash)
+		return
+	}
+	l.weights[infoHash] = weight
+}
+
+// Wait blocks until n bytes of the torrent with the given info hash may
+// pass. The bytes are taken up front, so a block larger than the burst size
+// makes the next transfer wait instead.
+func (l *RateLimiter) Wait(infoHash [20]byte, n int) {
+	if ready := l.reserve(infoHash, n); ready != nil {
+		<-ready
+	}
+}
+
+// reserve takes n bytes from the bucket when transfers may pass, or queues
+// the transfer and returns a channel closed once it may pass
+func (l *RateLimiter) reserve(infoHash [20]byte, n int) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return nil
+	}
+
+	w := &waiter{n: n, tag: l.tag(infoHash, n), seq: l.queue.seq, ready: make(chan struct{})}
+	l.queue.seq++
+
+	l.refill(time.Now())
+	if len(l.queue.waiters) == 0 && l.tokens >= 0 {
+		l.serve(w)
+		return nil
+	}
+
+	heap.Push(&l.queue, w)
+	l.dispatchLocked()
+	return w.ready
+}
+
+// tag computes the virtual finish time of a transfer: a torrent's transfers
+// follow each other in virtual time, at a pace inversely proportional to its
+// weight, and an idle torrent starts again from the current virtual time
+func (l *RateLimiter) tag(infoHash [20]byte, n int) float64 {
+	weight, ok := l.weights[infoHash]
+	if !ok {
+		weight = 1
+	}
+
+	start := l.finish[infoHash]
+	if start < l.vtime {
+		start = l.vtime
+	}
+
+	tag := start + float64(n)/weight
+	l.finish[infoHash] = tag
+
+	// Torrents that fell behind the virtual time no longer need an entry
+	if len(l.finish) > 64 {
+		for hash, finish := range l.finish {
+			if finish <= l.vtime {
+				delete(l.finish, hash)
+			}
+		}
+	}
+
+	return tag
+}
+
+// refill adds the tokens earned since the last refill. Bursts are limited
+// to a second's worth of data.
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+}
+
+// serve lets a transfer pass, taking its bytes from the bucket
+func (l *RateLimiter) serve(w *waiter) {
+	l.tokens -= float64(w.n)
+	if w.tag > l.vtime {
+		l.vtime = w.tag
+	}
+	close(w.ready)
+}
+
+// dispatchLocked serves queued transfers in order of their finish time
+// while the bucket allows, and schedules itself for when it next will
+func (l *RateLimiter) dispatchLocked() {
+	if l.dispatch != nil {
+		l.dispatch.Stop()
+		l.dispatch = nil
+	}
+
+	if l.rate <= 0 {
+		// The limit was lifted: let everyone through
+		for len(l.queue.waiters) > 0 {
+			close(heap.Pop(&l.queue).(*waiter).ready)
+		}
+		return
+	}
+
+	l.refill(time.Now())
+	for len(l.queue.waiters) > 0 && l.tokens >= 0 {
+		l.serve(heap.Pop(&l.queue).(*waiter))
+	}
+
+	if len(l.queue.waiters) == 0 {
+		return
+	}
+
+	delay := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	l.dispatch = time.AfterFunc(delay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.dispatchLocked()
+	})
+}
+
+// waitQueue is a heap of waiting transfers ordered by finish time
+type waitQueue struct {
+	waiters []*waiter
+	seq     uint64
+}
+
+func (q *waitQueue) Len() int { return len(q.waiters) }
+
+func (q *waitQueue) Less(i, j int) bool {
+	a, b := q.waiters[i], q.waiters[j]
+	if a.tag != b.tag {
+		return a.tag < b.tag
+	}
+	return a.seq < b.seq
+}
+
+func (q *waitQueue) Swap(i, j int) { q.waiters[i], q.waiters[j] = q.waiters[j], q.waiters[i] }
+
+func (q *waitQueue) Push(x interface{}) { q.waiters = append(q.waiters, x.(*waiter)) }
+
+func (q *waitQueue) Pop() interface{} {
+	last := q.waiters[len(q.waiters)-1]
+	q.waiters = q.waiters[:len(q.waiters)-1]
+	return last
+}
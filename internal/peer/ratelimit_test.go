@@ -0,0 +1,69 @@
+package peer
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(1000)
+	var torrent [20]byte
+
+	// A second's worth of data may burst once the bucket has filled
+	l.last = time.Now().Add(-2 * time.Second)
+	if ready := l.reserve(torrent, 1000); ready != nil {
+		t.Error("reserve within burst had to wait")
+	}
+
+	// The bucket is empty now, so the next transfer waits its turn
+	start := time.Now()
+	l.Wait(torrent, 100)
+	l.Wait(torrent, 100)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("transfers beyond burst passed after %v, want about 100ms", elapsed)
+	}
+
+	// Unlimited never waits
+	l.SetRate(0)
+	if ready := l.reserve(torrent, 1<<20); ready != nil {
+		t.Error("unlimited reserve had to wait")
+	}
+}
+
+func TestRateLimiterWeightedFairShare(t *testing.T) {
+	l := NewRateLimiter(1000)
+	hot, cold := [20]byte{1}, [20]byte{2}
+	l.SetWeight(hot, 3)
+
+	// Run the bucket dry so every transfer queues
+	l.mu.Lock()
+	l.tokens = -1e9
+	l.mu.Unlock()
+
+	owner := make(map[chan struct{}][20]byte)
+	for i := 0; i < 6; i++ {
+		owner[l.reserve(hot, 100)] = hot
+		owner[l.reserve(cold, 100)] = cold
+	}
+
+	// The first eight transfers served go 3:1 to the heavier torrent
+	l.mu.Lock()
+	served := make(map[[20]byte]int)
+	for i := 0; i < 8; i++ {
+		served[owner[heap.Pop(&l.queue).(*waiter).ready]]++
+	}
+	l.mu.Unlock()
+
+	if served[hot] != 6 || served[cold] != 2 {
+		t.Errorf("served hot %d, cold %d; want 6 and 2", served[hot], served[cold])
+	}
+
+	// Lifting the limit releases everyone still waiting
+	l.SetRate(0)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.queue.waiters) != 0 {
+		t.Errorf("%d transfers still waiting after lifting the limit", len(l.queue.waiters))
+	}
+}
@@ -0,0 +1,92 @@
+package peer
+
+import (
+	"sync"
+	"time"
+)
+
+// RateWindows are the rolling windows over which the blocks and bytes
+// exchanged with a peer are reported, shortest first
+var RateWindows = []time.Duration{10 * time.Second, 30 * time.Second, 60 * time.Second}
+
+// rateHistory is the number of one-second buckets kept, enough for the
+// longest window
+const rateHistory = 60
+
+// PeerRates is the block data exchanged with a peer over a rolling window
+type PeerRates struct {
+	Window         time.Duration
+	BlocksReceived int
+	BlocksSent     int
+	DownloadRate   int64 // Block bytes per second received from the peer
+	UploadRate     int64 // Block bytes per second sent to the peer
+}
+
+// rateBucket is the block data exchanged during one second
+type rateBucket struct {
+	second              int64 // Unix second the bucket holds, 0 when unused
+	blocksIn, blocksOut int
+	bytesIn, bytesOut   int64
+}
+
+// transferRates records the blocks exchanged with a peer per second over
+// the last rateHistory seconds
+type transferRates struct {
+	mu      sync.Mutex
+	buckets [rateHistory]rateBucket
+}
+
+// add records a block of n bytes received from or sent to the peer
+func (r *transferRates) add(now time.Time, received bool, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	second := now.Unix()
+	b := &r.buckets[second%rateHistory]
+	if b.second != second {
+		*b = rateBucket{second: second}
+	}
+
+	if received {
+		b.blocksIn++
+		b.bytesIn += int64(n)
+	} else {
+		b.blocksOut++
+		b.bytesOut += int64(n)
+	}
+}
+
+// over returns the blocks exchanged during the window up to now. Rates
+// are averaged over the whole window, so a peer that connected recently
+// ranks below one that kept the same speed for longer.
+func (r *transferRates) over(now time.Time, window time.Duration) PeerRates {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seconds := int64(window / time.Second)
+	if seconds > rateHistory {
+		seconds = rateHistory
+	}
+
+	rates := PeerRates{Window: window}
+	if seconds <= 0 {
+		return rates
+	}
+
+	var bytesIn, bytesOut int64
+	last := now.Unix()
+	for _, b := range r.buckets {
+		if b.second == 0 || b.second > last || b.second <= last-seconds {
+			continue
+		}
+
+		rates.BlocksReceived += b.blocksIn
+		rates.BlocksSent += b.blocksOut
+		bytesIn += b.bytesIn
+		bytesOut += b.bytesOut
+	}
+
+	rates.DownloadRate = bytesIn / seconds
+	rates.UploadRate = bytesOut / seconds
+	return rates
+}
@@ -2,72 +2,326 @@ package peer
 
 import (
 	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/piyushgupta53/go-torrent/internal/tracker"
 )
 
 // Session represents an active session with a peer
 type Session struct {
-	client  *Client
-	handler *MessageHandler
-	addr    string
-	mu      sync.Mutex
+	client     *Client
+	handler    *MessageHandler
+	fsm        *stateMachine
+	addr       string
+	interested bool // Whether Start tells the peer we want its pieces
+	mu         sync.Mutex
+
+	closed    chan struct{} // Closed when the session closes
+	closeOnce sync.Once
+	onClose   func() // Called once the session has closed
+
+	suggested   map[int]bool // Pieces already suggested to the peer
+	connectedAt time.Time    // When the connection was established
+	listenPort  int          // Sent in the extended handshake, 0 when we don't accept connections
+	incoming    bool         // The peer connected to us, from an ephemeral port
 }
 
-// NewSession creates a new peer session
+// Keep-alives are only sent once we have been silent towards the peer for a
+// random time in this range; the jitter keeps sessions from firing together
+const (
+	keepAliveMinIdle = 90 * time.Second
+	keepAliveMaxIdle = 110 * time.Second
+)
+
+// NewSession creates a new peer session. The session is returned in the
+// bitfield state once connected and moves on to choked when started.
 func NewSession(peerAdrr string, infoHash, ourPeerID [20]byte) (*Session, error) {
-	client, err := NewClient(peerAdrr, infoHash, ourPeerID)
+	fsm := newStateMachine(StateConnecting)
+	client, err := connectClient(peerAdrr, infoHash, ourPeerID, func(state SessionState) {
+		fsm.transition(state)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	handler := NewMessageHandler(client)
+	return &Session{
+		client:      client,
+		handler:     newMessageHandler(client, fsm),
+		fsm:         fsm,
+		addr:        peerAdrr,
+		interested:  true,
+		closed:      make(chan struct{}),
+		connectedAt: time.Now(),
+	}, nil
+}
+
+// NewIncomingSession creates a session for a connection a peer opened to us
+func NewIncomingSession(conn net.Conn, infoHash, ourPeerID [20]byte) (*Session, error) {
+	fsm := newStateMachine(StateHandshaking)
+	client, err := NewIncomingClient(conn, infoHash, ourPeerID)
+	if err != nil {
+		return nil, err
+	}
+	fsm.transition(StateBitfield)
 
 	return &Session{
-		client:  client,
-		handler: handler,
-		addr:    peerAdrr,
+		client:      client,
+		handler:     newMessageHandler(client, fsm),
+		fsm:         fsm,
+		addr:        conn.RemoteAddr().String(),
+		interested:  true,
+		closed:      make(chan struct{}),
+		connectedAt: time.Now(),
+		incoming:    true,
 	}, nil
 }
 
-// Start begins the session
+// Start begins the session, ending the bitfield exchange. Peers choke
+// us until they say otherwise.
 func (s *Session) Start() error {
+	// The bitfield sent right after the handshake must fit the torrent too
+	if len(s.client.Bitfield) > 0 {
+		if err := s.handler.checkBitfield(s.client.Bitfield); err != nil {
+			return err
+		}
+	}
+
+	s.fsm.transition(StateChoked)
+
+	// Tell extension protocol peers who we are, after our bitfield
+	if s.client.Extended {
+		s.mu.Lock()
+		err := s.client.SendExtendedHandshake(s.listenPort, s.peerIP())
+		s.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to send extended handshake: %w", err)
+		}
+	}
+
 	// Send interested message
-	if err := s.client.SendInterested(); err != nil {
-		return fmt.Errorf("failed to send interested: %w", err)
+	if s.interested {
+		if err := s.client.SendInterested(); err != nil {
+			return fmt.Errorf("failed to send interested: %w", err)
+		}
 	}
 
-	// Start the message handler's processing loop
+	// Start the message handler's processing loop; a connection the peer
+	// dropped closes the session, stopping the routines below
+	s.handler.onExit = func() { s.Close() }
 	s.handler.Start()
 
-	// Start a goroutine to keep the connection alive
+	// Start goroutines to keep the connection alive and notice snubs
 	go s.keepAliveRoutine()
+	go s.snubRoutine()
 
 	return nil
 }
 
-// keepAliveRoutine sends periodic keep-alive messages
-func (s *Session) keepAliveRoutine() {
-	ticker := time.NewTicker(2 * time.Minute)
+// snubRoutine marks the session snubbed when the peer stops answering our
+// requests while unchoking us, until the session closes
+func (s *Session) snubRoutine() {
+	ticker := time.NewTicker(snubCheckInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			s.fsm.checkSnub(now)
+		}
+	}
+}
+
+// keepAliveDelay picks how long we may stay silent before a keep-alive
+func keepAliveDelay() time.Duration {
+	return keepAliveMinIdle + time.Duration(rand.Int63n(int64(keepAliveMaxIdle-keepAliveMinIdle)))
+}
+
+// keepAliveRoutine sends a keep-alive whenever nothing else has been sent to
+// the peer for a while, until the session closes
+func (s *Session) keepAliveRoutine() {
+	idle := keepAliveDelay()
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-timer.C:
+		}
+
+		// Any message sent in the meantime pushes the keep-alive back
+		if since := time.Since(s.client.LastSent()); since < idle {
+			timer.Reset(idle - since)
+			continue
+		}
+
 		s.mu.Lock()
-		if err := s.client.SendKeepAlive(); err != nil {
+		err := s.client.SendKeepAlive()
+		s.mu.Unlock()
+		if err != nil {
 			fmt.Printf("Failed to send keep-alive to %s: %v\n", s.addr, err)
-			s.mu.Unlock()
 			return
 		}
-		s.mu.Unlock()
+
+		idle = keepAliveDelay()
+		timer.Reset(idle)
 	}
 }
 
-// IsChoked returns whether we're choked by this peer
-func (s *Session) IsChoked() bool {
+// SetLayout sets the torrent's piece layout before Start, so bitfields,
+// piece indices and block offsets that don't fit it drop the peer
+func (s *Session) SetLayout(layout Layout) {
+	s.handler.layout = layout
+}
+
+// SetListenPort sets the port the extended handshake tells the peer we
+// accept connections on; without one the port is left out
+func (s *Session) SetListenPort(port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listenPort = port
+}
+
+// peerIP returns the peer's address, which the extended handshake reports
+// back to it, or nil if the address isn't an IP
+func (s *Session) peerIP() net.IP {
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// ExtendedHandshake returns the extended handshake the peer sent, or nil
+// if it hasn't sent one (yet)
+func (s *Session) ExtendedHandshake() *ExtendedHandshake {
+	return s.client.ExtendedHandshake()
+}
+
+// SetOnExtendedHandshake sets the callback for when the peer sends its
+// extended handshake
+func (s *Session) SetOnExtendedHandshake(callback func(*ExtendedHandshake)) {
+	s.handler.onExtended = callback
+}
+
+// ListenAddrs returns the addresses the peer accepts connections on as far
+// as we know, each carrying its peer ID: the one we connected to and the
+// other addresses of a multihomed peer from its extended handshake, so a
+// dropped connection can be retried over another address family
+func (s *Session) ListenAddrs() []tracker.Peer {
+	host, portStr, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	port, _ := strconv.Atoi(portStr)
+
+	// Incoming connections come from an ephemeral port; only the extended
+	// handshake tells us where the peer listens
+	h := s.client.ExtendedHandshake()
+	if h != nil && h.Port > 0 {
+		port = h.Port
+	} else if s.incoming {
+		return nil
+	}
+
+	peers := []tracker.Peer{{ID: s.client.PeerID, IP: ip, Port: port}}
+	if h == nil {
+		return peers
+	}
+	for _, alt := range []net.IP{h.IPv4, h.IPv6} {
+		if alt != nil && !alt.Equal(ip) && !alt.IsUnspecified() {
+			peers = append(peers, tracker.Peer{ID: s.client.PeerID, IP: alt, Port: port})
+		}
+	}
+
+	return peers
+}
+
+// RequestQueue returns the number of requests the peer says it queues, or 0
+// if it didn't say
+func (s *Session) RequestQueue() int {
+	if h := s.client.ExtendedHandshake(); h != nil {
+		return h.RequestQ
+	}
+	return 0
+}
+
+// SetInterested controls whether Start tells the peer we want its pieces,
+// for sessions that only upload
+func (s *Session) SetInterested(interested bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.interested = interested
+}
+
+// IsChoked returns whether we're choked by this peer. Peers choke us until
+// they unchoke us, so sessions that haven't started count as choked.
+func (s *Session) IsChoked() bool {
+	state := s.State()
+	return state != StateUnchoked && state != StateSnubbed
+}
+
+// PeerInterested returns whether the peer wants pieces from us
+func (s *Session) PeerInterested() bool {
+	s.handler.chokeMu.Lock()
+	defer s.handler.chokeMu.Unlock()
+	return s.handler.peerInterested
+}
+
+// AmChoking returns whether we refuse the peer's requests. Peers are choked
+// until they get an upload slot.
+func (s *Session) AmChoking() bool {
+	s.handler.chokeMu.Lock()
+	defer s.handler.chokeMu.Unlock()
+	return s.handler.amChoking
+}
+
+// SetUnchokeOnInterest controls whether the peer is unchoked as soon as it
+// is interested; a choker that hands out upload slots turns this off
+func (s *Session) SetUnchokeOnInterest(unchoke bool) {
+	s.handler.chokeMu.Lock()
+	defer s.handler.chokeMu.Unlock()
+	s.handler.unchokeOnInterest = unchoke
+}
+
+// Choke stops serving the peer's requests
+func (s *Session) Choke() error {
+	return s.handler.setChoking(true)
+}
+
+// Unchoke gives the peer an upload slot
+func (s *Session) Unchoke() error {
+	return s.handler.setChoking(false)
+}
+
+// ConnectedAt returns when the connection to the peer was established
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// State returns the state of the session
+func (s *Session) State() SessionState {
+	state, _ := s.fsm.get()
+	return state
+}
+
+// StateSince returns when the session entered its current state
+func (s *Session) StateSince() time.Time {
+	_, since := s.fsm.get()
+	return since
+}
 
-	return s.client.Choked
+// SetOnStateChange sets the callback for every state change of the session
+func (s *Session) SetOnStateChange(callback func(from, to SessionState)) {
+	s.fsm.setOnChange(callback)
 }
 
 // HasPiece returns whether the peer has a specific piece
@@ -90,16 +344,98 @@ func (s *Session) SetOnPiece(callback func(*Piece)) {
 	s.handler.SetOnPiece(callback)
 }
 
+// SetOnRequest sets the callback for when the peer requests a block from us
+func (s *Session) SetOnRequest(callback func(*Request)) {
+	s.handler.SetOnRequest(callback)
+}
+
+// SendPiece sends a block of piece data to the peer
+func (s *Session) SendPiece(index, begin int, block []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.SendPiece(index, begin, block)
+}
+
+// SupportsFast reports whether the Fast extension is enabled with the peer
+func (s *Session) SupportsFast() bool {
+	return s.client.Fast
+}
+
+// SuggestPiece points a Fast extension peer at a piece we can serve
+// cheaply. Each piece is suggested at most once.
+func (s *Session) SuggestPiece(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.suggested[index] {
+		return nil
+	}
+	if s.suggested == nil {
+		s.suggested = make(map[int]bool)
+	}
+	s.suggested[index] = true
+
+	return s.client.SendSuggestPiece(index)
+}
+
+// RejectRequest tells a Fast extension peer we won't serve a request
+func (s *Session) RejectRequest(req *Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.SendRejectRequest(req.Index, req.Begin, req.Length)
+}
+
+// SendHaveAll tells a Fast extension peer we have every piece
+func (s *Session) SendHaveAll() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.SendHaveAll()
+}
+
+// SendHaveNone tells a Fast extension peer we have no pieces
+func (s *Session) SendHaveNone() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.SendHaveNone()
+}
+
+// SendBitfield tells the peer which pieces we have
+func (s *Session) SendBitfield(bf Bitfield) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.SendBitfield(bf)
+}
+
 // Close closes the session
 func (s *Session) Close() error {
+	closing := false
+	s.closeOnce.Do(func() {
+		s.fsm.transition(StateClosing)
+		close(s.closed)
+		closing = true
+	})
+
+	s.mu.Lock()
+	err := s.client.Close()
+	onClose := s.onClose
+	s.mu.Unlock()
+
+	if closing && onClose != nil {
+		onClose()
+	}
+	return err
+}
+
+// setOnClose sets the callback run once the session has closed
+func (s *Session) setOnClose(callback func()) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.client.Close()
+	s.onClose = callback
 }
 
 // String returns a string representation of the session
 func (s *Session) String() string {
-	return fmt.Sprintf("Session{addr=%s, choked=%v}", s.addr, s.IsChoked())
+	return fmt.Sprintf("Session{addr=%s, state=%s}", s.addr, s.State())
 }
 
 // SendInterested sends an interested message to the peer
@@ -112,6 +448,26 @@ func (s *Session) Read() (*Message, error) {
 	return s.client.Read()
 }
 
+// Stats returns the bytes exchanged with the peer
+func (s *Session) Stats() ConnStats {
+	return s.client.Stats()
+}
+
+// Rates returns the block data exchanged with the peer over each of
+// RateWindows
+func (s *Session) Rates() []PeerRates {
+	rates := make([]PeerRates, len(RateWindows))
+	for i, window := range RateWindows {
+		rates[i] = s.client.Rates(window)
+	}
+	return rates
+}
+
+// Rate returns the block data exchanged with the peer over one window
+func (s *Session) Rate(window time.Duration) PeerRates {
+	return s.client.Rates(window)
+}
+
 // GetAddr returns the peer's address
 func (s *Session) GetAddr() string {
 	return s.addr
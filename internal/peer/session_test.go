@@ -0,0 +1,133 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveDelay(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := keepAliveDelay(); d < keepAliveMinIdle || d >= keepAliveMaxIdle {
+			t.Fatalf("keepAliveDelay() = %v, want within [%v, %v)", d, keepAliveMinIdle, keepAliveMaxIdle)
+		}
+	}
+}
+
+func TestClientLastSent(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	client := &Client{Conn: a}
+	go ReadMessage(b)
+
+	before := time.Now()
+	if err := client.SendHave(1); err != nil {
+		t.Fatalf("SendHave() error = %v", err)
+	}
+
+	if got := client.LastSent(); got.Before(before) {
+		t.Errorf("LastSent() = %v, want after %v", got, before)
+	}
+}
+
+func TestSessionCloseStopsKeepAlive(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	s := &Session{client: &Client{Conn: a}, fsm: newStateMachine(StateChoked), closed: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		s.keepAliveRoutine()
+		close(done)
+	}()
+
+	s.Close()
+	s.Close() // closing twice is harmless
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keep-alive routine still running after Close")
+	}
+}
+
+func TestExtendedHandshakeExchange(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	ours := &Client{Conn: a, Extended: true}
+	theirs := NewMessageHandler(&Client{Conn: b, Extended: true})
+
+	go ours.SendExtendedHandshake(6881, net.IPv4(192, 0, 2, 1))
+	msg, err := ReadMessage(b)
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if err := theirs.handleMessage(msg); err != nil {
+		t.Fatalf("handleMessage() error = %v", err)
+	}
+
+	s := &Session{client: theirs.client, handler: theirs}
+	h := s.ExtendedHandshake()
+	if h == nil || h.Version != ClientVersion || h.Port != 6881 || !h.YourIP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("ExtendedHandshake() = %+v", h)
+	}
+	if n := s.RequestQueue(); n != MaxRequestQueue {
+		t.Errorf("RequestQueue() = %d, want %d", n, MaxRequestQueue)
+	}
+
+	// Anonymous clients leave the version out
+	SetClientVersion("")
+	defer SetClientVersion(ClientVersion)
+	go ours.SendExtendedHandshake(0, nil)
+	msg, _ = ReadMessage(b)
+	theirs.handleMessage(msg)
+	if h := s.ExtendedHandshake(); h.Version != "" || h.Port != 0 {
+		t.Errorf("ExtendedHandshake() = %+v, want no version or port", h)
+	}
+}
+
+func TestSessionListenAddrs(t *testing.T) {
+	id := [20]byte{'-', 'G', 'T'}
+	client := &Client{PeerID: id}
+	client.extended.Store(&ExtendedHandshake{IPv6: net.ParseIP("2001:db8::1")})
+	s := &Session{client: client, addr: "192.0.2.1:6881"}
+
+	want := []string{"192.0.2.1:6881", "[2001:db8::1]:6881"}
+	got := s.ListenAddrs()
+	if len(got) != len(want) {
+		t.Fatalf("ListenAddrs() = %v, want %v", got, want)
+	}
+	for i, p := range got {
+		if p.String() != want[i] || p.ID != id {
+			t.Errorf("ListenAddrs()[%d] = %s with ID %q, want %s with the peer's ID", i, p.String(), p.ID, want[i])
+		}
+	}
+
+	// An incoming peer's port is ephemeral until its handshake names one
+	s.incoming = true
+	if got := s.ListenAddrs(); len(got) != 0 {
+		t.Errorf("ListenAddrs() = %v for an incoming peer without a port, want none", got)
+	}
+	client.extended.Store(&ExtendedHandshake{Port: 51413, IPv4: net.IPv4(192, 0, 2, 1)})
+	if got := s.ListenAddrs(); len(got) != 1 || got[0].String() != "192.0.2.1:51413" {
+		t.Errorf("ListenAddrs() = %v, want only 192.0.2.1:51413", got)
+	}
+}
+
+func TestPoolHasPeerID(t *testing.T) {
+	p := NewPool([20]byte{}, [20]byte{})
+	id := [20]byte{'-', 'G', 'T'}
+	p.Sessions["192.0.2.1:6881"] = &Session{client: &Client{PeerID: id}}
+
+	if !p.hasPeerID(id) {
+		t.Error("hasPeerID() = false for a connected peer")
+	}
+	if p.hasPeerID([20]byte{'x'}) || p.hasPeerID([20]byte{}) {
+		t.Error("hasPeerID() = true for a peer that isn't connected")
+	}
+}
@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// SocketOptions are the TCP settings applied to every peer connection
+type SocketOptions struct {
+	NoDelay     bool          // Send small messages such as requests without waiting to coalesce them
+	KeepAlive   time.Duration // Interval of TCP keep-alive probes, negative to disable
+	ReadBuffer  int           // Receive buffer in bytes, 0 for the OS default
+	WriteBuffer int           // Send buffer in bytes, 0 for the OS default
+}
+
+// DefaultSocketOptions returns the settings used unless configured
+// otherwise. Buffer sizes are left to the OS: Linux and macOS grow them to
+// the path's bandwidth-delay product on their own, while a fixed size turns
+// that auto-tuning off. Set them on systems that don't auto-tune.
+func DefaultSocketOptions() SocketOptions {
+	return SocketOptions{
+		NoDelay:   true,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+var (
+	socketOptionsMu sync.RWMutex
+	socketOptions   = DefaultSocketOptions()
+)
+
+// SetSocketOptions sets the TCP options of peer connections opened from now on
+func SetSocketOptions(opts SocketOptions) {
+	socketOptionsMu.Lock()
+	defer socketOptionsMu.Unlock()
+	socketOptions = opts
+}
+
+// tuneConn applies the socket options to a TCP connection; other
+// connections are left as they are
+func tuneConn(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	socketOptionsMu.RLock()
+	opts := socketOptions
+	socketOptionsMu.RUnlock()
+
+	var errs []error
+	errs = append(errs, tcp.SetNoDelay(opts.NoDelay))
+	if opts.KeepAlive < 0 {
+		errs = append(errs, tcp.SetKeepAlive(false))
+	} else if opts.KeepAlive > 0 {
+		errs = append(errs, tcp.SetKeepAlive(true), tcp.SetKeepAlivePeriod(opts.KeepAlive))
+	}
+	if opts.ReadBuffer > 0 {
+		errs = append(errs, tcp.SetReadBuffer(opts.ReadBuffer))
+	}
+	if opts.WriteBuffer > 0 {
+		errs = append(errs, tcp.SetWriteBuffer(opts.WriteBuffer))
+	}
+
+	// The connection still works with the OS defaults
+	for _, err := range errs {
+		if err != nil {
+			fmt.Printf("Failed to set socket options for %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
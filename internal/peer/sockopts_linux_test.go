@@ -0,0 +1,112 @@
+package peer
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// loopbackPair returns both ends of a TCP connection over loopback
+func loopbackPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	other := <-accepted
+	if other == nil {
+		t.Fatal("Accept() failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		other.Close()
+	})
+
+	return dialed.(*net.TCPConn), other.(*net.TCPConn)
+}
+
+// sockopt reads an integer socket option of a connection
+func sockopt(t *testing.T, conn *net.TCPConn, level, opt int) int {
+	t.Helper()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn() error = %v", err)
+	}
+
+	var value int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatalf("Control() error = %v", err)
+	}
+	if optErr != nil {
+		t.Fatalf("GetsockoptInt(%d, %d) error = %v", level, opt, optErr)
+	}
+	return value
+}
+
+func TestTuneConnLoopback(t *testing.T) {
+	t.Cleanup(func() { SetSocketOptions(DefaultSocketOptions()) })
+
+	const buffer = 256 * 1024
+	SetSocketOptions(SocketOptions{
+		NoDelay:     true,
+		KeepAlive:   45 * time.Second,
+		ReadBuffer:  buffer,
+		WriteBuffer: buffer,
+	})
+	conn, _ := loopbackPair(t)
+	tuneConn(conn)
+
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got == 0 {
+		t.Error("TCP_NODELAY off, want on")
+	}
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got == 0 {
+		t.Error("SO_KEEPALIVE off, want on")
+	}
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 45 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 45", got)
+	}
+	// Linux doubles the buffer sizes to leave room for its bookkeeping
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF); got < buffer {
+		t.Errorf("SO_RCVBUF = %d, want at least %d", got, buffer)
+	}
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF); got < buffer {
+		t.Errorf("SO_SNDBUF = %d, want at least %d", got, buffer)
+	}
+
+	// A negative interval turns off the keep-alive Go enables when dialing
+	SetSocketOptions(SocketOptions{NoDelay: false, KeepAlive: -1})
+	conn, _ = loopbackPair(t)
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got == 0 {
+		t.Fatal("SO_KEEPALIVE off before tuneConn, want Go's default of on")
+	}
+	tuneConn(conn)
+
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+		t.Error("TCP_NODELAY on, want off")
+	}
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got != 0 {
+		t.Error("SO_KEEPALIVE on with a negative KeepAlive, want off")
+	}
+}
@@ -0,0 +1,160 @@
+package peer
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionState is the stage a peer session is in
+type SessionState int
+
+const (
+	StateConnecting  SessionState = iota // Dialing the peer
+	StateHandshaking                     // Exchanging handshakes
+	StateBitfield                        // Exchanging the pieces we have, until Start
+	StateChoked                          // The peer won't serve our requests
+	StateUnchoked                        // The peer serves our requests
+	StateSnubbed                         // Unchoked, but the peer stopped sending blocks
+	StateClosing                         // The session is shutting down
+)
+
+// String returns the name of the state, as shown to users
+func (s SessionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateHandshaking:
+		return "handshaking"
+	case StateBitfield:
+		return "bitfield"
+	case StateChoked:
+		return "choked"
+	case StateUnchoked:
+		return "unchoked"
+	case StateSnubbed:
+		return "snubbed"
+	case StateClosing:
+		return "closing"
+	default:
+		return "unknown"
+	}
+}
+
+// sessionTransitions lists the states each state may move to. A session
+// can close from anywhere, and nothing follows closing.
+var sessionTransitions = map[SessionState][]SessionState{
+	StateConnecting:  {StateHandshaking, StateClosing},
+	StateHandshaking: {StateBitfield, StateClosing},
+	StateBitfield:    {StateChoked, StateClosing},
+	StateChoked:      {StateUnchoked, StateClosing},
+	StateUnchoked:    {StateChoked, StateSnubbed, StateClosing},
+	StateSnubbed:     {StateUnchoked, StateChoked, StateClosing},
+}
+
+// canTransition reports whether a session may move between two states
+func canTransition(from, to SessionState) bool {
+	for _, next := range sessionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// A peer that leaves our requests unanswered this long while unchoking us
+// is snubbing us
+const (
+	snubTimeout       = 60 * time.Second
+	snubCheckInterval = 10 * time.Second
+)
+
+// stateMachine tracks the state of a session and tells the transition hook
+// about every change
+type stateMachine struct {
+	mu       sync.Mutex
+	state    SessionState
+	since    time.Time
+	onChange func(from, to SessionState)
+
+	// waitingSince is when we started waiting on a request the peer
+	// hasn't answered with a block, zero when nothing is outstanding
+	waitingSince time.Time
+}
+
+// newStateMachine creates a state machine starting in the given state
+func newStateMachine(state SessionState) *stateMachine {
+	return &stateMachine{state: state, since: time.Now()}
+}
+
+// get returns the current state and when it was entered
+func (m *stateMachine) get() (SessionState, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.since
+}
+
+// transition moves to a new state, ignoring moves the state machine doesn't
+// allow such as an unchoke after the session started closing. It reports
+// whether the state changed.
+func (m *stateMachine) transition(to SessionState) bool {
+	m.mu.Lock()
+	from := m.state
+	if !canTransition(from, to) {
+		m.mu.Unlock()
+		return false
+	}
+
+	m.state = to
+	m.since = time.Now()
+	if to != StateUnchoked && to != StateSnubbed {
+		m.waitingSince = time.Time{} // A choke discards our requests
+	}
+	onChange := m.onChange
+	m.mu.Unlock()
+
+	if onChange != nil {
+		onChange(from, to)
+	}
+	return true
+}
+
+// setOnChange sets the transition hook
+func (m *stateMachine) setOnChange(callback func(from, to SessionState)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = callback
+}
+
+// requested records that we sent the peer a request
+func (m *stateMachine) requested() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.waitingSince.IsZero() {
+		m.waitingSince = time.Now()
+	}
+}
+
+// received records a block from the peer, which ends a snub
+func (m *stateMachine) received() {
+	m.mu.Lock()
+	m.waitingSince = time.Time{}
+	snubbed := m.state == StateSnubbed
+	m.mu.Unlock()
+
+	if snubbed {
+		m.transition(StateUnchoked)
+	}
+}
+
+// checkSnub moves an unchoked session to snubbed once a request has gone
+// unanswered for longer than snubTimeout
+func (m *stateMachine) checkSnub(now time.Time) {
+	m.mu.Lock()
+	snubbed := m.state == StateUnchoked && !m.waitingSince.IsZero() && now.Sub(m.waitingSince) > snubTimeout
+	m.mu.Unlock()
+
+	if snubbed {
+		m.transition(StateSnubbed)
+	}
+}
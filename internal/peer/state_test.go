@@ -0,0 +1,222 @@
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStateMachineTransitions(t *testing.T) {
+	fsm := newStateMachine(StateConnecting)
+
+	var changes []string
+	fsm.setOnChange(func(from, to SessionState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+
+	for _, to := range []SessionState{StateHandshaking, StateBitfield, StateChoked, StateUnchoked, StateChoked} {
+		if !fsm.transition(to) {
+			t.Fatalf("transition(%s) refused", to)
+		}
+	}
+
+	// Skipping ahead is refused, and nothing follows closing
+	if fsm.transition(StateSnubbed) {
+		t.Error("transition(snubbed) allowed while choked")
+	}
+	if !fsm.transition(StateClosing) || fsm.transition(StateUnchoked) {
+		t.Error("unchoke allowed after closing")
+	}
+
+	want := []string{
+		"connecting->handshaking", "handshaking->bitfield", "bitfield->choked",
+		"choked->unchoked", "unchoked->choked", "choked->closing",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("hook saw %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %s, want %s", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestStateMachineSnub(t *testing.T) {
+	fsm := newStateMachine(StateUnchoked)
+	now := time.Now()
+
+	// Nothing outstanding, nothing to snub
+	fsm.checkSnub(now.Add(2 * snubTimeout))
+	if state, _ := fsm.get(); state != StateUnchoked {
+		t.Fatalf("state = %s with no requests, want unchoked", state)
+	}
+
+	fsm.requested()
+	fsm.checkSnub(now.Add(snubTimeout / 2))
+	if state, _ := fsm.get(); state != StateUnchoked {
+		t.Fatalf("state = %s before the timeout, want unchoked", state)
+	}
+
+	fsm.checkSnub(now.Add(2 * snubTimeout))
+	if state, _ := fsm.get(); state != StateSnubbed {
+		t.Fatalf("state = %s after the timeout, want snubbed", state)
+	}
+
+	// A block ends the snub
+	fsm.received()
+	if state, _ := fsm.get(); state != StateUnchoked {
+		t.Errorf("state = %s after a block, want unchoked", state)
+	}
+}
+
+func TestMessageHandlerChoke(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for {
+			if _, err := ReadMessage(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	h := NewMessageHandler(&Client{Conn: a, HaveAll: true})
+	if err := h.RequestPiece(0, 0, 16384); err == nil {
+		t.Error("RequestPiece() succeeded while choked")
+	}
+
+	h.handleMessage(&Message{ID: MsgUnchoke})
+	if err := h.RequestPiece(0, 0, 16384); err != nil {
+		t.Errorf("RequestPiece() error = %v after unchoke", err)
+	}
+
+	h.handleMessage(&Message{ID: MsgChoke})
+	if state, _ := h.fsm.get(); state != StateChoked {
+		t.Errorf("state = %s after choke, want choked", state)
+	}
+}
+
+func TestMessageHandlerInterest(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	sent := make(chan MessageID, 10)
+	go func() {
+		for {
+			msg, err := ReadMessage(b)
+			if err != nil {
+				return
+			}
+			sent <- msg.ID
+		}
+	}()
+
+	s := &Session{handler: NewMessageHandler(&Client{Conn: a})}
+	if !s.AmChoking() || s.PeerInterested() {
+		t.Fatal("new session should choke an uninterested peer")
+	}
+
+	// Without a choker an interested peer is unchoked right away
+	s.handler.handleMessage(&Message{ID: MsgInterested})
+	if id := <-sent; id != MsgUnchoke || s.AmChoking() || !s.PeerInterested() {
+		t.Fatalf("sent %v after interested, want unchoke", id)
+	}
+
+	// Choking twice sends a single message
+	s.handler.handleMessage(&Message{ID: MsgNotInterested})
+	s.Choke()
+	s.Choke()
+	s.SetUnchokeOnInterest(false)
+	s.handler.handleMessage(&Message{ID: MsgInterested})
+	s.Unchoke()
+	for _, want := range []MessageID{MsgChoke, MsgUnchoke} {
+		if id := <-sent; id != want {
+			t.Errorf("sent %v, want %v", id, want)
+		}
+	}
+	if s.AmChoking() || !s.PeerInterested() {
+		t.Error("peer should be interested and unchoked")
+	}
+}
+
+func TestMessageHandlerDropsInvalidBitfield(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	h := NewMessageHandler(&Client{Conn: a})
+	h.layout = Layout{NumPieces: 11, PieceLength: 4, TotalLength: 42}
+
+	if err := h.handleMessage(&Message{ID: MsgBitfield, Payload: []byte{0xff, 0xe0}}); err != nil {
+		t.Fatalf("handleMessage(valid bitfield) error = %v", err)
+	}
+	if !h.HasPiece(10) {
+		t.Error("HasPiece(10) = false after a full bitfield")
+	}
+
+	// Spare bits set: the peer is dropped and the bitfield ignored
+	exited := make(chan struct{})
+	h.onExit = func() { close(exited) }
+	h.Start()
+	if _, err := b.Write((&Message{ID: MsgBitfield, Payload: []byte{0x00, 0x10}}).Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("message loop still running after an invalid bitfield")
+	}
+	if h.client.Bitfield[1] != 0xe0 {
+		t.Errorf("bitfield = %08b after an invalid one, want the previous one", h.client.Bitfield)
+	}
+}
+
+func TestSessionStartRejectsInvalidBitfield(t *testing.T) {
+	client := &Client{Bitfield: Bitfield{0xff}}
+	s := &Session{client: client, handler: NewMessageHandler(client), fsm: newStateMachine(StateBitfield)}
+	s.SetLayout(Layout{NumPieces: 11, PieceLength: 4, TotalLength: 42})
+
+	if err := s.Start(); !errors.Is(err, ErrInvalidBitfield) {
+		t.Errorf("Start() = %v, want ErrInvalidBitfield", err)
+	}
+}
+
+func TestMessageHandlerChecksIndices(t *testing.T) {
+	h := NewMessageHandler(&Client{})
+	h.layout = Layout{NumPieces: 3, PieceLength: 16, TotalLength: 42}
+
+	var delivered int
+	h.SetOnRequest(func(*Request) { delivered++ })
+	h.SetOnPiece(func(*Piece) { delivered++ })
+
+	have := make([]byte, 4)
+	binary.BigEndian.PutUint32(have, 3)
+
+	for _, msg := range []*Message{
+		{ID: MsgHave, Payload: have},
+		{ID: MsgRequest, Payload: SerializeRequest(3, 0, 16)},
+		{ID: MsgRequest, Payload: SerializeRequest(2, 0, 16)},
+		{ID: MsgPiece, Payload: SerializePiece(0, 8, make([]byte, 16))},
+		{ID: MsgCancel, Payload: SerializeRequest(1, 16, 1)},
+	} {
+		if err := h.handleMessage(msg); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("handleMessage(%s) = %v, want ErrOutOfRange", msg, err)
+		}
+	}
+
+	if delivered != 0 || h.HasPiece(3) {
+		t.Errorf("out of range messages were used: %d delivered, HasPiece(3) = %v", delivered, h.HasPiece(3))
+	}
+
+	// Messages within the torrent go through
+	h.handleMessage(&Message{ID: MsgRequest, Payload: SerializeRequest(2, 0, 10)})
+	h.handleMessage(&Message{ID: MsgPiece, Payload: SerializePiece(1, 0, make([]byte, 16))})
+	if delivered != 2 {
+		t.Errorf("%d valid messages delivered, want 2", delivered)
+	}
+}
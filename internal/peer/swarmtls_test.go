@@ -0,0 +1,110 @@
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSwarmCertificate creates a self-signed certificate and key and
+// returns the swarm TLS configuration loaded from them
+func writeSwarmCertificate(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "swarm"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "swarm.crt"), filepath.Join(dir, "swarm.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	config, err := NewSwarmTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewSwarmTLSConfig() error = %v", err)
+	}
+	return config
+}
+
+// handshakePair runs the swarm TLS handshake between two configurations
+// and returns the client's error
+func handshakePair(client, server *tls.Config) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			tls.Server(conn, server).Handshake()
+			conn.Close()
+		}
+	}()
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+
+	conn := tls.Client(raw, client)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn.Handshake()
+}
+
+func TestSwarmTLSSameCertificate(t *testing.T) {
+	config := writeSwarmCertificate(t)
+
+	if err := handshakePair(config, config); err != nil {
+		t.Errorf("handshake with the swarm certificate failed: %v", err)
+	}
+}
+
+func TestSwarmTLSRejectsOtherCertificate(t *testing.T) {
+	ours := writeSwarmCertificate(t)
+	theirs := writeSwarmCertificate(t)
+
+	if err := handshakePair(ours, theirs); !errors.Is(err, ErrUnknownSwarmCertificate) {
+		t.Errorf("handshake error = %v, want %v", err, ErrUnknownSwarmCertificate)
+	}
+}
+
+func TestWrapSwarmTLSDisabled(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	conn, err := wrapSwarmTLS(a, true, time.Second)
+	if err != nil || conn != a {
+		t.Errorf("wrapSwarmTLS() without a config = %v, %v, want the plain connection", conn, err)
+	}
+}
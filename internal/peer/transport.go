@@ -0,0 +1,52 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ProxyDialFunc opens a connection through a proxy, like net.Dialer.DialContext
+type ProxyDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+var (
+	proxyMu   sync.RWMutex
+	proxyDial ProxyDialFunc
+)
+
+// SetProxy routes every peer connection through dial, for example a SOCKS5
+// proxy
+func SetProxy(dial ProxyDialFunc) {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	proxyDial = dial
+}
+
+// dialPeer connects to a peer address, and tunnels the connection through
+// TLS in private swarm mode
+func dialPeer(peerAddr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialTransport(peerAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	tuneConn(conn)
+
+	return wrapSwarmTLS(conn, true, timeout)
+}
+
+// dialTransport opens the underlying TCP connection to a peer address,
+// through the proxy if one is set
+func dialTransport(peerAddr string, timeout time.Duration) (net.Conn, error) {
+	proxyMu.RLock()
+	proxy := proxyDial
+	proxyMu.RUnlock()
+
+	if proxy != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return proxy(ctx, "tcp", peerAddr)
+	}
+
+	return net.DialTimeout("tcp", peerAddr, timeout)
+}
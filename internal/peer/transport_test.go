@@ -0,0 +1,28 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialTransportProxy(t *testing.T) {
+	var network, proxied string
+	SetProxy(func(ctx context.Context, n, addr string) (net.Conn, error) {
+		network, proxied = n, addr
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	defer SetProxy(nil)
+
+	conn, err := dialTransport("192.0.2.1:6881", time.Second)
+	if err != nil {
+		t.Fatalf("dialTransport() through the proxy error = %v", err)
+	}
+	conn.Close()
+	if network != "tcp" || proxied != "192.0.2.1:6881" {
+		t.Errorf("proxy dialed %s %s, want tcp 192.0.2.1:6881", network, proxied)
+	}
+}
@@ -0,0 +1,269 @@
+// Package socks implements the client side of SOCKS5 (RFC 1928) CONNECT
+// and UDP ASSOCIATE, with username/password authentication (RFC 1929), for
+// routing traffic through proxies such as Tor.
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrAuthFailed      = errors.New("socks proxy authentication failed")
+	ErrRefused         = errors.New("socks proxy refused the connection")
+	ErrUDPNotSupported = errors.New("socks proxy does not relay UDP")
+
+	errInvalidAddr = errors.New("socks: invalid address")
+)
+
+const (
+	version5 = 0x05
+
+	authNone     = 0x00
+	authPassword = 0x02
+
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+
+	replyCommandNotSupported = 0x07
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// Dialer opens connections through a SOCKS5 proxy. Hostnames are sent to
+// the proxy unresolved, so no DNS lookups leak outside the proxy.
+type Dialer struct {
+	ProxyAddr string // host:port of the proxy
+	Username  string // Optional; Tor isolates streams by credentials
+	Password  string
+	Timeout   time.Duration // Limit on connecting and negotiating with the proxy
+}
+
+// NewDialer creates a dialer for the proxy at addr
+func NewDialer(addr string) *Dialer {
+	return &Dialer{ProxyAddr: addr, Timeout: 30 * time.Second}
+}
+
+// DialContext connects to addr (host:port) through the proxy
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("socks: unsupported network %s", network)
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("socks: failed to reach proxy: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := d.connect(conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect negotiates authentication and a CONNECT to addr
+func (d *Dialer) connect(conn net.Conn, addr string) error {
+	if err := d.greet(conn); err != nil {
+		return err
+	}
+
+	_, err := request(conn, cmdConnect, addr)
+	return err
+}
+
+// greet negotiates the authentication method and authenticates
+func (d *Dialer) greet(conn net.Conn) error {
+	// Greeting: offer password auth only when we have credentials
+	method := byte(authNone)
+	if d.Username != "" {
+		method = authPassword
+	}
+	if _, err := conn.Write([]byte{version5, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != version5 || reply[1] != method {
+		return ErrAuthFailed
+	}
+
+	if method == authPassword {
+		return d.authenticate(conn)
+	}
+	return nil
+}
+
+// request sends a command for addr and returns the address the proxy bound
+// for it
+func request(conn net.Conn, cmd byte, addr string) (*Addr, error) {
+	req, err := appendAddr([]byte{version5, cmd, 0}, addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	// Reply: version, status, reserved, bound address
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[1] == replyCommandNotSupported && cmd == cmdUDPAssociate {
+		return nil, ErrUDPNotSupported
+	}
+	if header[1] != 0 {
+		return nil, fmt.Errorf("%w: %s (code %d)", ErrRefused, addr, header[1])
+	}
+
+	return readAddr(conn)
+}
+
+// Addr is a host and port as a proxy reports them; the host is a name
+// when the proxy didn't resolve it
+type Addr struct {
+	Host string
+	Port int
+}
+
+func (a *Addr) Network() string { return "udp" }
+func (a *Addr) String() string  { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+
+// appendAddr appends addr (host:port) in the address format of the
+// protocol: its type, the address and the port. Host names are left for
+// the proxy to resolve.
+func appendAddr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("socks: invalid port %q", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks: host name too long")
+		}
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, atypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, atypIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readAddr reads an address in the format of the protocol
+func readAddr(r io.Reader) (*Addr, error) {
+	// The type and the first byte of the address, the length of names
+	b := make([]byte, 2, 2+255+2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	var rest int
+	switch b[0] {
+	case atypIPv4:
+		rest = net.IPv4len - 1
+	case atypIPv6:
+		rest = net.IPv6len - 1
+	case atypDomain:
+		rest = int(b[1])
+	default:
+		return nil, fmt.Errorf("socks: invalid address type %d in reply", b[0])
+	}
+
+	b = b[:2+rest+2]
+	if _, err := io.ReadFull(r, b[2:]); err != nil {
+		return nil, err
+	}
+
+	addr, _, err := parseAddr(b)
+	return addr, err
+}
+
+// parseAddr decodes the address at the start of b and returns its length
+func parseAddr(b []byte) (*Addr, int, error) {
+	if len(b) < 2 {
+		return nil, 0, errInvalidAddr
+	}
+
+	var host string
+	var n int
+	switch b[0] {
+	case atypIPv4:
+		n = 1 + net.IPv4len
+	case atypIPv6:
+		n = 1 + net.IPv6len
+	case atypDomain:
+		n = 2 + int(b[1])
+	default:
+		return nil, 0, errInvalidAddr
+	}
+	if len(b) < n+2 {
+		return nil, 0, errInvalidAddr
+	}
+
+	if b[0] == atypDomain {
+		host = string(b[2:n])
+	} else {
+		host = net.IP(b[1:n]).String()
+	}
+
+	return &Addr{Host: host, Port: int(binary.BigEndian.Uint16(b[n:]))}, n + 2, nil
+}
+
+// authenticate performs username/password authentication
+func (d *Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return fmt.Errorf("socks: credentials too long")
+	}
+
+	req := []byte{0x01, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
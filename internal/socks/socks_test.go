@@ -0,0 +1,183 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// serveSOCKS runs a single-connection SOCKS5 server that answers the
+// CONNECT with status and, on success, echoes data back. It reports the
+// requested destination on dest.
+func serveSOCKS(t *testing.T, username, password string, status byte) (string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	dest := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		io.ReadFull(conn, buf[:3])
+		method := buf[2]
+		conn.Write([]byte{version5, method})
+
+		if method == authPassword {
+			io.ReadFull(conn, buf[:2])
+			user := make([]byte, buf[1])
+			io.ReadFull(conn, user)
+			io.ReadFull(conn, buf[:1])
+			pass := make([]byte, buf[0])
+			io.ReadFull(conn, pass)
+
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		io.ReadFull(conn, buf[:5])
+		host := make([]byte, buf[4])
+		io.ReadFull(conn, host)
+		io.ReadFull(conn, buf[:2])
+		dest <- string(host)
+
+		conn.Write([]byte{version5, status, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+		if status == 0 {
+			io.Copy(conn, conn)
+		}
+	}()
+
+	return ln.Addr().String(), dest
+}
+
+func TestDialSendsHostname(t *testing.T) {
+	addr, dest := serveSOCKS(t, "", "", 0)
+
+	conn, err := NewDialer(addr).DialContext(context.Background(), "tcp", "tracker.onion:80")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-dest; got != "tracker.onion" {
+		t.Errorf("proxy asked for %q, want the unresolved host name", got)
+	}
+
+	conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Errorf("relayed %q, %v, want ping", reply, err)
+	}
+}
+
+func TestDialAuthentication(t *testing.T) {
+	addr, _ := serveSOCKS(t, "user", "secret", 0)
+
+	d := NewDialer(addr)
+	d.Username, d.Password = "user", "wrong"
+	if _, err := d.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("DialContext() error = %v, want %v", err, ErrAuthFailed)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	addr, _ := serveSOCKS(t, "", "", 0x05)
+
+	if _, err := NewDialer(addr).DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, ErrRefused) {
+		t.Errorf("DialContext() error = %v, want %v", err, ErrRefused)
+	}
+}
+
+// serveUDPAssociate runs a SOCKS5 server answering one UDP ASSOCIATE with
+// status. Its relay sends every datagram back as it came, so the sender
+// of the reply is the destination of the request.
+func serveUDPAssociate(t *testing.T, status byte) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			relay.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 10)
+		io.ReadFull(conn, buf[:3])
+		conn.Write([]byte{version5, authNone})
+		io.ReadFull(conn, buf) // UDP ASSOCIATE 0.0.0.0:0
+
+		// Answer with an unspecified address for our own
+		port := relay.LocalAddr().(*net.UDPAddr).Port
+		conn.Write([]byte{version5, status, 0, atypIPv4, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+		io.Copy(io.Discard, conn)
+	}()
+
+	return ln.Addr().String()
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+func TestListenPacket(t *testing.T) {
+	conn, err := NewDialer(serveUDPAssociate(t, 0)).ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer conn.Close()
+
+	tracker := &Addr{Host: "tracker.example", Port: 6969}
+	if _, err := conn.WriteTo([]byte("connect"), tracker); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if string(buf[:n]) != "connect" || from.String() != tracker.String() {
+		t.Errorf("ReadFrom() = %q from %v, want %q from %v", buf[:n], from, "connect", tracker)
+	}
+}
+
+func TestListenPacketUnsupported(t *testing.T) {
+	_, err := NewDialer(serveUDPAssociate(t, replyCommandNotSupported)).ListenPacket(context.Background())
+	if !errors.Is(err, ErrUDPNotSupported) {
+		t.Errorf("ListenPacket() error = %v, want %v", err, ErrUDPNotSupported)
+	}
+}
@@ -0,0 +1,138 @@
+package socks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// maxUDPHeader is the longest header of a relayed datagram: reserved bytes,
+// fragment number and an address holding a 255 byte host name
+const maxUDPHeader = 3 + 2 + 255 + 2
+
+// PacketConn sends and receives datagrams through a UDP association of a
+// SOCKS5 proxy. Addresses may be host names, which the proxy resolves.
+// The association ends when the proxy closes its control connection.
+type PacketConn struct {
+	ctrl  net.Conn     // TCP connection the association lives as long as
+	relay *net.UDPConn // Connected to the relay address of the proxy
+}
+
+// ListenPacket asks the proxy to relay UDP for us. It returns
+// ErrUDPNotSupported when the proxy can't, as is the case for Tor, so
+// callers can turn off whatever needs UDP instead of leaking it past the
+// proxy.
+func (d *Dialer) ListenPacket(ctx context.Context) (*PacketConn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	ctrl, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("socks: failed to reach proxy: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		ctrl.SetDeadline(deadline)
+	}
+
+	relay, err := d.associate(ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	ctrl.SetDeadline(time.Time{})
+	c := &PacketConn{ctrl: ctrl, relay: relay}
+
+	// The control connection carries nothing more; the proxy closing it
+	// ends the association
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		relay.Close()
+	}()
+
+	return c, nil
+}
+
+// associate negotiates a UDP association and connects a UDP socket to the
+// relay address of the proxy
+func (d *Dialer) associate(ctrl net.Conn) (*net.UDPConn, error) {
+	if err := d.greet(ctrl); err != nil {
+		return nil, err
+	}
+
+	// We don't know which address our datagrams will come from behind
+	// NAT, so leave it unspecified
+	bound, err := request(ctrl, cmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+
+	// Proxies may answer with an unspecified address, meaning their own
+	ip := net.ParseIP(bound.Host)
+	if ip == nil || ip.IsUnspecified() {
+		ip = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	relay, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: bound.Port})
+	if err != nil {
+		return nil, fmt.Errorf("socks: failed to reach UDP relay: %w", err)
+	}
+	return relay, nil
+}
+
+// ReadFrom reads the next datagram relayed to us and returns its sender.
+// Fragmented datagrams are dropped.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxUDPHeader+len(p))
+	for {
+		n, err := c.relay.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Reserved, fragment number, sender address, data
+		if n < 3 || buf[2] != 0 {
+			continue
+		}
+		addr, length, err := parseAddr(buf[3:n])
+		if err != nil {
+			continue
+		}
+
+		var from net.Addr = addr
+		if ip := net.ParseIP(addr.Host); ip != nil {
+			from = &net.UDPAddr{IP: ip, Port: addr.Port}
+		}
+		return copy(p, buf[3+length:n]), from, nil
+	}
+}
+
+// WriteTo sends a datagram to addr through the relay
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	datagram, err := appendAddr([]byte{0, 0, 0}, addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.relay.Write(append(datagram, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the association
+func (c *PacketConn) Close() error {
+	c.relay.Close()
+	return c.ctrl.Close()
+}
+
+func (c *PacketConn) LocalAddr() net.Addr                { return c.relay.LocalAddr() }
+func (c *PacketConn) SetDeadline(t time.Time) error      { return c.relay.SetDeadline(t) }
+func (c *PacketConn) SetReadDeadline(t time.Time) error  { return c.relay.SetReadDeadline(t) }
+func (c *PacketConn) SetWriteDeadline(t time.Time) error { return c.relay.SetWriteDeadline(t) }
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// Encrypted state files are laid out as
+// magic | salt | nonce | AES-256-GCM ciphertext
+var encryptedMagic = []byte("GTSTATE1")
+
+const (
+	saltSize        = 16
+	keySize         = 32
+	pbkdf2Iteration = 600000 // OWASP recommendation for PBKDF2-HMAC-SHA256
+)
+
+// isEncrypted reports whether the file contents are an encrypted state file
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// deriveKey derives the file key from the passphrase with PBKDF2-HMAC-SHA256
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iteration, keySize)
+}
+
+// newGCM creates the AEAD for a passphrase and salt
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals the state with a key derived from the passphrase
+func encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), encryptedMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+
+	// The header is authenticated so it cannot be swapped
+	return gcm.Seal(out, nonce, plaintext, out), nil
+}
+
+// decrypt opens an encrypted state file
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	if len(data) < len(encryptedMagic)+saltSize {
+		return nil, ErrWrongPassphrase
+	}
+
+	salt := data[len(encryptedMagic) : len(encryptedMagic)+saltSize]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(encryptedMagic) + saltSize + gcm.NonceSize()
+	if len(data) < headerLen+gcm.Overhead() {
+		return nil, ErrWrongPassphrase
+	}
+
+	nonce := data[len(encryptedMagic)+saltSize : headerLen]
+	plaintext, err := gcm.Open(nil, nonce, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,25 @@
+package state
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// Known answer computed independently with Python's
+	// hashlib.pbkdf2_hmac("sha256", passphrase, salt, 600000, 32)
+	const want = "ef177144eec9420cbc1093d2a8b344a92bc506d0d4ec9c028dd19f8324d8c1e6"
+
+	salt := make([]byte, saltSize)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	key, err := deriveKey("correct horse battery staple", salt)
+	if err != nil {
+		t.Fatalf("deriveKey() error = %v", err)
+	}
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("deriveKey() = %s, want %s", got, want)
+	}
+}
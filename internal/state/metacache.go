@@ -0,0 +1,115 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+// metaCacheVersion is bumped whenever the cached layout of TorrentFile or
+// the way it is parsed changes, so stale entries are parsed again instead
+// of misread. Version 3 hashes the info dictionary as encoded in the file.
+const metaCacheVersion = 3
+
+// metaCacheEntry is the on-disk form of a cached torrent
+type metaCacheEntry struct {
+	Version int
+	Torrent *torrent.TorrentFile
+}
+
+// MetaCache caches parsed torrent metadata, including the info hash and
+// piece hashes, keyed by the hash of the .torrent file. Large torrents with
+// thousands of files are then only decoded and hashed the first time.
+type MetaCache struct {
+	Dir string
+}
+
+// NewMetaCache creates a metadata cache in the given directory
+func NewMetaCache(dir string) *MetaCache {
+	return &MetaCache{Dir: dir}
+}
+
+// ParseFile parses a .torrent file, using the cached metadata when the file
+// has been parsed before
+func (c *MetaCache) ParseFile(path string) (*torrent.TorrentFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(data)
+	key := hex.EncodeToString(sum[:])
+
+	if t, ok := c.Get(key); ok {
+		return t, nil
+	}
+
+	t, err := torrent.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// A cache we can't write to only costs us the speedup
+	if err := c.Put(key, t); err != nil {
+		fmt.Printf("Failed to cache torrent metadata: %v\n", err)
+	}
+
+	return t, nil
+}
+
+// Get returns the cached metadata for a key. Missing, unreadable and
+// outdated entries are all reported as a miss.
+func (c *MetaCache) Get(key string) (*torrent.TorrentFile, bool) {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var entry metaCacheEntry
+	if err := gob.NewDecoder(f).Decode(&entry); err != nil {
+		return nil, false
+	}
+
+	if entry.Version != metaCacheVersion || entry.Torrent == nil {
+		return nil, false
+	}
+
+	return entry.Torrent, true
+}
+
+// Put stores the metadata for a key, replacing the entry atomically
+func (c *MetaCache) Put(key string, t *torrent.TorrentFile) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(metaCacheEntry{Version: metaCacheVersion, Torrent: t}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create metadata cache directory: %w", err)
+	}
+
+	path := c.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// path returns the cache file for a key
+func (c *MetaCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".gob")
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"os"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/torrent"
+)
+
+func TestMetaCacheRoundTrip(t *testing.T) {
+	cache := NewMetaCache(t.TempDir())
+
+	want := &torrent.TorrentFile{
+		Announce: "http://tracker/announce",
+		Info: torrent.InfoDict{
+			PieceLength: 16384,
+			Pieces:      "\x00\xff\xfe binary \x80",
+			Name:        "dir",
+			Files:       []torrent.FileDict{{Length: 10, Path: []string{"a", "b"}}},
+			IsDirectory: true,
+		},
+		InfoHash:   [20]byte{1, 2, 3},
+		PiecesHash: [][20]byte{{4, 5, 6}},
+	}
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get() on empty cache reported a hit")
+	}
+
+	if err := cache.Put("key", want); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, ok := cache.Get("key")
+	if !ok {
+		t.Fatal("Get() after Put() reported a miss")
+	}
+
+	if got.InfoHash != want.InfoHash || got.Info.Pieces != want.Info.Pieces ||
+		len(got.Info.Files) != 1 || got.Info.Files[0].Path[1] != "b" || got.PiecesHash[0] != want.PiecesHash[0] {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMetaCacheCorruptEntry(t *testing.T) {
+	cache := NewMetaCache(t.TempDir())
+	if err := os.WriteFile(cache.path("key"), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Get() of a corrupt entry reported a hit")
+	}
+}
@@ -0,0 +1,197 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPassphraseRequired = errors.New("state file is encrypted, passphrase required")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupted state file")
+)
+
+// Torrent is the saved state of a single torrent
+type Torrent struct {
+	InfoHash     string    `json:"info_hash"` // Hex encoded info hash
+	Name         string    `json:"name"`
+	DisplayName  string    `json:"display_name,omitempty"` // Name shown instead of Name, see DownloadManager.SetDisplayName
+	TorrentPath  string    `json:"torrent_path"`
+	Source       *Source   `json:"source,omitempty"` // Where the torrent was added from
+	DownloadPath string    `json:"download_path"`
+	Trackers     []string  `json:"trackers"`
+	Downloaded   int64     `json:"downloaded"`
+	Uploaded     int64     `json:"uploaded"`
+	Completed    bool      `json:"completed"`
+	Stopped      bool      `json:"stopped,omitempty"` // Added without being started, e.g. to be queued
+	Pieces       string    `json:"pieces,omitempty"`  // Hex encoded bitfield of the completed pieces
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// How long each tracker asked us to wait, respected after a restart
+	TrackerBackoff []TrackerBackoff `json:"tracker_backoff,omitempty"`
+}
+
+// TrackerBackoff is the saved announce backoff of one tracker, see
+// download.TrackerBackoff
+type TrackerBackoff struct {
+	URL          string    `json:"url"`
+	LastAnnounce time.Time `json:"last_announce"`
+	Interval     int       `json:"interval,omitempty"`     // Seconds
+	MinInterval  int       `json:"min_interval,omitempty"` // Seconds
+	Failures     int       `json:"failures,omitempty"`     // Consecutive failed announces
+}
+
+// Kinds of torrent sources
+const (
+	SourceFile   = "file"
+	SourceURL    = "url"
+	SourceStdin  = "stdin"
+	SourceMagnet = "magnet"
+)
+
+// Source records where a torrent was added from, so it can be added again
+// from the same place and shown as it was given
+type Source struct {
+	Kind    string    `json:"kind"`
+	URI     string    `json:"uri"` // Absolute path or URL, "" for stdin
+	AddedAt time.Time `json:"added_at"`
+}
+
+// SourceOf returns the source of a torrent given on the command line as a
+// path, an http(s) URL, a magnet link or "-" for stdin
+func SourceOf(arg string, now time.Time) Source {
+	switch {
+	case arg == "-":
+		return Source{Kind: SourceStdin, AddedAt: now}
+	case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+		return Source{Kind: SourceURL, URI: arg, AddedAt: now}
+	case strings.HasPrefix(arg, "magnet:?"):
+		return Source{Kind: SourceMagnet, URI: arg, AddedAt: now}
+	}
+
+	if abs, err := filepath.Abs(arg); err == nil {
+		arg = abs
+	}
+	return Source{Kind: SourceFile, URI: arg, AddedAt: now}
+}
+
+// KeepAddedAt returns s with the AddedAt of prev when both name the same
+// place, so a torrent added again keeps the time it was first added
+func (s Source) KeepAddedAt(prev *Source) Source {
+	if prev != nil && prev.Kind == s.Kind && prev.URI == s.URI {
+		s.AddedAt = prev.AddedAt
+	}
+	return s
+}
+
+// Readdable reports whether the torrent can be loaded from the source again
+func (s *Source) Readdable() bool {
+	return s != nil && s.Kind != SourceStdin && s.URI != ""
+}
+
+// Session is the state saved between runs
+type Session struct {
+	Torrents []Torrent `json:"torrents"`
+}
+
+// Store loads and saves the session state file. When a passphrase is set
+// the file is encrypted at rest; encrypted files are detected on load.
+type Store struct {
+	Path       string
+	Passphrase string
+}
+
+// NewStore creates a state store for the given file
+func NewStore(path, passphrase string) *Store {
+	return &Store{Path: path, Passphrase: passphrase}
+}
+
+// Load reads the session state. A missing file yields an empty session.
+func (s *Store) Load() (*Session, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Session{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if isEncrypted(data) {
+		if s.Passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		if data, err = decrypt(data, s.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+
+	return &session, nil
+}
+
+// Save writes the session state, replacing the file atomically so a crash
+// never leaves a truncated state file behind
+func (s *Store) Save(session *Session) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if s.Passphrase != "" {
+		if data, err = encrypt(data, s.Passphrase); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// Update replaces the entry with the same info hash, or adds it. A
+// torrent added from the same source again keeps its original AddedAt.
+func (s *Session) Update(t Torrent) {
+	for i := range s.Torrents {
+		if s.Torrents[i].InfoHash == t.InfoHash {
+			if t.Source != nil {
+				source := t.Source.KeepAddedAt(s.Torrents[i].Source)
+				t.Source = &source
+			}
+			s.Torrents[i] = t
+			return
+		}
+	}
+
+	s.Torrents = append(s.Torrents, t)
+}
+
+// Find returns the entry for an info hash, or nil
+func (s *Session) Find(infoHash string) *Torrent {
+	for i := range s.Torrents {
+		if s.Torrents[i].InfoHash == infoHash {
+			return &s.Torrents[i]
+		}
+	}
+
+	return nil
+}
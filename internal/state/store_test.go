@@ -0,0 +1,94 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	session := &Session{}
+	session.Update(Torrent{InfoHash: "abcd", Name: "test", Trackers: []string{"http://tracker/announce"}, Downloaded: 42})
+
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{"plain", ""},
+		{"encrypted", "correct horse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			store := NewStore(path, tt.passphrase)
+
+			if err := store.Save(session); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			data, _ := os.ReadFile(path)
+			if got := bytes.Contains(data, []byte("tracker")); got == (tt.passphrase != "") {
+				t.Errorf("state file plaintext visible = %v, encrypted = %v", got, tt.passphrase != "")
+			}
+
+			loaded, err := store.Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			got := loaded.Find("abcd")
+			if got == nil || got.Downloaded != 42 || len(got.Trackers) != 1 {
+				t.Errorf("Load() = %+v, want saved torrent", loaded)
+			}
+		})
+	}
+}
+
+func TestStoreWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := NewStore(path, "secret").Save(&Session{}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if _, err := NewStore(path, "").Load(); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Load() without passphrase error = %v, want %v", err, ErrPassphraseRequired)
+	}
+
+	if _, err := NewStore(path, "guess").Load(); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Load() with wrong passphrase error = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestSessionKeepsSource(t *testing.T) {
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	later := first.Add(48 * time.Hour)
+
+	source := SourceOf("https://example.com/a.torrent", first)
+	session := &Session{}
+	session.Update(Torrent{InfoHash: "abcd", Source: &source})
+
+	// Adding it from the same place again keeps when it was first added
+	again := SourceOf("https://example.com/a.torrent", later)
+	session.Update(Torrent{InfoHash: "abcd", Source: &again})
+	if got := session.Find("abcd").Source; got.Kind != SourceURL || !got.AddedAt.Equal(first) {
+		t.Errorf("Source = %+v, want a URL added at %v", got, first)
+	}
+
+	moved := SourceOf("a.torrent", later)
+	session.Update(Torrent{InfoHash: "abcd", Source: &moved})
+	got := session.Find("abcd").Source
+	if got.Kind != SourceFile || !filepath.IsAbs(got.URI) || !got.AddedAt.Equal(later) {
+		t.Errorf("Source = %+v, want an absolute path added at %v", got, later)
+	}
+	if !got.Readdable() {
+		t.Error("a file source should be readdable")
+	}
+
+	if stdin := SourceOf("-", later); stdin.Readdable() {
+		t.Error("a stdin source should not be readdable")
+	}
+}
@@ -0,0 +1,93 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrFetchFailed is returned when a .torrent file can't be fetched over HTTP
+var ErrFetchFailed = errors.New("failed to fetch torrent file")
+
+// DefaultMaxFetchSize is the largest .torrent file a Fetcher accepts by default
+const DefaultMaxFetchSize = 10 << 20
+
+// acceptedContentTypes are the types servers send .torrent files with.
+// Anything else, typically an HTML login or error page, is refused.
+var acceptedContentTypes = map[string]bool{
+	"application/x-bittorrent":   true,
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/force-download": true,
+}
+
+// Fetcher downloads .torrent files over HTTP(S)
+type Fetcher struct {
+	Client  *http.Client // Proxy-aware clients can be supplied
+	MaxSize int64        // Largest file accepted, in bytes
+	Options ParseOptions // How the fetched file is parsed
+}
+
+// NewFetcher creates a fetcher with the default size limit and a timeout
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Client:  &http.Client{Timeout: 30 * time.Second},
+		MaxSize: DefaultMaxFetchSize,
+	}
+}
+
+// IsURL reports whether a torrent argument is an HTTP(S) URL rather than a path
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// Fetch downloads a .torrent file and parses it
+func (f *Fetcher) Fetch(url string) (*TorrentFile, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	req.Header.Set("Accept", "application/x-bittorrent, application/octet-stream;q=0.9")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %s", ErrFetchFailed, url, resp.Status)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if !acceptedContentTypes[mediaType] {
+			return nil, fmt.Errorf("%w: %s returned %s, not a torrent file", ErrFetchFailed, url, contentType)
+		}
+	}
+
+	if resp.ContentLength > f.MaxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrFetchFailed, url, resp.ContentLength, f.MaxSize)
+	}
+
+	// Servers may omit or understate the length, so the limit is enforced
+	// on the body itself
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	if int64(len(body)) > f.MaxSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrFetchFailed, url, f.MaxSize)
+	}
+
+	// A torrent file is a bencoded dictionary
+	if len(body) == 0 || body[0] != 'd' {
+		return nil, fmt.Errorf("%w: %s is not a torrent file", ErrInvalidTorrentFile, url)
+	}
+
+	return ParseBytesWithOptions(body, f.Options)
+}
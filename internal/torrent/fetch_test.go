@@ -0,0 +1,68 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+func TestFetcherFetch(t *testing.T) {
+	var metainfo bytes.Buffer
+	bencode.Encode(&metainfo, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"piece length": int64(16384),
+			"pieces":       "abcdefghijklmnopqrst",
+			"length":       int64(100),
+		},
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.torrent":
+			w.Header().Set("Content-Type", "application/x-bittorrent")
+			w.Write(metainfo.Bytes())
+		case "/login":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>please log in</html>"))
+		case "/big.torrent":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(bytes.Repeat([]byte("d"), 2048))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.MaxSize = 1024
+
+	tf, err := fetcher.Fetch(server.URL + "/ok.torrent")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if tf.Info.Name != "test.txt" {
+		t.Errorf("Info.Name = %q, want test.txt", tf.Info.Name)
+	}
+
+	for path, want := range map[string]string{
+		"/login":       "text/html",
+		"/big.torrent": "limit is 1024",
+		"/missing":     "404",
+	} {
+		_, err := fetcher.Fetch(server.URL + path)
+		if !errors.Is(err, ErrFetchFailed) || !strings.Contains(err.Error(), want) {
+			t.Errorf("Fetch(%s) error = %v, want ErrFetchFailed mentioning %q", path, err, want)
+		}
+	}
+
+	if !IsURL("https://example.com/a.torrent") || IsURL("a.torrent") {
+		t.Error("IsURL() misclassifies URLs and paths")
+	}
+}
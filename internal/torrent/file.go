@@ -5,9 +5,14 @@ import (
 	"time"
 )
 
+// MaxPieceLength is the largest piece length accepted. Payload offsets and
+// lengths are int64 throughout, but a piece is held in memory and its
+// blocks are addressed with int, which is 32 bits on some platforms.
+const MaxPieceLength = 1 << 30
+
 type TorrentFile struct {
 	Announce     string     // URL of the primary tracker server
-	AnnouceList  [][]string // List of backup tracker servers organized in tiers
+	AnnounceList [][]string // List of backup tracker servers organized in tiers
 	CreationDate time.Time  // When the torrent file was created
 	Comment      string     // Optional comment about the torrent
 	CreatedBy    string     // Name of the program that created the torrent
@@ -15,6 +20,17 @@ type TorrentFile struct {
 	Info         InfoDict   // Contains the core torrent metadata
 	InfoHash     [20]byte   // SHA-1 hash of the info dictionary
 	PiecesHash   [][20]byte // Array of SHA-1 hashes for each piece
+	URLList      []string   // Web seed URLs (BEP 19)
+	HTTPSeeds    []string   // HTTP seed URLs (BEP 17)
+	Nodes        []Node     // DHT bootstrap nodes (BEP 5)
+	Similar      [][20]byte // Info hashes of torrents sharing files (BEP 38)
+	Collections  []string   // Collections this torrent belongs to (BEP 38)
+}
+
+// Node is a DHT node listed in the metainfo
+type Node struct {
+	Host string
+	Port int
 }
 
 type InfoDict struct {
@@ -3,11 +3,50 @@ package torrent
 import (
 	"bytes"
 	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/piyushgupta53/go-torrent/internal/bencode"
 )
 
+// ErrInfoHashMismatch is returned when a torrent isn't the one expected
+var ErrInfoHashMismatch = errors.New("info hash mismatch")
+
+// ParseInfoHash parses an info hash written as 40 hex digits or, as in
+// magnet links, 32 base32 characters
+func ParseInfoHash(s string) ([20]byte, error) {
+	var hash [20]byte
+
+	var decoded []byte
+	var err error
+	switch len(s) {
+	case 40:
+		decoded, err = hex.DecodeString(s)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("info hash %q must be 40 hex or 32 base32 characters", s)
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash %q: %w", s, err)
+	}
+
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// CheckInfoHash returns ErrInfoHashMismatch unless the torrent has the
+// expected info hash
+func (t *TorrentFile) CheckInfoHash(expected [20]byte) error {
+	if t.InfoHash != expected {
+		return fmt.Errorf("%w: torrent has %x, expected %x", ErrInfoHashMismatch, t.InfoHash, expected)
+	}
+	return nil
+}
+
 // calculateHashInfo computes the SHA-1 hash of the bencoded info dictionary
 func calculateHashInfo(info map[string]interface{}) ([20]byte, error) {
 	var buf bytes.Buffer
@@ -0,0 +1,103 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+// ErrInvalidMagnet is returned for magnet links that don't name a
+// BitTorrent v1 info hash
+var ErrInvalidMagnet = errors.New("invalid magnet link")
+
+// Magnet is a magnet link (BEP 9): a torrent known by its info hash, whose
+// info dictionary is fetched from peers
+type Magnet struct {
+	InfoHash [20]byte
+	Name     string   // Suggested display name ("dn"), may be empty
+	Trackers []string // Tracker URLs ("tr")
+	Peers    []string // Peer addresses, host:port ("x.pe")
+}
+
+// IsMagnet reports whether a torrent argument is a magnet link
+func IsMagnet(s string) bool {
+	return strings.HasPrefix(s, "magnet:?")
+}
+
+// ParseMagnet parses a magnet link
+func ParseMagnet(uri string) (*Magnet, error) {
+	if !IsMagnet(uri) {
+		return nil, fmt.Errorf("%w: %q doesn't start with magnet:?", ErrInvalidMagnet, uri)
+	}
+	query, err := url.ParseQuery(strings.TrimPrefix(uri, "magnet:?"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+
+	m := &Magnet{Name: query.Get("dn"), Peers: query["x.pe"]}
+	found := false
+	for _, xt := range query["xt"] {
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		if m.InfoHash, err = ParseInfoHash(hash); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: no urn:btih info hash", ErrInvalidMagnet)
+	}
+
+	// Trackers are "tr", or numbered "tr.1", "tr.2", ... by some clients
+	var keys []string
+	for key := range query {
+		if key == "tr" || strings.HasPrefix(key, "tr.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.Trackers = append(m.Trackers, query[key]...)
+	}
+	return m, nil
+}
+
+// Metainfo returns a .torrent file holding info, the bencoded info
+// dictionary fetched for the magnet link, and the link's trackers, each in
+// a tier of its own. The info dictionary is kept byte for byte, so the
+// file has the info hash of the link.
+func (m *Magnet) Metainfo(info []byte) ([]byte, error) {
+	dec := bencode.NewDecoder(bytes.NewReader(info))
+	decoded, err := dec.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInfoDict, err)
+	}
+	if _, ok := decoded.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%w: not a dictionary", ErrInvalidInfoDict)
+	}
+	if dec.InputOffset() != int64(len(info)) {
+		return nil, fmt.Errorf("%w: data after the dictionary", ErrInvalidInfoDict)
+	}
+
+	// The announce URL is required, so trackerless links get an empty one
+	metainfo := struct {
+		Announce     string             `bencode:"announce"`
+		AnnounceList [][]string         `bencode:"announce-list,omitempty"`
+		Info         bencode.RawMessage `bencode:"info"`
+	}{Info: info}
+	if len(m.Trackers) > 0 {
+		metainfo.Announce = m.Trackers[0]
+		for _, tracker := range m.Trackers {
+			metainfo.AnnounceList = append(metainfo.AnnounceList, []string{tracker})
+		}
+	}
+
+	return bencode.Marshal(metainfo)
+}
@@ -0,0 +1,75 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	m, err := ParseMagnet("magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Some+Name" +
+		"&tr=http%3A%2F%2Ftracker.example%2Fannounce&tr.1=udp%3A%2F%2Fother.example%3A1337&x.pe=192.0.2.1:6881")
+	if err != nil {
+		t.Fatalf("ParseMagnet() error = %v", err)
+	}
+
+	want := &Magnet{
+		InfoHash: [20]byte{0xc1, 0x2f, 0xe1, 0xc0, 0x6b, 0xba, 0x25, 0x4a, 0x9d, 0xc9, 0xf5, 0x19, 0xb3, 0x35, 0xaa, 0x7c, 0x13, 0x67, 0xa8, 0x8a},
+		Name:     "Some Name",
+		Trackers: []string{"http://tracker.example/announce", "udp://other.example:1337"},
+		Peers:    []string{"192.0.2.1:6881"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseMagnet() = %+v, want %+v", m, want)
+	}
+
+	// Base32 info hashes are as good as hex ones
+	m, err = ParseMagnet("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK")
+	if err != nil || m.InfoHash != want.InfoHash {
+		t.Errorf("ParseMagnet(base32) = %+v, %v, want info hash %x", m, err, want.InfoHash)
+	}
+
+	for _, uri := range []string{
+		"http://example.com/a.torrent",
+		"magnet:?dn=no+hash",
+		"magnet:?xt=urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e",
+		"magnet:?xt=urn:btih:nothex",
+	} {
+		if _, err := ParseMagnet(uri); !errors.Is(err, ErrInvalidMagnet) {
+			t.Errorf("ParseMagnet(%q) error = %v, want ErrInvalidMagnet", uri, err)
+		}
+	}
+}
+
+func TestMagnetMetainfo(t *testing.T) {
+	info := []byte("d6:lengthi8e4:name8:test.bin12:piece lengthi4e6:pieces40:" + string(bytes.Repeat([]byte{1}, 40)) + "e")
+	m := &Magnet{InfoHash: sha1.Sum(info), Trackers: []string{"http://a.example/announce", "http://b.example/announce"}}
+
+	metainfo, err := m.Metainfo(info)
+	if err != nil {
+		t.Fatalf("Metainfo() error = %v", err)
+	}
+
+	torrentFile, err := ParseReader(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatalf("ParseReader() error = %v", err)
+	}
+	if err := torrentFile.CheckInfoHash(m.InfoHash); err != nil {
+		t.Error(err)
+	}
+	if torrentFile.Announce != m.Trackers[0] || len(torrentFile.AnnounceList) != 2 || torrentFile.Info.Name != "test.bin" {
+		t.Errorf("Metainfo() = %+v", torrentFile)
+	}
+
+	if !bytes.Contains(metainfo, append([]byte("4:info"), info...)) {
+		t.Errorf("Metainfo() = %q, want the info dictionary as it was", metainfo)
+	}
+
+	for _, info := range []string{"le", "d4:name1:xee"} {
+		if _, err := m.Metainfo([]byte(info)); !errors.Is(err, ErrInvalidInfoDict) {
+			t.Errorf("Metainfo(%q) error = %v, want ErrInvalidInfoDict", info, err)
+		}
+	}
+}
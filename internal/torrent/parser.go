@@ -1,8 +1,11 @@
 package torrent
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -25,18 +28,99 @@ func ParseFromFile(path string) (*TorrentFile, error) {
 
 	defer file.Close()
 
+	return ParseReader(file)
+}
+
+// ParseReader reads .torrent data, such as piped to stdin, and returns a
+// TorrentFile struct
+func ParseReader(r io.Reader) (*TorrentFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseBytes(data)
+}
+
+// ParseBytes parses the contents of a .torrent file. Unlike Parse, the info
+// hash is computed over the info dictionary exactly as it is encoded in
+// data, so torrents whose creators didn't encode it canonically, e.g. with
+// unsorted keys, keep the info hash the rest of the swarm uses.
+func ParseBytes(data []byte) (*TorrentFile, error) {
+	return ParseBytesWithOptions(data, ParseOptions{})
+}
+
+// ParseBytesWithOptions is ParseBytes, matching keys according to the
+// options
+func ParseBytesWithOptions(data []byte, opts ParseOptions) (*TorrentFile, error) {
 	// Decode the bencode data
-	data, err := bencode.Decode(file)
+	decoded, err := bencode.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
+	// Decoding succeeded, so only a torrent that isn't a dictionary fails
+	var raw struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.NewDecoder(bytes.NewReader(data)).Unmarshal(&raw); err != nil {
+		return nil, ErrInvalidTorrentFile
+	}
+
 	// Convert the decoded data to a TorrentFile struct
-	return Parse(data)
+	return parse(decoded, raw.Info, opts)
 }
 
-// Parse converts the decoded bencode data into a TorrentFile struct
+// ParseOptions controls how metainfo keys are matched
+type ParseOptions struct {
+	// Strict only accepts the keys from the specifications. Otherwise the
+	// misspellings and aliases produced by some torrent creators are
+	// accepted too.
+	Strict bool
+}
+
+// keyAliases lists the nonstandard spellings of metainfo keys seen in the
+// wild, tried in order after the spec-correct key
+var keyAliases = map[string][]string{
+	"announce":      {"annouce", "announce_url", "announce-url"},
+	"announce-list": {"annouce-list", "announce_list", "announcelist"},
+	"creation date": {"creation_date", "creationdate"},
+	"created by":    {"created_by", "createdby"},
+	"piece length":  {"piece_length", "piecelength"},
+	"url-list":      {"url_list", "urllist"},
+}
+
+// lookup returns the value of a key, falling back to its aliases unless
+// parsing is strict
+func (o ParseOptions) lookup(dict map[string]interface{}, key string) (interface{}, bool) {
+	if val, ok := dict[key]; ok {
+		return val, true
+	}
+
+	if o.Strict {
+		return nil, false
+	}
+
+	for _, alias := range keyAliases[key] {
+		if val, ok := dict[alias]; ok {
+			return val, true
+		}
+	}
+
+	return nil, false
+}
+
+// Parse converts the decoded bencode data into a TorrentFile struct,
+// accepting known key aliases. The info hash is computed over the info
+// dictionary encoded again, so prefer ParseBytes when the file is at hand.
 func Parse(data interface{}) (*TorrentFile, error) {
+	return parse(data, nil, ParseOptions{})
+}
+
+// parse converts the decoded bencode data into a TorrentFile struct. The
+// info hash is computed over rawInfo, the info dictionary as encoded in the
+// file, or over the info dictionary encoded again when it is nil.
+func parse(data interface{}, rawInfo bencode.RawMessage, opts ParseOptions) (*TorrentFile, error) {
 	dict, ok := data.(map[string]interface{})
 	if !ok {
 		return nil, ErrInvalidTorrentFile
@@ -45,46 +129,46 @@ func Parse(data interface{}) (*TorrentFile, error) {
 	// Create a new TorrentFile strcut
 	t := &TorrentFile{}
 
-	// Parse annouce URL
-	annouceVal, ok := dict["annouce"]
+	// Parse announce URL
+	announceVal, ok := opts.lookup(dict, "announce")
 	if !ok {
-		return nil, fmt.Errorf("%w: missing annouce URL", ErrInvalidTorrentFile)
+		return nil, fmt.Errorf("%w: missing announce URL", ErrInvalidTorrentFile)
 	}
 
-	annouce, ok := annouceVal.(string)
+	announce, ok := announceVal.(string)
 	if !ok {
-		return nil, fmt.Errorf("%w: annouce is not a string", ErrInvalidTorrentFile)
+		return nil, fmt.Errorf("%w: announce is not a string", ErrInvalidTorrentFile)
 	}
 
-	t.Announce = annouce
+	t.Announce = announce
 
-	// Parse annouce-list
-	if announceListVal, ok := dict["annouce-list"]; ok {
+	// Parse announce-list
+	if announceListVal, ok := opts.lookup(dict, "announce-list"); ok {
 		announceList, ok := announceListVal.([]interface{})
 		if !ok {
-			return nil, fmt.Errorf("%w: annouce-list is not a list", ErrInvalidTorrentFile)
+			return nil, fmt.Errorf("%w: announce-list is not a list", ErrInvalidTorrentFile)
 		}
 
-		t.AnnouceList = make([][]string, len(announceList))
+		t.AnnounceList = make([][]string, len(announceList))
 		for i, tier := range announceList {
 			tierList, ok := tier.([]interface{})
 			if !ok {
-				return nil, fmt.Errorf("%w: annouce-list tier is not a list", ErrInvalidInfoDict)
+				return nil, fmt.Errorf("%w: announce-list tier is not a list", ErrInvalidInfoDict)
 			}
 
-			t.AnnouceList[i] = make([]string, len(tierList))
+			t.AnnounceList[i] = make([]string, len(tierList))
 			for j, tracker := range tierList {
 				trackerURL, ok := tracker.(string)
 				if !ok {
 					return nil, fmt.Errorf("%w: tracker URL is not a string", ErrInvalidTorrentFile)
 				}
-				t.AnnouceList[i][j] = trackerURL
+				t.AnnounceList[i][j] = trackerURL
 			}
 		}
 	}
 
 	// Parse creation date
-	if creationDateVal, ok := dict["creation date"]; ok {
+	if creationDateVal, ok := opts.lookup(dict, "creation date"); ok {
 		creationDate, ok := creationDateVal.(int64)
 		if !ok {
 			return nil, fmt.Errorf("%w: creation date is not an interger", ErrInvalidTorrentFile)
@@ -104,7 +188,7 @@ func Parse(data interface{}) (*TorrentFile, error) {
 	}
 
 	// Parse created by
-	if createdByVal, ok := dict["created by"]; ok {
+	if createdByVal, ok := opts.lookup(dict, "created by"); ok {
 		createdBy, ok := createdByVal.(string)
 		if !ok {
 			return nil, fmt.Errorf("%w: created by is not a string", ErrInvalidTorrentFile)
@@ -133,17 +217,21 @@ func Parse(data interface{}) (*TorrentFile, error) {
 	}
 
 	// Parse into fields
-	if err := parseInfoDict(infoDict, &t.Info); err != nil {
+	if err := parseInfoDict(infoDict, &t.Info, opts); err != nil {
 		return nil, err
 	}
 
 	// Calculate the info hash
-	infoHash, err := calculateHashInfo(infoDict)
-	if err != nil {
-		return nil, err
-	}
+	if rawInfo != nil {
+		t.InfoHash = sha1.Sum(rawInfo)
+	} else {
+		infoHash, err := calculateHashInfo(infoDict)
+		if err != nil {
+			return nil, err
+		}
 
-	t.InfoHash = infoHash
+		t.InfoHash = infoHash
+	}
 
 	// Parse pieces hash
 	piecesHash, err := parsePieces(t.Info.Pieces)
@@ -152,13 +240,125 @@ func Parse(data interface{}) (*TorrentFile, error) {
 	}
 
 	t.PiecesHash = piecesHash
+
+	if err := parseExtensions(dict, infoDict, t, opts); err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
 
+// parseExtensions parses the optional keys added by later BEPs: web seeds,
+// HTTP seeds, DHT bootstrap nodes, similar torrents and collections
+func parseExtensions(dict, info map[string]interface{}, t *TorrentFile, opts ParseOptions) error {
+	// url-list is a single URL or a list of them; empty entries are common
+	if urlListVal, ok := opts.lookup(dict, "url-list"); ok {
+		urls, err := parseStringOrList(urlListVal)
+		if err != nil {
+			return fmt.Errorf("%w: url-list %v", ErrInvalidTorrentFile, err)
+		}
+		t.URLList = urls
+	}
+
+	if httpSeedsVal, ok := dict["httpseeds"]; ok {
+		urls, err := parseStringOrList(httpSeedsVal)
+		if err != nil {
+			return fmt.Errorf("%w: httpseeds %v", ErrInvalidTorrentFile, err)
+		}
+		t.HTTPSeeds = urls
+	}
+
+	// nodes is a list of [host, port] pairs
+	if nodesVal, ok := dict["nodes"]; ok {
+		nodes, ok := nodesVal.([]interface{})
+		if !ok {
+			return fmt.Errorf("%w: nodes is not a list", ErrInvalidTorrentFile)
+		}
+
+		for _, nodeVal := range nodes {
+			pair, ok := nodeVal.([]interface{})
+			if !ok || len(pair) != 2 {
+				return fmt.Errorf("%w: node is not a host and port pair", ErrInvalidTorrentFile)
+			}
+
+			host, ok := pair[0].(string)
+			if !ok {
+				return fmt.Errorf("%w: node host is not a string", ErrInvalidTorrentFile)
+			}
+
+			port, ok := pair[1].(int64)
+			if !ok || port <= 0 || port > 65535 {
+				return fmt.Errorf("%w: node port is not a valid port", ErrInvalidTorrentFile)
+			}
+
+			t.Nodes = append(t.Nodes, Node{Host: host, Port: int(port)})
+		}
+	}
+
+	// similar and collections may sit in the info dictionary, where they are
+	// covered by the info hash, or next to it; both are merged
+	for _, d := range []map[string]interface{}{info, dict} {
+		if similarVal, ok := d["similar"]; ok {
+			similar, ok := similarVal.([]interface{})
+			if !ok {
+				return fmt.Errorf("%w: similar is not a list", ErrInvalidTorrentFile)
+			}
+
+			for _, hashVal := range similar {
+				hash, ok := hashVal.(string)
+				if !ok || len(hash) != 20 {
+					return fmt.Errorf("%w: similar entry is not an info hash", ErrInvalidTorrentFile)
+				}
+
+				var infoHash [20]byte
+				copy(infoHash[:], hash)
+				t.Similar = append(t.Similar, infoHash)
+			}
+		}
+
+		if collectionsVal, ok := d["collections"]; ok {
+			collections, err := parseStringOrList(collectionsVal)
+			if err != nil {
+				return fmt.Errorf("%w: collections %v", ErrInvalidTorrentFile, err)
+			}
+			t.Collections = append(t.Collections, collections...)
+		}
+	}
+
+	return nil
+}
+
+// parseStringOrList parses a value that is either a string or a list of
+// strings, dropping empty strings
+func parseStringOrList(val interface{}) ([]string, error) {
+	var items []interface{}
+	switch v := val.(type) {
+	case string:
+		items = []interface{}{v}
+	case []interface{}:
+		items = v
+	default:
+		return nil, errors.New("is not a string or list")
+	}
+
+	var result []string
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, errors.New("entry is not a string")
+		}
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
+
 // parseInfoDict parses the info dictionary
-func parseInfoDict(info map[string]interface{}, infoDict *InfoDict) error {
+func parseInfoDict(info map[string]interface{}, infoDict *InfoDict, opts ParseOptions) error {
 	// parse piece length
-	pieceLengthVal, ok := info["piece length"]
+	pieceLengthVal, ok := opts.lookup(info, "piece length")
 	if !ok {
 		return fmt.Errorf("%w: missing piece length", ErrInvalidInfoDict)
 	}
@@ -168,6 +368,12 @@ func parseInfoDict(info map[string]interface{}, infoDict *InfoDict) error {
 		return fmt.Errorf("%w: piece length is not an integer", ErrInvalidInfoDict)
 	}
 
+	// Pieces are held in memory and indexed with int, so their length must
+	// fit in an int even on 32-bit platforms
+	if pieceLength <= 0 || pieceLength > MaxPieceLength {
+		return fmt.Errorf("%w: piece length %d is out of range (1 to %d)", ErrInvalidInfoDict, pieceLength, int64(MaxPieceLength))
+	}
+
 	infoDict.PieceLength = pieceLength
 
 	// parse pieces hashes
@@ -212,6 +418,9 @@ func parseInfoDict(info map[string]interface{}, infoDict *InfoDict) error {
 		if !ok {
 			return fmt.Errorf("%w: length is not an integer", ErrInvalidInfoDict)
 		}
+		if length < 0 {
+			return fmt.Errorf("%w: length %d is negative", ErrInvalidInfoDict, length)
+		}
 
 		infoDict.Length = length
 		infoDict.IsDirectory = false
@@ -240,6 +449,9 @@ func parseInfoDict(info map[string]interface{}, infoDict *InfoDict) error {
 			if !ok {
 				return fmt.Errorf("%w: file length is not an integer", ErrInvalidInfoDict)
 			}
+			if fileLength < 0 {
+				return fmt.Errorf("%w: file length %d is negative", ErrInvalidInfoDict, fileLength)
+			}
 
 			infoDict.Files[i].Length = fileLength
 
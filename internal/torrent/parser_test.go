@@ -3,6 +3,9 @@ package torrent
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -88,7 +91,7 @@ func TestParse(t *testing.T) {
 			data: multiFileData,
 			expected: &TorrentFile{
 				Announce: "http://tracker.example.com/announce",
-				AnnouceList: [][]string{
+				AnnounceList: [][]string{
 					{"http://tracker1.example.com/announce", "http://tracker2.example.com/announce"},
 					{"http://tracker3.example.com/announce"},
 				},
@@ -229,3 +232,300 @@ func TestTorrentFileHelpers(t *testing.T) {
 		t.Errorf("FilePathForPiece(1) = %v, want %v", got, expectedPaths)
 	}
 }
+
+func TestParseExtensions(t *testing.T) {
+	similar := string(bytes.Repeat([]byte{0xab}, 20))
+
+	tests := []struct {
+		name    string
+		dict    map[string]interface{}
+		info    map[string]interface{}
+		want    *TorrentFile
+		wantErr bool
+	}{
+		{
+			name: "All keys",
+			dict: map[string]interface{}{
+				"url-list":    []interface{}{"http://seed1/", "", "http://seed2/"},
+				"httpseeds":   []interface{}{"http://httpseed/seed"},
+				"nodes":       []interface{}{[]interface{}{"router.example.com", int64(6881)}},
+				"collections": []interface{}{"outer"},
+			},
+			info: map[string]interface{}{
+				"similar":     []interface{}{similar},
+				"collections": []interface{}{"inner"},
+			},
+			want: &TorrentFile{
+				URLList:     []string{"http://seed1/", "http://seed2/"},
+				HTTPSeeds:   []string{"http://httpseed/seed"},
+				Nodes:       []Node{{Host: "router.example.com", Port: 6881}},
+				Similar:     [][20]byte{[20]byte(bytes.Repeat([]byte{0xab}, 20))},
+				Collections: []string{"inner", "outer"},
+			},
+		},
+		{
+			name: "Single url-list string",
+			dict: map[string]interface{}{"url-list": "http://seed/"},
+			want: &TorrentFile{URLList: []string{"http://seed/"}},
+		},
+		{
+			name: "Empty url-list string",
+			dict: map[string]interface{}{"url-list": ""},
+			want: &TorrentFile{},
+		},
+		{
+			name:    "Node without port",
+			dict:    map[string]interface{}{"nodes": []interface{}{[]interface{}{"host"}}},
+			wantErr: true,
+		},
+		{
+			name:    "Short similar hash",
+			info:    map[string]interface{}{"similar": []interface{}{"short"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &TorrentFile{}
+			err := parseExtensions(tt.dict, tt.info, got, ParseOptions{})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtensions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExtensions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeyAliases(t *testing.T) {
+	// The torrents in testdata are generated by testdata/gen.go for
+	// testdata/payload.txt, one per spelling of the keys
+	payload, err := os.ReadFile(filepath.Join("testdata", "payload.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file      string
+		strict    bool
+		wantErr   bool
+		wantSeeds int
+	}{
+		{file: "spec.torrent", wantSeeds: 1},
+		{file: "spec.torrent", strict: true, wantSeeds: 1},
+		{file: "misspelled.torrent"},
+		{file: "misspelled.torrent", strict: true, wantErr: true},
+		{file: "underscored.torrent", wantSeeds: 1},
+		{file: "underscored.torrent", strict: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		name := tt.file
+		if tt.strict {
+			name += " strict"
+		}
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ParseBytesWithOptions(data, ParseOptions{Strict: tt.strict})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBytesWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.Announce != "http://tracker.example.com/announce" {
+				t.Errorf("Announce = %q, want tracker URL", got.Announce)
+			}
+			if got.Info.Length != int64(len(payload)) || got.Info.PieceLength != 16384 {
+				t.Errorf("Length, PieceLength = %d, %d, want %d, 16384", got.Info.Length, got.Info.PieceLength, len(payload))
+			}
+			if len(got.URLList) != tt.wantSeeds {
+				t.Errorf("URLList = %v, want %d entries", got.URLList, tt.wantSeeds)
+			}
+
+			// The pieces must be those of the payload
+			if len(got.PiecesHash) != got.NumPieces() {
+				t.Fatalf("%d piece hashes, want %d", len(got.PiecesHash), got.NumPieces())
+			}
+			for i, hash := range got.PiecesHash {
+				end := min(int64(i+1)*got.Info.PieceLength, got.Info.Length)
+				if sha1.Sum(payload[int64(i)*got.Info.PieceLength:end]) != hash {
+					t.Errorf("piece %d hash doesn't match the payload", i)
+				}
+			}
+		})
+	}
+
+	// Misspelled keys outside the info dictionary leave the info hash alone
+	spec, err := ParseFromFile(filepath.Join("testdata", "spec.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	misspelled, err := ParseFromFile(filepath.Join("testdata", "misspelled.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.InfoHash != misspelled.InfoHash {
+		t.Errorf("InfoHash = %x and %x, want the same", spec.InfoHash, misspelled.InfoHash)
+	}
+}
+
+func TestParseInfoHash(t *testing.T) {
+	want := [20]byte{0xd5, 0x59, 0xb8, 0x09, 0x2c, 0x9f, 0x83, 0x64, 0x72, 0x3a, 0xd9, 0xf2, 0x19, 0x12, 0xd8, 0xe9, 0x65, 0x90, 0xce, 0xa8}
+
+	for _, s := range []string{
+		"d559b8092c9f8364723ad9f21912d8e96590cea8",
+		"D559B8092C9F8364723AD9F21912D8E96590CEA8",
+		"2VM3QCJMT6BWI4R23HZBSEWY5FSZBTVI",
+		"2vm3qcjmt6bwi4r23hzbsewy5fszbtvi",
+	} {
+		got, err := ParseInfoHash(s)
+		if err != nil || got != want {
+			t.Errorf("ParseInfoHash(%q) = %x, %v, want %x", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "d559b8092c9f8364723ad9f21912d8e96590cea", "z559b8092c9f8364723ad9f21912d8e96590cea8"} {
+		if _, err := ParseInfoHash(s); err == nil {
+			t.Errorf("ParseInfoHash(%q) succeeded, want an error", s)
+		}
+	}
+
+	tf := &TorrentFile{InfoHash: want}
+	if err := tf.CheckInfoHash(want); err != nil {
+		t.Errorf("CheckInfoHash(matching) = %v", err)
+	}
+	if err := tf.CheckInfoHash([20]byte{}); !errors.Is(err, ErrInfoHashMismatch) {
+		t.Errorf("CheckInfoHash(other) = %v, want ErrInfoHashMismatch", err)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	var buf bytes.Buffer
+	err := bencode.Encode(&buf, map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"piece length": int64(16384),
+			"pieces":       "abcdefghijklmnopqrst",
+			"length":       int64(16384),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode torrent: %v", err)
+	}
+
+	tf, err := ParseReader(&buf)
+	if err != nil {
+		t.Fatalf("ParseReader() error = %v", err)
+	}
+	if tf.Info.Name != "test.txt" || len(tf.PiecesHash) != 1 {
+		t.Errorf("ParseReader() = %+v", tf.Info)
+	}
+
+	if _, err := ParseReader(bytes.NewReader(nil)); err == nil {
+		t.Error("ParseReader(empty) succeeded, want an error")
+	}
+}
+
+func TestParseBytesNonCanonicalInfo(t *testing.T) {
+	// "name" sorts after "length" and "piece length", so encoding the info
+	// dictionary again reorders it and changes its hash
+	info := "d4:name8:test.txt12:piece lengthi16384e6:pieces20:abcdefghijklmnopqrst6:lengthi16384ee"
+	data := []byte("d8:announce35:http://tracker.example.com/announce4:info" + info + "e")
+
+	tf, err := ParseBytes(data)
+	if err != nil {
+		t.Fatalf("ParseBytes() error = %v", err)
+	}
+	if want := sha1.Sum([]byte(info)); tf.InfoHash != want {
+		t.Errorf("InfoHash = %x, want %x, the hash of the info dictionary as encoded", tf.InfoHash, want)
+	}
+
+	strict, err := ParseBytesWithOptions(data, ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ParseBytesWithOptions(strict) error = %v", err)
+	}
+	if strict.InfoHash != tf.InfoHash {
+		t.Errorf("ParseBytesWithOptions(strict) InfoHash = %x, want %x", strict.InfoHash, tf.InfoHash)
+	}
+
+	decoded, err := bencode.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := Parse(decoded)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if reencoded.InfoHash == tf.InfoHash {
+		t.Error("Parse() of the decoded torrent has the same info hash, want the hash of the canonical encoding")
+	}
+
+	if _, err := ParseBytes([]byte("l4:spame")); !errors.Is(err, ErrInvalidTorrentFile) {
+		t.Errorf("ParseBytes(list) error = %v, want ErrInvalidTorrentFile", err)
+	}
+}
+
+func TestParseLargeTorrent(t *testing.T) {
+	const gb = int64(1) << 30
+	const pieceLength = 32 << 20
+
+	// A 5GB file followed by a small one, in 32MB pieces
+	numPieces := int((5*gb + 100 + pieceLength - 1) / pieceLength)
+	info := func(pieceLength, fileLength int64) map[string]interface{} {
+		return map[string]interface{}{
+			"name":         "large",
+			"piece length": pieceLength,
+			"pieces":       string(make([]byte, 20*numPieces)),
+			"files": []interface{}{
+				map[string]interface{}{"length": fileLength, "path": []interface{}{"big.bin"}},
+				map[string]interface{}{"length": int64(100), "path": []interface{}{"small.txt"}},
+			},
+		}
+	}
+
+	tf, err := Parse(map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info":     info(pieceLength, 5*gb),
+	})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if got := tf.TotalLength(); got != 5*gb+100 {
+		t.Errorf("TotalLength() = %d, want %d", got, 5*gb+100)
+	}
+	if got := tf.PieceSize(numPieces - 1); got != 100 {
+		t.Errorf("PieceSize(last) = %d, want 100", got)
+	}
+	if got := tf.FilePathForPiece(numPieces - 1); !reflect.DeepEqual(got, []string{"large/small.txt"}) {
+		t.Errorf("FilePathForPiece(last) = %v, want the small file", got)
+	}
+
+	for _, tt := range []struct {
+		name                    string
+		pieceLength, fileLength int64
+	}{
+		{"Zero piece length", 0, 5 * gb},
+		{"Piece length too large", 2 * gb, 5 * gb},
+		{"Negative file length", pieceLength, -1},
+	} {
+		_, err := Parse(map[string]interface{}{
+			"announce": "http://tracker.example.com/announce",
+			"info":     info(tt.pieceLength, tt.fileLength),
+		})
+		if !errors.Is(err, ErrInvalidInfoDict) {
+			t.Errorf("%s: Parse() error = %v, want ErrInvalidInfoDict", tt.name, err)
+		}
+	}
+}
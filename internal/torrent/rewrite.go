@@ -0,0 +1,105 @@
+package torrent
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// RewriteRule replaces the parts of tracker URLs that match a regular
+// expression, for example to swap a dead tracker domain for its new one
+type RewriteRule struct {
+	Find    string `json:"find"`    // Regular expression in RE2 syntax
+	Replace string `json:"replace"` // Replacement; $1 and ${name} expand submatches
+}
+
+// RewriteStats reports how many tracker URLs a rule changed
+type RewriteStats struct {
+	Rule  RewriteRule
+	Fired int
+}
+
+// TrackerRewriter applies rewrite rules to the trackers of torrents as they
+// are loaded and counts how often each rule fired
+type TrackerRewriter struct {
+	rules    []RewriteRule
+	compiled []*regexp.Regexp
+
+	mu    sync.Mutex
+	fired []int
+}
+
+// NewTrackerRewriter compiles the rules, which are applied in order
+func NewTrackerRewriter(rules []RewriteRule) (*TrackerRewriter, error) {
+	r := &TrackerRewriter{rules: rules, fired: make([]int, len(rules))}
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Find)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tracker rewrite rule %d: %w", i+1, err)
+		}
+		r.compiled = append(r.compiled, re)
+	}
+
+	return r, nil
+}
+
+// Rewrite rewrites the announce URL and announce list of a torrent in
+// place and returns the number of URLs changed. Each rule sees the output
+// of the rules before it. A URL that ends up twice in a tier is kept once.
+func (r *TrackerRewriter) Rewrite(t *TorrentFile) int {
+	changed := 0
+	rewrite := func(url string) string {
+		out := r.rewriteURL(url)
+		if out != url {
+			changed++
+		}
+		return out
+	}
+
+	if t.Announce != "" {
+		t.Announce = rewrite(t.Announce)
+	}
+
+	for i, tier := range t.AnnounceList {
+		seen := make(map[string]bool, len(tier))
+		rewritten := tier[:0]
+		for _, url := range tier {
+			url = rewrite(url)
+			if !seen[url] {
+				seen[url] = true
+				rewritten = append(rewritten, url)
+			}
+		}
+		t.AnnounceList[i] = rewritten
+	}
+
+	return changed
+}
+
+// rewriteURL applies every rule to a URL, counting the rules that change it
+func (r *TrackerRewriter) rewriteURL(url string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, re := range r.compiled {
+		out := re.ReplaceAllString(url, r.rules[i].Replace)
+		if out != url {
+			r.fired[i]++
+			url = out
+		}
+	}
+
+	return url
+}
+
+// Stats returns how often each rule fired so far, in rule order
+func (r *TrackerRewriter) Stats() []RewriteStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make([]RewriteStats, len(r.rules))
+	for i, rule := range r.rules {
+		stats[i] = RewriteStats{Rule: rule, Fired: r.fired[i]}
+	}
+	return stats
+}
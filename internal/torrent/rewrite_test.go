@@ -0,0 +1,53 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackerRewriter(t *testing.T) {
+	rules := []RewriteRule{
+		{Find: `^(https?)://tracker\.dead\.example(:\d+)?/`, Replace: "${1}://tracker.new.example$2/"},
+		{Find: `^http://`, Replace: "https://"},
+		{Find: `never-matches`, Replace: ""},
+	}
+	rewriter, err := NewTrackerRewriter(rules)
+	if err != nil {
+		t.Fatalf("NewTrackerRewriter() error = %v", err)
+	}
+
+	torrentFile := &TorrentFile{
+		Announce: "http://tracker.dead.example:8080/announce",
+		AnnounceList: [][]string{
+			{"http://tracker.dead.example:8080/announce", "https://tracker.new.example:8080/announce"},
+			{"udp://open.example:1337/announce"},
+		},
+	}
+
+	if n := rewriter.Rewrite(torrentFile); n != 2 {
+		t.Errorf("Rewrite() = %d URLs changed, want 2", n)
+	}
+
+	if want := "https://tracker.new.example:8080/announce"; torrentFile.Announce != want {
+		t.Errorf("Announce = %q, want %q", torrentFile.Announce, want)
+	}
+	wantList := [][]string{
+		{"https://tracker.new.example:8080/announce"},
+		{"udp://open.example:1337/announce"},
+	}
+	if !reflect.DeepEqual(torrentFile.AnnounceList, wantList) {
+		t.Errorf("AnnounceList = %v, want %v", torrentFile.AnnounceList, wantList)
+	}
+
+	var fired []int
+	for _, s := range rewriter.Stats() {
+		fired = append(fired, s.Fired)
+	}
+	if !reflect.DeepEqual(fired, []int{2, 2, 0}) {
+		t.Errorf("rules fired %v times, want [2 2 0]", fired)
+	}
+
+	if _, err := NewTrackerRewriter([]RewriteRule{{Find: "("}}); err == nil {
+		t.Error("NewTrackerRewriter() accepted an invalid regular expression")
+	}
+}
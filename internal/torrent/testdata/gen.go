@@ -0,0 +1,79 @@
+//go:build ignore
+
+// gen writes the .torrent files in this directory for payload.txt, one per
+// spelling of the metainfo keys that TestParseTestdata covers. Run it from
+// this directory with: go run gen.go
+package main
+
+import (
+	"crypto/sha1"
+	"log"
+	"os"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+const pieceLength = 16384
+
+func main() {
+	payload, err := os.ReadFile("payload.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pieces []byte
+	for offset := 0; offset < len(payload); offset += pieceLength {
+		end := min(offset+pieceLength, len(payload))
+		sum := sha1.Sum(payload[offset:end])
+		pieces = append(pieces, sum[:]...)
+	}
+
+	info := func(pieceLengthKey string) map[string]interface{} {
+		return map[string]interface{}{
+			"length":       int64(len(payload)),
+			"name":         "payload.txt",
+			pieceLengthKey: int64(pieceLength),
+			"pieces":       string(pieces),
+		}
+	}
+
+	const tracker = "http://tracker.example.com/announce"
+	torrents := map[string]map[string]interface{}{
+		// The keys of BEP 3, BEP 12 and BEP 19
+		"spec.torrent": {
+			"announce":      tracker,
+			"announce-list": []interface{}{[]interface{}{tracker}},
+			"created by":    "go-torrent testdata",
+			"creation date": int64(1617235200),
+			"info":          info("piece length"),
+			"url-list":      []interface{}{"http://seed.example.com/"},
+		},
+		// announce and announce-list misspelled without the second n
+		"misspelled.torrent": {
+			"annouce":      tracker,
+			"annouce-list": []interface{}{[]interface{}{tracker}},
+			"info":         info("piece length"),
+		},
+		// Spaces and dashes in the keys replaced by underscores
+		"underscored.torrent": {
+			"announce_url":  tracker,
+			"created_by":    "go-torrent testdata",
+			"creation_date": int64(1617235200),
+			"info":          info("piece_length"),
+			"url_list":      []interface{}{"http://seed.example.com/"},
+		},
+	}
+
+	for name, metainfo := range torrents {
+		f, err := os.Create(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := bencode.Encode(f, metainfo); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package tracing records timing spans in the OpenTelemetry data model and
+// exports them to an OTLP/HTTP collector, such as the OpenTelemetry
+// Collector, Jaeger or Tempo, in the JSON encoding of OTLP. It doesn't
+// depend on the OpenTelemetry SDK.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// flushInterval is the longest spans wait before they are sent
+	flushInterval = 5 * time.Second
+
+	// batchSize spans are sent right away
+	batchSize = 512
+
+	// maxQueued spans wait at most; more are dropped while the collector
+	// is slow or unreachable
+	maxQueued = 8 * batchSize
+)
+
+// TraceID identifies a trace, the spans of one operation
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// NewTraceID returns a random trace ID
+func NewTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+// NewSpanID returns a random span ID
+func NewSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// Span is a timed step of an operation
+type Span struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID // Zero for the root span of a trace
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string // Why the step failed, "" when it succeeded
+}
+
+// Attribute describes a span. Values are strings, bools, integers or
+// floats.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Exporter sends spans to an OTLP/HTTP collector in batches
+type Exporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	queued  []Span
+	dropped int
+	full    chan struct{} // Signals a full batch
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewExporter returns an exporter posting spans of serviceName to the
+// collector at endpoint. An endpoint without a path, such as
+// http://localhost:4318, gets the standard /v1/traces path.
+func NewExporter(endpoint, serviceName string) (*Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: want an http:// or https:// URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	e := &Exporter{
+		endpoint:    u.String(),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		full:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go e.run()
+
+	return e, nil
+}
+
+// Export queues spans to be sent. It never waits, so it may be called with
+// locks held.
+func (e *Exporter) Export(spans ...Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	room := maxQueued - len(e.queued)
+	if len(spans) > room {
+		e.dropped += len(spans) - room
+		spans = spans[:room]
+	}
+	e.queued = append(e.queued, spans...)
+
+	if len(e.queued) >= batchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close sends the queued spans and stops the exporter
+func (e *Exporter) Close() error {
+	close(e.done)
+	<-e.stopped
+	return e.flush()
+}
+
+// run sends the queued spans every flushInterval, or as soon as a batch
+// is full
+func (e *Exporter) run() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.full:
+		}
+
+		if err := e.flush(); err != nil {
+			fmt.Printf("Failed to export traces: %v\n", err)
+		}
+	}
+}
+
+// flush sends the queued spans in batches
+func (e *Exporter) flush() error {
+	for {
+		e.mu.Lock()
+		batch := e.queued[:min(len(e.queued), batchSize)]
+		e.queued = e.queued[len(batch):]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			fmt.Printf("Dropped %d spans while the trace collector was behind\n", dropped)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.send(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// send posts one batch
+func (e *Exporter) send(spans []Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// The JSON encoding of an OTLP ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	spanKindInternal = 1
+	statusError      = 2
+)
+
+func (e *Exporter) request(spans []Span) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+		}
+		if s.ParentID != (SpanID{}) {
+			encoded[i].ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		if s.Error != "" {
+			encoded[i].Status = &otlpStatus{Code: statusError, Message: s.Error}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes([]Attribute{{Key: "service.name", Value: e.serviceName}})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: e.serviceName},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func encodeAttributes(attrs []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch v := a.Value.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: a.Key, Value: value})
+	}
+	return encoded
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, req)
+		path = r.URL.Path
+	}))
+	defer server.Close()
+
+	exporter, err := NewExporter(server.URL, "go-torrent")
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+
+	start := time.Unix(1700000000, 5)
+	root := Span{TraceID: NewTraceID(), SpanID: NewSpanID(), Name: "piece", Start: start, End: start.Add(time.Second),
+		Attributes: []Attribute{{Key: "piece.index", Value: 7}, {Key: "piece.verified", Value: false}}}
+	child := Span{TraceID: root.TraceID, SpanID: NewSpanID(), ParentID: root.SpanID, Name: "verify",
+		Start: start, End: start.Add(time.Millisecond), Error: "hash check failed"}
+	exporter.Export(root, child)
+
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path != "/v1/traces" || len(requests) != 1 {
+		t.Fatalf("collector got %d requests at %q, want 1 at /v1/traces", len(requests), path)
+	}
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("collector got %d spans, want 2", len(spans))
+	}
+
+	got := spans[0]
+	if got.TraceID != hex.EncodeToString(root.TraceID[:]) || got.ParentSpanID != "" || got.StartTimeUnixNano != "1700000000000000005" {
+		t.Errorf("root span = %+v", got)
+	}
+	if got.Attributes[0].Value["intValue"] != "7" || got.Attributes[1].Value["boolValue"] != false {
+		t.Errorf("root span attributes = %+v", got.Attributes)
+	}
+
+	got = spans[1]
+	if got.ParentSpanID != hex.EncodeToString(root.SpanID[:]) || got.Status == nil || got.Status.Code != statusError {
+		t.Errorf("child span = %+v, want the root as parent and an error status", got)
+	}
+}
+
+func TestNewExporterRejectsInvalidEndpoints(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "grpc://localhost:4317", "http://"} {
+		if _, err := NewExporter(endpoint, "go-torrent"); err == nil {
+			t.Errorf("NewExporter(%q) succeeded", endpoint)
+		}
+	}
+}
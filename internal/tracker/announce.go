@@ -1,27 +1,89 @@
 package tracker
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
-	"time"
+	"strings"
+)
 
-	"github.com/piyushgupta53/go-torrent/internal/bencode"
+var (
+	ErrTrackerUnreachable = errors.New("tracker unreachable")
+	ErrTrackerFailure     = errors.New("tracker error") // The tracker answered with a failure reason
 )
 
-// Announce sends an announce request to the tracker and returns the response
-func (c *Client) Announce(trackerURL string, req *AnnounceRequest) (*AnnounceResponse, error) {
+// Announce sends an announce request to the tracker and returns the
+// response. Cancelling ctx abandons the request.
+func (c *Client) Announce(ctx context.Context, trackerURL string, req *AnnounceRequest) (*AnnounceResponse, error) {
 	// Build the URL with the query parameters
 	u, err := url.Parse(trackerURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid tracker URL: %w", err)
 	}
 
+	// Wait for our turn on this tracker host
+	release, err := c.acquireHost(ctx, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// WebTorrent trackers speak JSON over WebSocket
+	if isWebSocketURL(trackerURL) {
+		return c.announceWebSocket(ctx, trackerURL, req)
+	}
+
+	// Trackers that fail compact announces get a second chance without
+	// compact, and are then asked without it for the rest of the session
+	compact := req.Compact && c.compactMode(trackerURL) != compactRefused
+	response, err := c.announceHTTP(ctx, u, req, compact)
+	if err != nil && compact && c.compactMode(trackerURL) == compactUnknown &&
+		(errors.Is(err, ErrTrackerFailure) || errors.Is(err, ErrInvalidResponse)) {
+		if retried, retryErr := c.announceHTTP(ctx, u, req, false); retryErr == nil {
+			c.setCompactMode(trackerURL, compactRefused)
+			return retried, nil
+		}
+	}
+	if err == nil && compact {
+		c.setCompactMode(trackerURL, compactAccepted)
+	}
+	return response, err
+}
+
+// compactMode returns what is known about compact announces to a tracker
+func (c *Client) compactMode(trackerURL string) compactMode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.compact[trackerURL]
+}
+
+func (c *Client) setCompactMode(trackerURL string, mode compactMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.compact == nil {
+		c.compact = make(map[string]compactMode)
+	}
+	c.compact[trackerURL] = mode
+}
+
+// CompactRefused reports whether a tracker failed compact announces and is
+// now asked for non-compact peer lists
+func (c *Client) CompactRefused(trackerURL string) bool {
+	return c.compactMode(trackerURL) == compactRefused
+}
+
+// announceHTTP sends an announce to an HTTP tracker, asking for compact
+// peer lists or not
+func (c *Client) announceHTTP(ctx context.Context, u *url.URL, req *AnnounceRequest, compact bool) (*AnnounceResponse, error) {
 	// Build query parameters
 	params := url.Values{}
 
@@ -32,7 +94,7 @@ func (c *Client) Announce(trackerURL string, req *AnnounceRequest) (*AnnounceRes
 	params.Add("downloaded", strconv.FormatInt(req.Downloaded, 10))
 	params.Add("left", strconv.FormatInt(req.Left, 10))
 
-	if req.Compact {
+	if compact {
 		params.Add("compact", "1")
 	} else {
 		params.Add("compact", "0")
@@ -42,42 +104,57 @@ func (c *Client) Announce(trackerURL string, req *AnnounceRequest) (*AnnounceRes
 		params.Add("event", req.Event)
 	}
 
-	u.RawQuery = params.Encode()
+	if req.TrackerID != "" {
+		params.Add("trackerid", req.TrackerID)
+	}
+
+	if req.NumWant > 0 {
+		params.Add("numwant", strconv.Itoa(req.NumWant))
+	}
 
-	// Create HTTP client with a timeout
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+	// Trackers that key peers by IP and port, as private trackers do,
+	// recognize us by the key after our address changes
+	if c.Key != 0 {
+		params.Add("key", fmt.Sprintf("%08X", c.Key))
 	}
 
-	// Send the request
-	resp, err := client.Get(u.String())
+	u.RawQuery = params.Encode()
+
+	// Send the request over the shared connection pool
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to contact tracker: %w", err)
+		return nil, fmt.Errorf("invalid tracker URL: %w", err)
+	}
+	resp, err := sharedHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrackerUnreachable, err)
 	}
 
 	defer resp.Body.Close()
 
 	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read tracker response: %w", err)
 	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes (HTTP %s)", ErrInvalidResponse, maxResponseSize, resp.Status)
+	}
 
-	// Parse the response
-	return parseAnnounceResponse(body)
+	// Parse the response; a body that isn't bencode is often explained
+	// by the status
+	response, err := parseAnnounceResponse(body)
+	if errors.Is(err, ErrInvalidResponse) {
+		return nil, fmt.Errorf("%w (HTTP %s)", err, resp.Status)
+	}
+	return response, err
 }
 
 // parseAnnounceResponse parses the bencode-encoded tracker response
 func parseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
-
-	decoded, err := bencode.Decode(bytes.NewReader(data))
+	dict, err := decodeResponse(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
-	}
-
-	dict, ok := decoded.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("tracker response is not a dictionary")
+		return nil, err
 	}
 
 	// Check for error from tracker
@@ -87,29 +164,51 @@ func parseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
 			return nil, fmt.Errorf("invalid failure reason format")
 		}
 
-		return nil, fmt.Errorf("tracker error: %s", reason)
+		return nil, fmt.Errorf("%w: %s", ErrTrackerFailure, reason)
 	}
 
 	response := &AnnounceResponse{}
 
-	// Parse interval
-	if internalVal, ok := dict["interval"]; ok {
-		interval, ok := internalVal.(int)
-		if !ok {
-			return nil, fmt.Errorf("invalid interval format")
+	// Parse the integer fields: intervals and swarm counts
+	for key, field := range map[string]*int{
+		"interval":     &response.Interval,
+		"min interval": &response.MinInterval,
+		"complete":     &response.Complete,
+		"incomplete":   &response.Incomplete,
+	} {
+		if val, ok := dict[key]; ok {
+			n, ok := val.(int64)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s format", key)
+			}
+
+			*field = int(n)
 		}
+	}
+
+	// Parse the string fields
+	for key, field := range map[string]*string{
+		"tracker id":      &response.TrackerID,
+		"warning message": &response.WarningMessage,
+	} {
+		if val, ok := dict[key]; ok {
+			str, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s format", key)
+			}
 
-		response.Interval = int(interval)
+			*field = str
+		}
 	}
 
-	// Parse complete count (seeders)
-	if completeVal, ok := dict["complete"]; ok {
-		complete, ok := completeVal.(int)
-		if !ok {
-			return nil, fmt.Errorf("invalid complete format")
+	// Parse our address as seen by the tracker (BEP 24)
+	if externalIPVal, ok := dict["external ip"]; ok {
+		externalIP, ok := externalIPVal.(string)
+		if !ok || (len(externalIP) != net.IPv4len && len(externalIP) != net.IPv6len) {
+			return nil, fmt.Errorf("invalid external ip format")
 		}
 
-		response.Complete = int(complete)
+		response.ExternalIP = net.IP(externalIP)
 	}
 
 	// Parse peers
@@ -127,11 +226,31 @@ func parseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse non-compact peers: %w", err)
 			}
+		case map[string]interface{}:
+			// Non-compact peers keyed by peer ID, sent by some old trackers
+			response.Peers, err = parsePeersByID(peers)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse non-compact peers: %w", err)
+			}
 		default:
 			return nil, fmt.Errorf("invalid peers format")
 		}
 	}
 
+	// Parse IPv6 peers (BEP 7)
+	if peers6Val, ok := dict["peers6"]; ok {
+		peers6, ok := peers6Val.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid peers6 format")
+		}
+
+		peers, err := parseCompactPeers6([]byte(peers6))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse compact peers6: %w", err)
+		}
+		response.Peers = append(response.Peers, peers...)
+	}
+
 	return response, nil
 }
 
@@ -149,7 +268,7 @@ func parseCompactPeers(data []byte) ([]Peer, error) {
 		offset := i * 6
 
 		// Parse IP (4 bytes)
-		ip := net.IP(data[offset : offset+4])
+		ip := net.IPv4(data[offset], data[offset+1], data[offset+2], data[offset+3])
 
 		// Parse port (2 bytes, big endian)
 		port := binary.BigEndian.Uint16(data[offset+4 : offset+6])
@@ -163,11 +282,32 @@ func parseCompactPeers(data []byte) ([]Peer, error) {
 	return peers, nil
 }
 
+// parseCompactPeers6 parses the compact IPv6 peer format: 16 bytes of
+// address followed by a 2 byte port per peer
+func parseCompactPeers6(data []byte) ([]Peer, error) {
+	if len(data)%18 != 0 {
+		return nil, fmt.Errorf("invalid compact peers6 length: %d", len(data))
+	}
+
+	peers := make([]Peer, len(data)/18)
+	for i := range peers {
+		offset := i * 18
+		peers[i] = Peer{
+			IP:   net.IP(append([]byte(nil), data[offset:offset+16]...)),
+			Port: int(binary.BigEndian.Uint16(data[offset+16 : offset+18])),
+		}
+	}
+
+	return peers, nil
+}
+
 // parseNonCompactPeers parses the non-compact peer format
 func parseNonCompactPeers(data []interface{}) ([]Peer, error) {
-	peers := make([]Peer, len(data))
+	peers := make([]Peer, 0, len(data))
 
 	for i, peerData := range data {
+		var peer Peer
+
 		peerDict, ok := peerData.(map[string]interface{})
 		if !ok {
 			return nil, fmt.Errorf("peer %d is not a dictionary", i)
@@ -180,7 +320,7 @@ func parseNonCompactPeers(data []interface{}) ([]Peer, error) {
 				return nil, fmt.Errorf("peer %d has invalid peer id", i)
 			}
 
-			copy(peers[i].ID[:], []byte(peerIDStr))
+			copy(peer.ID[:], []byte(peerIDStr))
 		}
 
 		// Parse IP
@@ -194,9 +334,14 @@ func parseNonCompactPeers(data []interface{}) ([]Peer, error) {
 			return nil, fmt.Errorf("peer %d has invalid ip", i)
 		}
 
-		peers[i].IP = net.ParseIP(ipStr)
-		if peers[i].IP == nil {
-			return nil, fmt.Errorf("peer %d has invalid ip address: %s", i, ipStr)
+		peer.IP = net.ParseIP(ipStr)
+		if peer.IP == nil {
+			// BEP 3 allows a DNS name, which some trackers send for
+			// peers behind dynamic DNS; those peers are left out
+			if !isHostname(ipStr) {
+				return nil, fmt.Errorf("peer %d has invalid ip address: %s", i, ipStr)
+			}
+			continue
 		}
 
 		// Parse Port
@@ -210,8 +355,55 @@ func parseNonCompactPeers(data []interface{}) ([]Peer, error) {
 			return nil, fmt.Errorf("peer %d has invalid port", i)
 		}
 
-		peers[i].Port = int(port)
+		peer.Port = int(port)
+		peers = append(peers, peer)
 	}
 
 	return peers, nil
 }
+
+// parsePeersByID parses non-compact peers given as a dictionary from peer
+// ID to the peer's ip and port, in peer ID order
+func parsePeersByID(data map[string]interface{}) ([]Peer, error) {
+	ids := make([]string, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]interface{}, len(ids))
+	for i, id := range ids {
+		peerDict, ok := data[id].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("peer %x is not a dictionary", id)
+		}
+
+		withID := map[string]interface{}{"peer id": id}
+		for key, val := range peerDict {
+			withID[key] = val
+		}
+		list[i] = withID
+	}
+
+	return parseNonCompactPeers(list)
+}
+
+// isHostname reports whether s is a valid DNS name
+func isHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
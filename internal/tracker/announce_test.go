@@ -51,6 +51,23 @@ func TestParseCompactPeers(t *testing.T) {
 	}
 }
 
+func TestParseCompactPeers6(t *testing.T) {
+	data := append(net.ParseIP("2001:db8::1").To16(), 0x1A, 0xE1)
+
+	got, err := parseCompactPeers6(data)
+	if err != nil {
+		t.Fatalf("parseCompactPeers6() error = %v", err)
+	}
+
+	if len(got) != 1 || got[0].String() != "[2001:db8::1]:6881" {
+		t.Errorf("parseCompactPeers6() = %v, want [2001:db8::1]:6881", got)
+	}
+
+	if _, err := parseCompactPeers6(data[:17]); err == nil {
+		t.Errorf("parseCompactPeers6() with 17 bytes succeeded, want error")
+	}
+}
+
 func TestParseAnnounceResponse(t *testing.T) {
 	// Create a mock tracker response
 	compactResponse := map[string]interface{}{
@@ -73,6 +90,22 @@ func TestParseAnnounceResponse(t *testing.T) {
 		},
 	}
 
+	peersByIDResponse := map[string]interface{}{
+		"interval": int64(1800),
+		"peers": map[string]interface{}{
+			"01234567890123456789": map[string]interface{}{"ip": "127.0.0.2", "port": int64(6882)},
+			"00000000000000000000": map[string]interface{}{"ip": "127.0.0.1", "port": int64(6881)},
+		},
+	}
+
+	extendedResponse := map[string]interface{}{
+		"interval":        int64(1800),
+		"min interval":    int64(900),
+		"tracker id":      "abc",
+		"warning message": "slow down",
+		"external ip":     string([]byte{203, 0, 113, 7}),
+	}
+
 	errorResponse := map[string]interface{}{
 		"failure reason": "Invalid info_hash",
 	}
@@ -113,6 +146,30 @@ func TestParseAnnounceResponse(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:     "Peers keyed by peer ID",
+			response: peersByIDResponse,
+			expected: &AnnounceResponse{
+				Interval: 1800,
+				Peers: []Peer{
+					{ID: [20]byte{'0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0', '0'}, IP: net.ParseIP("127.0.0.1"), Port: 6881},
+					{ID: [20]byte{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9'}, IP: net.ParseIP("127.0.0.2"), Port: 6882},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Extended response",
+			response: extendedResponse,
+			expected: &AnnounceResponse{
+				Interval:       1800,
+				MinInterval:    900,
+				TrackerID:      "abc",
+				WarningMessage: "slow down",
+				ExternalIP:     net.IP{203, 0, 113, 7},
+			},
+			wantErr: false,
+		},
 		{
 			name:     "Error response",
 			response: errorResponse,
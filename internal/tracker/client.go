@@ -1,8 +1,11 @@
 package tracker
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/piyushgupta53/go-torrent/internal/torrent"
@@ -23,7 +26,7 @@ func (c *Client) DiscoverPeers(torrent *torrent.TorrentFile) ([]Peer, error) {
 	}
 
 	// Contact the tracker
-	response, err := c.Announce(torrent.Announce, req)
+	response, err := c.Announce(context.Background(), torrent.Announce, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to announce to tracker: %w", err)
 	}
@@ -39,5 +42,5 @@ func (c *Client) DiscoverPeers(torrent *torrent.TorrentFile) ([]Peer, error) {
 
 // String returns a string representation of a peer
 func (p *Peer) String() string {
-	return fmt.Sprintf("%s:%d", p.IP.String(), p.Port)
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(p.Port))
 }
@@ -2,7 +2,9 @@ package tracker
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
+	"math/big"
 )
 
 // GeneratePeerID generates a unique peer ID for our client
@@ -23,3 +25,42 @@ func GeneratePeerID() ([20]byte, error) {
 
 	return peerID, nil
 }
+
+// GenerateAnonymousPeerID generates a peer ID that doesn't identify our
+// client: the Azureus-style prefix uses random letters and version digits,
+// so each torrent gets a different one.
+// Format: -[2 random letters][4 random digits]-[12 random bytes]
+func GenerateAnonymousPeerID() ([20]byte, error) {
+	peerID, err := GeneratePeerID()
+	if err != nil {
+		return peerID, err
+	}
+
+	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	const digits = "0123456789"
+
+	for i := 1; i < 7; i++ {
+		alphabet := digits
+		if i < 3 {
+			alphabet = letters
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return peerID, fmt.Errorf("failed to generate peer ID: %w", err)
+		}
+		peerID[i] = alphabet[n.Int64()]
+	}
+
+	return peerID, nil
+}
+
+// generateKey returns a random announce key. A key that can't be generated
+// is left out of announces.
+func generateKey() uint32 {
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(key[:])
+}
@@ -0,0 +1,135 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver caches the DNS lookups of tracker hostnames. Answers are reused
+// until their TTL expires, and when a refresh fails the last good answer
+// keeps being used, so a flaky DNS server doesn't fail announces. With Pin
+// set, the first answer for a host is used for the rest of the session.
+//
+// The standard library resolver doesn't report record TTLs, so every
+// answer is trusted for the same configured TTL.
+type Resolver struct {
+	TTL         time.Duration // How long an answer is used before it is refreshed
+	NegativeTTL time.Duration // How long a failed lookup is remembered
+	Pin         bool          // Never refresh an answer once we have one
+
+	// Lookup resolves a host, net.DefaultResolver.LookupIPAddr by default
+	Lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	stats   ResolverStats
+}
+
+// dnsEntry is the cached answer for one host
+type dnsEntry struct {
+	addrs   []net.IPAddr // Last good answer
+	err     error        // Last failure, while no good answer is known
+	expires time.Time
+}
+
+// ResolverStats counts the lookups made by a resolver
+type ResolverStats struct {
+	Lookups  int64 // Queries sent to DNS
+	Hits     int64 // Answers served from the cache
+	Failures int64 // Queries that failed
+	Stale    int64 // Expired answers served because a refresh failed
+}
+
+// DefaultResolver resolves the hosts of every tracker the process talks to
+var DefaultResolver = NewResolver(5 * time.Minute)
+
+// NewResolver creates a resolver caching answers for ttl
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		TTL:         ttl,
+		NegativeTTL: 30 * time.Second,
+		Lookup:      net.DefaultResolver.LookupIPAddr,
+		entries:     make(map[string]*dnsEntry),
+	}
+}
+
+// Resolve returns the addresses of host, from the cache when possible
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	if ok && (time.Now().Before(entry.expires) || (r.Pin && entry.addrs != nil)) {
+		r.stats.Hits++
+		addrs, err := entry.addrs, entry.err
+		r.mu.Unlock()
+		return addrs, err
+	}
+	r.stats.Lookups++
+	r.mu.Unlock()
+
+	addrs, err := r.Lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses found for %s", host)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.stats.Failures++
+
+		// Keep using the last good answer rather than failing the announce
+		if ok && entry.addrs != nil {
+			r.stats.Stale++
+			entry.expires = time.Now().Add(r.NegativeTTL)
+			return entry.addrs, nil
+		}
+
+		r.entries[host] = &dnsEntry{err: err, expires: time.Now().Add(r.NegativeTTL)}
+		return nil, err
+	}
+
+	r.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(r.TTL)}
+	return addrs, nil
+}
+
+// Stats returns the resolver's lookup counters
+func (r *Resolver) Stats() ResolverStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// DialContext returns a dial function that resolves hosts through the
+// cache and tries each of their addresses in turn
+func (r *Resolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := r.Resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,95 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeLookup answers lookups from a table and counts them
+type fakeLookup struct {
+	answers map[string][]net.IPAddr
+	err     error
+	calls   int
+}
+
+func (f *fakeLookup) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.answers[host], nil
+}
+
+func TestResolverCachesUntilTTL(t *testing.T) {
+	fake := &fakeLookup{answers: map[string][]net.IPAddr{"tracker": {{IP: net.ParseIP("192.0.2.1")}}}}
+	r := NewResolver(time.Hour)
+	r.Lookup = fake.lookup
+
+	for i := 0; i < 3; i++ {
+		addrs, err := r.Resolve(context.Background(), "tracker")
+		if err != nil || len(addrs) != 1 {
+			t.Fatalf("Resolve() = %v, %v", addrs, err)
+		}
+	}
+
+	if fake.calls != 1 {
+		t.Errorf("lookups = %d, want 1", fake.calls)
+	}
+	if got := r.Stats(); got.Lookups != 1 || got.Hits != 2 {
+		t.Errorf("Stats() = %+v, want 1 lookup and 2 hits", got)
+	}
+}
+
+func TestResolverServesStaleOnFailure(t *testing.T) {
+	fake := &fakeLookup{answers: map[string][]net.IPAddr{"tracker": {{IP: net.ParseIP("192.0.2.1")}}}}
+	r := NewResolver(0) // every answer expires immediately
+	r.Lookup = fake.lookup
+
+	if _, err := r.Resolve(context.Background(), "tracker"); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	fake.err = errors.New("server misbehaving")
+	addrs, err := r.Resolve(context.Background(), "tracker")
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("Resolve() with failing DNS = %v, %v, want last good answer", addrs, err)
+	}
+
+	if got := r.Stats(); got.Failures != 1 || got.Stale != 1 {
+		t.Errorf("Stats() = %+v, want 1 failure served stale", got)
+	}
+}
+
+func TestResolverPin(t *testing.T) {
+	fake := &fakeLookup{answers: map[string][]net.IPAddr{"tracker": {{IP: net.ParseIP("192.0.2.1")}}}}
+	r := NewResolver(0)
+	r.Pin = true
+	r.Lookup = fake.lookup
+
+	r.Resolve(context.Background(), "tracker")
+	fake.answers["tracker"] = []net.IPAddr{{IP: net.ParseIP("192.0.2.2")}}
+
+	addrs, _ := r.Resolve(context.Background(), "tracker")
+	if !addrs[0].IP.Equal(net.ParseIP("192.0.2.1")) || fake.calls != 1 {
+		t.Errorf("Resolve() = %v after %d lookups, want pinned 192.0.2.1 after 1", addrs, fake.calls)
+	}
+}
+
+func TestResolverNegativeCache(t *testing.T) {
+	fake := &fakeLookup{err: errors.New("no such host")}
+	r := NewResolver(time.Hour)
+	r.Lookup = fake.lookup
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(context.Background(), "tracker"); err == nil {
+			t.Fatal("Resolve() error = nil, want failure")
+		}
+	}
+
+	if fake.calls != 1 {
+		t.Errorf("lookups = %d, want failure remembered", fake.calls)
+	}
+}
@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+// ErrInvalidResponse is returned when a tracker answers with something
+// other than a bencoded dictionary, such as an HTML error page from a proxy
+// in front of it or a body cut off mid-transfer
+var ErrInvalidResponse = errors.New("invalid tracker response")
+
+// responseSnippetLength is the number of bytes of an invalid response
+// quoted in the error
+const responseSnippetLength = 80
+
+// maxResponseSize is the largest response body read from a tracker, far
+// more than any list of peers needs
+const maxResponseSize = 8 << 20
+
+// decodeResponse decodes the body of a tracker response into its
+// dictionary, describing what came back instead when it isn't one
+func decodeResponse(data []byte) (map[string]interface{}, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("%w: empty body", ErrInvalidResponse)
+	case trimmed[0] == '<':
+		return nil, fmt.Errorf("%w: got an HTML page: %s", ErrInvalidResponse, htmlSnippet(trimmed))
+	case data[0] != 'd':
+		return nil, fmt.Errorf("%w: not bencoded: %s", ErrInvalidResponse, snippet(data))
+	}
+
+	// A hostile tracker may claim huge strings or nest lists endlessly
+	decoded, err := bencode.DecodeLimited(bytes.NewReader(data), bencode.DefaultLimits)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: truncated after %d bytes: %s", ErrInvalidResponse, len(data), snippet(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %s", ErrInvalidResponse, err, snippet(data))
+	}
+
+	dict, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: not a dictionary: %s", ErrInvalidResponse, snippet(data))
+	}
+
+	return dict, nil
+}
+
+// snippet quotes the start of a response body with its whitespace collapsed
+func snippet(data []byte) string {
+	truncated := len(data) > responseSnippetLength
+	if truncated {
+		data = data[:responseSnippetLength]
+	}
+
+	s := fmt.Sprintf("%q", strings.Join(strings.Fields(string(data)), " "))
+	if truncated {
+		s += "..."
+	}
+	return s
+}
+
+// htmlSnippet quotes the title of an HTML page, which names the error on
+// most error pages, or else the start of the page
+func htmlSnippet(page []byte) string {
+	lower := bytes.ToLower(page)
+	start := bytes.Index(lower, []byte("<title>"))
+	end := bytes.Index(lower, []byte("</title>"))
+	if start < 0 || end < start {
+		return snippet(page)
+	}
+
+	return snippet(page[start+len("<title>") : end])
+}
@@ -0,0 +1,300 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Hand-written announce responses covering the shapes trackers send:
+// compact and non-compact peers, peers6, failures, warnings and broken
+// bodies. They are not captured from real trackers: no recorded responses
+// from opentracker, chihaya or private tracker software are checked in, so
+// interoperability with them is not tested here. Keys are sorted as
+// bencoding requires; peer data is binary.
+var handWrittenResponses = []struct {
+	name     string
+	body     string
+	wantErr  string // Substring of the expected error, empty if none
+	peers    []string
+	interval int
+	min      int
+	seeders  int
+	leechers int
+	warning  string
+}{
+	{
+		name: "compact with swarm counts",
+		body: "d8:completei4e10:downloadedi10e10:incompletei2e8:intervali1800e12:min intervali900e" +
+			"5:peers12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x1f\x90e",
+		peers:    []string{"10.0.0.1:6881", "192.168.1.2:8080"},
+		interval: 1800, min: 900, seeders: 4, leechers: 2,
+	},
+	{
+		name: "empty peers with IPv6 peers6",
+		body: "d8:completei1e10:downloadedi0e10:incompletei1e8:intervali1800e12:min intervali900e" +
+			"5:peers0:6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1e",
+		peers:    []string{"[2001:db8::1]:6881"},
+		interval: 1800, min: 900, seeders: 1, leechers: 1,
+	},
+	{
+		name:    "failure reason",
+		body:    "d14:failure reason36:Requested download is not authorizede",
+		wantErr: "Requested download is not authorized",
+	},
+	{
+		name: "compact peers and peers6",
+		body: "d8:completei0e10:incompletei2e8:intervali1800e12:min intervali900e" +
+			"5:peers6:\x0a\x00\x00\x02\x1a\xe16:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x1a\xe2e",
+		peers:    []string{"10.0.0.2:6881", "[2001:db8::2]:6882"},
+		interval: 1800, min: 900, leechers: 2,
+	},
+	{
+		name: "non-compact without peer IDs",
+		body: "d8:completei1e10:incompletei1e8:intervali1800e12:min intervali900e" +
+			"5:peersld2:ip8:10.0.0.34:porti6881eed2:ip11:2001:db8::34:porti51413eeee",
+		peers:    []string{"10.0.0.3:6881", "[2001:db8::3]:51413"},
+		interval: 1800, min: 900, seeders: 1, leechers: 1,
+	},
+	{
+		name:     "non-compact with a DNS name",
+		body:     "d8:intervali1800e5:peersld2:ip16:peer.example.net7:peer id20:-XX0001-abcdefghijkl4:porti6881eed2:ip8:10.0.0.44:porti6881eeee",
+		peers:    []string{"10.0.0.4:6881"},
+		interval: 1800,
+	},
+	{
+		name: "private tracker with a warning",
+		body: "d8:completei12e10:downloadedi30e10:incompletei3e8:intervali2400e12:min intervali1800e" +
+			"5:peers6:\x0a\x00\x00\x05\xc8\xd515:warning message29:Your client is not up to datee",
+		peers:    []string{"10.0.0.5:51413"},
+		interval: 2400, min: 1800, seeders: 12, leechers: 3,
+		warning: "Your client is not up to date",
+	},
+	{
+		name:    "private tracker unregistered torrent",
+		body:    "d14:failure reason21:Unregistered torrent.8:intervali5400e12:min intervali5400ee",
+		wantErr: "Unregistered torrent.",
+	},
+	{
+		name:    "error page from a proxy in front of the tracker",
+		body:    "<!DOCTYPE html>\n<html><head><title>502 Bad Gateway</title></head><body><h1>Bad Gateway</h1></body></html>",
+		wantErr: `got an HTML page: "502 Bad Gateway"`,
+	},
+	{
+		name:    "body cut off mid-transfer",
+		body:    "d8:intervali1800e5:peers12:\x0a\x00\x00\x01",
+		wantErr: "truncated after 31 bytes",
+	},
+	{
+		name:    "plain text error",
+		body:    "Torrent not registered\n",
+		wantErr: `not bencoded: "Torrent not registered"`,
+	},
+	{
+		name:    "hostile string length",
+		body:    "d8:intervali1800e5:peers99999999999:\x0a\x00\x00\x01",
+		wantErr: "string of length 99999999999 is longer than",
+	},
+	{
+		name:    "hostile nesting",
+		body:    "d8:intervali1800e5:peers" + strings.Repeat("l", 1000),
+		wantErr: "nested deeper than",
+	},
+}
+
+func TestParseHandWrittenResponses(t *testing.T) {
+	for _, tt := range handWrittenResponses {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := parseAnnounceResponse([]byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseAnnounceResponse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAnnounceResponse() error = %v", err)
+			}
+
+			var peers []string
+			for _, peer := range resp.Peers {
+				peers = append(peers, peer.String())
+			}
+			if strings.Join(peers, " ") != strings.Join(tt.peers, " ") {
+				t.Errorf("peers = %v, want %v", peers, tt.peers)
+			}
+
+			if resp.Interval != tt.interval || resp.MinInterval != tt.min {
+				t.Errorf("intervals = %d, %d, want %d, %d", resp.Interval, resp.MinInterval, tt.interval, tt.min)
+			}
+			if resp.Complete != tt.seeders || resp.Incomplete != tt.leechers {
+				t.Errorf("swarm = %d seeders, %d leechers, want %d, %d", resp.Complete, resp.Incomplete, tt.seeders, tt.leechers)
+			}
+			if resp.WarningMessage != tt.warning {
+				t.Errorf("warning = %q, want %q", resp.WarningMessage, tt.warning)
+			}
+		})
+	}
+}
+
+func TestAnnounceReportsInvalidResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("<html><title>Service Unavailable</title></html>"))
+	}))
+	defer server.Close()
+
+	client := NewClient([20]byte{}, 6881)
+	_, err := client.Announce(context.Background(), server.URL+"/announce", &AnnounceRequest{Compact: true})
+	if !errors.Is(err, ErrInvalidResponse) || !strings.Contains(err.Error(), "HTTP 503 Service Unavailable") {
+		t.Errorf("Announce() error = %v, want ErrInvalidResponse with the HTTP status", err)
+	}
+}
+
+func TestAnnounceCancelled(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	client := NewClient([20]byte{}, 6881)
+	client.Scheduler = nil
+	start := time.Now()
+	_, err := client.Announce(ctx, server.URL+"/announce", &AnnounceRequest{Compact: true})
+	if !errors.Is(err, ErrTrackerUnreachable) {
+		t.Errorf("Announce() error = %v, want ErrTrackerUnreachable", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Announce() returned %v after its context was cancelled", elapsed)
+	}
+}
+
+func TestAnnounceFallsBackToNonCompact(t *testing.T) {
+	var compact []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compact = append(compact, r.URL.Query().Get("compact"))
+		if r.URL.Query().Get("compact") == "1" {
+			w.Write([]byte("d14:failure reason24:compact not supportede"))
+			return
+		}
+		w.Write([]byte("d8:intervali1800e5:peersld2:ip9:127.0.0.14:porti6881eeee"))
+	}))
+	defer server.Close()
+
+	client := NewClient([20]byte{}, 6881)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Announce(context.Background(), server.URL+"/announce", &AnnounceRequest{Compact: true})
+		if err != nil {
+			t.Fatalf("Announce() error = %v", err)
+		}
+		if len(resp.Peers) != 1 {
+			t.Errorf("Announce() = %d peers, want 1", len(resp.Peers))
+		}
+	}
+
+	// The second announce goes straight to compact=0
+	if !reflect.DeepEqual(compact, []string{"1", "0", "0"}) {
+		t.Errorf("tracker saw compact = %q, want [1 0 0]", compact)
+	}
+	if !client.CompactRefused(server.URL + "/announce") {
+		t.Error("CompactRefused() = false after falling back")
+	}
+}
+
+func TestAnnounceParameters(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Write([]byte("d8:intervali1800e5:peers0:10:tracker id3:abce"))
+	}))
+	defer server.Close()
+
+	infoHash := [20]byte{0x00, 0xff, ' ', '%', '&'}
+	peerID := [20]byte{'-', 'G', 'T', '0', '0', '0', '1', '-'}
+	client := NewClient(peerID, 6881)
+
+	req := &AnnounceRequest{InfoHash: infoHash, PeerID: peerID, Port: 6881, Left: 100, Compact: true, Event: "started"}
+	resp, err := client.Announce(context.Background(), server.URL+"/announce", req)
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+
+	req.Event = ""
+	req.TrackerID = resp.TrackerID
+	req.NumWant = 200
+	if _, err := client.Announce(context.Background(), server.URL+"/announce", req); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+
+	if len(queries) != 2 {
+		t.Fatalf("tracker saw %d announces, want 2", len(queries))
+	}
+
+	first, second := queries[0], queries[1]
+	for key, want := range map[string]string{
+		"info_hash":  string(infoHash[:]),
+		"peer_id":    string(peerID[:]),
+		"port":       "6881",
+		"uploaded":   "0",
+		"downloaded": "0",
+		"left":       "100",
+		"compact":    "1",
+		"event":      "started",
+	} {
+		if got := first.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// The key is 8 hex digits and stays the same for the session
+	if key := first.Get("key"); len(key) != 8 || key != second.Get("key") {
+		t.Errorf("key = %q then %q, want the same 8 hex digits", key, second.Get("key"))
+	}
+
+	// Regular announces leave the event out and echo the tracker ID
+	if _, ok := second["event"]; ok {
+		t.Errorf("regular announce sent event %q", second.Get("event"))
+	}
+	if got := second.Get("trackerid"); got != "abc" {
+		t.Errorf("trackerid = %q, want abc", got)
+	}
+
+	// numwant is only sent when asked for
+	if _, ok := first["numwant"]; ok {
+		t.Errorf("first announce sent numwant %q", first.Get("numwant"))
+	}
+	if got := second.Get("numwant"); got != "200" {
+		t.Errorf("numwant = %q, want 200", got)
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	for host, want := range map[string]bool{
+		"peer.example.net":  true,
+		"localhost":         true,
+		"peer.example.net.": true,
+		"":                  false,
+		"-bad.example":      false,
+		"bad..example":      false,
+		"under_score.net":   false,
+		"[::1]":             false,
+	} {
+		if got := isHostname(host); got != want {
+			t.Errorf("isHostname(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
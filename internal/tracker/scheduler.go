@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HostScheduler paces requests to each tracker host so that many torrents
+// announcing to the same tracker don't hammer it or trip its rate limits
+type HostScheduler struct {
+	MinInterval   time.Duration // Minimum spacing between requests to one host
+	MaxConcurrent int           // Requests allowed in flight to one host
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState tracks the requests to a single host
+type hostState struct {
+	next  time.Time     // Earliest time the next request may start
+	slots chan struct{} // One token per request in flight
+}
+
+// DefaultScheduler is shared by all clients that don't set their own, so
+// every torrent in the process is paced together
+var DefaultScheduler = NewHostScheduler(250*time.Millisecond, 2)
+
+// dialTracker opens the connections to trackers
+var dialTracker = DefaultResolver.DialContext(&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second})
+
+// sharedTransport reuses tracker connections across announces and torrents
+var sharedTransport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	DialContext:         dialTracker,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 4,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// sharedHTTPClient sends every HTTP tracker request
+var sharedHTTPClient = &http.Client{
+	Timeout:   15 * time.Second,
+	Transport: sharedTransport,
+}
+
+// SetProxy routes every tracker connection through dial, for example a
+// SOCKS5 proxy. Proxy settings from the environment are ignored, and host
+// names are left for the proxy to resolve.
+func SetProxy(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	sharedTransport.CloseIdleConnections()
+	sharedTransport.Proxy = nil
+	sharedTransport.DialContext = dial
+	dialTracker = dial
+}
+
+// NewHostScheduler creates a scheduler with the given per-host limits
+func NewHostScheduler(minInterval time.Duration, maxConcurrent int) *HostScheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
+	return &HostScheduler{
+		MinInterval:   minInterval,
+		MaxConcurrent: maxConcurrent,
+		hosts:         make(map[string]*hostState),
+	}
+}
+
+// Acquire waits until a request to host may start. The returned function
+// must be called once the request has finished.
+func (s *HostScheduler) Acquire(ctx context.Context, host string) (func(), error) {
+	s.mu.Lock()
+	state, ok := s.hosts[host]
+	if !ok {
+		state = &hostState{slots: make(chan struct{}, s.MaxConcurrent)}
+		s.hosts[host] = state
+	}
+	s.mu.Unlock()
+
+	// Wait for a free slot
+	select {
+	case state.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-state.slots }
+
+	// Reserve the next start time on this host
+	s.mu.Lock()
+	now := time.Now()
+	start := state.next
+	if start.Before(now) {
+		start = now
+	}
+	state.next = start.Add(s.MinInterval)
+	s.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// acquireHost waits for the client's scheduler to allow a request to host
+func (c *Client) acquireHost(ctx context.Context, host string) (func(), error) {
+	if c.Scheduler == nil {
+		return func() {}, nil
+	}
+
+	return c.Scheduler.Acquire(ctx, host)
+}
@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostSchedulerPacesRequests(t *testing.T) {
+	s := NewHostScheduler(50*time.Millisecond, 4)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := s.Acquire(context.Background(), "tracker.example.com")
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		release()
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests to one host took %v, want at least 100ms", elapsed)
+	}
+
+	// Other hosts are not held back
+	start = time.Now()
+	release, err := s.Acquire(context.Background(), "other.example.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	release()
+
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("request to another host waited %v", elapsed)
+	}
+}
+
+func TestHostSchedulerLimitsConcurrency(t *testing.T) {
+	s := NewHostScheduler(0, 1)
+
+	release, err := s.Acquire(context.Background(), "tracker.example.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.Acquire(ctx, "tracker.example.com"); err != context.DeadlineExceeded {
+		t.Errorf("Acquire() with host busy error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	release()
+	if release, err := s.Acquire(context.Background(), "tracker.example.com"); err != nil {
+		t.Errorf("Acquire() after release error = %v", err)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,182 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsMessageType is the media type of DNS-over-HTTPS requests and answers
+const dnsMessageType = "application/dns-message"
+
+// dotPort is the DNS-over-TLS port (RFC 7858)
+const dotPort = "853"
+
+// NewSecureResolver returns a resolver sending tracker lookups to an
+// encrypted DNS server, so the network can neither read nor rewrite them:
+// an https:// URL is a DNS-over-HTTPS endpoint (RFC 8484), for example
+// https://cloudflare-dns.com/dns-query, and tls://host[:port] a
+// DNS-over-TLS server (RFC 7858). Use its LookupIPAddr as Resolver.Lookup.
+func NewSecureResolver(server string) (*net.Resolver, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS server: %w", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return NewDoHResolver(server, &http.Client{Timeout: 10 * time.Second}), nil
+	case "tls":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS server %q: missing host", server)
+		}
+		return NewDoTResolver(u.Host), nil
+	default:
+		return nil, fmt.Errorf("invalid DNS server %q: want an https:// or tls:// URL", server)
+	}
+}
+
+// NewDoTResolver returns a resolver that sends every query over TLS to
+// server (host or host:port, port 853 by default), whose certificate must
+// be valid for host
+func NewDoTResolver(server string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), dotPort)
+	}
+	host, _, _ := net.SplitHostPort(server)
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config:    &tls.Config{ServerName: host},
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		// The Go resolver speaks DNS over TCP on connections that aren't
+		// packet connections, which is all DNS-over-TLS adds to TLS
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", server)
+		},
+	}
+}
+
+// NewDoHResolver returns a resolver that posts every query to a
+// DNS-over-HTTPS endpoint with client
+func NewDoHResolver(endpoint string, client *http.Client) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, endpoint: endpoint}, nil
+		},
+	}
+}
+
+// dohConn carries the DNS-over-TCP exchange of the Go resolver over HTTPS:
+// each length-prefixed query written is posted to the endpoint, and the
+// answer is read back with the same framing
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	endpoint string
+
+	mu       sync.Mutex
+	queries  bytes.Buffer // Written, not yet sent
+	answers  bytes.Buffer // Received, not yet read
+	deadline time.Time
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.queries.Write(p)
+}
+
+func (c *dohConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.answers.Len() == 0 {
+		if err := c.exchange(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.answers.Read(p)
+}
+
+// exchange posts the next complete query; callers must hold c.mu
+func (c *dohConn) exchange() error {
+	data := c.queries.Bytes()
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+		return io.ErrUnexpectedEOF
+	}
+	query := c.queries.Next(2 + int(binary.BigEndian.Uint16(data)))[2:]
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DNS-over-HTTPS server returned %s", resp.Status)
+	}
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 65535+1))
+	if err != nil {
+		return err
+	}
+	if len(answer) > 65535 {
+		return errors.New("DNS-over-HTTPS answer too large")
+	}
+
+	binary.Write(&c.answers, binary.BigEndian, uint16(len(answer)))
+	c.answers.Write(answer)
+	return nil
+}
+
+func (c *dohConn) Close() error         { return nil }
+func (c *dohConn) LocalAddr() net.Addr  { return dohAddr(c.endpoint) }
+func (c *dohConn) RemoteAddr() net.Addr { return dohAddr(c.endpoint) }
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// dohAddr is the address of a DNS-over-HTTPS endpoint
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// dnsAnswer answers an A query with ip and any other query with no records
+func dnsAnswer(query []byte, ip net.IP) []byte {
+	// The question follows the 12 byte header: a name, then type and class
+	end := 12
+	for query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	question := query[12 : end+5]
+	qtype := binary.BigEndian.Uint16(query[end+1:])
+
+	answer := append([]byte(nil), query[:2]...)     // ID
+	answer = append(answer, 0x81, 0x80, 0, 1, 0, 0) // Response, recursion available, one question
+	if qtype == 1 {
+		answer[7] = 1 // One answer
+	}
+	answer = append(answer, 0, 0, 0, 0)
+	answer = append(answer, question...)
+	if qtype == 1 {
+		answer = append(answer, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4) // Name of the question, A, IN, TTL 60
+		answer = append(answer, ip.To4()...)
+	}
+	return answer
+}
+
+func TestDoHResolver(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(dnsAnswer(query, net.IPv4(192, 0, 2, 7)))
+	}))
+	defer server.Close()
+
+	resolver := NewDoHResolver(server.URL, server.Client())
+	addrs, err := resolver.LookupIPAddr(context.Background(), "tracker.example.net")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(192, 0, 2, 7)) {
+		t.Errorf("LookupIPAddr() = %v, want [192.0.2.7]", addrs)
+	}
+	if requests.Load() == 0 {
+		t.Error("the DNS-over-HTTPS endpoint was never asked")
+	}
+}
+
+func TestNewSecureResolver(t *testing.T) {
+	for _, server := range []string{"https://dns.example/dns-query", "tls://dns.example", "tls://[2001:db8::1]:8853"} {
+		if _, err := NewSecureResolver(server); err != nil {
+			t.Errorf("NewSecureResolver(%q) error = %v", server, err)
+		}
+	}
+
+	for _, server := range []string{"dns.example", "udp://dns.example", "tls://"} {
+		if _, err := NewSecureResolver(server); err == nil {
+			t.Errorf("NewSecureResolver(%q) accepted an unencrypted or invalid server", server)
+		}
+	}
+}
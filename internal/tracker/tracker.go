@@ -1,16 +1,38 @@
 package tracker
 
-import "net"
+import (
+	"net"
+	"sync"
+)
 
 type Client struct {
-	PeerID   [20]byte // Our unique peer ID
-	HTTPPort int      // Port we're listening on
+	PeerID    [20]byte       // Our unique peer ID
+	HTTPPort  int            // Port we're listening on
+	Scheduler *HostScheduler // Paces requests per tracker host
+
+	// Key identifies us to trackers across address changes; it is sent
+	// with every announce and never shared with other peers
+	Key uint32
+
+	mu      sync.Mutex
+	compact map[string]compactMode // Tracker URL -> whether compact announces work
 }
 
+// compactMode is what a tracker made of compact announces so far
+type compactMode int
+
+const (
+	compactUnknown  compactMode = iota // No compact announce succeeded yet
+	compactAccepted                    // A compact announce succeeded
+	compactRefused                     // Compact announces failed where non-compact ones worked
+)
+
 func NewClient(peerID [20]byte, port int) *Client {
 	return &Client{
-		PeerID:   peerID,
-		HTTPPort: port,
+		PeerID:    peerID,
+		HTTPPort:  port,
+		Scheduler: DefaultScheduler,
+		Key:       generateKey(),
 	}
 }
 
@@ -24,14 +46,20 @@ type AnnounceRequest struct {
 	Left       int64
 	Compact    bool
 	Event      string
+	TrackerID  string // Tracker ID from a previous response, sent back as is
+	NumWant    int    // Number of peers wanted, 0 leaves it to the tracker
 }
 
 // AnnounceResponse contains the response from a tracker
 type AnnounceResponse struct {
-	Interval   int
-	Peers      []Peer
-	Complete   int
-	Incomplete int
+	Interval       int // Seconds to wait between regular announces
+	MinInterval    int // Seconds announces must never be more frequent than
+	Peers          []Peer
+	Complete       int
+	Incomplete     int
+	TrackerID      string // Echoed back in later announces
+	WarningMessage string // Non-fatal message from the tracker
+	ExternalIP     net.IP // Our address as seen by the tracker (BEP 24)
 }
 
 type Peer struct {
@@ -0,0 +1,222 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webTorrentTimeout bounds a whole exchange with a WebSocket tracker
+const webTorrentTimeout = 15 * time.Second
+
+// ScrapeResult contains the swarm statistics a tracker reports for a torrent
+type ScrapeResult struct {
+	Complete   int // Number of seeders
+	Incomplete int // Number of leechers
+	Downloaded int // Number of completed downloads
+}
+
+// webTorrentMessage is the JSON message exchanged with WebTorrent trackers.
+// Binary values such as info hashes are sent as strings with one character
+// per byte.
+type webTorrentMessage struct {
+	Action        string                       `json:"action"`
+	InfoHash      interface{}                  `json:"info_hash,omitempty"`
+	PeerID        string                       `json:"peer_id,omitempty"`
+	NumWant       *int                         `json:"numwant,omitempty"`
+	Uploaded      *int64                       `json:"uploaded,omitempty"`
+	Downloaded    *int64                       `json:"downloaded,omitempty"`
+	Left          *int64                       `json:"left,omitempty"`
+	Event         string                       `json:"event,omitempty"`
+	Offers        []interface{}                `json:"offers,omitempty"`
+	Interval      int                          `json:"interval,omitempty"`
+	Complete      int                          `json:"complete,omitempty"`
+	Incomplete    int                          `json:"incomplete,omitempty"`
+	FailureReason string                       `json:"failure reason,omitempty"`
+	Warning       string                       `json:"warning message,omitempty"`
+	Files         map[string]webTorrentScrape  `json:"files,omitempty"`
+	Offer         *webTorrentSessionDescriptor `json:"offer,omitempty"`
+	OfferID       string                       `json:"offer_id,omitempty"`
+}
+
+// webTorrentScrape is the per-torrent entry of a scrape response
+type webTorrentScrape struct {
+	Complete   int `json:"complete"`
+	Incomplete int `json:"incomplete"`
+	Downloaded int `json:"downloaded"`
+}
+
+// webTorrentSessionDescriptor is a WebRTC session description relayed by the tracker
+type webTorrentSessionDescriptor struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// binaryString encodes bytes the way WebTorrent trackers expect
+func binaryString(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// stringBytes decodes a WebTorrent binary string back to bytes
+func stringBytes(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// isWebSocketURL reports whether a tracker URL uses the WebTorrent protocol
+func isWebSocketURL(trackerURL string) bool {
+	return strings.HasPrefix(trackerURL, "ws://") || strings.HasPrefix(trackerURL, "wss://")
+}
+
+// announceWebSocket announces to a WebTorrent tracker. Peers in these
+// swarms are only reachable over WebRTC, so the response carries the swarm
+// statistics and interval but no TCP peers.
+func (c *Client) announceWebSocket(ctx context.Context, trackerURL string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	conn, err := dialWebSocket(ctx, trackerURL, webTorrentTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Closing the connection unblocks a read once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.conn.Close() })
+	defer stop()
+
+	numWant := 0
+	msg := webTorrentMessage{
+		Action:     "announce",
+		InfoHash:   binaryString(req.InfoHash[:]),
+		PeerID:     binaryString(req.PeerID[:]),
+		NumWant:    &numWant,
+		Uploaded:   &req.Uploaded,
+		Downloaded: &req.Downloaded,
+		Left:       &req.Left,
+		Event:      req.Event,
+	}
+
+	if err := writeWebTorrentMessage(conn, &msg); err != nil {
+		return nil, err
+	}
+
+	// Offers relayed from other peers may arrive before our response
+	for {
+		resp, err := readWebTorrentMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Action != "announce" || resp.Offer != nil {
+			continue
+		}
+
+		if resp.FailureReason != "" {
+			return nil, fmt.Errorf("tracker error: %s", resp.FailureReason)
+		}
+
+		return &AnnounceResponse{
+			Interval:   resp.Interval,
+			Complete:   resp.Complete,
+			Incomplete: resp.Incomplete,
+		}, nil
+	}
+}
+
+// Scrape asks a WebTorrent tracker for the swarm statistics of the given torrents
+func (c *Client) Scrape(trackerURL string, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	if !isWebSocketURL(trackerURL) {
+		return nil, fmt.Errorf("scrape is not supported for tracker %s", trackerURL)
+	}
+
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker URL: %w", err)
+	}
+
+	release, err := c.acquireHost(context.Background(), u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	conn, err := dialWebSocket(context.Background(), trackerURL, webTorrentTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	hashes := make([]string, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		hashes[i] = binaryString(infoHash[:])
+	}
+
+	if err := writeWebTorrentMessage(conn, &webTorrentMessage{Action: "scrape", InfoHash: hashes}); err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, err := readWebTorrentMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Action != "scrape" {
+			continue
+		}
+
+		if resp.FailureReason != "" {
+			return nil, fmt.Errorf("tracker error: %s", resp.FailureReason)
+		}
+
+		results := make(map[[20]byte]ScrapeResult, len(resp.Files))
+		for hash, file := range resp.Files {
+			var infoHash [20]byte
+			copy(infoHash[:], stringBytes(hash))
+			results[infoHash] = ScrapeResult{
+				Complete:   file.Complete,
+				Incomplete: file.Incomplete,
+				Downloaded: file.Downloaded,
+			}
+		}
+
+		return results, nil
+	}
+}
+
+// writeWebTorrentMessage sends a JSON message to the tracker
+func writeWebTorrentMessage(conn *wsConn, msg *webTorrentMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.WriteText(data); err != nil {
+		return fmt.Errorf("failed to contact tracker: %w", err)
+	}
+
+	return nil
+}
+
+// readWebTorrentMessage reads a JSON message from the tracker
+func readWebTorrentMessage(conn *wsConn) (*webTorrentMessage, error) {
+	data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tracker response: %w", err)
+	}
+
+	var msg webTorrentMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode tracker response: %w", err)
+	}
+
+	return &msg, nil
+}
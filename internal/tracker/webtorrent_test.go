@@ -0,0 +1,115 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveWebSocket upgrades the request and answers each message with reply
+func serveWebSocket(t *testing.T, reply func(msg webTorrentMessage) interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsAcceptGUID))
+
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		brw.Flush()
+
+		ws := &wsConn{conn: conn, br: bufio.NewReader(brw)}
+		data, err := ws.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("server ReadMessage() error = %v", err)
+			}
+			return
+		}
+
+		var msg webTorrentMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Errorf("invalid client message %q: %v", data, err)
+			return
+		}
+
+		out, _ := json.Marshal(reply(msg))
+
+		// Servers send unmasked frames
+		frame := []byte{0x80 | wsOpText, byte(len(out))}
+		if len(out) >= 126 {
+			frame = []byte{0x80 | wsOpText, 126, byte(len(out) >> 8), byte(len(out))}
+		}
+		conn.Write(append(frame, out...))
+	}))
+}
+
+func TestAnnounceWebSocket(t *testing.T) {
+	infoHash := [20]byte{0xff, 0x00, 0x80, 'a'}
+
+	server := serveWebSocket(t, func(msg webTorrentMessage) interface{} {
+		if msg.Action != "announce" || msg.InfoHash != binaryString(infoHash[:]) {
+			t.Errorf("unexpected announce %+v", msg)
+		}
+
+		return map[string]interface{}{
+			"action":     "announce",
+			"info_hash":  msg.InfoHash,
+			"interval":   120,
+			"complete":   4,
+			"incomplete": 2,
+		}
+	})
+	defer server.Close()
+
+	client := NewClient([20]byte{}, 6881)
+	resp, err := client.Announce(context.Background(), strings.Replace(server.URL, "http://", "ws://", 1), &AnnounceRequest{
+		InfoHash: infoHash,
+		Left:     100,
+		Event:    "started",
+	})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+
+	if resp.Interval != 120 || resp.Complete != 4 || resp.Incomplete != 2 {
+		t.Errorf("Announce() = %+v, want interval 120, complete 4, incomplete 2", resp)
+	}
+}
+
+func TestScrapeWebSocket(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3, 0xfe}
+
+	server := serveWebSocket(t, func(msg webTorrentMessage) interface{} {
+		return map[string]interface{}{
+			"action": "scrape",
+			"files": map[string]interface{}{
+				binaryString(infoHash[:]): map[string]int{"complete": 7, "incomplete": 1, "downloaded": 30},
+			},
+		}
+	})
+	defer server.Close()
+
+	client := NewClient([20]byte{}, 6881)
+	results, err := client.Scrape(strings.Replace(server.URL, "http://", "ws://", 1), [][20]byte{infoHash})
+	if err != nil {
+		t.Fatalf("Scrape() error = %v", err)
+	}
+
+	want := ScrapeResult{Complete: 7, Incomplete: 1, Downloaded: 30}
+	if results[infoHash] != want {
+		t.Errorf("Scrape() = %+v, want %+v", results[infoHash], want)
+	}
+}
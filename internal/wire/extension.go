@@ -0,0 +1,119 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+// ExtendedHandshakeID is the extended message ID of the extended handshake;
+// the other IDs are assigned by the handshake's "m" dictionary
+const ExtendedHandshakeID = 0
+
+// ExtendedHandshake is the handshake of the extension protocol (BEP 10),
+// sent as an extended message after the BitTorrent handshake. Fields a
+// peer leaves out are zero.
+type ExtendedHandshake struct {
+	Extensions map[string]int // Extended message IDs by extension name ("m")
+	Version    string         // Client name and version ("v")
+	RequestQ   int            // Requests the peer queues without dropping them ("reqq")
+	Port       int            // The peer's listen port ("p")
+	YourIP     net.IP         // Our address as the peer sees it ("yourip")
+	IPv4       net.IP         // Another address of a multihomed peer ("ipv4")
+	IPv6       net.IP         // Another address of a multihomed peer ("ipv6")
+
+	MetadataSize int // Size of the info dictionary the peer can send with ut_metadata ("metadata_size")
+}
+
+// Serialize encodes the handshake as the payload of an extended message,
+// starting with its extended message ID
+func (h *ExtendedHandshake) Serialize() []byte {
+	m := make(map[string]interface{}, len(h.Extensions))
+	for name, id := range h.Extensions {
+		m[name] = id
+	}
+
+	dict := map[string]interface{}{"m": m}
+	if h.Version != "" {
+		dict["v"] = h.Version
+	}
+	if h.RequestQ > 0 {
+		dict["reqq"] = h.RequestQ
+	}
+	if h.Port > 0 {
+		dict["p"] = h.Port
+	}
+	if ip := h.YourIP.To4(); ip != nil {
+		dict["yourip"] = string(ip)
+	} else if len(h.YourIP) == net.IPv6len {
+		dict["yourip"] = string(h.YourIP)
+	}
+	if ip := h.IPv4.To4(); ip != nil {
+		dict["ipv4"] = string(ip)
+	}
+	if len(h.IPv6) == net.IPv6len && h.IPv6.To4() == nil {
+		dict["ipv6"] = string(h.IPv6)
+	}
+	if h.MetadataSize > 0 {
+		dict["metadata_size"] = h.MetadataSize
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(ExtendedHandshakeID)
+	bencode.Encode(&buf, dict) // Only encodes types bencode supports
+	return buf.Bytes()
+}
+
+// ParseExtendedHandshake decodes the payload of an extended handshake
+// message, including its extended message ID. Fields of the wrong type or
+// out of range are ignored, as BEP 10 asks.
+func ParseExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	if len(payload) == 0 || payload[0] != ExtendedHandshakeID {
+		return nil, fmt.Errorf("not an extended handshake")
+	}
+
+	value, err := bencode.DecodeLimited(bytes.NewReader(payload[1:]), bencode.DefaultLimits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended handshake: %w", err)
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid extended handshake: not a dictionary")
+	}
+
+	h := &ExtendedHandshake{Extensions: make(map[string]int)}
+	if m, ok := dict["m"].(map[string]interface{}); ok {
+		for name, id := range m {
+			// An ID of 0 disables the extension
+			if id, ok := id.(int64); ok && id > 0 && id <= 255 {
+				h.Extensions[name] = int(id)
+			}
+		}
+	}
+
+	if v, ok := dict["v"].(string); ok {
+		h.Version = v
+	}
+	if reqq, ok := dict["reqq"].(int64); ok && reqq > 0 && reqq <= 1<<16 {
+		h.RequestQ = int(reqq)
+	}
+	if p, ok := dict["p"].(int64); ok && p > 0 && p <= 65535 {
+		h.Port = int(p)
+	}
+	if ip, ok := dict["yourip"].(string); ok && (len(ip) == net.IPv4len || len(ip) == net.IPv6len) {
+		h.YourIP = net.IP(ip)
+	}
+	if ip, ok := dict["ipv4"].(string); ok && len(ip) == net.IPv4len {
+		h.IPv4 = net.IP(ip)
+	}
+	if ip, ok := dict["ipv6"].(string); ok && len(ip) == net.IPv6len {
+		h.IPv6 = net.IP(ip)
+	}
+	if size, ok := dict["metadata_size"].(int64); ok && size > 0 && size <= MaxMetadataSize {
+		h.MetadataSize = int(size)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,68 @@
+package wire
+
+import (
+	"net"
+	"testing"
+)
+
+func TestExtendedHandshakeRoundTrip(t *testing.T) {
+	sent := &ExtendedHandshake{
+		Extensions: map[string]int{"ut_metadata": 3},
+		Version:    "GoTorrent 0.0.1",
+		RequestQ:   250,
+		Port:       6881,
+		YourIP:     net.IPv4(192, 0, 2, 1),
+	}
+
+	got, err := ParseExtendedHandshake(sent.Serialize())
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake() error = %v", err)
+	}
+	if got.Extensions["ut_metadata"] != 3 || got.Version != sent.Version || got.RequestQ != 250 || got.Port != 6881 {
+		t.Errorf("ParseExtendedHandshake() = %+v, want %+v", got, sent)
+	}
+	if !got.YourIP.Equal(sent.YourIP) || len(got.YourIP) != net.IPv4len {
+		t.Errorf("YourIP = %v, want %v in 4 bytes", got.YourIP, sent.YourIP)
+	}
+
+	ipv6 := net.ParseIP("2001:db8::1")
+	got, err = ParseExtendedHandshake((&ExtendedHandshake{YourIP: ipv6}).Serialize())
+	if err != nil || !got.YourIP.Equal(ipv6) {
+		t.Errorf("ParseExtendedHandshake() = %+v, %v, want yourip %v", got, err, ipv6)
+	}
+
+	// Multihomed peers list their other addresses
+	got, err = ParseExtendedHandshake((&ExtendedHandshake{IPv4: net.IPv4(198, 51, 100, 7), IPv6: ipv6}).Serialize())
+	if err != nil || !got.IPv4.Equal(net.IPv4(198, 51, 100, 7)) || !got.IPv6.Equal(ipv6) {
+		t.Errorf("ParseExtendedHandshake() = %+v, %v, want ipv4 and ipv6", got, err)
+	}
+
+	got, err = ParseExtendedHandshake((&ExtendedHandshake{MetadataSize: 31235}).Serialize())
+	if err != nil || got.MetadataSize != 31235 {
+		t.Errorf("ParseExtendedHandshake() = %+v, %v, want metadata_size 31235", got, err)
+	}
+
+	// An IPv4 address is no ipv6 field
+	if got := (&ExtendedHandshake{IPv6: net.IPv4(198, 51, 100, 7)}).Serialize(); string(got[1:]) != "d1:mdee" {
+		t.Errorf("Serialize() = %q, want no ipv6", got[1:])
+	}
+}
+
+func TestParseExtendedHandshakeIgnoresBadFields(t *testing.T) {
+	payload := append([]byte{ExtendedHandshakeID},
+		"d1:md11:ut_metadatai0e6:ut_pex3:abce1:pi70000e4:reqqi-1e1:vi1e6:yourip3:abce"...)
+
+	h, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake() error = %v", err)
+	}
+	if len(h.Extensions) != 0 || h.Port != 0 || h.RequestQ != 0 || h.Version != "" || h.YourIP != nil {
+		t.Errorf("ParseExtendedHandshake() = %+v, want every field ignored", h)
+	}
+
+	for _, payload := range [][]byte{nil, {1, 'd', 'e'}, {ExtendedHandshakeID, 'l', 'e'}, {ExtendedHandshakeID, 'x'}} {
+		if _, err := ParseExtendedHandshake(payload); err == nil {
+			t.Errorf("ParseExtendedHandshake(%q) succeeded, want error", payload)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// HandshakeLength is the size of a BitTorrent handshake on the wire
+const HandshakeLength = 68
+
+// protocol is the protocol string every handshake starts with
+const protocol = "BitTorrent protocol"
+
+// Handshake represents a BitTorrent handshake message
+type Handshake struct {
+	ProtocolLen byte
+	Protocol    [19]byte
+	Reserved    [8]byte
+	InfoHash    [20]byte
+	PeerID      [20]byte
+}
+
+// fastExtensionBit in the last reserved byte advertises the Fast extension (BEP 6)
+const fastExtensionBit = 0x04
+
+// extensionProtocolBit in the sixth reserved byte advertises the extension
+// protocol (BEP 10)
+const extensionProtocolBit = 0x10
+
+// NewHandshake creates a new handshake message
+func NewHandshake(infoHash, peerID [20]byte) *Handshake {
+	h := &Handshake{
+		ProtocolLen: byte(len(protocol)),
+		Reserved:    [8]byte{0, 0, 0, 0, 0, extensionProtocolBit, 0, fastExtensionBit},
+		InfoHash:    infoHash,
+		PeerID:      peerID,
+	}
+	copy(h.Protocol[:], protocol)
+
+	return h
+}
+
+// Serialize converts the handshake to bytes for sending
+func (h *Handshake) Serialize() []byte {
+	buf := make([]byte, HandshakeLength)
+
+	buf[0] = h.ProtocolLen
+	copy(buf[1:20], h.Protocol[:])
+	copy(buf[20:28], h.Reserved[:])
+	copy(buf[28:48], h.InfoHash[:])
+	copy(buf[48:68], h.PeerID[:])
+
+	return buf
+}
+
+// CheckProtocolLength checks the first byte of a handshake, so transports
+// can drop peers speaking another protocol before reading the rest
+func CheckProtocolLength(b byte) error {
+	if int(b) != len(protocol) {
+		return fmt.Errorf("invalid protocol length: %d", b)
+	}
+	return nil
+}
+
+// DecodeHandshake decodes a handshake from the start of buf
+func DecodeHandshake(buf []byte) (*Handshake, error) {
+	if len(buf) < 1 {
+		return nil, ErrIncomplete
+	}
+	if err := CheckProtocolLength(buf[0]); err != nil {
+		return nil, err
+	}
+	if len(buf) < HandshakeLength {
+		return nil, ErrIncomplete
+	}
+
+	handshake := &Handshake{
+		ProtocolLen: buf[0],
+	}
+
+	copy(handshake.Protocol[:], buf[1:20])
+	copy(handshake.Reserved[:], buf[20:28])
+	copy(handshake.InfoHash[:], buf[28:48])
+	copy(handshake.PeerID[:], buf[48:68])
+
+	// Verify protocol string
+	if string(handshake.Protocol[:]) != protocol {
+		return nil, fmt.Errorf("invalid protocol: %s", string(handshake.Protocol[:]))
+	}
+
+	return handshake, nil
+}
+
+// SupportsFast reports whether the handshake advertises the Fast extension
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[7]&fastExtensionBit != 0
+}
+
+// SupportsExtended reports whether the handshake advertises the extension
+// protocol
+func (h *Handshake) SupportsExtended() bool {
+	return h.Reserved[5]&extensionProtocolBit != 0
+}
+
+// Validate checks if the handshake is valid for our torrent
+func (h *Handshake) Validate(expectedInfoHash [20]byte) error {
+	if !bytes.Equal(h.InfoHash[:], expectedInfoHash[:]) {
+		return fmt.Errorf("info hash mismatch: got %x, want %x", h.InfoHash, expectedInfoHash)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package wire
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeHandshake(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3}
+	peerID := [20]byte{4, 5, 6}
+	buf := NewHandshake(infoHash, peerID).Serialize()
+
+	h, err := DecodeHandshake(buf)
+	if err != nil {
+		t.Fatalf("DecodeHandshake() error = %v", err)
+	}
+	if h.InfoHash != infoHash || h.PeerID != peerID || !h.SupportsFast() || !h.SupportsExtended() {
+		t.Errorf("DecodeHandshake() = %+v", h)
+	}
+
+	for i := 0; i < HandshakeLength; i++ {
+		if _, err := DecodeHandshake(buf[:i]); !errors.Is(err, ErrIncomplete) {
+			t.Errorf("DecodeHandshake(%d bytes) = %v, want ErrIncomplete", i, err)
+		}
+	}
+
+	// Other protocols are refused from the first byte
+	if _, err := DecodeHandshake([]byte{18}); err == nil || errors.Is(err, ErrIncomplete) {
+		t.Errorf("DecodeHandshake(length 18) = %v, want a protocol error", err)
+	}
+
+	bad := append([]byte(nil), buf...)
+	bad[1] = 'b'
+	if _, err := DecodeHandshake(bad); err == nil {
+		t.Error("DecodeHandshake() accepted another protocol string")
+	}
+}
@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOutOfRange is returned for messages whose piece index or block offsets
+// lie outside the torrent
+var ErrOutOfRange = errors.New("piece index or offset out of range")
+
+// Layout is the piece geometry of a torrent, against which the indices and
+// offsets peers send are checked
+type Layout struct {
+	NumPieces   int
+	PieceLength int64
+	TotalLength int64
+}
+
+// PieceSize returns the length of a piece; only the last one may be shorter
+func (l Layout) PieceSize(index int) int64 {
+	if index == l.NumPieces-1 {
+		if last := l.TotalLength - int64(index)*l.PieceLength; last > 0 {
+			return last
+		}
+	}
+	return l.PieceLength
+}
+
+// CheckBitfield checks that a bitfield has one bit per piece and no spare
+// bits set
+func (l Layout) CheckBitfield(bf Bitfield) error {
+	return bf.Validate(l.NumPieces)
+}
+
+// CheckIndex checks that a piece index exists
+func (l Layout) CheckIndex(index int) error {
+	if index < 0 || index >= l.NumPieces {
+		return fmt.Errorf("%w: piece %d of %d", ErrOutOfRange, index, l.NumPieces)
+	}
+	return nil
+}
+
+// CheckBlock checks that a block lies within its piece
+func (l Layout) CheckBlock(index, begin, length int) error {
+	if err := l.CheckIndex(index); err != nil {
+		return err
+	}
+
+	if begin < 0 || length < 0 || int64(begin)+int64(length) > l.PieceSize(index) {
+		return fmt.Errorf("%w: %d bytes at %d in piece %d of %d bytes",
+			ErrOutOfRange, length, begin, index, l.PieceSize(index))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package wire
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLayoutCheckBitfield(t *testing.T) {
+	l := Layout{NumPieces: 10, PieceLength: 16, TotalLength: 160}
+
+	if err := l.CheckBitfield(Bitfield{0xff, 0xc0}); err != nil {
+		t.Errorf("CheckBitfield() error = %v for a full bitfield", err)
+	}
+	for _, bf := range []Bitfield{{0xff}, {0xff, 0xc0, 0x00}, {0xff, 0xe0}} {
+		if err := l.CheckBitfield(bf); !errors.Is(err, ErrInvalidBitfield) {
+			t.Errorf("CheckBitfield(%08b) = %v, want ErrInvalidBitfield", bf, err)
+		}
+	}
+}
+
+func TestLayoutCheckBlock(t *testing.T) {
+	// Three pieces of 16 bytes, the last one holding 10
+	l := Layout{NumPieces: 3, PieceLength: 16, TotalLength: 42}
+
+	if n := l.PieceSize(2); n != 10 {
+		t.Errorf("PieceSize(2) = %d, want 10", n)
+	}
+
+	tests := []struct {
+		index, begin, length int
+		valid                bool
+	}{
+		{0, 0, 16, true},
+		{1, 8, 8, true},
+		{2, 0, 10, true},
+		{2, 4, 0, true},
+		{-1, 0, 1, false},
+		{3, 0, 1, false},
+		{0, 0, 17, false},
+		{0, 16, 1, false},
+		{2, 8, 4, false}, // Past the end of the short last piece
+		{0, -1, 4, false},
+		{0, 4, -4, false},
+		{1, 1 << 30, 1 << 30, false},
+	}
+
+	for _, tt := range tests {
+		err := l.CheckBlock(tt.index, tt.begin, tt.length)
+		if tt.valid && err != nil {
+			t.Errorf("CheckBlock(%d, %d, %d) error = %v", tt.index, tt.begin, tt.length, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("CheckBlock(%d, %d, %d) = %v, want ErrOutOfRange", tt.index, tt.begin, tt.length, err)
+		}
+	}
+}
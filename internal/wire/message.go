@@ -0,0 +1,276 @@
+// Package wire encodes and decodes the BitTorrent peer wire protocol. It
+// does no I/O, so every peer transport can share it.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrIncomplete is returned when a buffer ends before the message does
+	ErrIncomplete = errors.New("incomplete message")
+
+	// ErrMessageTooLong is returned for length prefixes beyond MaxMessageLength
+	ErrMessageTooLong = errors.New("message too long")
+
+	// ErrInvalidBitfield is returned for bitfields that don't fit the torrent
+	ErrInvalidBitfield = errors.New("invalid bitfield")
+)
+
+// LengthPrefixSize is the size of the length in front of every message
+const LengthPrefixSize = 4
+
+// MaxMessageLength is the longest message accepted, leaving room for the
+// bitfield of a torrent with millions of pieces and for oversized blocks
+const MaxMessageLength = 2 << 20
+
+type MessageID uint8
+
+const (
+	MsgChoke         MessageID = 0
+	MsgUnchoke       MessageID = 1
+	MsgInterested    MessageID = 2
+	MsgNotInterested MessageID = 3
+	MsgHave          MessageID = 4
+	MsgBitfield      MessageID = 5
+	MsgRequest       MessageID = 6
+	MsgPiece         MessageID = 7
+	MsgCancel        MessageID = 8
+
+	// Fast extension (BEP 6)
+	MsgSuggestPiece  MessageID = 13
+	MsgHaveAll       MessageID = 14
+	MsgHaveNone      MessageID = 15
+	MsgRejectRequest MessageID = 16
+	MsgAllowedFast   MessageID = 17
+
+	// Extension protocol (BEP 10)
+	MsgExtended MessageID = 20
+)
+
+// Message represents a peer wire protocol
+type Message struct {
+	ID      MessageID
+	Payload []byte
+}
+
+// Serialize converts a message to bytes for sending
+func (m *Message) Serialize() []byte {
+	if m == nil {
+		// Keep-alive message (length = 0)
+		return make([]byte, 4)
+	}
+
+	length := uint32(1 + len(m.Payload))
+	buf := make([]byte, 4+length)
+
+	binary.BigEndian.PutUint32(buf[0:4], length)
+	buf[4] = byte(m.ID)
+	copy(buf[5:], m.Payload)
+
+	return buf
+}
+
+// DecodeLength returns the length of the message following a length prefix
+func DecodeLength(prefix []byte) (int, error) {
+	if len(prefix) < LengthPrefixSize {
+		return 0, ErrIncomplete
+	}
+
+	length := binary.BigEndian.Uint32(prefix)
+	if length > MaxMessageLength {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMessageTooLong, length)
+	}
+
+	return int(length), nil
+}
+
+// DecodeBody decodes a message without its length prefix. An empty body is
+// a keep-alive, returned as a nil message. The payload shares body's memory.
+func DecodeBody(body []byte) *Message {
+	if len(body) == 0 {
+		return nil
+	}
+
+	return &Message{
+		ID:      MessageID(body[0]),
+		Payload: body[1:],
+	}
+}
+
+// Decode decodes the message at the start of buf and returns it together
+// with the number of bytes it took up. ErrIncomplete means buf holds only
+// part of the message; more data is needed.
+func Decode(buf []byte) (*Message, int, error) {
+	length, err := DecodeLength(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	end := LengthPrefixSize + length
+	if len(buf) < end {
+		return nil, 0, ErrIncomplete
+	}
+
+	return DecodeBody(buf[LengthPrefixSize:end]), end, nil
+}
+
+// String returns a string representation of the message
+func (m *Message) String() string {
+	if m == nil {
+		return "keep-alive"
+	}
+
+	switch m.ID {
+	case MsgChoke:
+		return "choke"
+	case MsgUnchoke:
+		return "unchoke"
+	case MsgInterested:
+		return "interested"
+	case MsgNotInterested:
+		return "not interested"
+	case MsgHave:
+		if len(m.Payload) != 4 {
+			return "have (malformed)"
+		}
+		return fmt.Sprintf("have (piece %d)", binary.BigEndian.Uint32(m.Payload))
+	case MsgBitfield:
+		return "bitfield"
+	case MsgRequest:
+		return "request"
+	case MsgPiece:
+		return "piece"
+	case MsgCancel:
+		return "cancel"
+	case MsgSuggestPiece:
+		return "suggest piece"
+	case MsgHaveAll:
+		return "have all"
+	case MsgHaveNone:
+		return "have none"
+	case MsgRejectRequest:
+		return "reject request"
+	case MsgAllowedFast:
+		return "allowed fast"
+	case MsgExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("unknown (ID: %d)", m.ID)
+	}
+}
+
+// Request represents a block request message
+type Request struct {
+	Index  int // Piece index
+	Begin  int // Byte offset within the piece
+	Length int // Length of the block
+}
+
+// ParseRequest parses a request message payload
+func ParseRequest(payload []byte) (*Request, error) {
+	if len(payload) != 12 {
+		return nil, fmt.Errorf("invalid request payload length: %d", len(payload))
+	}
+
+	index := binary.BigEndian.Uint32(payload[0:4])
+	begin := binary.BigEndian.Uint32(payload[4:8])
+	length := binary.BigEndian.Uint32(payload[8:12])
+
+	request := &Request{
+		Index:  int(index),
+		Begin:  int(begin),
+		Length: int(length),
+	}
+
+	return request, nil
+}
+
+// SerializeRequest creates a request message payload
+func SerializeRequest(index, begin, length int) []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+
+	return payload
+}
+
+// Piece represents a piece message with block data
+type Piece struct {
+	Index int    // Piece index
+	Begin int    // Byte offset within that piece
+	Block []byte // Block data
+}
+
+// ParsePiece parses a piece message payload
+func ParsePiece(payload []byte) (*Piece, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("invalid piece payload length: %d", len(payload))
+	}
+
+	piece := &Piece{
+		Index: int(binary.BigEndian.Uint32(payload[:4])),
+		Begin: int(binary.BigEndian.Uint32(payload[4:8])),
+		Block: payload[8:],
+	}
+
+	return piece, nil
+}
+
+// SerializePiece creates a piece message payload
+func SerializePiece(index, begin int, block []byte) []byte {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+
+	return payload
+}
+
+// Bitfield message
+type Bitfield []byte
+
+// HasPiece returns true if the bitfield indicates having a piece
+func (bf Bitfield) HasPiece(index int) bool {
+	if index < 0 || index >= len(bf)*8 {
+		return false
+	}
+
+	byteIndex := index / 8
+	offset := index % 8
+
+	return bf[byteIndex]>>(7-offset)&1 != 0
+}
+
+// Validate checks a bitfield received from a peer against a torrent of
+// numPieces pieces: it must be exactly one bit per piece rounded up to
+// whole bytes, with the spare bits at the end clear. Anything else means
+// the peer got the torrent wrong or isn't speaking our protocol.
+func (bf Bitfield) Validate(numPieces int) error {
+	if want := (numPieces + 7) / 8; len(bf) != want {
+		return fmt.Errorf("%w: %d bytes for %d pieces, want %d", ErrInvalidBitfield, len(bf), numPieces, want)
+	}
+
+	if spare := numPieces % 8; spare != 0 {
+		if last := bf[len(bf)-1]; last&(0xff>>spare) != 0 {
+			return fmt.Errorf("%w: spare bits set in last byte %08b", ErrInvalidBitfield, last)
+		}
+	}
+
+	return nil
+}
+
+// SetPiece sets a piece as available in the bitfield
+func (bf Bitfield) SetPiece(index int) {
+	if index < 0 || index >= len(bf)*8 {
+		return
+	}
+
+	byteIndex := index / 8
+	offset := index % 8
+
+	bf[byteIndex] |= 1 << (7 - offset)
+}
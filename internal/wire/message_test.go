@@ -0,0 +1,112 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	messages := []*Message{
+		nil,
+		{ID: MsgChoke},
+		{ID: MsgHave, Payload: []byte{0, 0, 0, 5}},
+		{ID: MsgBitfield, Payload: []byte{0xff, 0x80}},
+		{ID: MsgRequest, Payload: SerializeRequest(1, 16384, 16384)},
+		{ID: MsgPiece, Payload: SerializePiece(1, 0, []byte("block"))},
+		{ID: MsgAllowedFast, Payload: []byte{0, 0, 0, 9}},
+	}
+
+	// Messages back to back in one buffer come out one at a time
+	var stream []byte
+	for _, msg := range messages {
+		stream = append(stream, msg.Serialize()...)
+	}
+
+	for i, want := range messages {
+		got, n, err := Decode(stream)
+		if err != nil {
+			t.Fatalf("Decode() message %d error = %v", i, err)
+		}
+		if (got == nil) != (want == nil) || got != nil && (got.ID != want.ID || !bytes.Equal(got.Payload, want.Payload)) {
+			t.Errorf("Decode() message %d = %v, want %v", i, got, want)
+		}
+		stream = stream[n:]
+	}
+
+	if len(stream) != 0 {
+		t.Errorf("%d bytes left over", len(stream))
+	}
+}
+
+func TestDecodeIncomplete(t *testing.T) {
+	full := (&Message{ID: MsgPiece, Payload: SerializePiece(3, 0, []byte("data"))}).Serialize()
+
+	// Every cut short of the whole message asks for more data
+	for i := 0; i < len(full); i++ {
+		if _, n, err := Decode(full[:i]); !errors.Is(err, ErrIncomplete) || n != 0 {
+			t.Errorf("Decode(%d of %d bytes) = %d, %v, want ErrIncomplete", i, len(full), n, err)
+		}
+	}
+}
+
+func TestDecodeTooLong(t *testing.T) {
+	if _, err := DecodeLength([]byte{0xff, 0xff, 0xff, 0xff}); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("DecodeLength(4GB) = %v, want ErrMessageTooLong", err)
+	}
+	if n, err := DecodeLength([]byte{0, 0x20, 0, 0}); err != nil || n != MaxMessageLength {
+		t.Errorf("DecodeLength(max) = %d, %v", n, err)
+	}
+}
+
+func TestMessageStringMalformed(t *testing.T) {
+	// Peers control payloads, so describing one must never panic
+	for id := 0; id < 256; id++ {
+		for _, payload := range [][]byte{nil, {1}, {0, 0, 0, 1}} {
+			_ = (&Message{ID: MessageID(id), Payload: payload}).String()
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for n := 0; n < 16; n++ {
+		payload := make([]byte, n)
+		if _, err := ParseRequest(payload); (err == nil) != (n == 12) {
+			t.Errorf("ParseRequest(%d bytes) error = %v", n, err)
+		}
+		if _, err := ParsePiece(payload); (err == nil) != (n >= 8) {
+			t.Errorf("ParsePiece(%d bytes) error = %v", n, err)
+		}
+	}
+}
+
+func TestBitfieldValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitfield  Bitfield
+		numPieces int
+		valid     bool
+	}{
+		{"exact bytes", Bitfield{0xff, 0xff}, 16, true},
+		{"spare bits clear", Bitfield{0xff, 0xe0}, 11, true},
+		{"nothing yet", Bitfield{0x00, 0x00}, 11, true},
+		{"one spare bit set", Bitfield{0xff, 0xf0}, 11, false},
+		{"last spare bit set", Bitfield{0x00, 0x01}, 11, false},
+		{"too short", Bitfield{0xff}, 11, false},
+		{"too long", Bitfield{0xff, 0xe0, 0x00}, 11, false},
+		{"empty", Bitfield{}, 1, false},
+		{"empty torrent", Bitfield{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.bitfield.Validate(tt.numPieces)
+			if tt.valid && err != nil {
+				t.Errorf("Validate(%d) error = %v", tt.numPieces, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidBitfield) {
+				t.Errorf("Validate(%d) = %v, want ErrInvalidBitfield", tt.numPieces, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/piyushgupta53/go-torrent/internal/bencode"
+)
+
+// MetadataPieceSize is the size of the pieces the info dictionary is sent
+// in by the metadata extension (BEP 9); only the last one may be shorter
+const MetadataPieceSize = 16 * 1024
+
+// MaxMetadataSize is the largest info dictionary accepted from peers
+const MaxMetadataSize = 16 << 20
+
+// Types of metadata extension messages
+const (
+	MetadataRequest = 0
+	MetadataData    = 1
+	MetadataReject  = 2
+)
+
+// MetadataMessage is a message of the metadata extension (BEP 9), which
+// sends the info dictionary of a torrent known only by its info hash
+type MetadataMessage struct {
+	Type      int    // MetadataRequest, MetadataData or MetadataReject ("msg_type")
+	Piece     int    // Index of the piece of the info dictionary ("piece")
+	TotalSize int    // Size of the info dictionary, only in data messages ("total_size")
+	Data      []byte // The piece, following the dictionary of data messages
+}
+
+// Serialize encodes the message as the payload of an extended message
+// with the ID the peer assigned to ut_metadata
+func (m *MetadataMessage) Serialize(id byte) []byte {
+	header := struct {
+		Type      int `bencode:"msg_type"`
+		Piece     int `bencode:"piece"`
+		TotalSize int `bencode:"total_size,omitempty"`
+	}{Type: m.Type, Piece: m.Piece}
+	if m.Type == MetadataData {
+		header.TotalSize = m.TotalSize
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(id)
+	bencode.Encode(&buf, header) // Only encodes types bencode supports
+	buf.Write(m.Data)
+	return buf.Bytes()
+}
+
+// ParseMetadataMessage decodes the payload of a metadata extension message,
+// including its extended message ID
+func ParseMetadataMessage(payload []byte) (*MetadataMessage, error) {
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("invalid metadata message: too short")
+	}
+
+	var header struct {
+		Type      *int `bencode:"msg_type"`
+		Piece     *int `bencode:"piece"`
+		TotalSize int  `bencode:"total_size"`
+	}
+
+	// The piece follows the dictionary
+	dec := bencode.NewDecoder(bytes.NewReader(payload[1:]))
+	dec.SetLimits(bencode.DefaultLimits)
+	if err := dec.Unmarshal(&header); err != nil {
+		return nil, fmt.Errorf("invalid metadata message: %w", err)
+	}
+
+	switch {
+	case header.Type == nil || *header.Type < MetadataRequest || *header.Type > MetadataReject:
+		return nil, fmt.Errorf("invalid metadata message: unknown type")
+	case header.Piece == nil || *header.Piece < 0 || *header.Piece >= MaxMetadataSize/MetadataPieceSize:
+		return nil, fmt.Errorf("invalid metadata message: piece out of range")
+	}
+
+	m := &MetadataMessage{Type: *header.Type, Piece: *header.Piece}
+	if m.Type == MetadataData {
+		if header.TotalSize <= 0 || header.TotalSize > MaxMetadataSize {
+			return nil, fmt.Errorf("invalid metadata message: total size %d out of range", header.TotalSize)
+		}
+		m.TotalSize = header.TotalSize
+		m.Data = payload[1+dec.InputOffset():]
+	}
+
+	return m, nil
+}
@@ -0,0 +1,44 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetadataMessageRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("e"), 100) // Data that looks like the end of a dictionary
+	sent := &MetadataMessage{Type: MetadataData, Piece: 2, TotalSize: 2*MetadataPieceSize + 100, Data: data}
+
+	payload := sent.Serialize(3)
+	if payload[0] != 3 {
+		t.Fatalf("Serialize() starts with ID %d, want 3", payload[0])
+	}
+
+	got, err := ParseMetadataMessage(payload)
+	if err != nil {
+		t.Fatalf("ParseMetadataMessage() error = %v", err)
+	}
+	if got.Type != MetadataData || got.Piece != 2 || got.TotalSize != sent.TotalSize || !bytes.Equal(got.Data, data) {
+		t.Errorf("ParseMetadataMessage() = %+v, want %+v", got, sent)
+	}
+
+	got, err = ParseMetadataMessage((&MetadataMessage{Type: MetadataRequest, Piece: 1}).Serialize(3))
+	if err != nil || got.Type != MetadataRequest || got.Piece != 1 || got.Data != nil {
+		t.Errorf("ParseMetadataMessage(request) = %+v, %v", got, err)
+	}
+}
+
+func TestParseMetadataMessageRejectsBadFields(t *testing.T) {
+	for _, dict := range []string{
+		"d8:msg_typei3e5:piecei0ee",
+		"d8:msg_typei0e5:piecei-1ee",
+		"d8:msg_typei1e5:piecei0ee",
+		"d8:msg_typei1e5:piecei0e10:total_sizei99999999ee",
+		"le",
+		"d8:msg_type",
+	} {
+		if _, err := ParseMetadataMessage(append([]byte{1}, dict...)); err == nil {
+			t.Errorf("ParseMetadataMessage(%q) succeeded, want error", dict)
+		}
+	}
+}